@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// HeaderTimestamp holds the unix time at which an hmac request was signed.
+	HeaderTimestamp = "X-Difuse-Timestamp"
+	// HeaderNonce holds the random value making each hmac request unique.
+	HeaderNonce = "X-Difuse-Nonce"
+
+	schemeBearer = "Bearer"
+	schemeHMAC   = "HMAC"
+
+	// DefaultMaxSkew is the max age of a signed request
+	DefaultMaxSkew = 5 * time.Minute
+	// DefaultMaxBodySize is the max size of the body of a signed request
+	DefaultMaxBodySize = 64 << 20
+
+	// bounds of the length of a nonce
+	minNonceLen = 16
+	maxNonceLen = 64
+	// interval at which expired nonces are dropped
+	nonceSweepInterval = time.Minute
+)
+
+var (
+	// ErrUnauthenticated is returned when a request has no or invalid credentials.
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrForbidden is returned when the client lacks the right for the request.
+	ErrForbidden = errors.New("forbidden")
+
+	errRequestExpired  = errors.New("request expired")
+	errRequestReplayed = errors.New("request replayed")
+)
+
+// Authenticator authenticates http requests against a set of policies.  Requests
+// carry either a bearer token:
+//
+//	Authorization: Bearer <token>
+//
+// or are signed with the policy secret:
+//
+//	Authorization: HMAC <name>:<base64 signature>
+//	X-Difuse-Timestamp: <unix seconds>
+//	X-Difuse-Nonce: <random value>
+//
+// where the signature is the HMAC-SHA256 of the string returned by StringToSign.  A
+// signed request is accepted once per Authenticator as the nonce is remembered until the
+// timestamp expires.
+type Authenticator struct {
+	tokens  map[string]*Policy // sha256 of token to policy
+	secrets map[string]*Policy // name to policy
+	nonces  *nonceCache
+
+	// Max age of a signed request
+	MaxSkew time.Duration
+	// Max size of the body of a signed request read to verify the signature
+	MaxBodySize int64
+}
+
+// NewAuthenticator instantiates an Authenticator with the given policies.
+func NewAuthenticator(policies []*Policy) *Authenticator {
+	a := &Authenticator{
+		tokens:      make(map[string]*Policy),
+		secrets:     make(map[string]*Policy),
+		nonces:      &nonceCache{m: make(map[string]time.Time)},
+		MaxSkew:     DefaultMaxSkew,
+		MaxBodySize: DefaultMaxBodySize,
+	}
+
+	for _, p := range policies {
+		if p.Token != "" {
+			h := sha256.Sum256([]byte(p.Token))
+			a.tokens[string(h[:])] = p
+		}
+		if p.Secret != "" {
+			a.secrets[p.Name] = p
+		}
+	}
+	return a
+}
+
+// Authenticate returns the policy for the credentials in the request.  The body of
+// signed requests is read and replaced so it can be read again by the handler.
+func (a *Authenticator) Authenticate(r *http.Request) (*Policy, error) {
+	hdr := r.Header.Get("Authorization")
+
+	i := strings.IndexByte(hdr, ' ')
+	if i < 0 {
+		return nil, ErrUnauthenticated
+	}
+	scheme, cred := hdr[:i], strings.TrimSpace(hdr[i+1:])
+
+	switch scheme {
+	case schemeBearer:
+		// Lookup by hash so the comparison does not leak the token
+		h := sha256.Sum256([]byte(cred))
+		if p, ok := a.tokens[string(h[:])]; ok {
+			return p, nil
+		}
+
+	case schemeHMAC:
+		return a.authenticateHMAC(r, cred)
+	}
+
+	return nil, ErrUnauthenticated
+}
+
+func (a *Authenticator) authenticateHMAC(r *http.Request, cred string) (*Policy, error) {
+	pp := strings.SplitN(cred, ":", 2)
+	if len(pp) != 2 {
+		return nil, ErrUnauthenticated
+	}
+	p, ok := a.secrets[pp[0]]
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	sig, err := base64.StdEncoding.DecodeString(pp[1])
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+
+	ts, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+	if d := time.Since(time.Unix(ts, 0)); d > a.MaxSkew || d < -a.MaxSkew {
+		return nil, errRequestExpired
+	}
+	nonce := r.Header.Get(HeaderNonce)
+	if len(nonce) < minNonceLen || len(nonce) > maxNonceLen {
+		return nil, ErrUnauthenticated
+	}
+
+	body, err := readBody(r, a.MaxBodySize)
+	if err != nil {
+		return nil, err
+	}
+
+	want := sign([]byte(p.Secret), StringToSign(r, body))
+	if subtle.ConstantTimeCompare(sig, want) != 1 {
+		return nil, ErrUnauthenticated
+	}
+
+	// Only nonces of valid signatures are remembered so they cannot be flooded
+	if !a.nonces.add(p.Name+":"+nonce, time.Unix(ts, 0).Add(a.MaxSkew)) {
+		return nil, errRequestReplayed
+	}
+	return p, nil
+}
+
+// SignRequest signs the request with the policy name and secret setting the
+// authorization, timestamp and nonce headers.
+func SignRequest(r *http.Request, name, secret string) error {
+	nonce := make([]byte, minNonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	r.Header.Set(HeaderTimestamp, strconv.FormatInt(time.Now().Unix(), 10))
+	r.Header.Set(HeaderNonce, hex.EncodeToString(nonce))
+
+	body, err := readBody(r, 0)
+	if err != nil {
+		return err
+	}
+
+	sig := sign([]byte(secret), StringToSign(r, body))
+	r.Header.Set("Authorization", schemeHMAC+" "+name+":"+base64.StdEncoding.EncodeToString(sig))
+	return nil
+}
+
+// StringToSign returns the string signed for hmac authentication.  It is made up of the
+// method, request uri, timestamp, nonce and hex encoded sha256 of the body, separated by
+// newlines.
+func StringToSign(r *http.Request, body []byte) []byte {
+	bh := sha256.Sum256(body)
+	return []byte(strings.Join([]string{
+		r.Method,
+		r.URL.RequestURI(),
+		r.Header.Get(HeaderTimestamp),
+		r.Header.Get(HeaderNonce),
+		hex.EncodeToString(bh[:]),
+	}, "\n"))
+}
+
+func sign(secret, data []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// readBody reads the body replacing it with a reader over the read bytes.  Bodies larger
+// than max fail to read unless max is zero.
+func readBody(r *http.Request, max int64) ([]byte, error) {
+	if r.Body == nil {
+		return []byte{}, nil
+	}
+	if max > 0 {
+		r.Body = http.MaxBytesReader(nil, r.Body, max)
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return b, nil
+}
+
+// nonceCache remembers nonces until they expire
+type nonceCache struct {
+	mu    sync.Mutex
+	m     map[string]time.Time // nonce to expiry
+	sweep time.Time            // next time expired nonces are dropped
+}
+
+// add adds the nonce returning false if it has already been added and not yet expired.
+func (nc *nonceCache) add(nonce string, expiry time.Time) bool {
+	now := time.Now()
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	if now.After(nc.sweep) {
+		for n, e := range nc.m {
+			if now.After(e) {
+				delete(nc.m, n)
+			}
+		}
+		nc.sweep = now.Add(nonceSweepInterval)
+	}
+
+	if e, ok := nc.m[nonce]; ok && !now.After(e) {
+		return false
+	}
+	nc.m[nonce] = expiry
+	return true
+}
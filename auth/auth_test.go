@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var testPolicies = []byte(`[
+	{"name": "app", "token": "app-token", "rules": [
+		{"prefix": "app/", "rights": ["read", "write"]},
+		{"prefix": "shared/", "rights": ["read"]}
+	]},
+	{"name": "ops", "secret": "ops-secret", "rules": [
+		{"prefix": "", "rights": ["read", "admin"]}
+	]}
+]`)
+
+func TestParsePolicies(t *testing.T) {
+	policies, err := ParsePolicies(testPolicies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 2 {
+		t.Fatal("should have 2 policies")
+	}
+
+	app := policies[0]
+	if !app.Allowed([]byte("app/foo"), RightWrite) {
+		t.Error("should allow write")
+	}
+	if app.Allowed([]byte("shared/foo"), RightWrite) {
+		t.Error("should not allow write")
+	}
+	if !app.Allowed([]byte("shared/foo"), RightRead) {
+		t.Error("should allow read")
+	}
+	if app.Allowed([]byte("other"), RightRead) {
+		t.Error("should not allow read")
+	}
+	if app.Allowed([]byte("app/foo"), RightAdmin) {
+		t.Error("should not allow admin")
+	}
+	if !policies[1].Allowed([]byte("anything"), RightAdmin) {
+		t.Error("empty prefix should match all keys")
+	}
+
+	if _, err = ParsePolicies([]byte(`[{"name": "x", "rules": []}]`)); err == nil {
+		t.Error("should require credentials")
+	}
+	if _, err = ParsePolicies([]byte(`[{"name": "x", "token": "t", "rules": [{"rights": ["root"]}]}]`)); err == nil {
+		t.Error("should fail on unknown right")
+	}
+}
+
+func TestAuthenticateBearer(t *testing.T) {
+	policies, _ := ParsePolicies(testPolicies)
+	a := NewAuthenticator(policies)
+
+	r, _ := http.NewRequest("GET", "http://localhost/app/foo", nil)
+	if _, err := a.Authenticate(r); err != ErrUnauthenticated {
+		t.Fatal("should require credentials")
+	}
+
+	r.Header.Set("Authorization", "Bearer wrong")
+	if _, err := a.Authenticate(r); err != ErrUnauthenticated {
+		t.Fatal("should reject unknown token")
+	}
+
+	r.Header.Set("Authorization", "Bearer app-token")
+	p, err := a.Authenticate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "app" {
+		t.Fatal("wrong policy", p.Name)
+	}
+}
+
+func TestAuthenticateHMAC(t *testing.T) {
+	policies, _ := ParsePolicies(testPolicies)
+	a := NewAuthenticator(policies)
+
+	body := []byte("value")
+	r, _ := http.NewRequest("POST", "http://localhost/app/foo?consistency=all", bytes.NewReader(body))
+	if err := SignRequest(r, "ops", "ops-secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := a.Authenticate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "ops" {
+		t.Fatal("wrong policy", p.Name)
+	}
+	// Body should still be readable by the handler
+	if b, _ := ioutil.ReadAll(r.Body); !bytes.Equal(b, body) {
+		t.Fatal("body not preserved")
+	}
+
+	// Tampered body
+	r.Body = ioutil.NopCloser(bytes.NewReader([]byte("other")))
+	if _, err = a.Authenticate(r); err != ErrUnauthenticated {
+		t.Fatal("should reject tampered body")
+	}
+
+	// Replayed within the skew window
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if _, err = a.Authenticate(r); err != errRequestReplayed {
+		t.Fatal("should reject replayed request", err)
+	}
+
+	// Missing nonce
+	r, _ = http.NewRequest("GET", "http://localhost/app/foo", nil)
+	SignRequest(r, "ops", "ops-secret")
+	r.Header.Del(HeaderNonce)
+	if _, err = a.Authenticate(r); err != ErrUnauthenticated {
+		t.Fatal("should reject missing nonce")
+	}
+
+	// Body too large
+	a.MaxBodySize = 4
+	r, _ = http.NewRequest("POST", "http://localhost/app/foo", bytes.NewReader(body))
+	SignRequest(r, "ops", "ops-secret")
+	if _, err = a.Authenticate(r); err == nil {
+		t.Fatal("should reject large body")
+	}
+
+	// Wrong secret
+	r, _ = http.NewRequest("GET", "http://localhost/app/foo", nil)
+	SignRequest(r, "ops", "wrong")
+	if _, err = a.Authenticate(r); err != ErrUnauthenticated {
+		t.Fatal("should reject wrong secret")
+	}
+
+	// Expired
+	r, _ = http.NewRequest("GET", "http://localhost/app/foo", nil)
+	SignRequest(r, "ops", "ops-secret")
+	r.Header.Set(HeaderTimestamp, strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+	if _, err = a.Authenticate(r); err == nil {
+		t.Fatal("should reject expired request")
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	nc := &nonceCache{m: make(map[string]time.Time)}
+
+	if !nc.add("n1", time.Now().Add(time.Minute)) {
+		t.Fatal("should add")
+	}
+	if nc.add("n1", time.Now().Add(time.Minute)) {
+		t.Fatal("should be seen")
+	}
+
+	// Expired nonces are forgotten
+	nc.add("n2", time.Now().Add(-time.Second))
+	if !nc.add("n2", time.Now().Add(time.Minute)) {
+		t.Fatal("should add expired")
+	}
+}
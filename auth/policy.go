@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Right is a permission granted by a policy rule.
+type Right uint8
+
+const (
+	// RightRead allows reading keys
+	RightRead Right = 1 << iota
+	// RightWrite allows setting and deleting keys
+	RightWrite
+	// RightAdmin allows cluster administration i.e. admin routes
+	RightAdmin
+)
+
+func (r Right) String() string {
+	var s []string
+	if r&RightRead != 0 {
+		s = append(s, "read")
+	}
+	if r&RightWrite != 0 {
+		s = append(s, "write")
+	}
+	if r&RightAdmin != 0 {
+		s = append(s, "admin")
+	}
+	return strings.Join(s, ",")
+}
+
+// MarshalJSON encodes the rights as a list of names
+func (r Right) MarshalJSON() ([]byte, error) {
+	s := []string{}
+	if r != 0 {
+		s = strings.Split(r.String(), ",")
+	}
+	return json.Marshal(s)
+}
+
+// UnmarshalJSON decodes a list of right names
+func (r *Right) UnmarshalJSON(b []byte) error {
+	var names []string
+	if err := json.Unmarshal(b, &names); err != nil {
+		return err
+	}
+
+	*r = 0
+	for _, n := range names {
+		switch n {
+		case "read":
+			*r |= RightRead
+		case "write":
+			*r |= RightWrite
+		case "admin":
+			*r |= RightAdmin
+		default:
+			return fmt.Errorf("unknown right: %s", n)
+		}
+	}
+	return nil
+}
+
+// Rule grants rights to keys starting with the prefix.  An empty prefix matches all keys.
+type Rule struct {
+	Prefix string `json:"prefix"`
+	Rights Right  `json:"rights"`
+}
+
+// Policy is the identity of a client along with the rights it is granted.  A client
+// authenticates with either the bearer token or by signing requests with the secret.
+type Policy struct {
+	Name   string  `json:"name"`
+	Token  string  `json:"token,omitempty"`
+	Secret string  `json:"secret,omitempty"`
+	Rules  []*Rule `json:"rules"`
+}
+
+// Allowed returns true if any rule matching the key grants the right.
+func (p *Policy) Allowed(key []byte, right Right) bool {
+	for _, r := range p.Rules {
+		if r.Rights&right == right && strings.HasPrefix(string(key), r.Prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePolicies parses a json encoded list of policies.
+func ParsePolicies(b []byte) ([]*Policy, error) {
+	var policies []*Policy
+	if err := json.Unmarshal(b, &policies); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, p := range policies {
+		if p.Name == "" {
+			return nil, fmt.Errorf("policy name required")
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("duplicate policy: %s", p.Name)
+		}
+		if p.Token == "" && p.Secret == "" {
+			return nil, fmt.Errorf("policy %s: token or secret required", p.Name)
+		}
+		names[p.Name] = true
+	}
+
+	return policies, nil
+}
+
+// LoadPolicies loads the json encoded list of policies from the file.
+func LoadPolicies(path string) ([]*Policy, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePolicies(b)
+}
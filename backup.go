@@ -0,0 +1,253 @@
+package difuse
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"sort"
+
+	"golang.org/x/net/context"
+
+	chord "github.com/ipkg/go-chord"
+
+	"github.com/ipkg/difuse/erasure"
+	"github.com/ipkg/difuse/store"
+)
+
+// size of the chunks a snapshot is streamed in
+const snapshotChunkSize = 64 << 10
+
+// BackupRange is the range of the ring led by a vnode.
+type BackupRange struct {
+	Vnode *chord.Vnode
+	// Vnodes holding the range in the order they are tried starting with the leader
+	Replicas []*chord.Vnode
+}
+
+// MarshalJSON is a custom json encoder including the full id of the vnode
+func (br *BackupRange) MarshalJSON() ([]byte, error) {
+	replicas := make([]string, len(br.Replicas))
+	for i, vn := range br.Replicas {
+		replicas[i] = ShortVnodeID(vn)
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"id":       hex.EncodeToString(br.Vnode.Id),
+		"vnode":    ShortVnodeID(br.Vnode),
+		"replicas": replicas,
+	})
+}
+
+// BackupRanges walks the ring returning the range led by each vnode in ring order.
+func (s *Difuse) BackupRanges() ([]*BackupRange, error) {
+	vns, _ := s.transport.localVnodes()
+	if len(vns) == 0 {
+		return nil, errStoreNotFound
+	}
+
+	var (
+		out  []*BackupRange
+		seen = make(map[string]bool)
+		cur  = vns[0]
+	)
+	for {
+		vl, err := s.ring.Lookup(s.config.Chord.NumSuccessors+1, cur.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		n := len(out)
+		for _, vn := range vl {
+			if seen[vn.String()] {
+				continue
+			}
+			seen[vn.String()] = true
+
+			br, err := s.backupRange(vn)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, br)
+		}
+
+		// Back at the start of the ring
+		if len(out) == n {
+			break
+		}
+		cur = vl[len(vl)-1]
+	}
+
+	return out, nil
+}
+
+// backupRange returns the range led by the vnode along with its replicas.
+func (s *Difuse) backupRange(vn *chord.Vnode) (*BackupRange, error) {
+	vl, err := s.ring.Lookup(s.config.Chord.NumSuccessors+1, vn.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	br := &BackupRange{Vnode: vn, Replicas: []*chord.Vnode{vn}}
+	for _, v := range vl {
+		if !bytes.Equal(v.Id, vn.Id) && len(br.Replicas) < s.config.Chord.NumSuccessors {
+			br.Replicas = append(br.Replicas, v)
+		}
+	}
+	return br, nil
+}
+
+// RangeFilter returns the filter selecting the keys and blocks whose first successor is
+// the vnode.  Each key and block is looked up on the ring.  Shards are placed by key
+// rather than by hash so they are selected by the key of the inode referencing them.
+func (s *Difuse) RangeFilter(vn *chord.Vnode) *store.SnapshotFilter {
+	return &store.SnapshotFilter{
+		Key: func(key []byte) bool {
+			return s.leads(vn, key)
+		},
+		Block: func(hash, data, ref []byte) bool {
+			if erasure.IsShard(data) {
+				return ref != nil && s.leads(vn, ref)
+			}
+			return s.leads(vn, hash)
+		},
+	}
+}
+
+// SnapshotRange returns a snapshot of the keys, transactions and blocks of the range led
+// by the vnode with the id.  It is taken from the first replica of the range able to
+// serve it which is returned along with the snapshot.
+func (s *Difuse) SnapshotRange(ctx context.Context, id []byte) (io.ReadCloser, *chord.Vnode, error) {
+	br, err := s.backupRange(&chord.Vnode{Id: id})
+	if err != nil {
+		return nil, nil, err
+	}
+	// Use the leader as known to the ring
+	if len(br.Replicas) > 1 {
+		br.Replicas = br.Replicas[1:]
+	}
+
+	for _, src := range br.Replicas {
+		var rc io.ReadCloser
+		if rc, err = s.transport.Snapshot(ctx, src, br.Vnode); err == nil {
+			return rc, src, nil
+		}
+		log.Printf("action=snapshot-range status=failed range=%x src=%s msg='%v'", id[:8], ShortVnodeID(src), err)
+	}
+
+	return nil, nil, err
+}
+
+// RestoreStats holds the number of keys, transactions and blocks restored from a
+// snapshot along with the keys and blocks that failed.
+type RestoreStats struct {
+	Keys   int `json:"keys"`
+	Txs    int `json:"txs"`
+	Blocks int `json:"blocks"`
+	Shards int `json:"shards"`
+	// Error of each key that could not be restored
+	Failed map[string]string `json:"failed,omitempty"`
+	// Error of each block or shard that could not be restored by hex encoded hash
+	FailedBlocks map[string]string `json:"failed_blocks,omitempty"`
+}
+
+// Restore writes the snapshot of a range to the ring.  Blocks are written to the
+// successors of their hash and shards to the placement vnodes of their key.  The signed
+// transactions of each key are then appended to the successors of the key which apply
+// them, so the ring may have a different number of nodes than the one the snapshot was
+// taken from.  Keys already holding transactions are not restored.  Blocks and keys that
+// fail are recorded in the stats and the rest of the snapshot is still restored.
+func (s *Difuse) Restore(ctx context.Context, r io.Reader) (*RestoreStats, error) {
+	sn, err := store.ReadSnapshot(r)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &RestoreStats{Failed: map[string]string{}, FailedBlocks: map[string]string{}}
+
+	// Blocks are written first so keys only reference blocks that failed to restore
+	for k, data := range sn.Blocks {
+		hash, err := hex.DecodeString(k)
+		if err != nil {
+			stats.FailedBlocks[k] = err.Error()
+			continue
+		}
+
+		if !erasure.IsShard(data) {
+			if err = s.putBlock(ctx, hash, data, &RequestOptions{Consistency: ConsistencyAll}); err != nil {
+				log.Printf("action=restore status=failed block=%x msg='%v'", hash[:8], err)
+				stats.FailedBlocks[k] = err.Error()
+				continue
+			}
+			stats.Blocks++
+			continue
+		}
+
+		if err = s.restoreShard(ctx, []byte(sn.Refs[k]), data); err != nil {
+			log.Printf("action=restore status=failed shard=%x msg='%v'", hash[:8], err)
+			stats.FailedBlocks[k] = err.Error()
+			continue
+		}
+		stats.Shards++
+	}
+
+	keys := make([]string, 0, len(sn.Txs))
+	for k := range sn.Txs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if err = s.restoreTxs(ctx, []byte(k), sn); err != nil {
+			stats.Failed[k] = err.Error()
+			continue
+		}
+		stats.Keys++
+		stats.Txs += len(sn.Txs[k])
+	}
+
+	return stats, nil
+}
+
+// restoreTxs appends the transactions of the key to its successors waiting for the last
+// one to be applied.
+func (s *Difuse) restoreTxs(ctx context.Context, key []byte, sn *store.Snapshot) error {
+	vns, err := s.ring.Lookup(s.config.Chord.NumSuccessors, key)
+	if err != nil {
+		return err
+	}
+
+	txs := sn.Txs[string(key)]
+	for i, tx := range txs {
+		opts := &RequestOptions{Consistency: ConsistencyAll, WaitApply: i == len(txs)-1}
+		if _, err = fanout(ctx, vns, fanoutAll, func(ctx context.Context, vl ...*chord.Vnode) ([]*VnodeResponse, error) {
+			return s.transport.AppendTx(ctx, tx, opts, vl...)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreShard writes the shard to the placement vnode of its index for the key.
+func (s *Difuse) restoreShard(ctx context.Context, key, data []byte) error {
+	if len(key) == 0 {
+		return errShardUnavailable
+	}
+
+	shard, err := erasure.ParseShard(data)
+	if err != nil {
+		return err
+	}
+	vns, err := s.shardVnodes(key)
+	if err != nil {
+		return err
+	}
+	if shard.Index >= len(vns) {
+		return errTooFewHosts
+	}
+
+	_, err = writeShard(ctx, s.transport, shard, vns[shard.Index])
+	return err
+}
@@ -0,0 +1,79 @@
+package difuse
+
+import (
+	"encoding/json"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	chord "github.com/ipkg/go-chord"
+
+	"github.com/ipkg/difuse/store"
+	"github.com/ipkg/difuse/txlog"
+)
+
+// rangeStore selects the keys of the range by their first byte
+type rangeStore struct {
+	ConsistentStore
+	prefix byte
+}
+
+func (rs *rangeStore) RangeFilter(vn *chord.Vnode) *store.SnapshotFilter {
+	return &store.SnapshotFilter{Key: func(key []byte) bool { return key[0] == rs.prefix }}
+}
+
+func TestLocalTransportSnapshot(t *testing.T) {
+	kp, _ := txlog.GenerateECDSAKeypair()
+	vn := &chord.Vnode{Id: []byte("vnode-id"), Host: "host"}
+	st := store.NewMemLoggedStore(vn, kp)
+
+	lt := &localTransport{host: "host", local: localStore{vn.String(): st}, vnodes: []*chord.Vnode{vn}, cs: &rangeStore{prefix: 'a'}}
+
+	for _, k := range []string{"a", "b"} {
+		tx, _ := st.NewTx([]byte(k))
+		tx.Data = []byte{0xff}
+		tx.Sign(kp)
+		st.AppendTx(tx)
+		// Invalid tx type fails to apply but is still logged
+		st.WaitTx(context.Background(), tx.Key, tx.Hash())
+	}
+
+	rc, err := lt.Snapshot(context.Background(), vn, vn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	sn, err := store.ReadSnapshot(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sn.Txs) != 1 || len(sn.Txs["a"]) != 1 {
+		t.Fatal("should only have the txs of the range", len(sn.Txs))
+	}
+
+	if _, err = lt.Snapshot(context.Background(), &chord.Vnode{Id: []byte("other"), Host: "host"}, vn); err == nil {
+		t.Fatal("should fail without a store")
+	}
+}
+
+func TestBackupRangeJSON(t *testing.T) {
+	br := &BackupRange{
+		Vnode:    &chord.Vnode{Id: []byte{0xab, 0xcd, 1, 2, 3, 4, 5, 6, 7}, Host: "host-1"},
+		Replicas: []*chord.Vnode{{Id: []byte{0xab, 0xcd, 1, 2, 3, 4, 5, 6, 7}, Host: "host-1"}, {Id: []byte{1, 2, 3, 4, 5, 6, 7, 8}, Host: "host-2"}},
+	}
+
+	b, err := json.Marshal(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m struct {
+		ID       string   `json:"id"`
+		Replicas []string `json:"replicas"`
+	}
+	json.Unmarshal(b, &m)
+
+	if m.ID != "abcd01020304050607" || len(m.Replicas) != 2 || m.Replicas[1] != "host-2/0102030405060708" {
+		t.Fatal("wrong json", string(b))
+	}
+}
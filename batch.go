@@ -0,0 +1,320 @@
+package difuse
+
+import (
+	"encoding/json"
+	"sync"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"golang.org/x/net/context"
+
+	chord "github.com/ipkg/go-chord"
+
+	"github.com/ipkg/difuse/store"
+)
+
+const (
+	// max entries sent to a host in a single batch request
+	maxBatchEntries = 256
+	// number of keys of a batch processed concurrently by the host
+	batchConcurrency = 16
+)
+
+// BatchEntry is a key and value of a batch request or the result for the key.
+type BatchEntry struct {
+	Key   []byte
+	Value []byte
+	// Vnode that served the key.  For writes this is the leader.
+	Vnode *chord.Vnode
+	Err   error
+}
+
+// MarshalJSON encodes the key and value as strings along with the error and its code.
+func (e *BatchEntry) MarshalJSON() ([]byte, error) {
+	o := map[string]interface{}{
+		"key": string(e.Key),
+	}
+
+	if e.Value != nil {
+		o["value"] = string(e.Value)
+	}
+	if e.Vnode != nil {
+		o["vnode"] = ShortVnodeID(e.Vnode)
+	}
+	if e.Err != nil {
+		o["error"] = e.Err.Error()
+		o["code"] = ErrorCodeOf(e.Err).String()
+	}
+
+	return json.Marshal(o)
+}
+
+// newBatchResult returns the result for the key.
+func newBatchResult(key, value []byte, meta *ResponseMeta, err error) *BatchEntry {
+	be := &BatchEntry{Key: key, Value: value, Err: err}
+	if meta != nil {
+		be.Vnode = meta.Vnode
+	}
+	return be
+}
+
+// batchRequest makes a batch request for the entries to the host.
+type batchRequest func(ctx context.Context, host string, entries []*BatchEntry) ([]*BatchEntry, error)
+
+// BatchGet gets the values of the keys.  Keys are grouped by the host of their first
+// successor and read with one request per host.  It returns a result per key in the order of the
+// keys.
+func (s *Difuse) BatchGet(ctx context.Context, keys [][]byte, options ...RequestOptions) []*BatchEntry {
+	var opts *RequestOptions
+	if len(options) > 0 {
+		opts = &options[0]
+	}
+
+	entries := make([]*BatchEntry, len(keys))
+	for i, k := range keys {
+		entries[i] = &BatchEntry{Key: k}
+	}
+
+	return s.batch(ctx, entries, func(ctx context.Context, host string, entries []*BatchEntry) ([]*BatchEntry, error) {
+		return s.transport.BatchGet(ctx, host, entries, opts)
+	})
+}
+
+// BatchSet sets the keys to their values.  Keys are grouped by the host of their first
+// successor and written with one request per host.  Entries of the same key are applied in order.
+// It returns a result per entry in the order of the entries.
+func (s *Difuse) BatchSet(ctx context.Context, entries []*BatchEntry, options ...RequestOptions) []*BatchEntry {
+	var opts *RequestOptions
+	if len(options) > 0 {
+		opts = &options[0]
+	}
+
+	return s.batch(ctx, entries, func(ctx context.Context, host string, entries []*BatchEntry) ([]*BatchEntry, error) {
+		return s.transport.BatchSet(ctx, host, entries, opts)
+	})
+}
+
+// batch groups the entries by the host of the first successor of their key on the ring
+// and makes the requests to each host concurrently.  The leader is only elected by the
+// host which redirects keys it does not lead.
+func (s *Difuse) batch(ctx context.Context, entries []*BatchEntry, req batchRequest) []*BatchEntry {
+	var (
+		out    = make([]*BatchEntry, len(entries))
+		groups = make(map[string][]int)
+	)
+
+	for i, e := range entries {
+		vl, err := s.ring.Lookup(1, e.Key)
+		if err != nil {
+			out[i] = &BatchEntry{Key: e.Key, Err: err}
+			continue
+		}
+		groups[vl[0].Host] = append(groups[vl[0].Host], i)
+	}
+
+	var wg sync.WaitGroup
+	for host, idx := range groups {
+		wg.Add(1)
+		go func(host string, idx []int) {
+			defer wg.Done()
+
+			for len(idx) > 0 {
+				n := len(idx)
+				if n > maxBatchEntries {
+					n = maxBatchEntries
+				}
+
+				chunk := make([]*BatchEntry, n)
+				for j, i := range idx[:n] {
+					chunk[j] = entries[i]
+				}
+
+				rsp, err := req(ctx, host, chunk)
+				for j, i := range idx[:n] {
+					switch {
+					case err != nil:
+						out[i] = &BatchEntry{Key: entries[i].Key, Err: err}
+					case j < len(rsp):
+						out[i] = rsp[j]
+					default:
+						out[i] = &BatchEntry{Key: entries[i].Key, Err: errMissingResponse}
+					}
+				}
+
+				idx = idx[n:]
+			}
+		}(host, idx)
+	}
+	wg.Wait()
+
+	return out
+}
+
+// batchGet gets the keys of the entries from the consistent store.
+func batchGet(ctx context.Context, cs ConsistentStore, entries []*BatchEntry, options *RequestOptions) []*BatchEntry {
+	return applyBatch(entries, func(e *BatchEntry) *BatchEntry {
+		var opts []RequestOptions
+		if options != nil {
+			opts = []RequestOptions{*options}
+		}
+
+		val, meta, err := cs.Get(ctx, e.Key, opts...)
+		return newBatchResult(e.Key, val, meta, err)
+	})
+}
+
+// SetBatch sets the keys of the entries to their values storing them as Set does.  The
+// txs of the keys led by this node are appended with one request per host carrying the
+// txs of each of its vnodes.  Other keys are submitted one by one as by SetInode which
+// redirects them to their leader.  Entries of the same key are applied in order.  It
+// returns a result per entry in the order of the entries.
+func (s *Difuse) SetBatch(ctx context.Context, entries []*BatchEntry, options *RequestOptions) []*BatchEntry {
+	opts := options
+	if opts == nil {
+		opts = &RequestOptions{Consistency: ConsistencyLeader}
+	}
+
+	out := make([]*BatchEntry, len(entries))
+	switch opts.Consistency {
+	case ConsistencyLeader, ConsistencyAll:
+	default:
+		err := invalidConsistencyError(opts.Consistency)
+		for i, e := range entries {
+			out[i] = newBatchResult(e.Key, nil, nil, err)
+		}
+		return out
+	}
+
+	// A tx chains on the previous tx of its key so a key is only written once per round
+	for _, round := range batchRounds(entries) {
+		s.setBatchRound(ctx, entries, round, opts, out)
+	}
+	return out
+}
+
+// setBatchRound sets the entries at the indexes, all of distinct keys, writing their
+// results to out.
+func (s *Difuse) setBatchRound(ctx context.Context, entries []*BatchEntry, idx []int, opts *RequestOptions, out []*BatchEntry) {
+	lts := make([]*leaderTx, len(idx))
+
+	parallel(len(idx), func(j int) {
+		e := entries[idx[j]]
+		if IsReservedKey(e.Key) {
+			out[idx[j]] = newBatchResult(e.Key, nil, nil, ErrReservedKey)
+			return
+		}
+
+		inode, err := s.valueInode(ctx, e.Key, e.Value)
+		if err != nil {
+			out[idx[j]] = newBatchResult(e.Key, nil, nil, err)
+			return
+		}
+
+		fb := flatbuffers.NewBuilder(0)
+		fb.Finish(inode.Serialize(fb))
+		lt, meta, err := s.newLeaderTx(ctx, store.TxTypeSet, e.Key, fb.Bytes[fb.Head():], opts)
+		if isRetryableLeaderErr(err) {
+			meta, err = s.submitInode(ctx, store.TxTypeSet, inode, opts)
+		}
+		if err != nil || lt == nil {
+			out[idx[j]] = newBatchResult(e.Key, nil, meta, err)
+			return
+		}
+		lts[j] = lt
+	})
+
+	errs := s.appendTxs(ctx, lts, opts)
+	for j, lt := range lts {
+		if lt != nil {
+			out[idx[j]] = newBatchResult(entries[idx[j]].Key, nil, lt.meta, errs[j])
+		}
+	}
+}
+
+// batchRounds splits the indexes of the entries into rounds where round n holds the n-th
+// entry of each key.
+func batchRounds(entries []*BatchEntry) [][]int {
+	var (
+		rounds [][]int
+		seen   = make(map[string]int)
+	)
+	for i, e := range entries {
+		n := seen[string(e.Key)]
+		seen[string(e.Key)] = n + 1
+		if n == len(rounds) {
+			rounds = append(rounds, nil)
+		}
+		rounds[n] = append(rounds[n], i)
+	}
+	return rounds
+}
+
+// parallel calls fn with each index below n running up to batchConcurrency calls at a time.
+func parallel(n int, fn func(int)) {
+	w := batchConcurrency
+	if n < w {
+		w = n
+	}
+
+	ch := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(w)
+	for i := 0; i < w; i++ {
+		go func() {
+			defer wg.Done()
+			for j := range ch {
+				fn(j)
+			}
+		}()
+	}
+
+	for j := 0; j < n; j++ {
+		ch <- j
+	}
+	close(ch)
+	wg.Wait()
+}
+
+// applyBatch calls fn for each entry processing up to batchConcurrency keys at a time.
+// Entries of the same key are processed in order.  It returns the results in the order
+// of the entries.
+func applyBatch(entries []*BatchEntry, fn func(*BatchEntry) *BatchEntry) []*BatchEntry {
+	var (
+		out   = make([]*BatchEntry, len(entries))
+		keys  []string
+		byKey = make(map[string][]int)
+	)
+	for i, e := range entries {
+		k := string(e.Key)
+		if _, ok := byKey[k]; !ok {
+			keys = append(keys, k)
+		}
+		byKey[k] = append(byKey[k], i)
+	}
+
+	n := batchConcurrency
+	if len(keys) < n {
+		n = len(keys)
+	}
+
+	ch := make(chan []int)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for w := 0; w < n; w++ {
+		go func() {
+			defer wg.Done()
+			for idx := range ch {
+				for _, i := range idx {
+					out[i] = fn(entries[i])
+				}
+			}
+		}()
+	}
+
+	for _, k := range keys {
+		ch <- byKey[k]
+	}
+	close(ch)
+	wg.Wait()
+
+	return out
+}
@@ -0,0 +1,124 @@
+package difuse
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	chord "github.com/ipkg/go-chord"
+
+	"github.com/ipkg/difuse/store"
+	"github.com/ipkg/difuse/txlog"
+)
+
+func TestApplyBatch(t *testing.T) {
+	var entries []*BatchEntry
+	for i := 0; i < 100; i++ {
+		entries = append(entries, &BatchEntry{Key: []byte(fmt.Sprintf("key%d", i%40)), Value: []byte(fmt.Sprint(i))})
+	}
+
+	var (
+		mu   sync.Mutex
+		last = make(map[string]string)
+	)
+	out := applyBatch(entries, func(e *BatchEntry) *BatchEntry {
+		mu.Lock()
+		last[string(e.Key)] = string(e.Value)
+		mu.Unlock()
+		return &BatchEntry{Key: e.Key, Value: e.Value}
+	})
+
+	if len(out) != len(entries) {
+		t.Fatal("wrong number of results", len(out))
+	}
+	for i, r := range out {
+		if string(r.Value) != fmt.Sprint(i) {
+			t.Fatal("result out of order", i, string(r.Value))
+		}
+	}
+	// Entries of the same key are applied in order
+	for i := 60; i < 100; i++ {
+		if v := last[fmt.Sprintf("key%d", i%40)]; v != fmt.Sprint(i) {
+			t.Fatal("entries applied out of order", i, v)
+		}
+	}
+
+	if out = applyBatch(nil, nil); len(out) != 0 {
+		t.Fatal("should be empty")
+	}
+}
+
+func TestBatchEntryWire(t *testing.T) {
+	entries := []*BatchEntry{
+		{Key: []byte("k1"), Value: []byte("v1")},
+		{Key: []byte("k2"), Err: store.ErrKeyNotFound},
+		{Key: []byte("k3")},
+	}
+
+	out := deserializeBatchEntryList(serializeBatchEntryList(entries))
+	if len(out) != 3 {
+		t.Fatal("wrong number of entries", len(out))
+	}
+	if string(out[0].Key) != "k1" || string(out[0].Value) != "v1" || out[0].Err != nil {
+		t.Fatal("wrong entry", out[0])
+	}
+	if ErrorCodeOf(out[1].Err) != CodeNotFound || out[1].Value != nil {
+		t.Fatal("wrong error", out[1].Err)
+	}
+	if string(out[2].Key) != "k3" || out[2].Value != nil || out[2].Err != nil {
+		t.Fatal("wrong entry", out[2])
+	}
+
+	b, err := json.Marshal(out[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]string
+	json.Unmarshal(b, &m)
+	if m["key"] != "k2" || m["code"] != "not-found" {
+		t.Fatal("wrong json", string(b))
+	}
+}
+
+func TestBatchRounds(t *testing.T) {
+	entries := []*BatchEntry{
+		{Key: []byte("a")}, {Key: []byte("b")}, {Key: []byte("a")}, {Key: []byte("c")}, {Key: []byte("a")},
+	}
+
+	rounds := batchRounds(entries)
+	if len(rounds) != 3 {
+		t.Fatal("wrong number of rounds", len(rounds))
+	}
+	if fmt.Sprint(rounds) != "[[0 1 3] [2] [4]]" {
+		t.Fatal("wrong rounds", rounds)
+	}
+}
+
+func TestVnodeTxsWire(t *testing.T) {
+	kp, _ := txlog.GenerateECDSAKeypair()
+	tx1 := txlog.NewTx([]byte("k1"), txlog.ZeroHash(), []byte("v1"))
+	tx1.Sign(kp)
+	tx2 := txlog.NewTx([]byte("k2"), txlog.ZeroHash(), []byte("v2"))
+	tx2.Sign(kp)
+
+	batches := []*VnodeTxs{
+		{Vnode: &chord.Vnode{Id: []byte("vn1"), Host: "host"}, Txs: txlog.TxSlice{tx1, tx2}},
+		{Vnode: &chord.Vnode{Id: []byte("vn2"), Host: "host"}, Txs: txlog.TxSlice{tx2}},
+	}
+
+	out := deserializeVnodeTxsList(serializeVnodeTxsList(batches))
+	if len(out) != 2 {
+		t.Fatal("wrong number of batches", len(out))
+	}
+	for i, b := range out {
+		if string(b.Vnode.Id) != string(batches[i].Vnode.Id) || len(b.Txs) != len(batches[i].Txs) {
+			t.Fatal("wrong batch", i)
+		}
+		for j, tx := range b.Txs {
+			if !txlog.EqualBytes(tx.Hash(), batches[i].Txs[j].Hash()) {
+				t.Fatal("wrong tx", i, j)
+			}
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package difuse
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	chord "github.com/ipkg/go-chord"
+
+	"github.com/ipkg/difuse/erasure"
+	"github.com/ipkg/difuse/store"
+	"github.com/ipkg/difuse/txlog"
+)
+
+// time a vnode of a joining node waits for its successor to start bootstrapping it before
+// serving reads regardless
+const bootstrapTimeout = 2 * time.Minute
+
+var (
+	errBootstrapping    = errors.New("vnode bootstrapping")
+	errNotBootstrapping = errors.New("vnode not bootstrapping")
+	errNotSuccessor     = errors.New("not the successor of the vnode")
+)
+
+// bootstrapStore is the store of a vnode of a joining node.  It does not serve reads until
+// it has been bootstrapped from a snapshot of its successor.  Writes are accepted
+// throughout.
+type bootstrapStore struct {
+	VnodeStore
+
+	vn *chord.Vnode
+
+	mu      sync.Mutex
+	ready   bool
+	started bool
+	timer   *time.Timer
+	// closed once ready
+	done chan struct{}
+}
+
+func newBootstrapStore(vn *chord.Vnode, st VnodeStore, timeout time.Duration) *bootstrapStore {
+	bs := &bootstrapStore{VnodeStore: st, vn: vn, done: make(chan struct{})}
+	bs.timer = time.AfterFunc(timeout, func() {
+		if bs.setReady() {
+			log.Printf("action=bootstrap status=timeout vnode=%s", shortID(vn))
+		}
+	})
+	return bs
+}
+
+// begin stops the timeout as the bootstrap has started returning false if the store is
+// ready or already being bootstrapped.
+func (bs *bootstrapStore) begin() bool {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if bs.ready || bs.started {
+		return false
+	}
+	bs.started = true
+	bs.timer.Stop()
+	return true
+}
+
+// setReady marks the store ready to serve reads returning false if it already was.
+func (bs *bootstrapStore) setReady() bool {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	bs.timer.Stop()
+	if bs.ready {
+		return false
+	}
+	bs.ready = true
+	close(bs.done)
+	return true
+}
+
+// wait waits until the store is ready.
+func (bs *bootstrapStore) wait() {
+	<-bs.done
+}
+
+func (bs *bootstrapStore) isReady() bool {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.ready
+}
+
+// storeReady returns whether the store is serving reads.
+func storeReady(st VnodeStore) bool {
+	if bs, ok := st.(*bootstrapStore); ok {
+		return bs.isReady()
+	}
+	return true
+}
+
+// restoreBootstrap restores the snapshot into the store of a vnode being bootstrapped.  The
+// store serves reads once done whether or not the restore succeeded.  A store that is
+// ready or already being bootstrapped is not restored into.
+func restoreBootstrap(st VnodeStore, r io.Reader) error {
+	bs, ok := st.(*bootstrapStore)
+	if !ok || !bs.begin() {
+		return errNotBootstrapping
+	}
+	defer bs.setReady()
+
+	return st.Restore(r)
+}
+
+// bootstrapRoots returns the tx root of each key of the store selected by the filter.
+// Roots read before the snapshot is taken are never ahead of it.
+func bootstrapRoots(st VnodeStore, filter *store.SnapshotFilter) map[string][]byte {
+	roots := make(map[string][]byte)
+	st.IterInodes(func(key []byte, inode *store.Inode) error {
+		if filter.MatchKey(key) {
+			roots[string(key)] = inode.TxRoot()
+		}
+		return nil
+	})
+	return roots
+}
+
+// catchupFilter selects the keys written to since their roots were read along with the
+// new keys selected by the filter.
+func catchupFilter(roots map[string][]byte, filter *store.SnapshotFilter) func([]byte, *store.Inode) bool {
+	return func(key []byte, inode *store.Inode) bool {
+		root, ok := roots[string(key)]
+		return (ok || filter.MatchKey(key)) && !txlog.EqualBytes(root, inode.TxRoot())
+	}
+}
+
+// bootstrapFilter selects the data of the local vnode a new predecessor needs.  The new
+// predecessor takes the place of the local vnode in the successors of every key it held,
+// except for the keys between it and the local vnode which the local vnode still leads.
+// The range is taken from the ids rather than a lookup as other local vnodes may not see
+// the new predecessor yet.  Shards are placed by repair rather than copied.
+func bootstrapFilter(local, pred *chord.Vnode) *store.SnapshotFilter {
+	return &store.SnapshotFilter{
+		Key: func(key []byte) bool {
+			return !inRange(pred.Id, local.Id, key)
+		},
+		Block: func(hash, data, ref []byte) bool {
+			return !erasure.IsShard(data) && !inRange(pred.Id, local.Id, hash)
+		},
+	}
+}
+
+// inRange returns whether the id lies on the ring after start up to and including end.
+func inRange(start, end, id []byte) bool {
+	if bytes.Compare(start, end) < 0 {
+		return bytes.Compare(start, id) < 0 && bytes.Compare(id, end) <= 0
+	}
+	return bytes.Compare(start, id) < 0 || bytes.Compare(id, end) <= 0
+}
+
+// IsSuccessor returns whether the vnode is the first successor of the local vnode on the
+// ring.  Only the successor of a new vnode may bootstrap it.  A vnode between the local
+// vnode and its known successor is taken to be a successor the ring does not see yet.
+func (s *Difuse) IsSuccessor(local, vn *chord.Vnode) bool {
+	vl, err := s.ring.Lookup(s.config.Chord.NumSuccessors+1, local.Id)
+	if err != nil {
+		return false
+	}
+	for _, v := range vl {
+		if !bytes.Equal(v.Id, local.Id) {
+			return inRange(local.Id, v.Id, vn.Id)
+		}
+	}
+	return false
+}
+
+// leads returns whether the vnode is the first successor of the id on the ring.
+func (s *Difuse) leads(vn *chord.Vnode, id []byte) bool {
+	vl, err := s.ring.Lookup(1, id)
+	return err == nil && len(vl) > 0 && bytes.Equal(vl[0].Id, vn.Id)
+}
@@ -0,0 +1,217 @@
+package difuse
+
+import (
+	"errors"
+	"sync"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"golang.org/x/net/context"
+
+	chord "github.com/ipkg/go-chord"
+)
+
+// Chord rpc's carried by a ChordRequest
+const (
+	chordListVnodes byte = iota + 1
+	chordPing
+	chordGetPredecessor
+	chordNotify
+	chordFindSuccessors
+	chordClearPredecessor
+	chordSkipSuccessor
+)
+
+var (
+	errChordVnodeNotFound = errors.New("chord vnode not found")
+	errChordNotServed     = errors.New("chord not served")
+)
+
+// ChordTransport is a chord transport carried over the difuse rpc service.  Ring
+// maintenance shares the connections of the NetTransport and with it mutual TLS when
+// enabled.
+type ChordTransport struct {
+	net *NetTransport
+
+	mu     sync.RWMutex
+	vnodes []*chord.Vnode
+	local  map[string]chord.VnodeRPC
+}
+
+// NewChordTransport instantiates a chord transport over the network transport.  The
+// network transport serves chord rpc's for the vnodes registered to the returned
+// transport.
+func NewChordTransport(net *NetTransport) *ChordTransport {
+	ct := &ChordTransport{net: net, local: make(map[string]chord.VnodeRPC)}
+	net.chord = ct
+	return ct
+}
+
+// Register a local vnode to serve chord rpc's for.
+func (ct *ChordTransport) Register(vn *chord.Vnode, rpc chord.VnodeRPC) {
+	ct.mu.Lock()
+	if _, ok := ct.local[vn.String()]; !ok {
+		ct.vnodes = append(ct.vnodes, vn)
+	}
+	ct.local[vn.String()] = rpc
+	ct.mu.Unlock()
+}
+
+// ListVnodes returns the vnodes of the host.
+func (ct *ChordTransport) ListVnodes(host string) ([]*chord.Vnode, error) {
+	data, err := ct.call(host, &chordRequest{method: chordListVnodes})
+	if err != nil {
+		return nil, err
+	}
+	return chord.DeserializeVnodeListErr(data)
+}
+
+// Ping returns whether the vnode exists on its host.
+func (ct *ChordTransport) Ping(vn *chord.Vnode) (bool, error) {
+	data, err := ct.call(vn.Host, &chordRequest{method: chordPing, target: vn})
+	if err != nil {
+		return false, err
+	}
+	pvn, err := chord.DeserializeVnodeErr(data)
+	return pvn != nil && len(pvn.Id) > 0, err
+}
+
+// GetPredecessor returns the predecessor of the vnode.
+func (ct *ChordTransport) GetPredecessor(vn *chord.Vnode) (*chord.Vnode, error) {
+	data, err := ct.call(vn.Host, &chordRequest{method: chordGetPredecessor, target: vn})
+	if err != nil {
+		return nil, err
+	}
+	return chord.DeserializeVnodeErr(data)
+}
+
+// Notify the target vnode of self as its possible predecessor returning the successors
+// of the target.
+func (ct *ChordTransport) Notify(target, self *chord.Vnode) ([]*chord.Vnode, error) {
+	data, err := ct.call(target.Host, &chordRequest{method: chordNotify, target: target, self: self})
+	if err != nil {
+		return nil, err
+	}
+	return chord.DeserializeVnodeListErr(data)
+}
+
+// FindSuccessors returns up to n successors of the key starting the search at the vnode.
+func (ct *ChordTransport) FindSuccessors(vn *chord.Vnode, n int, key []byte) ([]*chord.Vnode, error) {
+	data, err := ct.call(vn.Host, &chordRequest{method: chordFindSuccessors, target: vn, n: n, key: key})
+	if err != nil {
+		return nil, err
+	}
+	return chord.DeserializeVnodeListErr(data)
+}
+
+// ClearPredecessor clears the predecessor of the target if it is self.
+func (ct *ChordTransport) ClearPredecessor(target, self *chord.Vnode) error {
+	data, err := ct.call(target.Host, &chordRequest{method: chordClearPredecessor, target: target, self: self})
+	if err != nil {
+		return err
+	}
+	_, err = chord.DeserializeVnodeErr(data)
+	return err
+}
+
+// SkipSuccessor has the target skip self as its successor.
+func (ct *ChordTransport) SkipSuccessor(target, self *chord.Vnode) error {
+	data, err := ct.call(target.Host, &chordRequest{method: chordSkipSuccessor, target: target, self: self})
+	if err != nil {
+		return err
+	}
+	_, err = chord.DeserializeVnodeErr(data)
+	return err
+}
+
+// call sends the chord request to the host returning the response data.
+func (ct *ChordTransport) call(host string, req *chordRequest) ([]byte, error) {
+	ctx := context.Background()
+	out, err := ct.net.getConn(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	fb := flatbuffers.NewBuilder(0)
+	fb.Finish(serializeChordRequest(fb, req))
+	payload := &chord.Payload{Data: fb.Bytes[fb.Head():]}
+
+	rctx, cancel := ct.net.rpcContext(ctx)
+	defer cancel()
+
+	resp, err := out.client.ChordServe(rctx, payload)
+	if err != nil {
+		ct.net.reapConn(rctx, out)
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// serve serves the chord request from a peer for a local vnode.  A caller naming its
+// own vnode must hold a certificate valid for the host of the vnode.
+func (ct *ChordTransport) serve(ctx context.Context, req *chordRequest) []byte {
+	if req.method == chordListVnodes {
+		ct.mu.RLock()
+		defer ct.mu.RUnlock()
+		return chord.SerializeVnodeListErr(ct.vnodes, nil)
+	}
+
+	if req.self != nil {
+		if err := verifyPeerHost(ctx, req.self.Host); err != nil {
+			return chordResponse(req.method, nil, nil, err)
+		}
+	}
+
+	if req.target == nil {
+		return chordResponse(req.method, nil, nil, errChordVnodeNotFound)
+	}
+	ct.mu.RLock()
+	rpc, ok := ct.local[req.target.String()]
+	ct.mu.RUnlock()
+	if !ok {
+		if req.method == chordPing {
+			return chord.SerializeVnodeErr(nil, nil)
+		}
+		return chordResponse(req.method, nil, nil, errChordVnodeNotFound)
+	}
+
+	var (
+		vn  *chord.Vnode
+		vl  []*chord.Vnode
+		err error
+	)
+	switch req.method {
+	case chordPing:
+		vn = req.target
+	case chordGetPredecessor:
+		vn, err = rpc.GetPredecessor()
+	case chordNotify:
+		vl, err = rpc.Notify(req.self)
+	case chordFindSuccessors:
+		vl, err = rpc.FindSuccessors(req.n, req.key)
+	case chordClearPredecessor:
+		err = rpc.ClearPredecessor(req.self)
+	case chordSkipSuccessor:
+		err = rpc.SkipSuccessor(req.self)
+	}
+
+	return chordResponse(req.method, vn, vl, err)
+}
+
+// chordResponse serializes the response of the chord rpc.  Rpc's returning successors
+// respond with a vnode list.
+func chordResponse(method byte, vn *chord.Vnode, vl []*chord.Vnode, err error) []byte {
+	switch method {
+	case chordNotify, chordFindSuccessors:
+		return chord.SerializeVnodeListErr(vl, err)
+	}
+	return chord.SerializeVnodeErr(vn, err)
+}
+
+// chordRequest is a chord rpc to a target vnode
+type chordRequest struct {
+	method byte
+	target *chord.Vnode
+	self   *chord.Vnode
+	n      int
+	key    []byte
+}
@@ -0,0 +1,507 @@
+// Package client is a native Go client for difuse.  It learns the successors and leader of
+// each key from the cluster and talks to them directly over gRPC rather than going
+// through the HTTP interface of a single node.
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/fastsha256"
+	chord "github.com/ipkg/go-chord"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ipkg/difuse"
+	"github.com/ipkg/difuse/store"
+)
+
+var (
+	errNoSeeds       = errors.New("no seed nodes")
+	errNoBlockData   = errors.New("block not found")
+	errNoBlockCipher = errors.New("block cipher not configured")
+)
+
+// Config holds the client configuration.
+type Config struct {
+	// Addresses of nodes used to learn the ring.  Other nodes are learnt as keys are
+	// looked up.
+	Seeds []string
+	// Mutual TLS used to talk to nodes.  Plaintext is used if nil.
+	TLS *difuse.TLSConfig
+	// Dial and rpc timeouts applied to requests whose context has no earlier deadline.
+	Timeouts *difuse.NetTimeouts
+
+	// Number of times a request is retried against a freshly looked up leader when the
+	// leader is unreachable, no longer the leader or still catching up.
+	Retries int
+	// Time to wait between retries to allow the ring to stabilize.
+	RetryWait time.Duration
+	// Time the leader and successors of a key are cached for.
+	LeaderTTL time.Duration
+	// Maximum number of keys whose leader is cached.
+	MaxCachedKeys int
+
+	// Codec used to compress values.
+	Compression store.Codec
+	// Encrypts values before they are stored as blocks.  This must match the cipher of
+	// the cluster to read values written by nodes.
+	BlockCipher difuse.BlockCipher
+	// Keys matching the prefix of a class are erasure coded.
+	ErasureClasses []*difuse.ErasureClass
+}
+
+// DefaultConfig returns a sane config for the seed nodes.
+func DefaultConfig(seeds ...string) *Config {
+	return &Config{
+		Seeds:         seeds,
+		Timeouts:      difuse.DefaultNetTimeouts(),
+		Retries:       3,
+		RetryWait:     500 * time.Millisecond,
+		LeaderTTL:     5 * time.Second,
+		MaxCachedKeys: 4096,
+	}
+}
+
+// Client routes requests for a key straight to its leader or successors.
+type Client struct {
+	conf  *Config
+	trans *difuse.NetTransport
+	// learnt hosts and cached key leaders
+	ring *ringCache
+}
+
+// New instantiates a new Client connecting to the seed nodes.  It returns an error if
+// none of the seeds can be reached.
+func New(conf *Config) (*Client, error) {
+	if len(conf.Seeds) == 0 {
+		return nil, errNoSeeds
+	}
+
+	trans := difuse.NewNetTransport()
+	if conf.TLS != nil {
+		certs, err := difuse.NewCertReloader(conf.TLS)
+		if err != nil {
+			return nil, err
+		}
+		trans.UseTLS(certs)
+	}
+	if conf.Timeouts != nil {
+		trans.SetTimeouts(conf.Timeouts)
+	}
+
+	c := &Client{
+		conf:  conf,
+		trans: trans,
+		ring:  newRingCache(conf.Seeds, conf.LeaderTTL, conf.MaxCachedKeys),
+	}
+
+	// Make sure the cluster is reachable
+	if _, err := c.lookup(context.Background(), []byte("_difuse/client")); err != nil {
+		trans.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close closes all connections to the cluster.
+func (c *Client) Close() error {
+	return c.trans.Close()
+}
+
+// LookupLeader returns the leader and successor vnodes for the key.
+func (c *Client) LookupLeader(ctx context.Context, key []byte) (*chord.Vnode, []*chord.Vnode, error) {
+	rt, err := c.lookup(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rt.leader, rt.vnodes, nil
+}
+
+// lookup returns the cached route for the key or looks it up trying each known host
+// until one responds.
+func (c *Client) lookup(ctx context.Context, key []byte) (*route, error) {
+	if rt, ok := c.ring.get(key); ok {
+		return rt, nil
+	}
+
+	var err error
+	for _, host := range c.ring.hosts() {
+		l, vl, _, er := c.trans.LookupLeader(ctx, host, key)
+		if er != nil {
+			err = er
+			continue
+		}
+
+		rt := &route{leader: l, vnodes: vl}
+		c.ring.set(key, rt)
+		return rt, nil
+	}
+	return nil, err
+}
+
+// Stat returns the inode for the key.  By default it uses the leader consistency.
+func (c *Client) Stat(ctx context.Context, key []byte, options ...difuse.RequestOptions) (*store.Inode, *difuse.ResponseMeta, error) {
+	opts := &difuse.RequestOptions{Consistency: difuse.ConsistencyLeader}
+	if len(options) > 0 {
+		opts = &options[0]
+	}
+
+	var (
+		rmeta = &difuse.ResponseMeta{}
+		err   error
+	)
+
+	for i := 0; i <= c.conf.Retries; i++ {
+		if i > 0 {
+			if err = c.wait(ctx); err != nil {
+				break
+			}
+		}
+
+		var rt *route
+		if rt, err = c.lookup(ctx, key); err != nil {
+			continue
+		}
+
+		var vl [][]*chord.Vnode
+		switch opts.Consistency {
+		case difuse.ConsistencyLeader:
+			vl = [][]*chord.Vnode{{rt.leader}}
+		case difuse.ConsistencyLazy:
+			vl = groupByHost(rt.vnodes)
+		default:
+			return nil, nil, fmt.Errorf("invalid consistency level: %d", opts.Consistency)
+		}
+
+		for _, vns := range vl {
+			resp, er := c.trans.Stat(ctx, key, opts, vns...)
+			if er != nil {
+				err = er
+				continue
+			}
+
+			for j, rsp := range resp {
+				if rsp.Err != nil {
+					err = rsp.Err
+					continue
+				}
+				if inode, ok := rsp.Data.(*store.Inode); ok {
+					rmeta.Vnode = vns[j]
+					return inode, rmeta, nil
+				}
+			}
+		}
+
+		if !isRetryable(err, false) {
+			break
+		}
+		c.ring.remove(key)
+	}
+
+	return nil, rmeta, err
+}
+
+// Get retrieves the value of the key.  It first gets the inode and then any blocks or
+// shards it references.
+func (c *Client) Get(ctx context.Context, key []byte, options ...difuse.RequestOptions) ([]byte, *difuse.ResponseMeta, error) {
+	inode, meta, err := c.Stat(ctx, key, options...)
+	if err != nil {
+		return nil, meta, err
+	}
+
+	var out []byte
+	switch inode.Type {
+	case store.FileInodeType:
+		out, err = c.readBlocks(ctx, inode)
+	case store.ErasureInodeType:
+		out, err = c.readShards(ctx, inode)
+	default:
+		out, err = store.Decompress(inode.Blocks[0])
+	}
+	return out, meta, err
+}
+
+// Set sets the key to the value storing it the same way a node would given the client
+// config.  It returns the leader vnode and commit token in the response meta.
+func (c *Client) Set(ctx context.Context, key, value []byte, options ...difuse.RequestOptions) (*difuse.ResponseMeta, error) {
+	if difuse.IsReservedKey(key) {
+		return nil, difuse.ErrReservedKey
+	}
+
+	cv, err := store.Compress(c.conf.Compression, value)
+	if err != nil {
+		return nil, err
+	}
+
+	var inode *store.Inode
+	if ec := difuse.MatchErasureClass(c.conf.ErasureClasses, key); ec != nil {
+		var keys [][]byte
+		if c.conf.BlockCipher != nil {
+			ct, bkey, err := c.conf.BlockCipher.Encrypt(cv)
+			if err != nil {
+				return nil, err
+			}
+			cv, keys = ct, [][]byte{bkey}
+		}
+
+		rt, err := c.lookup(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		hashes, err := difuse.WriteShards(ctx, c.trans, difuse.ShardVnodes(rt.vnodes), cv, ec)
+		if err != nil {
+			return nil, err
+		}
+		inode = store.NewErasureInode(key, int64(len(value)), hashes, keys)
+
+	} else if c.conf.BlockCipher != nil {
+		ct, bkey, err := c.conf.BlockCipher.Encrypt(cv)
+		if err != nil {
+			return nil, err
+		}
+		hsh, err := c.setBlock(ctx, ct)
+		if err != nil {
+			return nil, err
+		}
+		inode = store.NewFileInode(key, int64(len(value)), [][]byte{hsh}, [][]byte{bkey})
+
+	} else {
+		inode = store.NewKeyInodeWithValue(key, cv)
+		inode.Size = int64(len(value))
+	}
+
+	return c.submit(ctx, inode, false, options)
+}
+
+// Delete deletes the inode of the key.  It returns the deleted inode and the leader
+// vnode in the response meta.
+func (c *Client) Delete(ctx context.Context, key []byte, options ...difuse.RequestOptions) (*store.Inode, *difuse.ResponseMeta, error) {
+	if difuse.IsReservedKey(key) {
+		return nil, nil, difuse.ErrReservedKey
+	}
+
+	inode, _, err := c.Stat(ctx, key, options...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rmeta, err := c.submit(ctx, inode, true, options)
+	return inode, rmeta, err
+}
+
+// submit sends the inode to the leader of the key following redirects to a new leader.
+// Writes are only retried when they were not applied i.e. the node was unreachable,
+// not the leader or still catching up.  It returns the leader and the commit token of
+// the write.
+func (c *Client) submit(ctx context.Context, inode *store.Inode, del bool, options []difuse.RequestOptions) (*difuse.ResponseMeta, error) {
+	var opts *difuse.RequestOptions
+	if len(options) > 0 {
+		opts = &options[0]
+	}
+
+	var (
+		rmeta = &difuse.ResponseMeta{}
+		err   error
+	)
+
+	for i := 0; i <= c.conf.Retries; i++ {
+		var rt *route
+		if rt, err = c.lookup(ctx, inode.Id); err != nil {
+			if e := c.wait(ctx); e != nil {
+				return rmeta, e
+			}
+			continue
+		}
+
+		var meta *difuse.ResponseMeta
+		if del {
+			meta, err = c.trans.DeleteInode(ctx, rt.leader.Host, inode, opts)
+		} else {
+			meta, err = c.trans.SetInode(ctx, rt.leader.Host, inode, opts)
+		}
+		if rmeta = meta; rmeta == nil {
+			rmeta = &difuse.ResponseMeta{}
+		}
+		vn := rmeta.Vnode
+
+		if err == nil {
+			// The node forwards the write if the leader has changed
+			if vn != nil && vn.Host != rt.leader.Host {
+				c.ring.setLeader(inode.Id, vn)
+			}
+			return rmeta, nil
+		}
+
+		if !isRetryable(err, true) {
+			break
+		}
+
+		// Go straight to the new leader if the node returned one otherwise wait for the
+		// ring to settle and look it up again.
+		if vn != nil && vn.Host != rt.leader.Host && difuse.ErrorCodeOf(err) == difuse.CodeNotLeader {
+			c.ring.setLeader(inode.Id, vn)
+			continue
+		}
+		c.ring.remove(inode.Id)
+		if e := c.wait(ctx); e != nil {
+			return rmeta, e
+		}
+	}
+
+	return rmeta, err
+}
+
+// getBlock gets the block from the first successor of the hash that has it.
+func (c *Client) getBlock(ctx context.Context, hash []byte) ([]byte, error) {
+	rt, err := c.lookup(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	err = errNoBlockData
+	opts := &difuse.RequestOptions{Consistency: difuse.ConsistencyLazy}
+	for _, vns := range groupByHost(rt.vnodes) {
+		resp, er := c.trans.GetBlock(ctx, hash, opts, vns...)
+		if er != nil {
+			err = er
+			continue
+		}
+		for _, rsp := range resp {
+			if rsp.Err != nil {
+				err = rsp.Err
+				continue
+			}
+			if val, ok := rsp.Data.([]byte); ok {
+				return store.Decompress(val)
+			}
+		}
+	}
+	return nil, err
+}
+
+// setBlock sets the uncompressed block on all successors of its hash returning the hash.
+func (c *Client) setBlock(ctx context.Context, data []byte) ([]byte, error) {
+	sh := fastsha256.Sum256(data)
+
+	// Tags the data if it happens to look compressed or like a shard
+	cd, err := store.Compress(store.CodecNone, data)
+	if err != nil {
+		return nil, err
+	}
+
+	rt, err := c.lookup(ctx, sh[:])
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &difuse.RequestOptions{Consistency: difuse.ConsistencyAll}
+	for _, vns := range groupByHost(rt.vnodes) {
+		resp, err := c.trans.SetBlock(ctx, cd, opts, vns...)
+		if err != nil {
+			return nil, err
+		}
+		for _, rsp := range resp {
+			if rsp.Err != nil {
+				return nil, rsp.Err
+			}
+		}
+	}
+	return sh[:], nil
+}
+
+// readBlocks reads and concatenates the blocks of the inode decrypting and decompressing
+// them if the inode has keys.
+func (c *Client) readBlocks(ctx context.Context, inode *store.Inode) ([]byte, error) {
+	out := make([]byte, 0, inode.Size)
+	for i, bh := range inode.Blocks {
+		bd, err := c.getBlock(ctx, bh)
+		if err != nil {
+			return nil, err
+		}
+
+		if i < len(inode.Keys) {
+			if bd, err = c.decrypt(bd, inode.Keys[i]); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, bd...)
+	}
+	return out, nil
+}
+
+// readShards rebuilds the value of an erasure coded inode.
+func (c *Client) readShards(ctx context.Context, inode *store.Inode) ([]byte, error) {
+	rt, err := c.lookup(ctx, inode.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := difuse.ReadShards(ctx, c.trans, difuse.ShardVnodes(rt.vnodes), inode)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(inode.Keys) > 0 {
+		return c.decrypt(data, inode.Keys[0])
+	}
+	return store.Decompress(data)
+}
+
+func (c *Client) decrypt(data, key []byte) ([]byte, error) {
+	if c.conf.BlockCipher == nil {
+		return nil, errNoBlockCipher
+	}
+	pt, err := c.conf.BlockCipher.Decrypt(data, key)
+	if err != nil {
+		return nil, err
+	}
+	return store.Decompress(pt)
+}
+
+// wait waits before retrying a request.  It returns the context error if the context is
+// done first.
+func (c *Client) wait(ctx context.Context) error {
+	select {
+	case <-time.After(c.conf.RetryWait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isRetryable returns whether a request failing with the error can be retried.  Reads are
+// also retried on timeouts whereas writes are not as they may have been applied.
+func isRetryable(err error, write bool) bool {
+	if err == nil {
+		return false
+	}
+
+	if grpc.Code(err) == codes.DeadlineExceeded {
+		return !write
+	}
+
+	switch difuse.ErrorCodeOf(err) {
+	case difuse.CodeNotLeader, difuse.CodeUnavailable:
+		return true
+	}
+	return false
+}
+
+// groupByHost groups the vnodes by host preserving the order of the hosts.
+func groupByHost(vl []*chord.Vnode) [][]*chord.Vnode {
+	idx := make(map[string]int)
+	out := [][]*chord.Vnode{}
+	for _, vn := range vl {
+		i, ok := idx[vn.Host]
+		if !ok {
+			i = len(out)
+			idx[vn.Host] = i
+			out = append(out, []*chord.Vnode{})
+		}
+		out[i] = append(out[i], vn)
+	}
+	return out
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	chord "github.com/ipkg/go-chord"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ipkg/difuse"
+)
+
+func TestRingCache(t *testing.T) {
+	rc := newRingCache([]string{"seed:4624"}, 50*time.Millisecond, 2)
+
+	rt := &route{
+		leader: &chord.Vnode{Host: "a:4624"},
+		vnodes: []*chord.Vnode{{Host: "a:4624"}, {Host: "b:4624"}},
+	}
+	rc.set([]byte("key"), rt)
+
+	if _, ok := rc.get([]byte("key")); !ok {
+		t.Fatal("should be cached")
+	}
+	if len(rc.known) != 3 {
+		t.Fatal("should have learnt hosts", rc.known)
+	}
+
+	rc.setLeader([]byte("key"), &chord.Vnode{Host: "b:4624"})
+	if r, _ := rc.get([]byte("key")); r.leader.Host != "b:4624" {
+		t.Fatal("leader not updated")
+	}
+
+	// Hosts are rotated
+	if h1, h2 := rc.hosts(), rc.hosts(); h1[0] == h2[0] || len(h1) != 3 {
+		t.Fatal("hosts not rotated", h1, h2)
+	}
+
+	<-time.After(60 * time.Millisecond)
+	if _, ok := rc.get([]byte("key")); ok {
+		t.Fatal("should have expired")
+	}
+
+	rc.set([]byte("k1"), rt)
+	rc.set([]byte("k2"), rt)
+	rc.set([]byte("k3"), rt)
+	if len(rc.routes) > 2 {
+		t.Fatal("cache should be bounded")
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	if !isRetryable(&difuse.Error{Code: difuse.CodeNotLeader, Msg: difuse.ErrNotLeader.Error()}, true) {
+		t.Fatal("not leader should be retryable")
+	}
+	if !isRetryable(grpc.Errorf(codes.Unavailable, "down"), true) {
+		t.Fatal("unavailable should be retryable")
+	}
+
+	timeout := grpc.Errorf(codes.DeadlineExceeded, "timeout")
+	if isRetryable(timeout, true) || !isRetryable(timeout, false) {
+		t.Fatal("only reads should be retried on timeout")
+	}
+	if isRetryable(errors.New("key not found"), false) {
+		t.Fatal("should not be retryable")
+	}
+}
+
+func TestGroupByHost(t *testing.T) {
+	vl := []*chord.Vnode{{Host: "b"}, {Host: "a"}, {Host: "b"}}
+	g := groupByHost(vl)
+	if len(g) != 2 || g[0][0].Host != "b" || len(g[0]) != 2 || g[1][0].Host != "a" {
+		t.Fatal("wrong grouping")
+	}
+}
+
+func TestNewNoSeeds(t *testing.T) {
+	if _, err := New(DefaultConfig()); err != errNoSeeds {
+		t.Fatal("should fail without seeds")
+	}
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	chord "github.com/ipkg/go-chord"
+)
+
+// route is the leader and successor vnodes of a key.
+type route struct {
+	leader *chord.Vnode
+	vnodes []*chord.Vnode
+
+	expires time.Time
+}
+
+// ringCache caches the routes of keys and the hosts learnt from them.
+type ringCache struct {
+	mu sync.Mutex
+
+	ttl    time.Duration
+	max    int
+	routes map[string]*route
+
+	// seeds followed by learnt hosts
+	known []string
+	// index of the host to try first
+	next int
+}
+
+func newRingCache(seeds []string, ttl time.Duration, max int) *ringCache {
+	known := make([]string, len(seeds))
+	copy(known, seeds)
+	return &ringCache{ttl: ttl, max: max, routes: make(map[string]*route), known: known}
+}
+
+func (rc *ringCache) get(key []byte) (*route, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rt, ok := rc.routes[string(key)]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(rt.expires) {
+		delete(rc.routes, string(key))
+		return nil, false
+	}
+	return rt, true
+}
+
+// set caches the route and learns the hosts of its vnodes.
+func (rc *ringCache) set(key []byte, rt *route) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	// Start over rather than tracking usage when full
+	if len(rc.routes) >= rc.max {
+		rc.routes = make(map[string]*route)
+	}
+	rt.expires = time.Now().Add(rc.ttl)
+	rc.routes[string(key)] = rt
+
+	for _, vn := range append([]*chord.Vnode{rt.leader}, rt.vnodes...) {
+		rc.learn(vn.Host)
+	}
+}
+
+// setLeader updates the leader of a cached key.
+func (rc *ringCache) setLeader(key []byte, vn *chord.Vnode) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rt, ok := rc.routes[string(key)]; ok {
+		rc.routes[string(key)] = &route{leader: vn, vnodes: rt.vnodes, expires: rt.expires}
+	}
+	rc.learn(vn.Host)
+}
+
+func (rc *ringCache) remove(key []byte) {
+	rc.mu.Lock()
+	delete(rc.routes, string(key))
+	rc.mu.Unlock()
+}
+
+func (rc *ringCache) learn(host string) {
+	for _, h := range rc.known {
+		if h == host {
+			return
+		}
+	}
+	rc.known = append(rc.known, host)
+}
+
+// hosts returns the known hosts to try in order.  The starting host is rotated on each
+// call to spread lookups.
+func (rc *ringCache) hosts() []string {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	n := len(rc.known)
+	out := make([]string, n)
+	for i := range out {
+		out[i] = rc.known[(rc.next+i)%n]
+	}
+	rc.next = (rc.next + 1) % n
+	return out
+}
@@ -0,0 +1,96 @@
+// difuse-verify verifies the signed transaction chain of a key exported with
+// `difusectl export` without a running cluster.  It checks the signature of every
+// transaction, every link from the zero hash and that the merkle root of the chain
+// matches the txroot of the inode.  The first broken link is reported.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ipkg/difuse/txlog"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: difuse-verify [options] <export file|->\n\nOptions:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	signersFile := flag.String("signers", "", "File of trusted signer public keys, one per line (default the cluster keyring in the export)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	hdr, txs, err := readExport(flag.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+
+	if *signersFile != "" {
+		if hdr.Signers, err = readSigners(*signersFile); err != nil {
+			fatal(err)
+		}
+		// An empty keyring would authorize any signer
+		if len(hdr.Signers) == 0 {
+			fatal(fmt.Errorf("no signers in %s", *signersFile))
+		}
+	} else if len(hdr.Signers) == 0 {
+		fatal(fmt.Errorf("export has no signers as the cluster keyring was empty: use -signers"))
+	}
+
+	fmt.Printf("Key:     %s\nTxs:     %d\nSigners: %d\nTxRoot:  %x\n\n", hdr.Key, len(txs), len(hdr.Signers), hdr.TxRoot)
+
+	if err = txlog.VerifyChain(hdr, txs); err != nil {
+		fmt.Println("FAILED:", err)
+		os.Exit(1)
+	}
+	fmt.Println("OK")
+}
+
+func readExport(path string) (*txlog.ExportHeader, txlog.TxSlice, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	return txlog.ReadExport(bufio.NewReader(r))
+}
+
+// readSigners reads the public keys in the file skipping blank lines and comments.
+func readSigners(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys [][]byte
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, []byte(line))
+	}
+	return keys, s.Err()
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", strings.TrimSpace(err.Error()))
+	os.Exit(2)
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ctl runs commands printing the results as tables or json.
+type ctl struct {
+	client *httpClient
+	json   bool
+	out    io.Writer
+}
+
+// vnode as encoded by the node
+type vnode struct {
+	Id   []byte
+	Host string
+}
+
+func (vn *vnode) String() string {
+	if vn == nil {
+		return ""
+	}
+	id := hex.EncodeToString(vn.Id)
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return vn.Host + "/" + id
+}
+
+// printJSON prints v as indented json.
+func (c *ctl) printJSON(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(c.out, string(b))
+	return err
+}
+
+// printTable prints the rows under the header aligning the columns.
+func (c *ctl) printTable(header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+func runGet(c *ctl, args []string) error {
+	resp, err := c.client.do("GET", keyPath("/", args[0]), nil, false)
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(map[string]string{"key": args[0], "value": string(resp.body), "vnode": resp.vnode})
+	}
+	_, err = c.out.Write(resp.body)
+	return err
+}
+
+func runSet(c *ctl, args []string) error {
+	var (
+		value []byte
+		err   error
+	)
+	if len(args) > 1 {
+		value = []byte(args[1])
+	} else if value, err = ioutil.ReadAll(os.Stdin); err != nil {
+		return err
+	}
+
+	resp, err := c.client.do("POST", keyPath("/", args[0]), value, false)
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(map[string]interface{}{"key": args[0], "size": len(value), "leader": resp.vnode})
+	}
+	return c.printTable([]string{"KEY", "SIZE", "LEADER", "TIME"},
+		[][]string{{args[0], fmt.Sprint(len(value)), resp.vnode, resp.took}})
+}
+
+func runDelete(c *ctl, args []string) error {
+	resp, err := c.client.do("DELETE", keyPath("/", args[0]), nil, false)
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(map[string]string{"key": args[0], "leader": resp.vnode})
+	}
+	return c.printTable([]string{"KEY", "LEADER", "TIME"}, [][]string{{args[0], resp.vnode, resp.took}})
+}
+
+// inode as encoded by the node
+type inode struct {
+	Key       string          `json:"key"`
+	Size      int64           `json:"size"`
+	Type      string          `json:"type"`
+	TxRoot    string          `json:"txroot"`
+	Blocks    json.RawMessage `json:"blocks"`
+	Encrypted bool            `json:"encrypted,omitempty"`
+}
+
+func (ind *inode) row() []string {
+	nblks := "-"
+	var blks []interface{}
+	if json.Unmarshal(ind.Blocks, &blks) == nil && ind.Type != "key" {
+		nblks = fmt.Sprint(len(blks))
+	}
+	return []string{ind.Key, ind.Type, fmt.Sprint(ind.Size), nblks, fmt.Sprint(ind.Encrypted), ind.TxRoot}
+}
+
+var inodeHeader = []string{"KEY", "TYPE", "SIZE", "BLOCKS", "ENCRYPTED", "TXROOT"}
+
+func runStat(c *ctl, args []string) error {
+	var ind inode
+	if err := c.client.doJSON("GET", keyPath("/stat/", args[0]), nil, false, &ind); err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(&ind)
+	}
+	return c.printTable(inodeHeader, [][]string{ind.row()})
+}
+
+func runLeader(c *ctl, args []string) error {
+	var resp struct {
+		Leader *vnode   `json:"leader"`
+		Vnodes []*vnode `json:"vnodes"`
+	}
+	if err := c.client.doJSON("GET", keyPath("/leader/", args[0]), nil, true, &resp); err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(&resp)
+	}
+
+	rows := [][]string{{"leader", resp.Leader.String()}}
+	for _, vn := range resp.Vnodes {
+		rows = append(rows, []string{"successor", vn.String()})
+	}
+	return c.printTable([]string{"ROLE", "VNODE"}, rows)
+}
+
+// vnodeResponse as encoded by the node
+type vnodeResponse struct {
+	Vnode string          `json:"vnode"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error string          `json:"error,omitempty"`
+	Code  string          `json:"code,omitempty"`
+}
+
+func runLocate(c *ctl, args []string) error {
+	var path string
+	switch args[0] {
+	case "inode":
+		path = keyPath("/locate/inode/", args[1])
+	case "tx":
+		path = keyPath("/locate/tx/last/", args[1])
+	default:
+		return fmt.Errorf("unknown locate type: %s", args[0])
+	}
+
+	var resps []*vnodeResponse
+	if err := c.client.doJSON("GET", path, nil, true, &resps); err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(resps)
+	}
+
+	rows := make([][]string, len(resps))
+	for i, r := range resps {
+		if r.Error != "" {
+			rows[i] = []string{r.Vnode, fmt.Sprintf("error (%s): %s", r.Code, r.Error)}
+			continue
+		}
+
+		switch args[0] {
+		case "inode":
+			var ind inode
+			json.Unmarshal(r.Data, &ind)
+			rows[i] = []string{r.Vnode, fmt.Sprintf("type=%s size=%d txroot=%s", ind.Type, ind.Size, ind.TxRoot)}
+		default:
+			var tx struct {
+				ID   string `json:"id"`
+				Prev string `json:"prev"`
+			}
+			json.Unmarshal(r.Data, &tx)
+			rows[i] = []string{r.Vnode, fmt.Sprintf("id=%s prev=%s", tx.ID, tx.Prev)}
+		}
+	}
+	return c.printTable([]string{"VNODE", args[0]}, rows)
+}
+
+func runHistory(c *ctl, args []string) error {
+	var txs []*struct {
+		ID     string `json:"id"`
+		Prev   string `json:"prev"`
+		Op     string `json:"op"`
+		Signer string `json:"signer"`
+	}
+	if err := c.client.doJSON("GET", keyPath("/history/", args[0]), nil, true, &txs); err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(txs)
+	}
+
+	rows := make([][]string, len(txs))
+	for i, tx := range txs {
+		rows[i] = []string{fmt.Sprint(i), tx.Op, tx.ID, tx.Prev, tx.Signer}
+	}
+	return c.printTable([]string{"#", "OP", "ID", "PREV", "SIGNER"}, rows)
+}
+
+// runExport writes the export of the key to the file.
+func runExport(c *ctl, args []string) error {
+	rc, _, _, err := c.client.stream("GET", keyPath("/export/", args[0]), nil, true)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	f, err := os.Create(args[1])
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(f, rc)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(map[string]interface{}{"key": args[0], "path": args[1], "bytes": n})
+	}
+	return c.printTable([]string{"KEY", "PATH", "BYTES"}, [][]string{{args[0], args[1], fmt.Sprint(n)}})
+}
+
+func runList(c *ctl, args []string) error {
+	path := "/keys"
+	if len(args) > 0 {
+		path += "?prefix=" + url.QueryEscape(args[0])
+	}
+
+	var keys []string
+	if err := c.client.doJSON("GET", path, nil, true, &keys); err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(keys)
+	}
+	for _, k := range keys {
+		fmt.Fprintln(c.out, k)
+	}
+	return nil
+}
+
+// ringStatus as encoded by the node
+type ringStatus struct {
+	Host   string   `json:"host"`
+	Hosts  []string `json:"hosts"`
+	Vnodes []*struct {
+		Vnode      *vnode   `json:"vnode"`
+		Successors []*vnode `json:"successors"`
+		Keys       int      `json:"keys"`
+		Blocks     int      `json:"blocks"`
+	} `json:"vnodes"`
+}
+
+func (c *ctl) ringStatus() (*ringStatus, error) {
+	var rs ringStatus
+	err := c.client.doJSON("GET", "/ring", nil, true, &rs)
+	return &rs, err
+}
+
+func runRing(c *ctl, args []string) error {
+	rs, err := c.ringStatus()
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(rs)
+	}
+
+	fmt.Fprintf(c.out, "Host:  %s\nHosts: %s\n\n", rs.Host, strings.Join(rs.Hosts, ", "))
+
+	rows := make([][]string, len(rs.Vnodes))
+	for i, vs := range rs.Vnodes {
+		var next string
+		if len(vs.Successors) > 0 {
+			next = vs.Successors[0].String()
+		}
+		rows[i] = []string{vs.Vnode.String(), fmt.Sprint(vs.Keys), fmt.Sprint(vs.Blocks), next, fmt.Sprint(len(vs.Successors))}
+	}
+	return c.printTable([]string{"VNODE", "KEYS", "BLOCKS", "SUCCESSOR", "SUCCESSORS"}, rows)
+}
+
+func runRepair(c *ctl, args []string) error {
+	var resp struct {
+		Repaired int `json:"repaired"`
+	}
+	if err := c.client.doJSON("POST", keyPath("/repair/", args[0]), nil, true, &resp); err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(map[string]interface{}{"key": args[0], "repaired": resp.Repaired})
+	}
+	return c.printTable([]string{"KEY", "REPAIRED"}, [][]string{{args[0], fmt.Sprint(resp.Repaired)}})
+}
+
+// runSnapshot writes a snapshot of each local vnode of the node to the directory.
+func runSnapshot(c *ctl, args []string) error {
+	rs, err := c.ringStatus()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(args[0], 0755); err != nil {
+		return err
+	}
+
+	rows := make([][]string, len(rs.Vnodes))
+	for i, vs := range rs.Vnodes {
+		id := hex.EncodeToString(vs.Vnode.Id)
+		path := filepath.Join(args[0], id+".snap")
+
+		n, err := c.snapshot(id, path)
+		if err != nil {
+			return err
+		}
+		rows[i] = []string{vs.Vnode.String(), path, fmt.Sprint(n)}
+	}
+
+	if c.json {
+		out := make([]map[string]string, len(rows))
+		for i, r := range rows {
+			out[i] = map[string]string{"vnode": r[0], "path": r[1], "bytes": r[2]}
+		}
+		return c.printJSON(out)
+	}
+	return c.printTable([]string{"VNODE", "PATH", "BYTES"}, rows)
+}
+
+func (c *ctl) snapshot(id, path string) (int64, error) {
+	rc, _, _, err := c.client.stream("GET", "/snapshot/"+id, nil, true)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(f, rc)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	return n, err
+}
+
+// backupRange as encoded by the node
+type backupRange struct {
+	ID       string   `json:"id"`
+	Vnode    string   `json:"vnode"`
+	Replicas []string `json:"replicas"`
+}
+
+// backupFile is the snapshot of a range in a backup directory.
+type backupFile struct {
+	ID    string `json:"id"`
+	Vnode string `json:"vnode"`
+	// Replica the snapshot was taken from
+	Source string `json:"source"`
+	File   string `json:"file"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// backupManifest describes the snapshot of each range of a backup directory.
+type backupManifest struct {
+	Created time.Time     `json:"created"`
+	Ranges  []*backupFile `json:"ranges"`
+}
+
+const manifestFile = "manifest.json"
+
+// runBackup writes a snapshot of every range of the ring to the directory along with a
+// manifest.
+func runBackup(c *ctl, args []string) error {
+	var ranges []*backupRange
+	if err := c.client.doJSON("GET", "/backup", nil, true, &ranges); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(args[0], 0755); err != nil {
+		return err
+	}
+
+	m := &backupManifest{Created: time.Now().UTC()}
+	for _, br := range ranges {
+		file := br.ID + ".snap"
+		src, n, sum, err := c.backupRange(br.ID, filepath.Join(args[0], file))
+		if err != nil {
+			return fmt.Errorf("range %s: %v", br.Vnode, err)
+		}
+
+		m.Ranges = append(m.Ranges, &backupFile{ID: br.ID, Vnode: br.Vnode, Source: src, File: file, Bytes: n, SHA256: sum})
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(filepath.Join(args[0], manifestFile), b, 0644); err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(m)
+	}
+	rows := make([][]string, len(m.Ranges))
+	for i, r := range m.Ranges {
+		rows[i] = []string{r.Vnode, r.Source, r.File, fmt.Sprint(r.Bytes)}
+	}
+	return c.printTable([]string{"RANGE", "SOURCE", "FILE", "BYTES"}, rows)
+}
+
+// backupRange writes the snapshot of the range to the path returning the vnode it was
+// taken from, its size and sha256.
+func (c *ctl) backupRange(id, path string) (string, int64, string, error) {
+	rc, src, _, err := c.client.stream("GET", "/backup/"+id, nil, true)
+	if err != nil {
+		return "", 0, "", err
+	}
+	defer rc.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), rc)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	return src, n, hex.EncodeToString(h.Sum(nil)), err
+}
+
+// runRestore writes every snapshot in the manifest of the backup directory to the ring
+// after checking its sha256.
+func runRestore(c *ctl, args []string) error {
+	b, err := ioutil.ReadFile(filepath.Join(args[0], manifestFile))
+	if err != nil {
+		return err
+	}
+	var m backupManifest
+	if err = json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+
+	// restore stats as encoded by the node
+	type restoreStats struct {
+		Keys   int               `json:"keys"`
+		Txs    int               `json:"txs"`
+		Blocks int               `json:"blocks"`
+		Shards int               `json:"shards"`
+		Failed map[string]string `json:"failed"`
+
+		FailedBlocks map[string]string `json:"failed_blocks"`
+	}
+
+	var (
+		out          = make(map[string]*restoreStats, len(m.Ranges))
+		rows         = make([][]string, len(m.Ranges))
+		failed       int
+		failedBlocks int
+	)
+	for i, r := range m.Ranges {
+		data, err := ioutil.ReadFile(filepath.Join(args[0], r.File))
+		if err != nil {
+			return err
+		}
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != r.SHA256 {
+			return fmt.Errorf("%s: sha256 mismatch", r.File)
+		}
+
+		var st restoreStats
+		if err = c.client.doJSON("POST", "/restore", data, true, &st); err != nil {
+			return fmt.Errorf("%s: %v", r.File, err)
+		}
+		out[r.Vnode] = &st
+		failed += len(st.Failed)
+		failedBlocks += len(st.FailedBlocks)
+
+		rows[i] = []string{r.Vnode, fmt.Sprint(st.Keys), fmt.Sprint(st.Txs), fmt.Sprint(st.Blocks), fmt.Sprint(st.Shards), fmt.Sprint(len(st.Failed)), fmt.Sprint(len(st.FailedBlocks))}
+	}
+
+	if c.json {
+		err = c.printJSON(out)
+	} else {
+		err = c.printTable([]string{"RANGE", "KEYS", "TXS", "BLOCKS", "SHARDS", "FAILED", "FAILED BLOCKS"}, rows)
+	}
+	if err == nil && (failed > 0 || failedBlocks > 0) {
+		err = fmt.Errorf("%d keys and %d blocks not restored", failed, failedBlocks)
+	}
+	return err
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testNode serves canned responses for the routes used by the commands.
+func testNode() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/key", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vnode", "127.0.0.1:9090/0102")
+		w.Header().Set("Response-Time", "1ms")
+		if r.Method == "GET" {
+			w.Write([]byte("value"))
+		}
+	})
+	mux.HandleFunc("/stat/key", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"key": "key", "size": 5, "type": "key", "txroot": "abcd", "blocks": []}`))
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("prefix") == "app/" {
+			w.Write([]byte(`["app/a", "app/b"]`))
+			return
+		}
+		w.Write([]byte(`["app/a", "app/b", "key"]`))
+	})
+	mux.HandleFunc("/locate/inode/key", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"vnode": "a", "data": {"type": "key", "size": 5, "txroot": "abcd"}},
+			{"vnode": "b", "error": "not found", "code": "NotFound"}]`))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestCommandsOutput(t *testing.T) {
+	ts := testNode()
+	defer ts.Close()
+
+	cfg := &config{Addr: ts.URL}
+	cfg.normalize()
+	hc, err := newHTTPClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		run  func(*ctl, []string) error
+		args []string
+		// expected table output and json object or array
+		table string
+		json  string
+	}{
+		{
+			name:  "get",
+			run:   runGet,
+			args:  []string{"key"},
+			table: "value",
+			json:  `{"key": "key", "value": "value", "vnode": "127.0.0.1:9090/0102"}`,
+		},
+		{
+			name:  "set",
+			run:   runSet,
+			args:  []string{"key", "value"},
+			table: "KEY  SIZE  LEADER               TIME\nkey  5     127.0.0.1:9090/0102  1ms\n",
+			json:  `{"key": "key", "size": 5, "leader": "127.0.0.1:9090/0102"}`,
+		},
+		{
+			name:  "delete",
+			run:   runDelete,
+			args:  []string{"key"},
+			table: "KEY  LEADER               TIME\nkey  127.0.0.1:9090/0102  1ms\n",
+			json:  `{"key": "key", "leader": "127.0.0.1:9090/0102"}`,
+		},
+		{
+			name:  "stat",
+			run:   runStat,
+			args:  []string{"key"},
+			table: "KEY  TYPE  SIZE  BLOCKS  ENCRYPTED  TXROOT\nkey  key   5     -       false      abcd\n",
+			json:  `{"key": "key", "size": 5, "type": "key", "txroot": "abcd", "blocks": []}`,
+		},
+		{
+			name:  "list",
+			run:   runList,
+			table: "app/a\napp/b\nkey\n",
+			json:  `["app/a", "app/b", "key"]`,
+		},
+		{
+			name:  "list prefix",
+			run:   runList,
+			args:  []string{"app/"},
+			table: "app/a\napp/b\n",
+			json:  `["app/a", "app/b"]`,
+		},
+		{
+			name:  "locate",
+			run:   runLocate,
+			args:  []string{"inode", "key"},
+			table: "VNODE  inode\na      type=key size=5 txroot=abcd\nb      error (NotFound): not found\n",
+			json: `[{"vnode": "a", "data": {"type": "key", "size": 5, "txroot": "abcd"}},
+				{"vnode": "b", "error": "not found", "code": "NotFound"}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err = tt.run(&ctl{client: hc, out: &buf}, tt.args); err != nil {
+			t.Fatal(tt.name, err)
+		}
+		if buf.String() != tt.table {
+			t.Errorf("%s: table want=%q have=%q", tt.name, tt.table, buf.String())
+		}
+
+		buf.Reset()
+		if err = tt.run(&ctl{client: hc, json: true, out: &buf}, tt.args); err != nil {
+			t.Fatal(tt.name, err)
+		}
+		var want, have interface{}
+		if err = json.Unmarshal([]byte(tt.json), &want); err != nil {
+			t.Fatal(tt.name, err)
+		}
+		if err = json.Unmarshal(buf.Bytes(), &have); err != nil {
+			t.Fatalf("%s: invalid json %q: %v", tt.name, buf.String(), err)
+		}
+		wb, _ := json.Marshal(want)
+		hb, _ := json.Marshal(have)
+		if !bytes.Equal(wb, hb) {
+			t.Errorf("%s: json want=%s have=%s", tt.name, wb, hb)
+		}
+	}
+}
+
+func TestCommandsErrors(t *testing.T) {
+	ts := testNode()
+	defer ts.Close()
+
+	cfg := &config{Addr: ts.URL}
+	cfg.normalize()
+	hc, _ := newHTTPClient(cfg)
+	c := &ctl{client: hc, out: &bytes.Buffer{}}
+
+	if err := runLocate(c, []string{"block", "key"}); err == nil || !strings.Contains(err.Error(), "unknown locate type") {
+		t.Error("should fail with unknown locate type", err)
+	}
+	if err := runStat(c, []string{"missing"}); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Error("should fail with not found", err)
+	}
+}
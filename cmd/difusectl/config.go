@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	defaultAddr = "http://127.0.0.1:9090"
+
+	envAddr       = "DIFUSE_ADDR"
+	envAdminAddr  = "DIFUSE_ADMIN_ADDR"
+	envToken      = "DIFUSE_TOKEN"
+	envHMACName   = "DIFUSE_HMAC_NAME"
+	envHMACSecret = "DIFUSE_HMAC_SECRET"
+	envCAFile     = "DIFUSE_CA_FILE"
+	envConfig     = "DIFUSE_CONFIG"
+)
+
+// config holds the cluster address and auth settings.  Settings are read from the config
+// file and overridden by the environment followed by flags.
+type config struct {
+	// HTTP address of a node
+	Addr string `json:"addr"`
+	// HTTP address serving admin routes if served separately
+	AdminAddr string `json:"admin_addr"`
+
+	// Bearer token
+	Token string `json:"token"`
+	// Policy name and secret used to sign requests
+	HMACName   string `json:"hmac_name"`
+	HMACSecret string `json:"hmac_secret"`
+
+	// CA used to verify nodes serving HTTP over TLS
+	CAFile string `json:"ca_file"`
+}
+
+// defaultConfigPath returns the config file path from the environment or the default in
+// the home directory.
+func defaultConfigPath() string {
+	if p := os.Getenv(envConfig); p != "" {
+		return p
+	}
+	return filepath.Join(os.Getenv("HOME"), ".difusectl.json")
+}
+
+// loadConfig loads the config file if it exists and applies the environment.
+func loadConfig(path string) (*config, error) {
+	cfg := &config{}
+
+	b, err := ioutil.ReadFile(path)
+	if err == nil {
+		if err = json.Unmarshal(b, cfg); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for env, v := range map[string]*string{
+		envAddr:       &cfg.Addr,
+		envAdminAddr:  &cfg.AdminAddr,
+		envToken:      &cfg.Token,
+		envHMACName:   &cfg.HMACName,
+		envHMACSecret: &cfg.HMACSecret,
+		envCAFile:     &cfg.CAFile,
+	} {
+		if ev := os.Getenv(env); ev != "" {
+			*v = ev
+		}
+	}
+
+	return cfg, nil
+}
+
+// normalize fills in defaults and adds the scheme to addresses.
+func (cfg *config) normalize() {
+	if cfg.Addr == "" {
+		cfg.Addr = defaultAddr
+	}
+	if cfg.AdminAddr == "" {
+		cfg.AdminAddr = cfg.Addr
+	}
+	cfg.Addr = withScheme(cfg.Addr)
+	cfg.AdminAddr = withScheme(cfg.AdminAddr)
+}
+
+func withScheme(addr string) string {
+	if strings.HasPrefix(addr, "http://") || strings.HasPrefix(addr, "https://") {
+		return strings.TrimSuffix(addr, "/")
+	}
+	return "http://" + strings.TrimSuffix(addr, "/")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ipkg/difuse/auth"
+)
+
+// httpClient makes authenticated requests to the HTTP interface of a node.
+type httpClient struct {
+	cfg *config
+	hc  *http.Client
+}
+
+func newHTTPClient(cfg *config) (*httpClient, error) {
+	tr := &http.Transport{}
+	if cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", cfg.CAFile)
+		}
+		tr.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &httpClient{cfg: cfg, hc: &http.Client{Transport: tr, Timeout: 60 * time.Second}}, nil
+}
+
+// response is a successful response.
+type response struct {
+	body  []byte
+	vnode string
+	took  string
+}
+
+// do makes the request to the data address or the admin address if admin is set.  Non
+// 2xx responses are returned as errors.
+func (c *httpClient) do(method, path string, body []byte, admin bool) (*response, error) {
+	rc, vnode, took, err := c.stream(method, path, body, admin)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	return &response{body: b, vnode: vnode, took: took}, nil
+}
+
+// doJSON makes the request decoding the json response into v.
+func (c *httpClient) doJSON(method, path string, body []byte, admin bool, v interface{}) error {
+	resp, err := c.do(method, path, body, admin)
+	if err != nil {
+		return err
+	}
+	if len(resp.body) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resp.body, v)
+}
+
+// stream makes the request returning the response body which must be closed.
+func (c *httpClient) stream(method, path string, body []byte, admin bool) (io.ReadCloser, string, string, error) {
+	addr := c.cfg.Addr
+	if admin {
+		addr = c.cfg.AdminAddr
+	}
+
+	req, err := http.NewRequest(method, addr+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	switch {
+	case c.cfg.HMACName != "":
+		err = auth.SignRequest(req, c.cfg.HMACName, c.cfg.HMACSecret)
+	case c.cfg.Token != "":
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, "", "", fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+
+	return resp.Body, resp.Header.Get("Vnode"), resp.Header.Get("Response-Time"), nil
+}
+
+// keyPath escapes the key for use in a path.
+func keyPath(prefix, key string) string {
+	u := url.URL{Path: key}
+	return prefix + u.EscapedPath()
+}
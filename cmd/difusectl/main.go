@@ -0,0 +1,162 @@
+// difusectl is a command line tool to read and write keys and administer a difuse cluster
+// through the HTTP interface of a node.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// command is a difusectl subcommand.
+type command struct {
+	usage string
+	desc  string
+	// minimum number of arguments
+	nargs int
+	run   func(ctl *ctl, args []string) error
+}
+
+var commands = map[string]*command{
+	"get":      {usage: "get <key>", desc: "Get the value of a key", nargs: 1, run: runGet},
+	"set":      {usage: "set <key> [value]", desc: "Set a key to the value or stdin", nargs: 1, run: runSet},
+	"delete":   {usage: "delete <key>", desc: "Delete a key", nargs: 1, run: runDelete},
+	"stat":     {usage: "stat <key>", desc: "Show the inode of a key", nargs: 1, run: runStat},
+	"leader":   {usage: "leader <key>", desc: "Show the leader and successors of a key", nargs: 1, run: runLeader},
+	"locate":   {usage: "locate <inode|tx> <key>", desc: "Show the inode or last tx of a key on each replica", nargs: 2, run: runLocate},
+	"history":  {usage: "history <key>", desc: "Show the transactions of a key", nargs: 1, run: runHistory},
+	"export":   {usage: "export <key> <file>", desc: "Export the signed transactions of a key for difuse-verify", nargs: 2, run: runExport},
+	"list":     {usage: "list [prefix]", desc: "List the keys held by the node", run: runList},
+	"ring":     {usage: "ring", desc: "Show the local vnodes of the node and their successors", run: runRing},
+	"repair":   {usage: "repair <key>", desc: "Regenerate lost shards of an erasure coded key", nargs: 1, run: runRepair},
+	"backup":   {usage: "backup <dir>", desc: "Write a snapshot of every range of the ring and a manifest to the directory", nargs: 1, run: runBackup},
+	"restore":  {usage: "restore <dir>", desc: "Restore the ranges of a backup directory to the ring", nargs: 1, run: runRestore},
+	"snapshot": {usage: "snapshot <dir>", desc: "Write a snapshot of each local vnode of the node to the directory", nargs: 1, run: runSnapshot},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: difusectl [options] <command> [args]\n\nCommands:\n")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-26s %s\n", commands[name].usage, commands[name].desc)
+	}
+
+	fmt.Fprintf(os.Stderr, "\nOptions:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, `
+Settings are read from the config file, then the environment (%s, %s,
+%s, %s, %s, %s) and then the options.
+`, envAddr, envAdminAddr, envToken, envHMACName, envHMACSecret, envCAFile)
+}
+
+// usageError is returned by parseArgs for an invalid command line.  It is the usage of
+// cmd when the arguments of a command are missing.
+type usageError struct {
+	msg string
+	cmd *command
+}
+
+func (e *usageError) Error() string {
+	if e.cmd != nil {
+		return "Usage: difusectl " + e.cmd.usage
+	}
+	return e.msg
+}
+
+// invocation is a parsed command line.
+type invocation struct {
+	cmd  *command
+	args []string
+	cfg  *config
+	json bool
+}
+
+// parseArgs parses the command line loading the config and applying the options over it.
+func parseArgs(fs *flag.FlagSet, argv []string) (*invocation, error) {
+	var (
+		cfgPath   = fs.String("config", defaultConfigPath(), "Config file")
+		addr      = fs.String("addr", "", "HTTP address of a node (default "+defaultAddr+")")
+		adminAddr = fs.String("admin-addr", "", "HTTP address serving admin routes if separate")
+		token     = fs.String("token", "", "Bearer token")
+		output    = fs.String("o", "table", "Output format [table|json]")
+	)
+	if err := fs.Parse(argv); err != nil {
+		return nil, err
+	}
+
+	if fs.NArg() == 0 {
+		return nil, &usageError{}
+	}
+
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		return nil, &usageError{msg: "unknown command: " + fs.Arg(0)}
+	}
+	args := fs.Args()[1:]
+	if len(args) < cmd.nargs {
+		return nil, &usageError{cmd: cmd}
+	}
+
+	if *output != "table" && *output != "json" {
+		return nil, fmt.Errorf("unsupported output format: %s", *output)
+	}
+
+	cfg, err := loadConfig(*cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	if *addr != "" {
+		cfg.Addr = *addr
+	}
+	if *adminAddr != "" {
+		cfg.AdminAddr = *adminAddr
+	}
+	if *token != "" {
+		cfg.Token = *token
+	}
+	cfg.normalize()
+
+	return &invocation{cmd: cmd, args: args, cfg: cfg, json: *output == "json"}, nil
+}
+
+func main() {
+	flag.Usage = usage
+
+	inv, err := parseArgs(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		if ue, ok := err.(*usageError); ok {
+			if ue.cmd != nil {
+				fmt.Fprintln(os.Stderr, ue)
+			} else {
+				if ue.msg != "" {
+					fmt.Fprintf(os.Stderr, "%s\n\n", ue.msg)
+				}
+				usage()
+			}
+			os.Exit(2)
+		}
+		fatal(err)
+	}
+
+	hc, err := newHTTPClient(inv.cfg)
+	if err != nil {
+		fatal(err)
+	}
+
+	ctl := &ctl{client: hc, json: inv.json, out: os.Stdout}
+	if err = inv.cmd.run(ctl, inv.args); err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", strings.TrimSpace(err.Error()))
+	os.Exit(1)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var configEnv = []string{envAddr, envAdminAddr, envToken, envHMACName, envHMACSecret, envCAFile, envConfig}
+
+// setEnv sets the environment clearing the other config variables and returns a func
+// restoring it.
+func setEnv(env map[string]string) func() {
+	old := map[string]string{}
+	for _, k := range configEnv {
+		old[k] = os.Getenv(k)
+		os.Setenv(k, env[k])
+	}
+	return func() {
+		for k, v := range old {
+			os.Setenv(k, v)
+		}
+	}
+}
+
+func writeConfig(t *testing.T, dir string) string {
+	path := filepath.Join(dir, "difusectl.json")
+	b := []byte(`{"addr": "file:9090", "admin_addr": "file:9091", "token": "file-token", "hmac_name": "file-name"}`)
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func parse(argv ...string) (*invocation, error) {
+	fs := flag.NewFlagSet("difusectl", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	return parseArgs(fs, argv)
+}
+
+func TestParseArgsPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "difusectl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeConfig(t, dir)
+
+	tests := []struct {
+		name  string
+		env   map[string]string
+		argv  []string
+		addr  string
+		admin string
+		token string
+		hmac  string
+	}{
+		{
+			name:  "defaults",
+			env:   map[string]string{envConfig: filepath.Join(dir, "missing.json")},
+			argv:  []string{"ring"},
+			addr:  defaultAddr,
+			admin: defaultAddr,
+		},
+		{
+			name:  "file",
+			argv:  []string{"-config", path, "ring"},
+			addr:  "http://file:9090",
+			admin: "http://file:9091",
+			token: "file-token",
+			hmac:  "file-name",
+		},
+		{
+			name:  "file from env",
+			env:   map[string]string{envConfig: path},
+			argv:  []string{"ring"},
+			addr:  "http://file:9090",
+			admin: "http://file:9091",
+			token: "file-token",
+			hmac:  "file-name",
+		},
+		{
+			name:  "env over file",
+			env:   map[string]string{envConfig: path, envAddr: "https://env:9090/", envToken: "env-token"},
+			argv:  []string{"ring"},
+			addr:  "https://env:9090",
+			admin: "http://file:9091",
+			token: "env-token",
+			hmac:  "file-name",
+		},
+		{
+			name:  "flags over env",
+			env:   map[string]string{envConfig: path, envAddr: "env:9090", envAdminAddr: "env:9091", envToken: "env-token"},
+			argv:  []string{"-addr", "flag:9090", "-admin-addr", "flag:9091", "-token", "flag-token", "ring"},
+			addr:  "http://flag:9090",
+			admin: "http://flag:9091",
+			token: "flag-token",
+			hmac:  "file-name",
+		},
+		{
+			name:  "admin defaults to addr",
+			env:   map[string]string{envConfig: filepath.Join(dir, "missing.json")},
+			argv:  []string{"-addr", "flag:9090", "ring"},
+			addr:  "http://flag:9090",
+			admin: "http://flag:9090",
+		},
+	}
+
+	for _, tt := range tests {
+		restore := setEnv(tt.env)
+		inv, err := parse(tt.argv...)
+		restore()
+		if err != nil {
+			t.Fatal(tt.name, err)
+		}
+
+		cfg := inv.cfg
+		if cfg.Addr != tt.addr {
+			t.Errorf("%s: addr want=%s have=%s", tt.name, tt.addr, cfg.Addr)
+		}
+		if cfg.AdminAddr != tt.admin {
+			t.Errorf("%s: admin addr want=%s have=%s", tt.name, tt.admin, cfg.AdminAddr)
+		}
+		if cfg.Token != tt.token {
+			t.Errorf("%s: token want=%s have=%s", tt.name, tt.token, cfg.Token)
+		}
+		if cfg.HMACName != tt.hmac {
+			t.Errorf("%s: hmac name want=%s have=%s", tt.name, tt.hmac, cfg.HMACName)
+		}
+	}
+}
+
+func TestParseArgsInvalidConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "difusectl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "difusectl.json")
+	if err = ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	defer setEnv(nil)()
+	if _, err = parse("-config", path, "ring"); err == nil {
+		t.Fatal("should fail")
+	}
+}
+
+func TestParseArgsCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "difusectl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer setEnv(map[string]string{envConfig: filepath.Join(dir, "missing.json")})()
+
+	tests := []struct {
+		argv  []string
+		cmd   string
+		args  []string
+		json  bool
+		usage bool
+		err   bool
+	}{
+		{argv: []string{}, usage: true},
+		{argv: []string{"-o", "json"}, usage: true},
+		{argv: []string{"unknown"}, usage: true},
+		{argv: []string{"get"}, usage: true},
+		{argv: []string{"locate", "inode"}, usage: true},
+		{argv: []string{"-bogus", "get", "key"}, err: true},
+		{argv: []string{"-o", "yaml", "get", "key"}, err: true},
+		{argv: []string{"get", "key"}, cmd: "get", args: []string{"key"}},
+		{argv: []string{"-o", "json", "get", "key"}, cmd: "get", args: []string{"key"}, json: true},
+		{argv: []string{"-o", "table", "set", "key", "value"}, cmd: "set", args: []string{"key", "value"}},
+		{argv: []string{"locate", "tx", "key"}, cmd: "locate", args: []string{"tx", "key"}},
+		{argv: []string{"list"}, cmd: "list", args: []string{}},
+		{argv: []string{"list", "app/"}, cmd: "list", args: []string{"app/"}},
+		// options after the command are arguments
+		{argv: []string{"get", "-o", "json"}, cmd: "get", args: []string{"-o", "json"}},
+	}
+
+	for _, tt := range tests {
+		inv, err := parse(tt.argv...)
+
+		if tt.usage || tt.err {
+			if err == nil {
+				t.Errorf("%v: should fail", tt.argv)
+				continue
+			}
+			if _, ok := err.(*usageError); ok != tt.usage {
+				t.Errorf("%v: usage error want=%v have=%v", tt.argv, tt.usage, ok)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%v: %v", tt.argv, err)
+			continue
+		}
+		if inv.cmd != commands[tt.cmd] {
+			t.Errorf("%v: wrong command", tt.argv)
+		}
+		if len(inv.args) != len(tt.args) {
+			t.Errorf("%v: args want=%v have=%v", tt.argv, tt.args, inv.args)
+			continue
+		}
+		for i := range tt.args {
+			if inv.args[i] != tt.args[i] {
+				t.Errorf("%v: args want=%v have=%v", tt.argv, tt.args, inv.args)
+			}
+		}
+		if inv.json != tt.json {
+			t.Errorf("%v: json want=%v have=%v", tt.argv, tt.json, inv.json)
+		}
+	}
+}
+
+func TestUsageError(t *testing.T) {
+	ue := &usageError{cmd: commands["locate"]}
+	if ue.Error() != "Usage: difusectl locate <inode|tx> <key>" {
+		t.Error("wrong usage", ue.Error())
+	}
+}
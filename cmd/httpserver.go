@@ -1,25 +1,57 @@
 package main
 
 import (
+	"bytes"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
-	chord "github.com/ipkg/go-chord"
+	"golang.org/x/net/context"
 
 	"github.com/ipkg/difuse"
+	"github.com/ipkg/difuse/auth"
+	"github.com/ipkg/difuse/store"
 )
 
 const (
 	headerResponseTime = "Response-Time"
 	headerVnode        = "Vnode"
+	headerCommitToken  = "Commit-Token"
+
+	contentTypeNDJSON = "application/x-ndjson"
+)
+
+var (
+	errMethodNotAllowed = errors.New("Method not allowed")
+	errRouteNotFound    = &difuse.Error{Code: difuse.CodeNotFound, Msg: "not found"}
 )
 
+// policyKey is the request context key of the authenticated policy
+type policyKey struct{}
+
 type httpServer struct {
 	tt *difuse.Difuse
+	// Authenticates requests.  Authentication is disabled if nil.
+	auth *auth.Authenticator
+	// Faults injected into requests to other nodes.  The route is disabled if nil.
+	faults *difuse.FaultTransport
+
+	data  bool // serve data routes
+	admin bool // serve admin routes
+
+	// Offset of the HTTP port from the advertised port of a node used to redirect writes
+	// to the leader.  Writes are not redirected if redirect is false.
+	portOffset int
+	redirect   bool
 }
 
 func (hs *httpServer) handleData(w http.ResponseWriter, r *http.Request) (interface{}, error) {
@@ -38,10 +70,10 @@ func (hs *httpServer) handleData(w http.ResponseWriter, r *http.Request) (interf
 	case "GET":
 		if opts == nil {
 			ct.start()
-			data, meta, err = hs.tt.Get(key)
+			data, meta, err = hs.tt.Get(r.Context(), key)
 		} else {
 			ct.start()
-			data, meta, err = hs.tt.Get(key, *opts)
+			data, meta, err = hs.tt.Get(r.Context(), key, *opts)
 		}
 		rtime = ct.stop()
 
@@ -51,26 +83,69 @@ func (hs *httpServer) handleData(w http.ResponseWriter, r *http.Request) (interf
 			r.Body.Close()
 
 			ct.start()
-			meta, err = hs.tt.Set(key, b)
+			if opts == nil {
+				meta, err = hs.tt.Set(r.Context(), key, b)
+			} else {
+				meta, err = hs.tt.Set(r.Context(), key, b, *opts)
+			}
 			rtime = ct.stop()
 		}
 
 	case "DELETE":
 		ct.start()
-		_, meta, err = hs.tt.Delete(key)
+		if opts == nil {
+			_, meta, err = hs.tt.Delete(r.Context(), key)
+		} else {
+			_, meta, err = hs.tt.Delete(r.Context(), key, *opts)
+		}
 		rtime = ct.stop()
 
 	default:
-		return nil, fmt.Errorf("Method not allowed")
+		return nil, errMethodNotAllowed
 
 	}
 
 	w.Header().Set(headerResponseTime, fmt.Sprintf("%fms", rtime))
-	w.Header().Set(headerVnode, difuse.ShortVnodeID(meta.Vnode))
+	// meta is not returned for requests rejected before reaching a vnode
+	if meta != nil {
+		w.Header().Set(headerVnode, difuse.ShortVnodeID(meta.Vnode))
+		if len(meta.Token) > 0 {
+			w.Header().Set(headerCommitToken, hex.EncodeToString(meta.Token))
+		}
+		if meta.Vnode != nil && difuse.ErrorCodeOf(err) == difuse.CodeNotLeader {
+			if loc := hs.leaderLocation(r, meta.Vnode.Host); loc != "" {
+				w.Header().Set("Location", loc)
+			}
+		}
+	}
 
 	return data, err
 }
 
+// leaderLocation returns the url of the request on the HTTP server of the leader host or
+// an empty string if it cannot be derived.
+func (hs *httpServer) leaderLocation(r *http.Request, host string) string {
+	if !hs.redirect {
+		return ""
+	}
+	ip, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return ""
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return ""
+	}
+
+	u := *r.URL
+	u.Scheme = "http"
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+	u.Host = net.JoinHostPort(ip, strconv.Itoa(p+hs.portOffset))
+	return u.String()
+}
+
 func (hs *httpServer) handleLocate(w http.ResponseWriter, r *http.Request) (interface{}, error) {
 	var (
 		spath = strings.TrimPrefix(r.URL.Path[1:], "locate/")
@@ -84,17 +159,17 @@ func (hs *httpServer) handleLocate(w http.ResponseWriter, r *http.Request) (inte
 	case strings.HasPrefix(spath, "tx/last/"):
 		key := strings.TrimPrefix(spath, "tx/last/")
 		ct.start()
-		data, err = hs.tt.LocateLastTx([]byte(key))
+		data, err = hs.tt.LocateLastTx(r.Context(), []byte(key))
 		etime = ct.stop()
 
 	case strings.HasPrefix(spath, "inode/"):
 		key := strings.TrimPrefix(spath, "inode/")
 		ct.start()
-		data, err = hs.tt.LocateInode([]byte(key))
+		data, err = hs.tt.LocateInode(r.Context(), []byte(key))
 		etime = ct.stop()
 
 	default:
-		return nil, fmt.Errorf("not found")
+		return nil, errRouteNotFound
 
 	}
 
@@ -102,67 +177,590 @@ func (hs *httpServer) handleLocate(w http.ResponseWriter, r *http.Request) (inte
 	return data, err
 }
 
-func (hs *httpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	upath := r.URL.Path[1:]
-
+// handleForks reports forks for a key on GET and resolves them on POST.
+func (hs *httpServer) handleForks(w http.ResponseWriter, r *http.Request) (interface{}, error) {
 	var (
+		key   = []byte(strings.TrimPrefix(r.URL.Path[1:], "forks/"))
 		ct    = newCallTimer()
 		data  interface{}
 		err   error
 		etime float64
+	)
+
+	switch r.Method {
+	case "GET":
+		ct.start()
+		data, err = hs.tt.DetectFork(r.Context(), key)
+		etime = ct.stop()
+
+	case "POST":
+		ct.start()
+		data, err = hs.tt.ResolveFork(r.Context(), key)
+		etime = ct.stop()
+
+	default:
+		return nil, errMethodNotAllowed
+	}
+
+	w.Header().Set(headerResponseTime, fmt.Sprintf("%fms", etime))
+	return data, err
+}
+
+// handleRepair regenerates the lost shards of an erasure coded key on POST.
+func (hs *httpServer) handleRepair(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	if r.Method != "POST" {
+		return nil, errMethodNotAllowed
+	}
+
+	key := []byte(strings.TrimPrefix(r.URL.Path[1:], "repair/"))
+
+	ct := newCallTimer()
+	ct.start()
+	n, err := hs.tt.RepairShards(r.Context(), key)
+	w.Header().Set(headerResponseTime, fmt.Sprintf("%fms", ct.stop()))
+
+	if err != nil {
+		return nil, err
+	}
+	return map[string]int{"repaired": n}, nil
+}
+
+// handleSnapshot streams a snapshot of the local vnode with the hex id in the path.
+func (hs *httpServer) handleSnapshot(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	id, err := hex.DecodeString(strings.TrimPrefix(r.URL.Path[1:], "snapshot/"))
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := hs.tt.Snapshot(id)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, err = io.Copy(w, rc)
+	if err != nil {
+		log.Printf("action=snapshot status=failed vnode=%x msg='%v'", id, err)
+	}
+	return nil, nil
+}
 
-		meta = &difuse.ResponseMeta{}
+// handleBackup lists the ranges of the ring or streams the snapshot of the range led by
+// the vnode with the hex id in the path from one of its replicas.
+func (hs *httpServer) handleBackup(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	if r.Method != "GET" {
+		return nil, errMethodNotAllowed
+	}
+
+	idstr := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path[1:], "backup"), "/")
+	if idstr == "" {
+		return hs.tt.BackupRanges()
+	}
+
+	id, err := hex.DecodeString(idstr)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, src, err := hs.tt.SnapshotRange(r.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	w.Header().Set(headerVnode, difuse.ShortVnodeID(src))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if _, err = io.Copy(w, rc); err != nil {
+		log.Printf("action=backup status=failed range=%x msg='%v'", id, err)
+	}
+	return nil, nil
+}
+
+// handleRestore writes the range snapshot in the body to the ring on POST.
+func (hs *httpServer) handleRestore(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	if r.Method != "POST" {
+		return nil, errMethodNotAllowed
+	}
+	defer r.Body.Close()
+
+	ct := newCallTimer()
+	ct.start()
+	stats, err := hs.tt.Restore(r.Context(), r.Body)
+	w.Header().Set(headerResponseTime, fmt.Sprintf("%fms", ct.stop()))
+
+	return stats, err
+}
+
+// handleExport streams the transactions of the key in the path from its leader in the
+// txlog export format.
+func (hs *httpServer) handleExport(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	key := []byte(strings.TrimPrefix(r.URL.Path[1:], "export/"))
+
+	// Buffer so errors can still be returned before anything is written
+	var buf bytes.Buffer
+	if err := hs.tt.Export(r.Context(), key, &buf); err != nil {
+		return nil, err
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if _, err := io.Copy(w, &buf); err != nil {
+		log.Printf("action=export status=failed key='%s' msg='%v'", key, err)
+	}
+	return nil, nil
+}
+
+// proofPath splits the /proof/<key>/<txhash> path into the key and hex decoded tx hash.
+func proofPath(upath string) ([]byte, []byte, error) {
+	p := strings.TrimPrefix(upath, "proof/")
+	i := strings.LastIndex(p, "/")
+	if i < 1 {
+		return nil, nil, errRouteNotFound
+	}
+
+	txhash, err := hex.DecodeString(p[i+1:])
+	if err != nil {
+		return nil, nil, &difuse.Error{Code: difuse.CodeInvalidArgument, Msg: err.Error()}
+	}
+	return []byte(p[:i]), txhash, nil
+}
+
+// handleProof returns the inclusion proofs of the tx in the key's chain and of the key in
+// the store from each replica of the key holding it.
+func (hs *httpServer) handleProof(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	if r.Method != "GET" {
+		return nil, errMethodNotAllowed
+	}
+
+	key, txhash, err := proofPath(r.URL.Path[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	ct := newCallTimer()
+	ct.start()
+	proofs, err := hs.tt.Proof(r.Context(), key, txhash)
+	w.Header().Set(headerResponseTime, fmt.Sprintf("%fms", ct.stop()))
+
+	return proofs, err
+}
+
+// batchLine is a line of a batch request body.
+type batchLine struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// isBatchRoute returns whether the path is a batch route.  Batch requests are authorized
+// per key by the handler.
+func isBatchRoute(upath string) bool {
+	return upath == "batch/get" || upath == "batch/set"
+}
+
+// handleBatch gets or sets the keys in the NDJSON body, one {"key": ..., "value": ...}
+// object per line, writing a result per line in the same order.  Keys the client has no
+// right to fail on their own.
+func (hs *httpServer) handleBatch(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	if r.Method != "POST" {
+		return nil, errMethodNotAllowed
+	}
+
+	var (
+		set   = r.URL.Path[1:] == "batch/set"
+		right = auth.RightRead
+		lines []*batchLine
+	)
+	if set {
+		right = auth.RightWrite
+	}
+
+	dec := json.NewDecoder(r.Body)
+	for {
+		var l batchLine
+		if err := dec.Decode(&l); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		lines = append(lines, &l)
+	}
+	r.Body.Close()
+
+	var (
+		out     = make([]*difuse.BatchEntry, len(lines))
+		idx     []int
+		entries []*difuse.BatchEntry
+		keys    [][]byte
+	)
+	for i, l := range lines {
+		key := []byte(l.Key)
+		if !hs.allowed(r, key, right) {
+			out[i] = &difuse.BatchEntry{Key: key, Err: auth.ErrForbidden}
+			continue
+		}
+		idx = append(idx, i)
+		entries = append(entries, &difuse.BatchEntry{Key: key, Value: []byte(l.Value)})
+		keys = append(keys, key)
+	}
+
+	var (
 		opts = parseOptions(r)
+		ct   = newCallTimer()
+		rsp  []*difuse.BatchEntry
 	)
+	ct.start()
+	switch {
+	case set && opts != nil:
+		rsp = hs.tt.BatchSet(r.Context(), entries, *opts)
+	case set:
+		rsp = hs.tt.BatchSet(r.Context(), entries)
+	case opts != nil:
+		rsp = hs.tt.BatchGet(r.Context(), keys, *opts)
+	default:
+		rsp = hs.tt.BatchGet(r.Context(), keys)
+	}
+	etime := ct.stop()
+
+	for j, i := range idx {
+		out[i] = rsp[j]
+	}
+
+	w.Header().Set(headerResponseTime, fmt.Sprintf("%fms", etime))
+	w.Header().Set("Content-Type", contentTypeNDJSON)
+
+	enc := json.NewEncoder(w)
+	for _, e := range out {
+		if err := enc.Encode(e); err != nil {
+			log.Printf("action=batch status=failed msg='%v'", err)
+			break
+		}
+	}
+	return nil, nil
+}
+
+// handleCompression returns the cluster compression codec on GET and sets it to the
+// codec named in the body on POST.
+func (hs *httpServer) handleCompression(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	switch r.Method {
+	case "GET":
+		return map[string]string{"codec": hs.tt.Compression().String()}, nil
+
+	case "POST":
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		r.Body.Close()
+
+		codec, err := store.ParseCodec(strings.TrimSpace(string(b)))
+		if err != nil {
+			return nil, err
+		}
+		return nil, hs.tt.SetCompression(r.Context(), codec)
+	}
+
+	return nil, errMethodNotAllowed
+}
+
+// handleSigners lists the cluster keyring on GET.  A public key in the path is added on
+// POST and revoked on DELETE.
+func (hs *httpServer) handleSigners(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	pubkey := []byte(strings.TrimPrefix(strings.TrimPrefix(r.URL.Path[1:], "signers"), "/"))
+
+	switch r.Method {
+	case "GET":
+		keys, err := hs.tt.Signers(r.Context())
+		if err != nil {
+			return nil, err
+		}
+		out := make([]string, len(keys))
+		for i, k := range keys {
+			out[i] = string(k)
+		}
+		return out, nil
+
+	case "POST":
+		return nil, hs.tt.AddSigner(r.Context(), pubkey)
+
+	case "DELETE":
+		return nil, hs.tt.RevokeSigner(r.Context(), pubkey)
+	}
+
+	return nil, errMethodNotAllowed
+}
+
+// handleFaults returns the faults injected into requests to other nodes on GET, sets
+// them to the ones in the body on POST and clears them on DELETE.
+func (hs *httpServer) handleFaults(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	if hs.faults == nil {
+		return nil, errRouteNotFound
+	}
+
+	switch r.Method {
+	case "GET":
+		return hs.faults.Faults(), nil
+
+	case "POST":
+		var f difuse.Faults
+		err := json.NewDecoder(r.Body).Decode(&f)
+		r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if err = hs.faults.SetFaults(&f); err != nil {
+			return nil, err
+		}
+		b, _ := json.Marshal(&f)
+		log.Printf("action=faults status=set faults='%s'", b)
+		return nil, nil
+
+	case "DELETE":
+		log.Println("action=faults status=cleared")
+		return nil, hs.faults.SetFaults(nil)
+	}
+
+	return nil, errMethodNotAllowed
+}
+
+// route returns whether the request is for an admin route along with the key and right
+// required to access it.
+func route(r *http.Request) (admin bool, key []byte, right auth.Right) {
+	upath := r.URL.Path[1:]
 
 	switch {
 	case strings.HasPrefix(upath, "stat/"):
-		kstr := strings.TrimPrefix(upath, "stat/")
+		return false, []byte(strings.TrimPrefix(upath, "stat/")), auth.RightRead
 
-		if opts == nil {
-			ct.start()
-			data, meta, err = hs.tt.Stat([]byte(kstr))
-		} else {
-			ct.start()
-			data, meta, err = hs.tt.Stat([]byte(kstr), *opts)
-		}
-		etime = ct.stop()
+	case strings.HasPrefix(upath, "leader/"):
+		return true, []byte(strings.TrimPrefix(upath, "leader/")), auth.RightAdmin
 
-		w.Header().Set(headerVnode, difuse.ShortVnodeID(meta.Vnode))
-		w.Header().Set(headerResponseTime, fmt.Sprintf("%fms", etime))
+	case strings.HasPrefix(upath, "locate/tx/last/"):
+		return true, []byte(strings.TrimPrefix(upath, "locate/tx/last/")), auth.RightAdmin
+
+	case strings.HasPrefix(upath, "locate/inode/"):
+		return true, []byte(strings.TrimPrefix(upath, "locate/inode/")), auth.RightAdmin
+
+	case strings.HasPrefix(upath, "locate/"):
+		return true, nil, auth.RightAdmin
+
+	case strings.HasPrefix(upath, "forks/"):
+		return true, []byte(strings.TrimPrefix(upath, "forks/")), auth.RightAdmin
+
+	case strings.HasPrefix(upath, "repair/"):
+		return true, []byte(strings.TrimPrefix(upath, "repair/")), auth.RightAdmin
+
+	case strings.HasPrefix(upath, "history/"):
+		return true, []byte(strings.TrimPrefix(upath, "history/")), auth.RightAdmin
+
+	case strings.HasPrefix(upath, "export/"):
+		return true, []byte(strings.TrimPrefix(upath, "export/")), auth.RightAdmin
+
+	case upath == "keys":
+		return true, []byte(r.URL.Query().Get("prefix")), auth.RightAdmin
+
+	case upath == "ring", strings.HasPrefix(upath, "snapshot/"):
+		return true, nil, auth.RightAdmin
+
+	case upath == "backup", strings.HasPrefix(upath, "backup/"), upath == "restore":
+		return true, nil, auth.RightAdmin
+
+	case upath == "pubkey", upath == "compression", upath == "signers", strings.HasPrefix(upath, "signers/"):
+		return true, nil, auth.RightAdmin
+
+	case upath == "faults":
+		return true, nil, auth.RightAdmin
+
+	case upath == "batch/get":
+		return false, nil, auth.RightRead
+
+	case strings.HasPrefix(upath, "proof/"):
+		key, _, _ := proofPath(upath)
+		return false, key, auth.RightRead
+
+	case upath == "batch/set":
+		return false, nil, auth.RightWrite
+
+	case r.Method == "GET":
+		return false, []byte(upath), auth.RightRead
+	}
+
+	return false, []byte(upath), auth.RightWrite
+}
+
+// authorize authenticates the request and checks the client has the right on the key.
+// It returns the policy of the client and the http status code on failure.
+func (hs *httpServer) authorize(r *http.Request, key []byte, right auth.Right) (*auth.Policy, int, error) {
+	if hs.auth == nil {
+		return nil, 0, nil
+	}
+
+	p, err := hs.auth.Authenticate(r)
+	if err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+	if isBatchRoute(r.URL.Path[1:]) {
+		return p, 0, nil
+	}
+	if !p.Allowed(key, right) {
+		log.Printf("action=authorize status=denied policy=%s right=%s key='%s'", p.Name, right, key)
+		return nil, http.StatusForbidden, auth.ErrForbidden
+	}
+	return p, 0, nil
+}
 
+// allowed returns whether the policy the request was authenticated with grants the right
+// on the key.  All requests are allowed with authentication disabled.
+func (hs *httpServer) allowed(r *http.Request, key []byte, right auth.Right) bool {
+	p, ok := r.Context().Value(policyKey{}).(*auth.Policy)
+	if !ok {
+		return hs.auth == nil
+	}
+	if !p.Allowed(key, right) {
+		log.Printf("action=authorize status=denied policy=%s right=%s key='%s'", p.Name, right, key)
+		return false
+	}
+	return true
+}
+
+// serveAdmin handles cluster administration routes.
+func (hs *httpServer) serveAdmin(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	upath := r.URL.Path[1:]
+
+	switch {
 	case strings.HasPrefix(upath, "leader/"):
 		kstr := strings.TrimPrefix(upath, "leader/")
 
-		var (
-			l  *chord.Vnode
-			vs []*chord.Vnode
-			//vm map[string][]*chord.Vnode
-		)
-
+		ct := newCallTimer()
 		ct.start()
-		l, vs, _, err = hs.tt.LookupLeader([]byte(kstr))
-		etime = ct.stop()
+		l, vs, _, err := hs.tt.LookupLeader(r.Context(), []byte(kstr))
+		etime := ct.stop()
 
 		w.Header().Set(headerResponseTime, fmt.Sprintf("%fms", etime))
 
-		if err == nil {
-			data = map[string]interface{}{
-				"leader": l,
-				"vnodes": vs,
-				//"hosts":  vm,
-			}
+		if err != nil {
+			return nil, err
 		}
+		return map[string]interface{}{
+			"leader": l,
+			"vnodes": vs,
+			//"hosts":  vm,
+		}, nil
 
 	case strings.HasPrefix(upath, "locate/"):
-		data, err = hs.handleLocate(w, r)
+		return hs.handleLocate(w, r)
+
+	case strings.HasPrefix(upath, "forks/"):
+		return hs.handleForks(w, r)
+
+	case strings.HasPrefix(upath, "repair/"):
+		return hs.handleRepair(w, r)
+
+	case strings.HasPrefix(upath, "history/"):
+		return hs.tt.History(r.Context(), []byte(strings.TrimPrefix(upath, "history/")))
+
+	case strings.HasPrefix(upath, "export/"):
+		return hs.handleExport(w, r)
+
+	case upath == "keys":
+		return hs.tt.LocalKeys([]byte(r.URL.Query().Get("prefix"))), nil
+
+	case upath == "ring":
+		return hs.tt.RingStatus()
+
+	case strings.HasPrefix(upath, "snapshot/"):
+		return hs.handleSnapshot(w, r)
+
+	case upath == "backup", strings.HasPrefix(upath, "backup/"):
+		return hs.handleBackup(w, r)
+
+	case upath == "restore":
+		return hs.handleRestore(w, r)
+
+	case upath == "pubkey":
+		return map[string]string{"pubkey": string(hs.tt.PublicKey())}, nil
+
+	case upath == "compression":
+		return hs.handleCompression(w, r)
+
+	case upath == "faults":
+		return hs.handleFaults(w, r)
 
-	default:
-		data, err = hs.handleData(w, r)
+	}
+
+	return hs.handleSigners(w, r)
+}
+
+// serveData handles key and stat routes.
+func (hs *httpServer) serveData(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	upath := r.URL.Path[1:]
+
+	if isBatchRoute(upath) {
+		return hs.handleBatch(w, r)
+	}
+	if strings.HasPrefix(upath, "proof/") {
+		return hs.handleProof(w, r)
+	}
+	if !strings.HasPrefix(upath, "stat/") {
+		return hs.handleData(w, r)
+	}
+
+	var (
+		kstr = strings.TrimPrefix(upath, "stat/")
+		ct   = newCallTimer()
+		data interface{}
+		err  error
+		meta *difuse.ResponseMeta
+		opts = parseOptions(r)
+	)
+
+	if opts == nil {
+		ct.start()
+		data, meta, err = hs.tt.Stat(r.Context(), []byte(kstr))
+	} else {
+		ct.start()
+		data, meta, err = hs.tt.Stat(r.Context(), []byte(kstr), *opts)
+	}
+	etime := ct.stop()
+
+	if meta != nil {
+		w.Header().Set(headerVnode, difuse.ShortVnodeID(meta.Vnode))
+	}
+	w.Header().Set(headerResponseTime, fmt.Sprintf("%fms", etime))
+
+	return data, err
+}
+
+func (hs *httpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	admin, key, right := route(r)
+
+	if (admin && !hs.admin) || (!admin && !hs.data) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("not found"))
+		return
+	}
+
+	p, code, err := hs.authorize(r, key, right)
+	if err != nil {
+		if code == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		w.WriteHeader(code)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if p != nil {
+		r = r.WithContext(context.WithValue(r.Context(), policyKey{}, p))
+	}
+
+	var data interface{}
+	if admin {
+		data, err = hs.serveAdmin(w, r)
+	} else {
+		data, err = hs.serveData(w, r)
 	}
 
 	if err != nil {
-		w.WriteHeader(400)
+		w.WriteHeader(httpStatus(err))
 		w.Write([]byte(err.Error()))
 		return
 	}
@@ -191,6 +789,30 @@ func (hs *httpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// httpStatus returns the http status code for the error.  A write that could not be
+// redirected to the leader gets a 307 with the Vnode header set to the leader last seen
+// and Location to the same request on the leader.
+// Errors that cannot be classified are treated as bad requests.
+func httpStatus(err error) int {
+	if err == errMethodNotAllowed {
+		return http.StatusMethodNotAllowed
+	}
+
+	switch difuse.ErrorCodeOf(err) {
+	case difuse.CodeNotFound:
+		return http.StatusNotFound
+	case difuse.CodeConflict:
+		return http.StatusConflict
+	case difuse.CodeConditionFailed:
+		return http.StatusPreconditionFailed
+	case difuse.CodeNotLeader:
+		return http.StatusTemporaryRedirect
+	case difuse.CodeUnavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
 type callTimer struct {
 	t time.Time
 }
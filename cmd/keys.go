@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ipkg/difuse"
+	"github.com/ipkg/difuse/txlog"
+)
+
+const (
+	// envKeyPassphrase is the environment variable holding the key file passphrase.
+	envKeyPassphrase = "DIFUSE_KEY_PASSPHRASE"
+	// envConvergenceSecret is the environment variable holding the optional secret used
+	// for convergent block encryption.
+	envConvergenceSecret = "DIFUSE_CONVERGENCE_SECRET"
+	// envMasterKey is the environment variable holding the hex encoded cluster master key
+	// used for block encryption.
+	envMasterKey = "DIFUSE_MASTER_KEY"
+)
+
+func keyPassphrase() []byte {
+	return []byte(os.Getenv(envKeyPassphrase))
+}
+
+// runSubcommand runs the subcommand given as the first argument.  It returns false if the
+// argument is not a subcommand.
+func runSubcommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	var err error
+	switch args[0] {
+	case "keygen":
+		err = keygen(args[1:])
+	case "pubkey":
+		err = pubkey(args[1:])
+	default:
+		return false
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return true
+}
+
+// blockCipher returns the block cipher for the mode reading its secret from the
+// environment.
+func blockCipher(mode string) (difuse.BlockCipher, error) {
+	switch mode {
+	case "convergent":
+		return difuse.NewConvergentCipher([]byte(os.Getenv(envConvergenceSecret))), nil
+
+	case "master":
+		key, err := hex.DecodeString(os.Getenv(envMasterKey))
+		if err != nil {
+			return nil, err
+		}
+		return difuse.NewMasterKeyCipher(key)
+	}
+
+	return nil, fmt.Errorf("unsupported encryption mode: %s", mode)
+}
+
+func keyFlagSet(name string, cfg *difuse.Config) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&cfg.DataDir, "data", cfg.DataDir, "Data directory")
+	fs.StringVar(&cfg.KeyFile, "key", cfg.KeyFile, "Signing key file relative to the data directory")
+	fs.Var(&cfg.SignatureScheme, "scheme", "Signature scheme for new keys [ecdsa|ed25519]")
+	return fs
+}
+
+// keygen generates a new signing key and writes it to the key file.  The key is encrypted
+// if a passphrase is set in the environment.
+func keygen(args []string) error {
+	cfg := difuse.DefaultConfig()
+	keyFlagSet("keygen", cfg).Parse(args)
+
+	if cfg.DataDir != "" {
+		if err := os.MkdirAll(cfg.DataDir, 0700); err != nil {
+			return err
+		}
+	}
+
+	kp, err := txlog.GenerateKeypair(cfg.SignatureScheme)
+	if err != nil {
+		return err
+	}
+	if err = txlog.WriteKeypairFile(cfg.KeyPath(), kp, keyPassphrase()); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s\n", kp.PublicKey().Bytes())
+	return nil
+}
+
+// pubkey prints the public key from the key file.
+func pubkey(args []string) error {
+	cfg := difuse.DefaultConfig()
+	keyFlagSet("pubkey", cfg).Parse(args)
+
+	kp, err := txlog.ReadKeypairFile(cfg.KeyPath(), keyPassphrase())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s\n", kp.PublicKey().Bytes())
+	return nil
+}
+
+// readSigners reads the public keys in the file, one per line, skipping blank lines and
+// comments.
+func readSigners(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys [][]byte
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, []byte(line))
+	}
+	return keys, s.Err()
+}
@@ -4,13 +4,14 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
+	"time"
 
 	chord "github.com/ipkg/go-chord"
 
 	"github.com/ipkg/difuse"
-	"github.com/ipkg/difuse/netrpc"
+	"github.com/ipkg/difuse/auth"
+	"github.com/ipkg/difuse/store"
 )
 
 var (
@@ -27,6 +28,23 @@ var (
 	adminAddr   = flag.String("a", "127.0.0.1:9090", "HTTP admin address")
 	debugMode   = flag.Bool("debug", false, "Turn on debug mode")
 	showVersion = flag.Bool("version", false, "Show version")
+
+	tlsCA   = flag.String("tls-ca", "", "CA certificate used to verify peers")
+	tlsCert = flag.String("tls-cert", "", "Node certificate. Enables mutual TLS between nodes")
+	tlsKey  = flag.String("tls-key", "", "Node certificate key")
+
+	compression = flag.String("compress", "none", "Codec used to compress data until set cluster wide [none|gzip|flate]")
+	encryptMode = flag.String("encrypt", "", "Encrypt values as blocks [convergent|master]")
+	erasureSpec = flag.String("erasure", "", "Erasure code keys by prefix e.g. 'media/:4:2' [prefix:data:parity,...]")
+
+	httpAuth    = flag.String("http-auth", "", "JSON policy file. Enables HTTP authentication")
+	httpAdmin   = flag.String("http-admin", "", "Separate HTTP address for admin routes")
+	httpTLSCert = flag.String("http-tls-cert", "", "HTTP TLS certificate")
+	httpTLSKey  = flag.String("http-tls-key", "", "HTTP TLS certificate key")
+
+	signersFile = flag.String("signers", "", "File of public keys authorized to sign along with this node when creating the cluster, one per line")
+
+	faultInjection = flag.Bool("faults", false, "Enable injecting faults into node-to-node requests via the admin API. For testing only")
 )
 
 func initLogger() {
@@ -37,8 +55,16 @@ func initLogger() {
 }
 
 func init() {
+	if runSubcommand(os.Args[1:]) {
+		os.Exit(0)
+	}
+
 	flag.StringVar(&Conf.BindAddr, "b", "127.0.0.1:4624", "Bind address")
 	flag.StringVar(&Conf.AdvAddr, "adv", "", "Advertise address")
+	flag.StringVar(&Conf.DataDir, "data", "", "Data directory. A persistent signing key is kept here if set")
+	flag.StringVar(&Conf.KeyFile, "key", difuse.DefaultKeyFile, "Signing key file relative to the data directory")
+	flag.Var(&Conf.SignatureScheme, "scheme", "Signature scheme for new signing keys [ecdsa|ed25519]")
+	flag.Var(&Conf.ForkRule, "fork-rule", "Rule picking the winning chain of a forked key [majority|epoch]")
 	flag.Parse()
 
 	if *showVersion {
@@ -54,6 +80,49 @@ func init() {
 	initLogger()
 
 	Conf.SetPeers(*joinAddrs)
+
+	if *tlsCert != "" {
+		Conf.TLS = &difuse.TLSConfig{
+			CAFile:         *tlsCA,
+			CertFile:       *tlsCert,
+			KeyFile:        *tlsKey,
+			ReloadInterval: 30 * time.Second,
+		}
+	}
+
+	codec, err := store.ParseCodec(*compression)
+	if err != nil {
+		log.Fatal(err)
+	}
+	Conf.Compression = codec
+
+	if Conf.ErasureClasses, err = difuse.ParseErasureClasses(*erasureSpec); err != nil {
+		log.Fatal(err)
+	}
+
+	if *signersFile != "" {
+		if Conf.Signers, err = readSigners(*signersFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *encryptMode != "" {
+		bc, err := blockCipher(*encryptMode)
+		if err != nil {
+			log.Fatal(err)
+		}
+		Conf.BlockCipher = bc
+	}
+
+	if Conf.DataDir != "" {
+		created, err := Conf.LoadSignator(keyPassphrase())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if created {
+			log.Printf("action=keygen status=ok path=%s", Conf.KeyPath())
+		}
+	}
 }
 
 func initChordRing(cfg *difuse.Config, trans chord.Transport) (*chord.Ring, error) {
@@ -73,22 +142,28 @@ func initChordRing(cfg *difuse.Config, trans chord.Transport) (*chord.Ring, erro
 func main() {
 	printBanner(Conf)
 
-	ln, server := initNet(Conf.BindAddr)
-
 	// Initialize difuse transport
 	dtrans := difuse.NewNetTransport()
-	netrpc.RegisterDifuseRPCServer(server, dtrans)
+	dtrans.SetTimeouts(Conf.Timeouts)
+	// Wrap the transport to inject faults if enabled
+	var (
+		trans  difuse.Transport = dtrans
+		ftrans *difuse.FaultTransport
+	)
+	if *faultInjection {
+		ftrans = difuse.NewFaultTransport(dtrans)
+		trans = ftrans
+	}
 	// Initialize difuse
-	difused := difuse.NewDifuse(Conf, dtrans)
+	difused, err := difuse.NewDifuse(Conf, trans)
+	if err != nil {
+		log.Fatalf("action=keygen status=failed msg='%v'", err)
+	}
 	// Set difuse as the chord delegate
 	Conf.Chord.Delegate = difused
 
-	// Init chord transport
-	ctrans := chord.NewGRPCTransport(Conf.Timeouts.RPC, Conf.Timeouts.Idle)
-	chord.RegisterChordServer(server, ctrans)
-
 	// Start serving transports
-	go server.Serve(ln)
+	ctrans := initNet(Conf, dtrans)
 
 	// Init chord ring
 	ring, err := initChordRing(Conf, ctrans)
@@ -97,8 +172,28 @@ func main() {
 	}
 	difused.RegisterRing(ring)
 
-	// Start admin server
-	hs := &httpServer{tt: difused}
-	http.ListenAndServe(*adminAddr, hs)
+	// Start http servers
+	var hauth *auth.Authenticator
+	if *httpAuth != "" {
+		policies, err := auth.LoadPolicies(*httpAuth)
+		if err != nil {
+			log.Fatal(err)
+		}
+		hauth = auth.NewAuthenticator(policies)
+	}
+
+	offset, err := httpPortOffset(*adminAddr, Conf.AdvAddr)
+	if err != nil {
+		log.Printf("action=http-redirect status=disabled msg='%v'", err)
+	}
+	redirect := err == nil
+
+	if *httpAdmin == "" {
+		serveHTTP(*adminAddr, &httpServer{tt: difused, auth: hauth, faults: ftrans, data: true, admin: true, portOffset: offset, redirect: redirect})
+		return
+	}
+
+	go serveHTTP(*httpAdmin, &httpServer{tt: difused, auth: hauth, faults: ftrans, admin: true})
+	serveHTTP(*adminAddr, &httpServer{tt: difused, auth: hauth, data: true, portOffset: offset, redirect: redirect})
 
 }
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"encoding/hex"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	chord "github.com/ipkg/go-chord"
 
 	"github.com/ipkg/difuse"
+	"github.com/ipkg/difuse/netrpc"
 )
 
 func version() string {
@@ -30,23 +37,115 @@ func printBanner(cfg *difuse.Config) {
 `, cfg.BindAddr, cfg.AdvAddr, cfg.Chord.NumSuccessors, cfg.Chord.NumVnodes, *adminAddr)
 }
 
-func initNet(addr string) (net.Listener, *grpc.Server) {
-	ln, err := net.Listen("tcp", addr)
+// initNet starts serving the difuse and chord transports returning the chord transport.
+// With TLS enabled chord is carried over the difuse rpc service so all node-to-node
+// traffic uses mutual TLS.
+func initNet(cfg *difuse.Config, dtrans *difuse.NetTransport) chord.Transport {
+	ln, err := net.Listen("tcp", cfg.BindAddr)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	opt := grpc.CustomCodec(&chord.PayloadCodec{})
-	return ln, grpc.NewServer(opt)
+
+	if cfg.TLS == nil {
+		ctrans := chord.NewGRPCTransport(cfg.Timeouts.RPC, cfg.Timeouts.Idle)
+		server := grpc.NewServer(opt)
+		netrpc.RegisterDifuseRPCServer(server, dtrans)
+		chord.RegisterChordServer(server, ctrans)
+		go server.Serve(ln)
+		return ctrans
+	}
+
+	certs, err := difuse.NewCertReloader(cfg.TLS)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go certs.Start()
+	go reloadOnSignal(certs)
+
+	dtrans.UseTLS(certs)
+	ctrans := difuse.NewChordTransport(dtrans)
+
+	server := grpc.NewServer(opt, grpc.Creds(credentials.NewTLS(certs.ServerConfig())))
+	netrpc.RegisterDifuseRPCServer(server, dtrans)
+	go server.Serve(ln)
+	return ctrans
 }
 
+// httpPortOffset returns the offset of the HTTP port from the advertised port of the node.
+// Nodes are expected to be started with the same offset, as scripts/start-cluster.sh does,
+// so the HTTP address of another node can be derived from its host.
+func httpPortOffset(httpAddr, advAddr string) (int, error) {
+	hp, err := addrPort(httpAddr)
+	if err != nil {
+		return 0, err
+	}
+	ap, err := addrPort(advAddr)
+	if err != nil {
+		return 0, err
+	}
+	return hp - ap, nil
+}
+
+// addrPort returns the port of the host:port address.
+func addrPort(addr string) (int, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(port)
+}
+
+// serveHTTP serves the handler on the address using TLS if configured.
+func serveHTTP(addr string, h http.Handler) {
+	var err error
+	if *httpTLSCert != "" {
+		err = http.ListenAndServeTLS(addr, *httpTLSCert, *httpTLSKey, h)
+	} else {
+		err = http.ListenAndServe(addr, h)
+	}
+	log.Fatal(err)
+}
+
+// reloadOnSignal reloads the tls certificates on SIGHUP.
+func reloadOnSignal(certs *difuse.CertReloader) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		if err := certs.Reload(); err != nil {
+			log.Printf("action=tls-reload status=failed msg='%v'", err)
+		} else {
+			log.Printf("action=tls-reload status=ok")
+		}
+	}
+}
+
+// parseOptions parses the consistency, wait and token query parameters.  It returns nil
+// if none are set.
 func parseOptions(r *http.Request) *difuse.RequestOptions {
-	cst, ok := r.URL.Query()["consistency"]
+	var (
+		q    = r.URL.Query()
+		opts = &difuse.RequestOptions{}
+	)
+
+	// Wait for writes to be applied
+	if w := q.Get("wait"); w != "" && w != "0" && w != "false" {
+		opts.WaitApply = true
+	}
+	// Commit token of an earlier write for reads to wait on
+	if t := q.Get("token"); t != "" {
+		opts.Token, _ = hex.DecodeString(t)
+	}
+
+	cst, ok := q["consistency"]
 	if !ok || len(cst) == 0 {
-		return nil
+		if !opts.WaitApply && opts.Token == nil {
+			return nil
+		}
+		return opts
 	}
 
-	opts := &difuse.RequestOptions{}
 	switch cst[0] {
 	case "lazy":
 		opts.Consistency = difuse.ConsistencyLazy
@@ -1,8 +1,6 @@
 package difuse
 
 import (
-	"fmt"
-
 	flatbuffers "github.com/google/flatbuffers/go"
 
 	chord "github.com/ipkg/go-chord"
@@ -101,6 +99,7 @@ func serializeVnodeIdInodeErrList(rsps []*VnodeResponse) []byte {
 			gentypes.VnodeIdInodeErrStart(fb)
 			gentypes.VnodeIdInodeErrAddId(fb, ip)
 			gentypes.VnodeIdInodeErrAddE(fb, dp)
+			gentypes.VnodeIdInodeErrAddC(fb, byte(ErrorCodeOf(vn.Err)))
 
 		} else {
 			d := vn.Data.(*store.Inode)
@@ -141,6 +140,7 @@ func serializeVnodeIdBytesErrList(rsps []*VnodeResponse) []byte {
 			gentypes.VnodeIdBytesErrStart(fb)
 			gentypes.VnodeIdBytesErrAddId(fb, ip)
 			gentypes.VnodeIdBytesErrAddE(fb, dp)
+			gentypes.VnodeIdBytesErrAddC(fb, byte(ErrorCodeOf(vn.Err)))
 
 		} else {
 			d := vn.Data.([]byte)
@@ -181,6 +181,7 @@ func serializeVnodeIdTxErrList(vnds []*VnodeResponse) []byte {
 			gentypes.VnodeIdTxErrStart(fb)
 			gentypes.VnodeIdTxErrAddId(fb, ip)
 			gentypes.VnodeIdTxErrAddE(fb, dp)
+			gentypes.VnodeIdTxErrAddC(fb, byte(ErrorCodeOf(vn.Err)))
 
 		} else {
 			tx := vn.Data.(*txlog.Tx)
@@ -240,21 +241,7 @@ func serializeVnodeIdsTwoByteSlices(b1, b2 []byte, vns []*chord.Vnode) []byte {
 }
 
 func serializeTx(fb *flatbuffers.Builder, tx *txlog.Tx) flatbuffers.UOffsetT {
-	kp := fb.CreateByteString(tx.Key)
-	pp := fb.CreateByteString(tx.PrevHash)
-	sp := fb.CreateByteString(tx.Source)
-	dp := fb.CreateByteString(tx.Destination)
-	ddp := fb.CreateByteString(tx.Data)
-	ssp := fb.CreateByteString(tx.Signature)
-
-	gentypes.TxStart(fb)
-	gentypes.TxAddKey(fb, kp)
-	gentypes.TxAddPrevHash(fb, pp)
-	gentypes.TxAddSource(fb, sp)
-	gentypes.TxAddDestination(fb, dp)
-	gentypes.TxAddData(fb, ddp)
-	gentypes.TxAddSignature(fb, ssp)
-	return gentypes.TxEnd(fb)
+	return tx.Serialize(fb)
 }
 
 func serializeVnodeIdsTx(tx *txlog.Tx, vns []*chord.Vnode) []byte {
@@ -310,7 +297,7 @@ func deserializeVnodeIdTxErrList(data []byte) []*VnodeResponse {
 
 		e := obj.E()
 		if e != nil && len(e) > 0 {
-			vid.Err = fmt.Errorf("%s", e)
+			vid.Err = &Error{Code: ErrorCode(obj.C()), Msg: string(e)}
 		} else {
 			//vid.Data
 			tx := obj.Tx(nil)
@@ -322,17 +309,10 @@ func deserializeVnodeIdTxErrList(data []byte) []*VnodeResponse {
 	return out
 }
 
-func deserializeTx(tx *gentypes.Tx) *txlog.Tx {
-	return &txlog.Tx{
-		Key:       tx.KeyBytes(),
-		Data:      tx.DataBytes(),
-		Signature: tx.SignatureBytes(),
-		TxHeader: &txlog.TxHeader{
-			PrevHash:    tx.PrevHashBytes(),
-			Source:      tx.SourceBytes(),
-			Destination: tx.DestinationBytes(),
-		},
-	}
+func deserializeTx(obj *gentypes.Tx) *txlog.Tx {
+	tx := &txlog.Tx{}
+	tx.Deserialize(obj)
+	return tx
 }
 
 func deserializeVnodeIdsTx(data []byte) ([]*chord.Vnode, *txlog.Tx) {
@@ -377,7 +357,7 @@ func deserializeVnodeIdBytesErrList(data []byte) []*VnodeResponse {
 
 		e := obj.E()
 		if e != nil && len(e) > 0 {
-			vid.Err = fmt.Errorf("%s", e)
+			vid.Err = &Error{Code: ErrorCode(obj.C()), Msg: string(e)}
 		} else {
 			vid.Data = obj.BBytes()
 		}
@@ -398,7 +378,7 @@ func deserializeVnodeIdInodeErrList(data []byte) []*VnodeResponse {
 
 		vr := &VnodeResponse{Id: obj.IdBytes()}
 		if e := obj.E(); e != nil && len(e) > 0 {
-			vr.Err = fmt.Errorf("%s", e)
+			vr.Err = &Error{Code: ErrorCode(obj.C()), Msg: string(e)}
 		} else {
 			ind := obj.Inode(nil)
 
@@ -411,3 +391,260 @@ func deserializeVnodeIdInodeErrList(data []byte) []*VnodeResponse {
 	}
 	return vrl
 }
+
+func serializeBatchEntryList(entries []*BatchEntry) []byte {
+	fb := flatbuffers.NewBuilder(0)
+
+	ofs := make([]flatbuffers.UOffsetT, len(entries))
+
+	for i, e := range entries {
+		kp := fb.CreateByteString(e.Key)
+
+		var vp, np, ep flatbuffers.UOffsetT
+		if e.Value != nil {
+			vp = fb.CreateByteString(e.Value)
+		}
+		if e.Vnode != nil {
+			np = chord.SerializeVnode(fb, e.Vnode)
+		}
+		if e.Err != nil {
+			ep = fb.CreateByteString([]byte(e.Err.Error()))
+		}
+
+		gentypes.BatchEntryStart(fb)
+		gentypes.BatchEntryAddKey(fb, kp)
+		if e.Value != nil {
+			gentypes.BatchEntryAddValue(fb, vp)
+		}
+		if e.Vnode != nil {
+			gentypes.BatchEntryAddVnode(fb, np)
+		}
+		if e.Err != nil {
+			gentypes.BatchEntryAddE(fb, ep)
+			gentypes.BatchEntryAddC(fb, byte(ErrorCodeOf(e.Err)))
+		}
+		ofs[i] = gentypes.BatchEntryEnd(fb)
+	}
+
+	gentypes.BatchEntryListStartLVector(fb, len(entries))
+	for _, v := range ofs {
+		fb.PrependUOffsetT(v)
+	}
+	l := fb.EndVector(len(entries))
+
+	gentypes.BatchEntryListStart(fb)
+	gentypes.BatchEntryListAddL(fb, l)
+	p := gentypes.BatchEntryListEnd(fb)
+	fb.Finish(p)
+
+	return fb.Bytes[fb.Head():]
+}
+
+func deserializeBatchEntryList(data []byte) []*BatchEntry {
+	bel := gentypes.GetRootAsBatchEntryList(data, 0)
+	l := bel.LLength()
+
+	out := make([]*BatchEntry, l)
+	for i := 0; i < l; i++ {
+		var obj gentypes.BatchEntry
+		bel.L(&obj, i)
+
+		be := &BatchEntry{Key: obj.KeyBytes(), Value: obj.ValueBytes()}
+		if vn := obj.Vnode(nil); vn != nil {
+			be.Vnode = &chord.Vnode{Id: vn.IdBytes(), Host: string(vn.Host())}
+		}
+		if e := obj.E(); e != nil && len(e) > 0 {
+			be.Err = &Error{Code: ErrorCode(obj.C()), Msg: string(e)}
+		}
+		// deserialize in reverse
+		out[l-i-1] = be
+	}
+	return out
+}
+
+func serializeVnodeTxsList(batches []*VnodeTxs) []byte {
+	fb := flatbuffers.NewBuilder(0)
+
+	ofs := make([]flatbuffers.UOffsetT, len(batches))
+
+	for i, b := range batches {
+		txofs := make([]flatbuffers.UOffsetT, len(b.Txs))
+		for j, tx := range b.Txs {
+			txofs[j] = serializeTx(fb, tx)
+		}
+		gentypes.VnodeTxsStartTxsVector(fb, len(txofs))
+		for _, o := range txofs {
+			fb.PrependUOffsetT(o)
+		}
+		tv := fb.EndVector(len(txofs))
+		ip := fb.CreateByteString(b.Vnode.Id)
+
+		gentypes.VnodeTxsStart(fb)
+		gentypes.VnodeTxsAddId(fb, ip)
+		gentypes.VnodeTxsAddTxs(fb, tv)
+		ofs[i] = gentypes.VnodeTxsEnd(fb)
+	}
+
+	gentypes.VnodeTxsListStartLVector(fb, len(batches))
+	for _, o := range ofs {
+		fb.PrependUOffsetT(o)
+	}
+	l := fb.EndVector(len(batches))
+
+	gentypes.VnodeTxsListStart(fb)
+	gentypes.VnodeTxsListAddL(fb, l)
+	p := gentypes.VnodeTxsListEnd(fb)
+	fb.Finish(p)
+
+	return fb.Bytes[fb.Head():]
+}
+
+func deserializeVnodeTxsList(data []byte) []*VnodeTxs {
+	vtl := gentypes.GetRootAsVnodeTxsList(data, 0)
+	l := vtl.LLength()
+
+	out := make([]*VnodeTxs, l)
+	for i := 0; i < l; i++ {
+		var obj gentypes.VnodeTxs
+		vtl.L(&obj, i)
+
+		n := obj.TxsLength()
+		txs := make(txlog.TxSlice, n)
+		for j := 0; j < n; j++ {
+			var fbtx gentypes.Tx
+			obj.Txs(&fbtx, j)
+			// deserialize in reverse
+			txs[n-j-1] = deserializeTx(&fbtx)
+		}
+		out[l-i-1] = &VnodeTxs{Vnode: &chord.Vnode{Id: obj.IdBytes()}, Txs: txs}
+	}
+	return out
+}
+
+func serializeProof(fb *flatbuffers.Builder, proof txlog.Proof) flatbuffers.UOffsetT {
+	ofs := make([]flatbuffers.UOffsetT, len(proof))
+	for i, pn := range proof {
+		var hp flatbuffers.UOffsetT
+		if pn.Hash != nil {
+			hp = fb.CreateByteString(pn.Hash)
+		}
+		gentypes.ProofNodeStart(fb)
+		if pn.Hash != nil {
+			gentypes.ProofNodeAddHash(fb, hp)
+		}
+		if pn.Left {
+			gentypes.ProofNodeAddLeft(fb, 1)
+		}
+		ofs[i] = gentypes.ProofNodeEnd(fb)
+	}
+
+	fb.StartVector(4, len(ofs), 4)
+	for _, o := range ofs {
+		fb.PrependUOffsetT(o)
+	}
+	return fb.EndVector(len(ofs))
+}
+
+// deserializeProof deserializes the n nodes of a proof returned by node.
+func deserializeProof(n int, node func(*gentypes.ProofNode, int) bool) txlog.Proof {
+	proof := make(txlog.Proof, n)
+	for i := 0; i < n; i++ {
+		var obj gentypes.ProofNode
+		node(&obj, i)
+		// deserialize in reverse
+		proof[n-i-1] = &txlog.ProofNode{Hash: obj.HashBytes(), Left: obj.Left() == 1}
+	}
+	return proof
+}
+
+func serializeInclusionProof(p *InclusionProof) []byte {
+	fb := flatbuffers.NewBuilder(0)
+
+	tp := serializeProof(fb, p.TxProof)
+	kp := serializeProof(fb, p.KeyProof)
+	trp := fb.CreateByteString(p.TxRoot)
+	rp := fb.CreateByteString(p.Root)
+
+	gentypes.InclusionProofStart(fb)
+	gentypes.InclusionProofAddTxRoot(fb, trp)
+	gentypes.InclusionProofAddTxProof(fb, tp)
+	gentypes.InclusionProofAddRoot(fb, rp)
+	gentypes.InclusionProofAddKeyProof(fb, kp)
+	fb.Finish(gentypes.InclusionProofEnd(fb))
+
+	return fb.Bytes[fb.Head():]
+}
+
+// deserializeInclusionProof deserializes the roots and proofs of an inclusion proof.  The
+// vnode, key and tx are not part of the serialized proof.
+func deserializeInclusionProof(data []byte) *InclusionProof {
+	obj := gentypes.GetRootAsInclusionProof(data, 0)
+	return &InclusionProof{
+		TxRoot:   obj.TxRootBytes(),
+		TxProof:  deserializeProof(obj.TxProofLength(), obj.TxProof),
+		Root:     obj.RootBytes(),
+		KeyProof: deserializeProof(obj.KeyProofLength(), obj.KeyProof),
+	}
+}
+
+// serializeVnodeProofList serializes responses carrying inclusion proofs as a
+// VnodeIdBytesErrList.
+func serializeVnodeProofList(rsps []*VnodeResponse) []byte {
+	out := make([]*VnodeResponse, len(rsps))
+	for i, r := range rsps {
+		out[i] = &VnodeResponse{Id: r.Id, Err: r.Err}
+		if r.Err == nil {
+			out[i].Data = serializeInclusionProof(r.Data.(*InclusionProof))
+		}
+	}
+	return serializeVnodeIdBytesErrList(out)
+}
+
+func deserializeVnodeProofList(data []byte) []*VnodeResponse {
+	rsps := deserializeVnodeIdBytesErrList(data)
+	for _, r := range rsps {
+		if r.Err == nil {
+			r.Data = deserializeInclusionProof(r.Data.([]byte))
+		}
+	}
+	return rsps
+}
+
+func serializeChordRequest(fb *flatbuffers.Builder, req *chordRequest) flatbuffers.UOffsetT {
+	var tp, sp, kp flatbuffers.UOffsetT
+	if req.target != nil {
+		tp = chord.SerializeVnode(fb, req.target)
+	}
+	if req.self != nil {
+		sp = chord.SerializeVnode(fb, req.self)
+	}
+	if req.key != nil {
+		kp = fb.CreateByteString(req.key)
+	}
+
+	gentypes.ChordRequestStart(fb)
+	gentypes.ChordRequestAddMethod(fb, req.method)
+	if req.target != nil {
+		gentypes.ChordRequestAddTarget(fb, tp)
+	}
+	if req.self != nil {
+		gentypes.ChordRequestAddSelf(fb, sp)
+	}
+	gentypes.ChordRequestAddN(fb, int32(req.n))
+	if req.key != nil {
+		gentypes.ChordRequestAddKey(fb, kp)
+	}
+	return gentypes.ChordRequestEnd(fb)
+}
+
+func deserializeChordRequest(data []byte) *chordRequest {
+	obj := gentypes.GetRootAsChordRequest(data, 0)
+	req := &chordRequest{method: obj.Method(), n: int(obj.N()), key: obj.KeyBytes()}
+	if t := obj.Target(nil); t != nil {
+		req.target = &chord.Vnode{Id: t.IdBytes(), Host: string(t.Host())}
+	}
+	if s := obj.Self(nil); s != nil {
+		req.self = &chord.Vnode{Id: s.IdBytes(), Host: string(s.Host())}
+	}
+	return req
+}
@@ -0,0 +1,65 @@
+package difuse
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"github.com/ipkg/difuse/store"
+)
+
+// CompressionKey is the reserved key holding the name of the codec used by the cluster to
+// compress blocks and inline values.  It overrides the configured codec once set.
+var CompressionKey = []byte("_difuse/compression")
+
+// clusterCodec holds the codec currently used to compress new data.
+type clusterCodec struct {
+	mu    sync.RWMutex
+	codec store.Codec
+}
+
+func (cc *clusterCodec) get() store.Codec {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	return cc.codec
+}
+
+func (cc *clusterCodec) set(c store.Codec) {
+	cc.mu.Lock()
+	cc.codec = c
+	cc.mu.Unlock()
+}
+
+// Compression returns the codec used to compress new data.  Data compressed with any
+// registered codec can be read regardless of the current codec.
+func (s *Difuse) Compression() store.Codec {
+	return s.codec.get()
+}
+
+// SetCompression sets the codec used by the cluster to compress new data.  Every node
+// must have the codec registered.
+func (s *Difuse) SetCompression(ctx context.Context, c store.Codec) error {
+	if err := s.setSetting(ctx, CompressionKey, []byte(c.String())); err != nil {
+		return err
+	}
+
+	s.codec.set(c)
+	return nil
+}
+
+// syncCompression refreshes the codec from the cluster setting if one has been set.
+func (s *Difuse) syncCompression() error {
+	val, _, err := s.Get(context.Background(), CompressionKey)
+	if err != nil {
+		if ErrorCodeOf(err) == CodeNotFound {
+			return nil
+		}
+		return err
+	}
+
+	c, err := store.ParseCodec(string(val))
+	if err == nil {
+		s.codec.set(c)
+	}
+	return err
+}
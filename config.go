@@ -3,10 +3,20 @@ package difuse
 import (
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	chord "github.com/ipkg/go-chord"
+
+	"github.com/ipkg/difuse/store"
+	"github.com/ipkg/difuse/txlog"
+)
+
+const (
+	// DefaultKeyFile is the name of the node signing key file in the data directory.
+	DefaultKeyFile = "node.key"
 )
 
 // NetTimeouts holds timeouts for rpc's
@@ -34,6 +44,49 @@ type Config struct {
 	Peers    []string
 
 	Timeouts *NetTimeouts
+
+	// Mutual TLS for node-to-node traffic.  Plaintext is used if nil.
+	TLS *TLSConfig
+
+	// Number of times a write redirected to the leader is retried against a freshly
+	// looked up leader when the previous one is unreachable or no longer the leader.
+	RedirectRetries int
+	// Time to wait between redirect retries to allow the ring to stabilize.
+	RedirectWait time.Duration
+
+	// Rule used to pick the winning chain when a key's chain has forked.
+	ForkRule ForkRule
+
+	// Directory holding persistent node data such as the signing key.
+	DataDir string
+	// Signing key file.  Relative paths are relative to the data directory.
+	KeyFile string
+	// Signature scheme used when generating a new signing key.  Nodes verify
+	// transactions signed with any supported scheme.
+	SignatureScheme txlog.SignatureScheme
+	// Signator used to sign transactions.  A new one is generated by NewDifuse if not
+	// provided.
+	Signator txlog.Signator
+	// Public keys authorized to sign transactions along with the key of the node when it
+	// creates the cluster.  Other nodes must be added to the keyring before they can write.
+	Signers [][]byte
+	// Opens the store of a local vnode verifying transactions with the signator.  A new
+	// in-memory store is used if nil.
+	OpenStore func(vn *chord.Vnode, sig txlog.Signator) VnodeStore
+	// Encrypts values before they are stored as blocks.  Values are stored in plaintext in
+	// the inode if nil.
+	BlockCipher BlockCipher
+	// Codec used to compress blocks and inline values until a cluster wide codec is set.
+	Compression store.Codec
+	// Keys matching the prefix of a class are erasure coded rather than replicated.  The
+	// class with the longest matching prefix is used.
+	ErasureClasses []*ErasureClass
+	// Interval at which shards of erasure coded keys are checked and lost ones
+	// regenerated.  Zero disables repair.
+	ShardRepairInterval time.Duration
+	// Interval at which cluster settings i.e. the keyring of authorized signers and the
+	// compression codec are refreshed.  Zero disables refreshing.
+	KeyringRefresh time.Duration
 }
 
 // DefaultConfig returns a sane config
@@ -41,6 +94,15 @@ func DefaultConfig() *Config {
 	c := &Config{
 		Chord:    chord.DefaultConfig(""),
 		Timeouts: DefaultNetTimeouts(),
+
+		RedirectRetries: 3,
+		RedirectWait:    500 * time.Millisecond,
+		ForkRule:        ForkRuleMajority,
+		KeyFile:         DefaultKeyFile,
+		SignatureScheme: txlog.SchemeECDSA,
+		KeyringRefresh:  30 * time.Second,
+
+		ShardRepairInterval: 5 * time.Minute,
 	}
 
 	c.Chord.NumSuccessors = 7
@@ -96,3 +158,28 @@ func (cfg *Config) ValidateAddrs() error {
 
 	return nil
 }
+
+// KeyPath returns the path to the node signing key file.
+func (cfg *Config) KeyPath() string {
+	if filepath.IsAbs(cfg.KeyFile) {
+		return cfg.KeyFile
+	}
+	return filepath.Join(cfg.DataDir, cfg.KeyFile)
+}
+
+// LoadSignator loads the node signing key from the key file, optionally decrypting it
+// with the passphrase, and sets it as the config signator.  A new key of the configured
+// scheme is generated and written if the key file does not exist.  It returns whether a new key was generated.
+func (cfg *Config) LoadSignator(passphrase []byte) (bool, error) {
+	if cfg.DataDir != "" {
+		if err := os.MkdirAll(cfg.DataDir, 0700); err != nil {
+			return false, err
+		}
+	}
+
+	kp, created, err := txlog.LoadOrGenerateKeypair(cfg.KeyPath(), passphrase, cfg.SignatureScheme)
+	if err == nil {
+		cfg.Signator = kp
+	}
+	return created, err
+}
@@ -55,3 +55,29 @@ func TestConfigValidateAddrs(t *testing.T) {
 	}
 
 }
+
+func TestConfigErasureClass(t *testing.T) {
+	cfg := DefaultConfig()
+
+	ecs, err := ParseErasureClasses("media/:4:2, media/video/:6:3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ErasureClasses = ecs
+
+	if ec := cfg.erasureClass([]byte("media/video/a")); ec == nil || ec.DataShards != 6 {
+		t.Fatal("should match longest prefix")
+	}
+	if ec := cfg.erasureClass([]byte("media/a")); ec == nil || ec.DataShards != 4 {
+		t.Fatal("should match media/")
+	}
+	if cfg.erasureClass([]byte("other")) != nil {
+		t.Fatal("should not be erasure coded")
+	}
+
+	for _, s := range []string{"media/:4", "media/:x:2", "media/:0:2"} {
+		if _, err = ParseErasureClasses(s); err == nil {
+			t.Fatal("should fail", s)
+		}
+	}
+}
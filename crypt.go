@@ -0,0 +1,154 @@
+package difuse
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// Size of the key used to encrypt a block
+const blockKeySize = 32
+
+var (
+	errNoBlockCipher  = errors.New("block cipher not configured")
+	errInvalidKeySize = fmt.Errorf("key must be %d bytes", blockKeySize)
+	errInvalidBlkKey  = errors.New("invalid block key")
+)
+
+// BlockCipher encrypts blocks before they are sent to vnodes so that vnodes only hold
+// ciphertext.  Encryption must be deterministic so identical data results in the same
+// block, preserving de-duplication across keys.
+type BlockCipher interface {
+	// Encrypt returns the ciphertext along with the key material needed to decrypt it.
+	// The key material is stored in the inode referencing the block.
+	Encrypt(data []byte) (ciphertext, key []byte, err error)
+	// Decrypt the ciphertext using the key material returned by Encrypt.
+	Decrypt(ciphertext, key []byte) ([]byte, error)
+}
+
+// ConvergentCipher encrypts each block with a key derived from its content.  The key is
+// stored in the inode, so any client able to read the inode can decrypt the block.  An
+// optional convergence secret shared by the cluster prevents outsiders from confirming
+// whether a known plaintext is stored.
+type ConvergentCipher struct {
+	secret []byte
+}
+
+// NewConvergentCipher instantiates a ConvergentCipher with the convergence secret which
+// may be nil.
+func NewConvergentCipher(secret []byte) *ConvergentCipher {
+	return &ConvergentCipher{secret: secret}
+}
+
+// Encrypt the data returning the ciphertext and content derived key.
+func (cc *ConvergentCipher) Encrypt(data []byte) ([]byte, []byte, error) {
+	key := deriveBlockKey(cc.secret, data)
+	ct, err := sealBlock(key, data)
+	return ct, key, err
+}
+
+// Decrypt the ciphertext with the content derived key.
+func (cc *ConvergentCipher) Decrypt(ciphertext, key []byte) ([]byte, error) {
+	return openBlock(key, ciphertext)
+}
+
+// MasterKeyCipher encrypts blocks with keys derived from the content and a cluster master
+// key.  The block key is stored in the inode wrapped by the master key, so inodes are of
+// no use without it.
+type MasterKeyCipher struct {
+	key []byte
+}
+
+// NewMasterKeyCipher instantiates a MasterKeyCipher with the 32 byte master key.
+func NewMasterKeyCipher(key []byte) (*MasterKeyCipher, error) {
+	if len(key) != blockKeySize {
+		return nil, errInvalidKeySize
+	}
+	return &MasterKeyCipher{key: key}, nil
+}
+
+// Encrypt the data returning the ciphertext and the wrapped block key.
+func (mc *MasterKeyCipher) Encrypt(data []byte) ([]byte, []byte, error) {
+	key := deriveBlockKey(mc.key, data)
+	ct, err := sealBlock(key, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	gcm, err := newGCM(mc.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+
+	return ct, gcm.Seal(nonce, nonce, key, nil), nil
+}
+
+// Decrypt unwraps the block key with the master key and decrypts the ciphertext.
+func (mc *MasterKeyCipher) Decrypt(ciphertext, wrapped []byte) ([]byte, error) {
+	gcm, err := newGCM(mc.key)
+	if err != nil {
+		return nil, err
+	}
+	ns := gcm.NonceSize()
+	if len(wrapped) < ns {
+		return nil, errInvalidBlkKey
+	}
+
+	key, err := gcm.Open(nil, wrapped[:ns], wrapped[ns:], nil)
+	if err != nil {
+		return nil, err
+	}
+	return openBlock(key, ciphertext)
+}
+
+// deriveBlockKey returns the HMAC-SHA256 of the data with the secret or the SHA256 of the
+// data if there is no secret.
+func deriveBlockKey(secret, data []byte) []byte {
+	if len(secret) == 0 {
+		sh := sha256.Sum256(data)
+		return sh[:]
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// sealBlock encrypts the data with AES-256-GCM.  A zero nonce is used as each key is
+// unique to the content and only ever encrypts that content, which also keeps the
+// ciphertext deterministic.
+func sealBlock(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nil, make([]byte, gcm.NonceSize()), data, nil), nil
+}
+
+func openBlock(key, ciphertext []byte) ([]byte, error) {
+	if len(key) != blockKeySize {
+		return nil, errInvalidBlkKey
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, make([]byte, gcm.NonceSize()), ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	blk, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(blk)
+}
@@ -0,0 +1,74 @@
+package difuse
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testBlockCipher(t *testing.T, bc BlockCipher) {
+	data := []byte("block data to be encrypted")
+
+	ct1, key1, err := bc.Encrypt(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(ct1, data) {
+		t.Fatal("ciphertext contains plaintext")
+	}
+
+	// Same data should produce the same block for de-duplication
+	ct2, key2, _ := bc.Encrypt(data)
+	if !bytes.Equal(ct1, ct2) {
+		t.Fatal("ciphertext should be deterministic")
+	}
+
+	for _, key := range [][]byte{key1, key2} {
+		pt, err := bc.Decrypt(ct1, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(pt, data) {
+			t.Fatal("plaintext mismatch")
+		}
+	}
+
+	// Different data should use a different key
+	ct3, key3, _ := bc.Encrypt([]byte("other data"))
+	if bytes.Equal(ct1, ct3) {
+		t.Fatal("ciphertext should differ")
+	}
+	if _, err = bc.Decrypt(ct1, key3); err == nil {
+		t.Fatal("should fail with the wrong key")
+	}
+}
+
+func TestConvergentCipher(t *testing.T) {
+	testBlockCipher(t, NewConvergentCipher(nil))
+	testBlockCipher(t, NewConvergentCipher([]byte("cluster secret")))
+
+	// Different secrets should not produce the same block
+	ct1, _, _ := NewConvergentCipher(nil).Encrypt([]byte("data"))
+	ct2, _, _ := NewConvergentCipher([]byte("cluster secret")).Encrypt([]byte("data"))
+	if bytes.Equal(ct1, ct2) {
+		t.Fatal("secret should change the ciphertext")
+	}
+}
+
+func TestMasterKeyCipher(t *testing.T) {
+	if _, err := NewMasterKeyCipher([]byte("short")); err == nil {
+		t.Fatal("should fail")
+	}
+
+	mc, err := NewMasterKeyCipher(bytes.Repeat([]byte{1}, blockKeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBlockCipher(t, mc)
+
+	// The wrapped key should be useless without the master key
+	ct, key, _ := mc.Encrypt([]byte("data"))
+	other, _ := NewMasterKeyCipher(bytes.Repeat([]byte{2}, blockKeySize))
+	if _, err = other.Decrypt(ct, key); err == nil {
+		t.Fatal("should fail with a different master key")
+	}
+}
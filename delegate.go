@@ -3,33 +3,119 @@ package difuse
 import (
 	"log"
 
+	"golang.org/x/net/context"
+
 	"github.com/ipkg/difuse/store"
 	chord "github.com/ipkg/go-chord"
 )
 
-// Init initializes a log backed datastore for the given vnode
+// Init initializes a log backed datastore for the given vnode.  The vnodes of a node
+// joining a ring do not serve reads until bootstrapped by their successor.
 func (s *Difuse) Init(local *chord.Vnode) {
-	vstore := store.NewMemLoggedStore(local, s.signator)
+	var vstore VnodeStore
+	if s.config.OpenStore != nil {
+		vstore = s.config.OpenStore(local, s.signator)
+	} else {
+		vstore = store.NewMemLoggedStore(local, s.signator)
+	}
+	if len(s.config.Peers) > 0 {
+		vstore = newBootstrapStore(local, vstore, bootstrapTimeout)
+	}
 	s.transport.RegisterVnode(local, vstore)
 }
 
-// NewPredecessor is called when a new predecessor is found
+// NewPredecessor is called when a new predecessor is found.  The new predecessor is
+// bootstrapped from a snapshot of the local vnode falling back to transferring each key
+// and all blocks.
 func (s *Difuse) NewPredecessor(local, remoteNew, remotePrev *chord.Vnode) {
-	// skip local
+	// Leadership of the keys moved with the range must be caught up again
+	s.lkeys.reset()
+
+	// A vnode still being bootstrapped itself hands over once it is done.  This does not
+	// hold up the ring as the bootstrap of the local vnode may depend on it.
+	if st, err := s.transport.local.GetStore(local.Id); err == nil {
+		if bs, ok := st.(*bootstrapStore); ok && !bs.isReady() {
+			go func() {
+				bs.wait()
+				s.bootstrapPredecessor(local, remoteNew)
+			}()
+			return
+		}
+	}
+
+	s.bootstrapPredecessor(local, remoteNew)
+}
+
+// bootstrapPredecessor bootstraps the new predecessor from a snapshot of the local vnode
+// falling back to transferring each key and all blocks.
+func (s *Difuse) bootstrapPredecessor(local, remoteNew *chord.Vnode) {
 	if local.Host == remoteNew.Host {
+		if err := s.bootstrapLocal(local, remoteNew); err != nil {
+			log.Printf("action=bootstrap status=failed src=%s dst=%s msg='%v'", shortID(local), shortID(remoteNew), err)
+		}
+		return
+	}
+
+	err := s.transport.Bootstrap(context.Background(), local, remoteNew, bootstrapFilter(local, remoteNew))
+	if err == nil {
 		return
 	}
+	log.Printf("action=bootstrap status=failed src=%s dst=%s msg='%v'", shortID(local), shortID(remoteNew), err)
 
-	// TODO: queue rather than running right away
-	if err := s.transport.TransferKeys(local, remoteNew); err != nil {
+	if err := s.transport.TransferKeys(context.Background(), local, remoteNew); err != nil {
 		log.Printf("action=transfer status=failed src=%s dst=%s msg='%v'", shortID(local), shortID(remoteNew), err)
 	}
 
-	if err := s.transport.ReplicateBlocks(local, remoteNew); err != nil {
+	if err := s.transport.ReplicateBlocks(context.Background(), local, remoteNew); err != nil {
 		log.Printf("action=replicate-blocks status=failed msg='%v'", err)
 	}
 }
 
+// bootstrapLocal restores a snapshot of the local vnode into a new predecessor on the same
+// host.  Keys whose transactions changed after the snapshot was taken are then queued for
+// replication.  The predecessor serves reads once restored whether or not it succeeded.
+func (s *Difuse) bootstrapLocal(local, pred *chord.Vnode) error {
+	src, err := s.transport.local.GetStore(local.Id)
+	if err != nil {
+		return err
+	}
+	dst, err := s.transport.local.GetStore(pred.Id)
+	if err != nil {
+		return err
+	}
+	// Only a vnode of a joining node is bootstrapped
+	if bs, ok := dst.(*bootstrapStore); !ok || bs.isReady() {
+		return nil
+	}
+
+	filter := bootstrapFilter(local, pred)
+	roots := bootstrapRoots(src, filter)
+	rc, err := src.FilteredSnapshot(filter)
+	if err != nil {
+		if bs, ok := dst.(*bootstrapStore); ok {
+			bs.setReady()
+		}
+		return err
+	}
+	defer rc.Close()
+
+	if err = restoreBootstrap(dst, rc); err != nil {
+		return err
+	}
+
+	var keys [][]byte
+	src.IterInodes(func(key []byte, inode *store.Inode) error {
+		if catchupFilter(roots, filter)(key, inode) {
+			keys = append(keys, append([]byte(nil), key...))
+		}
+		return nil
+	})
+	for _, key := range keys {
+		s.replQ <- &ReplRequest{Src: local, Dst: pred, Key: key}
+	}
+	return nil
+}
+
 // Leaving is called when local node is leaving the ring
 func (s *Difuse) Leaving(local, pred, succ *chord.Vnode) {
 	//log.Printf("DBG [chord] Leaving local=%s succ=%s", shortID(local), shortID(succ))
@@ -37,11 +123,13 @@ func (s *Difuse) Leaving(local, pred, succ *chord.Vnode) {
 
 // PredecessorLeaving is called when a predecessor leaves
 func (s *Difuse) PredecessorLeaving(local, remote *chord.Vnode) {
+	s.lkeys.reset()
 	//log.Printf("DBG [chord] PredecessorLeaving local=%s remote=%s", shortID(local), shortID(remote))
 }
 
 // SuccessorLeaving is called when a successor leaves
 func (s *Difuse) SuccessorLeaving(local, remote *chord.Vnode) {
+	s.lkeys.reset()
 	//log.Printf("DBG [chord] SuccessorLeaving local=%s remote=%s", shortID(local), shortID(remote))
 }
 
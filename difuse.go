@@ -4,9 +4,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log"
+	"time"
 
 	"github.com/btcsuite/fastsha256"
 	flatbuffers "github.com/google/flatbuffers/go"
+	"golang.org/x/net/context"
 
 	chord "github.com/ipkg/go-chord"
 
@@ -23,11 +26,18 @@ const (
 var (
 	// ErrNotLeader is error not leader
 	ErrNotLeader = errors.New("not leader")
+	// ErrConditionFailed is returned by a conditional write whose condition does not hold
+	ErrConditionFailed = errors.New("condition failed")
 )
 
 // VnodeStore implements an actual persistent store.
 type VnodeStore interface {
 	AppendTx(tx *txlog.Tx) error
+	// ReplicateTx appends a tx of the key's existing chain replicated from another vnode.
+	// Its signer is not required to still be in the keyring.
+	ReplicateTx(tx *txlog.Tx) error
+	// WaitTx waits until the tx has been applied returning the apply error
+	WaitTx(ctx context.Context, key, txhash []byte) error
 	GetTx(key []byte, txhash []byte) (*txlog.Tx, error)
 	NewTx(key []byte) (*txlog.Tx, error)
 	LastTx(key []byte) (*txlog.Tx, error)
@@ -37,6 +47,14 @@ type VnodeStore interface {
 	// Transactions returns transactions starting from the seek point.  If seek is
 	// nil, all transactions are returned.
 	Transactions(key, seek []byte) (txlog.TxSlice, error)
+	// RebaseTx replaces the transactions following the ancestor with the branch keeping
+	// the replaced ones as an orphaned side branch.
+	RebaseTx(key, ancestor []byte, branch txlog.TxSlice) (txlog.TxSlice, error)
+	// OrphanedTx returns the side branches orphaned from the key's chain.
+	OrphanedTx(key []byte) ([]txlog.TxSlice, error)
+	// ProofTx returns the inclusion proofs of the tx within the chain of its key and of
+	// the key's merkle root within the store, both from the same version of the store.
+	ProofTx(key, txhash []byte) (*txlog.StoreProof, error)
 	// Iterate over all inodes in the store.
 	IterInodes(func(key []byte, inode *store.Inode) error) error
 	// Return the inode for the given key or error
@@ -53,42 +71,66 @@ type VnodeStore interface {
 	IterBlocks(func([]byte, []byte) error) error
 
 	Snapshot() (io.ReadCloser, error)
+	// FilteredSnapshot returns a snapshot of the blocks, inodes and transactions
+	// selected by the filter.
+	FilteredSnapshot(*store.SnapshotFilter) (io.ReadCloser, error)
 	Restore(io.Reader) error
 }
 
-// Transport is the transport interface for various rpc calls
+// Transport is the transport interface for various rpc calls.  Remote calls are bound by
+// the deadline of the context.
 type Transport interface {
 	// Stat returns the inode entries from the specified vnodes for the given key
-	Stat(key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error)
-	// Set the inode on the given host returning the leader vnode or error
-	SetInode(string, *store.Inode, *RequestOptions) (*chord.Vnode, error)
-	// Delete the inode on the given host returning the leader vnode or error
-	DeleteInode(string, *store.Inode, *RequestOptions) (*chord.Vnode, error)
+	Stat(ctx context.Context, key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error)
+	// Set the inode on the given host returning the leader vnode and commit token or error
+	SetInode(context.Context, string, *store.Inode, *RequestOptions) (*ResponseMeta, error)
+	// Delete the inode on the given host returning the leader vnode and commit token or error
+	DeleteInode(context.Context, string, *store.Inode, *RequestOptions) (*ResponseMeta, error)
 
 	// Block data is directly on the vnode. This is used when the transaction log is not
 	// needed.  Data set using this call should be stored seperately from the transactional
 	// data in terms of physicality.
-	SetBlock([]byte, *RequestOptions, ...*chord.Vnode) ([]*VnodeResponse, error)
-	GetBlock([]byte, *RequestOptions, ...*chord.Vnode) ([]*VnodeResponse, error)
-	DeleteBlock([]byte, *RequestOptions, ...*chord.Vnode) ([]*VnodeResponse, error)
+	SetBlock(context.Context, []byte, *RequestOptions, ...*chord.Vnode) ([]*VnodeResponse, error)
+	GetBlock(context.Context, []byte, *RequestOptions, ...*chord.Vnode) ([]*VnodeResponse, error)
+	DeleteBlock(context.Context, []byte, *RequestOptions, ...*chord.Vnode) ([]*VnodeResponse, error)
 	// Replicate blocks from the local vnode to the remote one.
-	ReplicateBlocks(src, dst *chord.Vnode) error
-
-	AppendTx(tx *txlog.Tx, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error)
-	GetTx(key, txhash []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error)
-	LastTx(key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error)
-	MerkleRootTx(key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error)
+	ReplicateBlocks(ctx context.Context, src, dst *chord.Vnode) error
+
+	AppendTx(ctx context.Context, tx *txlog.Tx, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error)
+	// AppendTxs appends the txs of each batch to its vnode.  The vnodes all belong to a
+	// single host.  It returns a response per tx in the order of the batches.
+	AppendTxs(ctx context.Context, batches []*VnodeTxs, options *RequestOptions) ([]*VnodeResponse, error)
+	GetTx(ctx context.Context, key, txhash []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error)
+	LastTx(ctx context.Context, key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error)
+	MerkleRootTx(ctx context.Context, key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error)
+	// ProofTx returns the inclusion proof of the tx from each vnode.
+	ProofTx(ctx context.Context, key, txhash []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error)
 	NewTx(key []byte, vs ...*chord.Vnode) ([]*VnodeResponse, error)
 	// Replicate transactions from remote to local vnode for the key starting at the
 	// seek hash.
-	ReplicateTransactions(key, seek []byte, remote, local *chord.Vnode) error
+	ReplicateTransactions(ctx context.Context, key, seek []byte, remote, local *chord.Vnode) error
+	// Transactions returns the transactions for the key from the vnode starting at the
+	// seek hash.  If seek is nil all transactions are returned.
+	Transactions(ctx context.Context, key, seek []byte, vn *chord.Vnode) (txlog.TxSlice, error)
 
 	// Transfer keys from the local vnode to the remote one.
-	TransferKeys(src, dst *chord.Vnode) error
+	TransferKeys(ctx context.Context, src, dst *chord.Vnode) error
+	// Bootstrap restores a snapshot of the data selected by the filter from the local
+	// vnode into the new remote one.  Keys written since the snapshot are then transferred.
+	Bootstrap(ctx context.Context, src, dst *chord.Vnode, filter *store.SnapshotFilter) error
 
 	// Lookup the leader for the given key on the given host returning the leader, an ordered list of
 	// other vnodes as well as a host-to-vnode map.
-	LookupLeader(host string, key []byte) (*chord.Vnode, []*chord.Vnode, map[string][]*chord.Vnode, error)
+	LookupLeader(ctx context.Context, host string, key []byte) (*chord.Vnode, []*chord.Vnode, map[string][]*chord.Vnode, error)
+
+	// BatchGet gets the keys of the entries on the given host returning a result per entry
+	BatchGet(ctx context.Context, host string, entries []*BatchEntry, options *RequestOptions) ([]*BatchEntry, error)
+	// BatchSet sets the entries on the given host returning a result per entry
+	BatchSet(ctx context.Context, host string, entries []*BatchEntry, options *RequestOptions) ([]*BatchEntry, error)
+
+	// Snapshot returns a snapshot of the keys and blocks of the range led by rng from
+	// the store of the src vnode.
+	Snapshot(ctx context.Context, src, rng *chord.Vnode) (io.ReadCloser, error)
 
 	// RegisterVnode registers a datastore for a vnode.
 	RegisterVnode(*chord.Vnode, VnodeStore)
@@ -98,32 +140,67 @@ type Transport interface {
 
 // ConsistentStore implements consistent store methods using the underlying ring methods.
 type ConsistentStore interface {
-	LookupLeader(key []byte) (*chord.Vnode, []*chord.Vnode, map[string][]*chord.Vnode, error)
-	// SetInode sets the given inode returning the leader for the inode and error
-	SetInode(inode *store.Inode, options *RequestOptions) (*chord.Vnode, error)
-	// DeleteInode deletes the given inode returning the leader for the inode and error
-	DeleteInode(inode *store.Inode, options *RequestOptions) (*chord.Vnode, error)
+	LookupLeader(ctx context.Context, key []byte) (*chord.Vnode, []*chord.Vnode, map[string][]*chord.Vnode, error)
+	// SetInode sets the given inode returning the leader for the inode, the commit token
+	// and error
+	SetInode(ctx context.Context, inode *store.Inode, options *RequestOptions) (*ResponseMeta, error)
+	// DeleteInode deletes the given inode returning the leader for the inode, the commit
+	// token and error
+	DeleteInode(ctx context.Context, inode *store.Inode, options *RequestOptions) (*ResponseMeta, error)
+	// Get returns the value of the key
+	Get(ctx context.Context, key []byte, options ...RequestOptions) ([]byte, *ResponseMeta, error)
+	// Set sets the key to the value returning the leader for the key
+	Set(ctx context.Context, key, value []byte, options ...RequestOptions) (*ResponseMeta, error)
+	// SetBatch sets the keys of the entries returning a result per entry
+	SetBatch(ctx context.Context, entries []*BatchEntry, options *RequestOptions) []*BatchEntry
+	// RangeFilter returns the filter selecting the keys and blocks of the range led by
+	// the vnode
+	RangeFilter(vn *chord.Vnode) *store.SnapshotFilter
+	// IsSuccessor returns whether the vnode is the first successor of the local vnode
+	IsSuccessor(local, vn *chord.Vnode) bool
 }
 
 // Difuse is the core engine
 type Difuse struct {
 	signator txlog.Signator
+	// authorized transaction signers
+	keyring *txlog.Keyring
 
 	ring   *chord.Ring
 	config *Config
 
 	transport *localTransport
 
+	// keys for which a local vnode is the caught up leader
+	lkeys *leaderKeys
+	// codec used to compress new data
+	codec *clusterCodec
+
 	replQ chan *ReplRequest
 }
 
-// NewDifuse instantiates a new Difuse instance, generating a new keypair and setting the
-// given remote transport.
-func NewDifuse(conf *Config, trans Transport) *Difuse {
-	sig, _ := txlog.GenerateECDSAKeypair()
+// NewDifuse instantiates a new Difuse instance setting the given remote transport.  The
+// signator from the config is used to sign transactions.  If one is not set an ephemeral
+// keypair is generated returning an error if that fails.
+func NewDifuse(conf *Config, trans Transport) (*Difuse, error) {
+	sig := conf.Signator
+	if sig == nil {
+		kp, err := txlog.GenerateKeypair(conf.SignatureScheme)
+		if err != nil {
+			return nil, err
+		}
+		log.Println("action=keygen status=ok msg='using ephemeral signing key'")
+		sig = kp
+	}
+
+	// No signers are authorized until the cluster keyring has been read
+	kr := txlog.NewPendingKeyring()
 	slt := &Difuse{
 		config:   conf,
-		signator: sig,
+		signator: txlog.NewKeyringSignator(sig, kr),
+		keyring:  kr,
+		lkeys:    newLeaderKeys(),
+		codec:    &clusterCodec{codec: conf.Compression},
 		replQ:    make(chan *ReplRequest, replicationQSize),
 	}
 
@@ -132,83 +209,197 @@ func NewDifuse(conf *Config, trans Transport) *Difuse {
 	trans.RegisterReplicationQ(slt.replQ)
 	go slt.startReplEngine()
 
-	return slt
+	return slt, nil
 }
 
 // RegisterRing registers the chord ring to the difuse instance.
 func (s *Difuse) RegisterRing(ring *chord.Ring) {
 	s.ring = ring
 	s.transport.Register(s)
+
+	// The node creating the cluster seeds the keyring.  Signers are only authorized once
+	// the cluster keyring has been read so a restarted node does not accept revoked
+	// signers in the meantime.
+	if len(s.config.Peers) == 0 {
+		if err := s.seedKeyring(); err != nil {
+			log.Printf("action=keyring-seed status=failed msg='%v'", err)
+		}
+	}
+	if err := s.syncKeyring(); err != nil {
+		log.Printf("action=keyring-sync status=failed msg='%v'", err)
+	}
+	go s.startClusterSync()
+	if s.config.ShardRepairInterval > 0 && len(s.config.ErasureClasses) > 0 {
+		go s.startShardRepair()
+	}
+}
+
+// PublicKey returns the encoded public key used by this node to sign transactions.
+func (s *Difuse) PublicKey() []byte {
+	return s.signator.PublicKey().Bytes()
 }
 
 // Get retrieves a the given key.  It first gets the inode then retrieves the underlying
 // blocks
-func (s *Difuse) Get(key []byte, options ...RequestOptions) ([]byte, *ResponseMeta, error) {
-	inode, meta, err := s.Stat(key, options...)
+func (s *Difuse) Get(ctx context.Context, key []byte, options ...RequestOptions) ([]byte, *ResponseMeta, error) {
+	inode, meta, err := s.Stat(ctx, key, options...)
 	if err != nil {
 		return nil, meta, err
 	}
-	return inode.Blocks[0], meta, nil
-	/*out := make([]byte, 0, inode.Size)
-	for _, bh := range inode.Blocks {
 
-		bd, err := s.GetBlock(bh, options...)
+	out, err := s.readInode(ctx, inode)
+	return out, meta, err
+}
+
+// readInode returns the value of the inode reading the blocks or shards it references.
+func (s *Difuse) readInode(ctx context.Context, inode *store.Inode) ([]byte, error) {
+	switch inode.Type {
+	case store.FileInodeType:
+		return s.readBlocks(ctx, inode)
+	case store.ErasureInodeType:
+		return s.readShards(ctx, inode)
+	}
+	return store.Decompress(inode.Blocks[0])
+}
+
+// readBlocks reads and concatenates the blocks of the inode, decrypting and decompressing
+// them if the inode has keys.
+func (s *Difuse) readBlocks(ctx context.Context, inode *store.Inode) ([]byte, error) {
+	out := make([]byte, 0, inode.Size)
+	for i, bh := range inode.Blocks {
+		bd, err := s.GetBlock(ctx, bh)
 		if err != nil {
-			return nil, meta, err
+			return nil, err
+		}
+
+		if i < len(inode.Keys) {
+			if s.config.BlockCipher == nil {
+				return nil, errNoBlockCipher
+			}
+			if bd, err = s.config.BlockCipher.Decrypt(bd, inode.Keys[i]); err != nil {
+				return nil, err
+			}
+			if bd, err = store.Decompress(bd); err != nil {
+				return nil, err
+			}
 		}
 
 		out = append(out, bd...)
 	}
-	return out, meta, nil*/
+	return out, nil
 }
 
 // Delete deletes an inode associated to the given key based on provided options. Returns
 // the leader vnode and error
-func (s *Difuse) Delete(key []byte, options ...RequestOptions) (*store.Inode, *ResponseMeta, error) {
-	inode, _, err := s.Stat(key, options...)
+func (s *Difuse) Delete(ctx context.Context, key []byte, options ...RequestOptions) (*store.Inode, *ResponseMeta, error) {
+	if IsReservedKey(key) {
+		return nil, nil, ErrReservedKey
+	}
+
+	inode, _, err := s.Stat(ctx, key, options...)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	rmeta := &ResponseMeta{}
+	var rmeta *ResponseMeta
 	if len(options) > 0 {
-		rmeta.Vnode, err = s.DeleteInode(inode, &options[0])
+		rmeta, err = s.DeleteInode(ctx, inode, &options[0])
 	} else {
-		rmeta.Vnode, err = s.DeleteInode(inode, nil)
+		rmeta, err = s.DeleteInode(ctx, inode, nil)
 	}
 
 	return inode, rmeta, err
 }
 
-// Set sets a key to the given value.  It first sets the underlying block data then
-// sets the inode.  Returns the leader vnode and error
-func (s *Difuse) Set(key, value []byte, options ...RequestOptions) (*ResponseMeta, error) {
-	/*hsh, err := s.SetBlock(value, options...)
+// Set sets a key to the given value.  If a block cipher is configured the value is
+// encrypted and stored as a block referenced by the inode, otherwise the value is stored
+// in the inode.  Values of keys belonging to an erasure class are stored as shards
+// referenced by the inode.  Returns the leader vnode and error
+func (s *Difuse) Set(ctx context.Context, key, value []byte, options ...RequestOptions) (*ResponseMeta, error) {
+	if IsReservedKey(key) {
+		return nil, ErrReservedKey
+	}
+
+	inode, err := s.valueInode(ctx, key, value)
 	if err != nil {
 		return nil, err
-	}*/
-	inode := store.NewKeyInodeWithValue(key, value)
-
-	/*inode := store.NewInode(key)
-	inode.Size = int64(len(value))
-	inode.Blocks = [][]byte{hsh}*/
+	}
 
-	var (
-		rmeta = &ResponseMeta{}
-		err   error
-	)
+	var rmeta *ResponseMeta
 	if len(options) > 0 {
-		rmeta.Vnode, err = s.SetInode(inode, &options[0])
+		rmeta, err = s.SetInode(ctx, inode, &options[0])
 	} else {
-		rmeta.Vnode, err = s.SetInode(inode, nil)
+		rmeta, err = s.SetInode(ctx, inode, nil)
 	}
 
 	return rmeta, err
 }
 
+// valueInode stores the value of the key as Set does returning the inode referencing it.
+func (s *Difuse) valueInode(ctx context.Context, key, value []byte) (*store.Inode, error) {
+	var inode *store.Inode
+
+	// Compress before encrypting as ciphertext does not compress
+	cv, err := store.Compress(s.codec.get(), value)
+	if err != nil {
+		return nil, err
+	}
+
+	if ec := s.config.erasureClass(key); ec != nil {
+		var keys [][]byte
+		if s.config.BlockCipher != nil {
+			ct, bkey, err := s.config.BlockCipher.Encrypt(cv)
+			if err != nil {
+				return nil, err
+			}
+			cv, keys = ct, [][]byte{bkey}
+		}
+
+		vns, err := s.shardVnodes(key)
+		if err != nil {
+			return nil, err
+		}
+		hashes, err := WriteShards(ctx, s.transport, vns, cv, ec)
+		if err != nil {
+			return nil, err
+		}
+		inode = store.NewErasureInode(key, int64(len(value)), hashes, keys)
+
+	} else if s.config.BlockCipher != nil {
+		// Store the value as an encrypted block referenced by the inode
+		ct, bkey, err := s.config.BlockCipher.Encrypt(cv)
+		if err != nil {
+			return nil, err
+		}
+		hsh, err := s.setBlock(ctx, ct, store.CodecNone)
+		if err != nil {
+			return nil, err
+		}
+		inode = store.NewFileInode(key, int64(len(value)), [][]byte{hsh}, [][]byte{bkey})
+	} else {
+		inode = store.NewKeyInodeWithValue(key, cv)
+		inode.Size = int64(len(value))
+	}
+
+	return inode, nil
+}
+
+// CompareAndSet sets the key to the value if its current value is old.  A nil old value
+// requires the key to not exist.  It fails with ErrConditionFailed if the current value
+// differs.  Other options are as for Set.
+func (s *Difuse) CompareAndSet(ctx context.Context, key, old, value []byte, options ...RequestOptions) (*ResponseMeta, error) {
+	var opts RequestOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	opts.If = &Condition{Value: old}
+
+	return s.Set(ctx, key, value, opts)
+}
+
 // DeleteInode deletes the given inode.  It only deletes the inode and not the underlying data.
-// It returns the leader and error
-func (s *Difuse) DeleteInode(inode *store.Inode, options *RequestOptions) (*chord.Vnode, error) {
+// It returns the leader and commit token of the delete and error
+func (s *Difuse) DeleteInode(ctx context.Context, inode *store.Inode, options *RequestOptions) (*ResponseMeta, error) {
 	var opts *RequestOptions
 	if options != nil {
 		opts = options
@@ -216,21 +407,12 @@ func (s *Difuse) DeleteInode(inode *store.Inode, options *RequestOptions) (*chor
 		opts = &RequestOptions{Consistency: ConsistencyLeader}
 	}
 
-	fb := flatbuffers.NewBuilder(0)
-	fb.Finish(inode.Serialize(fb))
-
-	lvn, err := s.appendTx(store.TxTypeDelete, inode.Id, fb.Bytes[fb.Head():], opts)
-	if err == ErrNotLeader {
-		// Redirect to leader
-		return s.transport.DeleteInode(lvn.Host, inode, opts)
-	}
-
-	return lvn, err
+	return s.submitInode(ctx, store.TxTypeDelete, inode, opts)
 }
 
 // SetInode takes the given inode, creates a set tx and submits it based on the
-// given consistency level. It returns the leader and error
-func (s *Difuse) SetInode(inode *store.Inode, options *RequestOptions) (*chord.Vnode, error) {
+// given consistency level. It returns the leader and commit token of the write and error
+func (s *Difuse) SetInode(ctx context.Context, inode *store.Inode, options *RequestOptions) (*ResponseMeta, error) {
 	var opts *RequestOptions
 	if options != nil {
 		opts = options
@@ -238,20 +420,60 @@ func (s *Difuse) SetInode(inode *store.Inode, options *RequestOptions) (*chord.V
 		opts = &RequestOptions{Consistency: ConsistencyLeader}
 	}
 
+	return s.submitInode(ctx, store.TxTypeSet, inode, opts)
+}
+
+// submitInode submits an inode tx of the given type.  If this node is not the leader the
+// request is redirected to the leader.  If the leader is unreachable, no longer the leader
+// or still catching up, the leader is looked up again and the request retried.  The
+// returned meta is never nil.
+func (s *Difuse) submitInode(ctx context.Context, txtype byte, inode *store.Inode, opts *RequestOptions) (*ResponseMeta, error) {
 	fb := flatbuffers.NewBuilder(0)
 	fb.Finish(inode.Serialize(fb))
+	data := fb.Bytes[fb.Head():]
+
+	meta, err := s.appendTx(ctx, txtype, inode.Id, data, opts)
+
+	for i := 0; i <= s.config.RedirectRetries && isRetryableLeaderErr(err); i++ {
+		lvn := meta.Vnode
+		rerr := err
+		// A local not-leader error contains the leader, otherwise wait for the ring to
+		// settle and lookup the leader again.
+		if err != ErrNotLeader {
+			select {
+			case <-time.After(s.config.RedirectWait):
+			case <-ctx.Done():
+				return meta, ctx.Err()
+			}
+			if lvn, _, _, err = s.LookupLeader(ctx, inode.Id); err != nil {
+				return &ResponseMeta{}, err
+			}
+		}
 
-	lvn, err := s.appendTx(store.TxTypeSet, inode.Id, fb.Bytes[fb.Head():], opts)
-	if err == ErrNotLeader {
-		//  Redirect to leader
-		return s.transport.SetInode(lvn.Host, inode, opts)
+		switch {
+		case s.isLeader(lvn):
+			meta, err = s.appendTx(ctx, txtype, inode.Id, data, opts)
+		case txtype == store.TxTypeDelete:
+			meta, err = s.transport.DeleteInode(ctx, lvn.Host, inode, opts)
+		default:
+			meta, err = s.transport.SetInode(ctx, lvn.Host, inode, opts)
+		}
+		if meta == nil {
+			meta = &ResponseMeta{}
+		}
+		// An earlier attempt that timed out may still have been applied making the
+		// condition of a retried write fail on its own value.  Return the earlier
+		// error as the outcome is unknown.
+		if opts.If != nil && ErrorCodeOf(err) == CodeConditionFailed && ErrorCodeOf(rerr) != CodeNotLeader {
+			return meta, rerr
+		}
 	}
 
-	return lvn, err
+	return meta, err
 }
 
 // Stat returns the inode entry for the key. By default it uses the leader consistency
-func (s *Difuse) Stat(key []byte, options ...RequestOptions) (*store.Inode, *ResponseMeta, error) {
+func (s *Difuse) Stat(ctx context.Context, key []byte, options ...RequestOptions) (*store.Inode, *ResponseMeta, error) {
 	var opts *RequestOptions
 	if len(options) > 0 {
 		opts = &options[0]
@@ -263,13 +485,13 @@ func (s *Difuse) Stat(key []byte, options ...RequestOptions) (*store.Inode, *Res
 
 	switch opts.Consistency {
 	case ConsistencyLeader:
-		l, _, _, err := s.LookupLeader(key)
+		l, _, _, err := s.LookupLeader(ctx, key)
 		if err != nil {
 			return nil, nil, err
 		}
 		rmeta.Vnode = l
 
-		resp, err := s.transport.Stat(key, opts, l)
+		resp, err := s.transport.Stat(ctx, key, opts, l)
 		if err != nil {
 			return nil, rmeta, err
 		}
@@ -284,61 +506,33 @@ func (s *Difuse) Stat(key []byte, options ...RequestOptions) (*store.Inode, *Res
 		return nil, rmeta, fmt.Errorf(errInvalidDataType, resp[0].Data)
 
 	case ConsistencyLazy:
-		ccfg := s.config.Chord
-
-		vl, err := s.ring.Lookup(ccfg.NumSuccessors, key)
+		vl, err := s.ring.Lookup(s.config.Chord.NumSuccessors, key)
 		if err != nil {
 			return nil, nil, err
 		}
 
-		vnb := vnodesByHost(vl)
-		// Try local vnodes first
-		if vns, ok := vnb[ccfg.Hostname]; ok {
-			resp, er := s.transport.Stat(key, opts, vns...)
-			if er == nil {
-				for i, rsp := range resp {
-					if rsp.Err == nil {
-						if ind, ok := rsp.Data.(*store.Inode); ok {
-							rmeta.Vnode = vns[i]
-							return ind, rmeta, nil
-						}
-					}
-				}
-			}
-			// Remove local host as we've already visited it.
-			delete(vnb, ccfg.Hostname)
-		}
-
-		// try the remainder
-		for _, vns := range vnb {
-			resp, er := s.transport.Stat(key, opts, vns...)
-			if er != nil {
-				err = er
+		resp, err := fanout(ctx, vl, fanoutFirst, func(ctx context.Context, vns ...*chord.Vnode) ([]*VnodeResponse, error) {
+			return s.transport.Stat(ctx, key, opts, vns...)
+		})
+		for i, rsp := range resp {
+			if rsp.Err != nil {
 				continue
 			}
-
-			for i, rsp := range resp {
-				if rsp.Err != nil {
-					err = rsp.Err
-					continue
-				}
-
-				if ind, ok := rsp.Data.(*store.Inode); ok {
-					rmeta.Vnode = vns[i]
-					return ind, rmeta, nil
-				}
-				err = fmt.Errorf(errInvalidDataType, rsp.Data)
+			if ind, ok := rsp.Data.(*store.Inode); ok {
+				rmeta.Vnode = vl[i]
+				return ind, rmeta, nil
 			}
+			err = fmt.Errorf(errInvalidDataType, rsp.Data)
 		}
 
 		return nil, rmeta, err
 	}
 
-	return nil, rmeta, fmt.Errorf(errInvalidConsistencyLevel, opts.Consistency)
+	return nil, rmeta, invalidConsistencyError(opts.Consistency)
 }
 
 // GetBlock gets a block based on the provided options
-func (s *Difuse) GetBlock(hash []byte, options ...RequestOptions) ([]byte, error) {
+func (s *Difuse) GetBlock(ctx context.Context, hash []byte, options ...RequestOptions) ([]byte, error) {
 	var opts *RequestOptions
 	if len(options) > 0 {
 		opts = &options[0]
@@ -353,35 +547,33 @@ func (s *Difuse) GetBlock(hash []byte, options ...RequestOptions) ([]byte, error
 			return nil, err
 		}
 
-		vm := vnodesByHost(vns)
-		for _, vl := range vm {
-			resp, er := s.transport.GetBlock(hash, opts, vl...)
-			if er != nil {
-				return nil, err
+		resp, err := fanout(ctx, vns, fanoutFirst, func(ctx context.Context, vl ...*chord.Vnode) ([]*VnodeResponse, error) {
+			return s.transport.GetBlock(ctx, hash, opts, vl...)
+		})
+		for _, v := range resp {
+			if v.Err != nil {
+				continue
 			}
-			// check all responses
-			for _, v := range resp {
-				if v.Err == nil {
-					if val, ok := v.Data.([]byte); ok {
-						return val, nil
-					}
-					err = fmt.Errorf(errInvalidDataType, v.Data)
-				} else {
-					err = v.Err
-				}
+			if val, ok := v.Data.([]byte); ok {
+				return store.Decompress(val)
 			}
+			err = fmt.Errorf(errInvalidDataType, v.Data)
 		}
 
 		return nil, err
-
 	}
 
-	return nil, fmt.Errorf(errInvalidConsistencyLevel, opts.Consistency)
+	return nil, invalidConsistencyError(opts.Consistency)
 }
 
 // SetBlock sets the block data on all vnodes based on the options returning the hash key
-// for the data or an error
-func (s *Difuse) SetBlock(data []byte, options ...RequestOptions) ([]byte, error) {
+// for the data or an error.  The data is compressed with the cluster codec while the
+// hash key is that of the uncompressed data.
+func (s *Difuse) SetBlock(ctx context.Context, data []byte, options ...RequestOptions) ([]byte, error) {
+	return s.setBlock(ctx, data, s.codec.get(), options...)
+}
+
+func (s *Difuse) setBlock(ctx context.Context, data []byte, codec store.Codec, options ...RequestOptions) ([]byte, error) {
 	var opts *RequestOptions
 	if len(options) > 0 {
 		opts = &options[0]
@@ -391,36 +583,37 @@ func (s *Difuse) SetBlock(data []byte, options ...RequestOptions) ([]byte, error
 
 	sh := fastsha256.Sum256(data)
 
+	cd, err := store.Compress(codec, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.putBlock(ctx, sh[:], cd, opts); err != nil {
+		return nil, err
+	}
+	return sh[:], nil
+}
+
+// putBlock writes the already encoded block data to the successors of the hash.
+func (s *Difuse) putBlock(ctx context.Context, hash, data []byte, opts *RequestOptions) error {
 	switch opts.Consistency {
 	case ConsistencyAll:
 
-		vns, err := s.ring.Lookup(s.config.Chord.NumSuccessors, sh[:])
+		vns, err := s.ring.Lookup(s.config.Chord.NumSuccessors, hash)
 		if err != nil {
-			return nil, err
-		}
-		vm := vnodesByHost(vns)
-
-		//out := make([]*VnodeResponse, 0)
-		for _, vl := range vm {
-			resp, er := s.transport.SetBlock(data, opts, vl...)
-			if er != nil {
-				return nil, err
-			}
-			// check all responses
-			for _, v := range resp {
-				if v.Err != nil {
-					return nil, v.Err
-				}
-			}
+			return err
 		}
-		return sh[:], nil
+		_, err = fanout(ctx, vns, fanoutAll, func(ctx context.Context, vl ...*chord.Vnode) ([]*VnodeResponse, error) {
+			return s.transport.SetBlock(ctx, data, opts, vl...)
+		})
+		return err
 	}
 
-	return nil, fmt.Errorf(errInvalidConsistencyLevel, opts.Consistency)
+	return invalidConsistencyError(opts.Consistency)
 }
 
 // DeleteBlock deletes the block from all vnodes based on the specified consistency.
-func (s *Difuse) DeleteBlock(hash []byte, options ...RequestOptions) error {
+func (s *Difuse) DeleteBlock(ctx context.Context, hash []byte, options ...RequestOptions) error {
 	var opts *RequestOptions
 	if len(options) > 0 {
 		opts = &options[0]
@@ -435,34 +628,30 @@ func (s *Difuse) DeleteBlock(hash []byte, options ...RequestOptions) error {
 		if err != nil {
 			return err
 		}
-		vm := vnodesByHost(vns)
-
-		for _, vl := range vm {
-			resp, er := s.transport.DeleteBlock(hash, opts, vl...)
-			if er != nil {
-				return err
-			}
-			// check all responses
-			for _, v := range resp {
-				if v.Err != nil {
-					return v.Err
-				}
-			}
-		}
-		return nil
+		_, err = fanout(ctx, vns, fanoutAll, func(ctx context.Context, vl ...*chord.Vnode) ([]*VnodeResponse, error) {
+			return s.transport.DeleteBlock(ctx, hash, opts, vl...)
+		})
+		return err
 	}
 
-	return fmt.Errorf(errInvalidConsistencyLevel, opts.Consistency)
+	return invalidConsistencyError(opts.Consistency)
 }
 
 // LookupLeader does a lookup on the key and returns the leader for the key, vnodes
 // used to compute the leader
-func (s *Difuse) LookupLeader(key []byte) (*chord.Vnode, []*chord.Vnode, map[string][]*chord.Vnode, error) {
+func (s *Difuse) LookupLeader(ctx context.Context, key []byte) (*chord.Vnode, []*chord.Vnode, map[string][]*chord.Vnode, error) {
+	l, vs, vm, _, err := s.lookupLeader(ctx, key)
+	return l, vs, vm, err
+}
+
+// lookupLeader is LookupLeader also returning whether the vote electing the leader was
+// settled.
+func (s *Difuse) lookupLeader(ctx context.Context, key []byte) (*chord.Vnode, []*chord.Vnode, map[string][]*chord.Vnode, bool, error) {
 	vs, err := s.ring.Lookup(s.config.Chord.NumSuccessors, key)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, false, err
 	}
 
-	l, vm, err := s.keyleader(key, vs)
-	return l, vs, vm, err
+	l, vm, settled, err := s.keyleader(ctx, key, vs)
+	return l, vs, vm, settled, err
 }
@@ -6,6 +6,8 @@ import (
 	"testing"
 	"time"
 
+	"golang.org/x/net/context"
+
 	"github.com/ipkg/difuse/store"
 	chord "github.com/ipkg/go-chord"
 )
@@ -25,7 +27,10 @@ func prepDifuse(p int, j ...string) (*Difuse, error) {
 		return nil, err
 	}
 
-	sault1 := NewDifuse(c1, t1)
+	sault1, err := NewDifuse(c1, t1)
+	if err != nil {
+		return nil, err
+	}
 	c1.Chord.Delegate = sault1
 
 	ct1 := chord.NewGRPCTransport(3*time.Second, 300*time.Second)
@@ -70,12 +75,12 @@ func TestDifuseLookupLeader(t *testing.T) {
 
 	testkey := []byte("test-key-for-leader-election")
 
-	lvn1, _, vm1, err := s1.transport.LookupLeader("127.0.0.1:12346", testkey)
+	lvn1, _, vm1, err := s1.transport.LookupLeader(context.Background(), "127.0.0.1:12346", testkey)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	lvn2, _, vm2, err := s2.transport.LookupLeader("127.0.0.1:12345", testkey)
+	lvn2, _, vm2, err := s2.transport.LookupLeader(context.Background(), "127.0.0.1:12345", testkey)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -84,11 +89,11 @@ func TestDifuseLookupLeader(t *testing.T) {
 		t.Errorf("leader mismatch %s!=%s", lvn1.String(), lvn2.String())
 	}
 
-	if _, _, err = s1.Stat([]byte("Key")); err == nil {
+	if _, _, err = s1.Stat(context.Background(), []byte("Key")); err == nil {
 		t.Fatal("should fail")
 	}
 
-	if _, _, err = s1.Stat([]byte("Key"), RequestOptions{Consistency: 99}); err == nil {
+	if _, _, err = s1.Stat(context.Background(), []byte("Key"), RequestOptions{Consistency: 99}); err == nil {
 		t.Fatal("should fail")
 	}
 
@@ -120,16 +125,16 @@ func TestDifuseSetStat(t *testing.T) {
 	testval := []byte("testvalue")
 	testInode := store.NewKeyInodeWithValue(testkey, testval)
 
-	if _, err = s1.SetInode(testInode, nil); err != nil {
+	if _, err = s1.SetInode(context.Background(), testInode, nil); err != nil {
 		t.Fatal(err)
 	}
 
-	ind1, _, err := s1.Stat(testkey)
+	ind1, _, err := s1.Stat(context.Background(), testkey)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	ind2, _, err := s2.Stat(testkey)
+	ind2, _, err := s2.Stat(context.Background(), testkey)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -142,21 +147,21 @@ func TestDifuseSetStat(t *testing.T) {
 		t.Fatal("block hash mismatch")
 	}
 
-	_, _, err = s1.Stat(testkey, RequestOptions{Consistency: ConsistencyLazy})
+	_, _, err = s1.Stat(context.Background(), testkey, RequestOptions{Consistency: ConsistencyLazy})
 	if err != nil {
 		t.Error(err)
 	}
 
-	shash, err := s2.SetBlock([]byte("testdata"))
+	shash, err := s2.SetBlock(context.Background(), []byte("testdata"))
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if _, err = s2.Set(testkey, testval); err != nil {
+	if _, err = s2.Set(context.Background(), testkey, testval); err != nil {
 		t.Fatal(err)
 	}
 
-	val, _, err := s1.Get(testkey)
+	val, _, err := s1.Get(context.Background(), testkey)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -164,13 +169,13 @@ func TestDifuseSetStat(t *testing.T) {
 		t.Fatal("value mismatch")
 	}
 
-	if _, _, err = s2.Delete(testkey); err != nil {
+	if _, _, err = s2.Delete(context.Background(), testkey); err != nil {
 		//t.Fatal("should fail")
 		t.Fatal(err)
 	}
 
 	//<-time.After(1 * time.Second)
-	if _, _, err = s1.Delete(testkey); err == nil {
+	if _, _, err = s1.Delete(context.Background(), testkey); err == nil {
 		t.Fatal("should fail")
 	}
 
@@ -178,7 +183,7 @@ func TestDifuseSetStat(t *testing.T) {
 		t.Fatal(err)
 	}*/
 
-	if err = s1.DeleteBlock(shash); err != nil {
+	if err = s1.DeleteBlock(context.Background(), shash); err != nil {
 		t.Fatal(err)
 	}
 
@@ -0,0 +1,229 @@
+// Package erasure implements systematic Reed-Solomon erasure coding.  Data is split into k
+// data shards and m parity shards such that the data can be rebuilt from any k shards.
+package erasure
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const maxShards = 255
+
+var (
+	errTooFewShards   = errors.New("too few shards to reconstruct")
+	errShardCount     = errors.New("wrong number of shards")
+	errShardSize      = errors.New("shards must be of equal size")
+	errInvalidShard   = errors.New("invalid shard")
+	errInvalidPayload = errors.New("invalid payload")
+)
+
+// Encoder encodes and reconstructs shards for a fixed number of data and parity shards.
+type Encoder struct {
+	k, m int
+	// (k+m) x k encoding matrix where the top k rows are the identity
+	enc matrix
+}
+
+// New instantiates an Encoder with the number of data and parity shards.
+func New(dataShards, parityShards int) (*Encoder, error) {
+	if dataShards < 1 || parityShards < 0 || dataShards+parityShards > maxShards {
+		return nil, fmt.Errorf("invalid shard counts: data=%d parity=%d", dataShards, parityShards)
+	}
+
+	vm := vandermonde(dataShards+parityShards, dataShards)
+	top, err := vm[:dataShards].invert()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Encoder{k: dataShards, m: parityShards, enc: vm.mul(top)}, nil
+}
+
+// DataShards returns the number of data shards.
+func (e *Encoder) DataShards() int { return e.k }
+
+// ParityShards returns the number of parity shards.
+func (e *Encoder) ParityShards() int { return e.m }
+
+// Split splits the data into data shards prefixed with its length and computes the
+// parity shards.  All shards are of equal size.
+func (e *Encoder) Split(data []byte) ([][]byte, error) {
+	payload := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint64(payload, uint64(len(data)))
+	copy(payload[8:], data)
+
+	size := (len(payload) + e.k - 1) / e.k
+	shards := make([][]byte, e.k+e.m)
+	for i := range shards {
+		shards[i] = make([]byte, size)
+		if i < e.k && i*size < len(payload) {
+			copy(shards[i], payload[i*size:])
+		}
+	}
+
+	return shards, e.Encode(shards)
+}
+
+// Encode computes the parity shards from the data shards.
+func (e *Encoder) Encode(shards [][]byte) error {
+	if len(shards) != e.k+e.m {
+		return errShardCount
+	}
+	size := len(shards[0])
+	for _, s := range shards {
+		if len(s) != size {
+			return errShardSize
+		}
+	}
+
+	for p := e.k; p < e.k+e.m; p++ {
+		out := shards[p]
+		for i := range out {
+			out[i] = 0
+		}
+		for d := 0; d < e.k; d++ {
+			mulAdd(e.enc[p][d], shards[d], out)
+		}
+	}
+	return nil
+}
+
+// Reconstruct rebuilds missing shards, signified by nil entries, from any k available
+// shards.
+func (e *Encoder) Reconstruct(shards [][]byte) error {
+	if len(shards) != e.k+e.m {
+		return errShardCount
+	}
+
+	var (
+		size  = -1
+		rows  = make([]int, 0, e.k)
+		avail = make([][]byte, 0, e.k)
+	)
+	for i, s := range shards {
+		if s == nil {
+			continue
+		}
+		if size < 0 {
+			size = len(s)
+		} else if len(s) != size {
+			return errShardSize
+		}
+		if len(rows) < e.k {
+			rows = append(rows, i)
+			avail = append(avail, s)
+		}
+	}
+	if len(rows) < e.k {
+		return errTooFewShards
+	}
+
+	// Rebuild missing data shards by inverting the rows of the available shards
+	sub := newMatrix(e.k, e.k)
+	for i, r := range rows {
+		copy(sub[i], e.enc[r])
+	}
+	dec, err := sub.invert()
+	if err != nil {
+		return err
+	}
+
+	for d := 0; d < e.k; d++ {
+		if shards[d] != nil {
+			continue
+		}
+		out := make([]byte, size)
+		for i, s := range avail {
+			mulAdd(dec[d][i], s, out)
+		}
+		shards[d] = out
+	}
+
+	// Recompute missing parity shards from the data shards
+	for p := e.k; p < e.k+e.m; p++ {
+		if shards[p] != nil {
+			continue
+		}
+		out := make([]byte, size)
+		for d := 0; d < e.k; d++ {
+			mulAdd(e.enc[p][d], shards[d], out)
+		}
+		shards[p] = out
+	}
+
+	return nil
+}
+
+// Join reassembles the data from the data shards, reconstructing them if needed.
+func (e *Encoder) Join(shards [][]byte) ([]byte, error) {
+	for _, s := range shards[:e.k] {
+		if s == nil {
+			if err := e.Reconstruct(shards); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	payload := bytes.Join(shards[:e.k], nil)
+	if len(payload) < 8 {
+		return nil, errInvalidPayload
+	}
+	n := binary.BigEndian.Uint64(payload)
+	if n > uint64(len(payload)-8) {
+		return nil, errInvalidPayload
+	}
+	return payload[8 : 8+n], nil
+}
+
+// Shards are framed with the magic followed by the number of data and parity shards and
+// the shard index so a stored shard is self describing.  Other blocks are written through
+// store.Compress which escapes data starting with the first byte of the magic, so only
+// shards start with it.
+var shardMagic = []byte{0xdf, 0x5e}
+
+const shardHeaderSize = 5
+
+// Shard is a single framed erasure coded shard.
+type Shard struct {
+	DataShards   int
+	ParityShards int
+	Index        int
+	Data         []byte
+}
+
+// Bytes returns the framed shard
+func (s *Shard) Bytes() []byte {
+	b := make([]byte, shardHeaderSize+len(s.Data))
+	copy(b, shardMagic)
+	b[2] = byte(s.DataShards)
+	b[3] = byte(s.ParityShards)
+	b[4] = byte(s.Index)
+	copy(b[shardHeaderSize:], s.Data)
+	return b
+}
+
+// IsShard returns true if the data is a framed shard.
+func IsShard(b []byte) bool {
+	return len(b) >= shardHeaderSize && bytes.HasPrefix(b, shardMagic)
+}
+
+// ParseShard parses a framed shard.
+func ParseShard(b []byte) (*Shard, error) {
+	if !IsShard(b) {
+		return nil, errInvalidShard
+	}
+
+	s := &Shard{
+		DataShards:   int(b[2]),
+		ParityShards: int(b[3]),
+		Index:        int(b[4]),
+		Data:         b[shardHeaderSize:],
+	}
+	if s.DataShards == 0 || s.Index >= s.DataShards+s.ParityShards {
+		return nil, errInvalidShard
+	}
+	return s, nil
+}
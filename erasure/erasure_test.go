@@ -0,0 +1,104 @@
+package erasure
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestGaloisInverse(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if gfMul(byte(a), gfInv(byte(a))) != 1 {
+			t.Fatalf("inverse of %d failed", a)
+		}
+	}
+}
+
+func TestEncoderReconstruct(t *testing.T) {
+	enc, err := New(4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 1000)
+	rand.Read(data)
+
+	shards, err := enc.Split(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shards) != 6 {
+		t.Fatal("wrong shard count")
+	}
+
+	// Every combination of 2 lost shards should be recoverable
+	for i := 0; i < 6; i++ {
+		for j := i + 1; j < 6; j++ {
+			cp := make([][]byte, len(shards))
+			copy(cp, shards)
+			cp[i], cp[j] = nil, nil
+
+			out, err := enc.Join(cp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Fatalf("mismatch losing %d and %d", i, j)
+			}
+			if err = enc.Reconstruct(cp); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(cp[i], shards[i]) || !bytes.Equal(cp[j], shards[j]) {
+				t.Fatalf("shards %d and %d not regenerated", i, j)
+			}
+		}
+	}
+
+	cp := make([][]byte, len(shards))
+	copy(cp[3:], shards[3:])
+	if err = enc.Reconstruct(cp); err != errTooFewShards {
+		t.Fatal("should fail with too few shards")
+	}
+}
+
+func TestSplitSmall(t *testing.T) {
+	enc, _ := New(3, 2)
+	for _, data := range [][]byte{{}, []byte("a"), []byte("abcdefghij")} {
+		shards, err := enc.Split(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards[0] = nil
+		out, err := enc.Join(shards)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatalf("mismatch %q", data)
+		}
+	}
+}
+
+func TestShardFraming(t *testing.T) {
+	s := &Shard{DataShards: 4, ParityShards: 2, Index: 5, Data: []byte("shard")}
+	b := s.Bytes()
+	if !IsShard(b) {
+		t.Fatal("should be a shard")
+	}
+
+	p, err := ParseShard(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.DataShards != 4 || p.ParityShards != 2 || p.Index != 5 || !bytes.Equal(p.Data, s.Data) {
+		t.Fatal("shard mismatch")
+	}
+
+	if IsShard([]byte("plain block")) {
+		t.Fatal("should not be a shard")
+	}
+	b[4] = 6
+	if _, err = ParseShard(b); err == nil {
+		t.Fatal("should fail with index out of range")
+	}
+}
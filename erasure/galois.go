@@ -0,0 +1,143 @@
+package erasure
+
+import "errors"
+
+// Arithmetic over GF(2^8) using the polynomial x^8 + x^4 + x^3 + x^2 + 1.
+const gfPoly = 0x11d
+
+var (
+	gfExp [512]byte
+	gfLog [256]byte
+
+	errSingularMatrix = errors.New("matrix is singular")
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= gfPoly
+		}
+	}
+	// Duplicate the table to avoid a modulo when multiplying
+	for i := 255; i < 512; i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])*n)%255]
+}
+
+// mulAdd adds c times in to out
+func mulAdd(c byte, in, out []byte) {
+	if c == 0 {
+		return
+	}
+	lc := int(gfLog[c])
+	for i, v := range in {
+		if v != 0 {
+			out[i] ^= gfExp[lc+int(gfLog[v])]
+		}
+	}
+}
+
+type matrix [][]byte
+
+func newMatrix(rows, cols int) matrix {
+	m := make(matrix, rows)
+	for i := range m {
+		m[i] = make([]byte, cols)
+	}
+	return m
+}
+
+// vandermonde returns a matrix where each element is row^col.  Any subset of rows equal
+// to the number of columns is invertible.
+func vandermonde(rows, cols int) matrix {
+	m := newMatrix(rows, cols)
+	for r := range m {
+		for c := range m[r] {
+			m[r][c] = gfPow(byte(r), c)
+		}
+	}
+	return m
+}
+
+func (m matrix) mul(o matrix) matrix {
+	out := newMatrix(len(m), len(o[0]))
+	for r := range out {
+		for c := range out[r] {
+			var v byte
+			for i := range o {
+				v ^= gfMul(m[r][i], o[i][c])
+			}
+			out[r][c] = v
+		}
+	}
+	return out
+}
+
+// invert returns the inverse of the square matrix using gaussian elimination.
+func (m matrix) invert() (matrix, error) {
+	n := len(m)
+	// augment with the identity
+	work := newMatrix(n, n*2)
+	for r := range m {
+		copy(work[r], m[r])
+		work[r][n+r] = 1
+	}
+
+	for c := 0; c < n; c++ {
+		// find a pivot
+		p := c
+		for p < n && work[p][c] == 0 {
+			p++
+		}
+		if p == n {
+			return nil, errSingularMatrix
+		}
+		work[c], work[p] = work[p], work[c]
+
+		// scale the pivot row to 1
+		if v := work[c][c]; v != 1 {
+			iv := gfInv(v)
+			for i := range work[c] {
+				work[c][i] = gfMul(work[c][i], iv)
+			}
+		}
+
+		// eliminate the column from other rows
+		for r := 0; r < n; r++ {
+			if r != c && work[r][c] != 0 {
+				mulAdd(work[r][c], work[c], work[r])
+			}
+		}
+	}
+
+	out := newMatrix(n, n)
+	for r := range out {
+		copy(out[r], work[r][n:])
+	}
+	return out, nil
+}
@@ -0,0 +1,121 @@
+package difuse
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ipkg/difuse/store"
+	"github.com/ipkg/difuse/txlog"
+)
+
+// ErrorCode classifies an error so callers can act on it once it has crossed the wire.
+type ErrorCode uint8
+
+const (
+	// CodeUnknown is an error that could not be classified
+	CodeUnknown ErrorCode = iota
+	// CodeNotFound is a key, block or store that does not exist
+	CodeNotFound
+	// CodeConflict is a write that does not extend the current transaction chain
+	CodeConflict
+	// CodeNotLeader is a write sent to a vnode that is not the leader for the key
+	CodeNotLeader
+	// CodeUnavailable is a vnode or leader that cannot currently serve the request
+	CodeUnavailable
+	// CodeInvalidArgument is a request that can never succeed as is
+	CodeInvalidArgument
+	// CodeConditionFailed is a conditional write whose condition does not hold
+	CodeConditionFailed
+)
+
+func (c ErrorCode) String() string {
+	switch c {
+	case CodeNotFound:
+		return "not-found"
+	case CodeConflict:
+		return "conflict"
+	case CodeNotLeader:
+		return "not-leader"
+	case CodeUnavailable:
+		return "unavailable"
+	case CodeInvalidArgument:
+		return "invalid-argument"
+	case CodeConditionFailed:
+		return "condition-failed"
+	}
+	return "unknown"
+}
+
+// Error is an error carrying its code.  Errors in responses from remote vnodes are
+// returned as *Error.
+type Error struct {
+	Code ErrorCode
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+// errorCodes maps known errors to their code.
+var errorCodes = map[error]ErrorCode{
+	store.ErrKeyNotFound:     CodeNotFound,
+	store.ErrBlockNotFound:   CodeNotFound,
+	store.ErrBlockTooLarge:   CodeInvalidArgument,
+	errStoreNotFound:         CodeNotFound,
+	errTxNotFound:            CodeNotFound,
+	txlog.ErrUnknownTx:       CodeNotFound,
+	ErrNotLeader:             CodeNotLeader,
+	ErrLeaderNotReady:        CodeUnavailable,
+	errTooFewHosts:           CodeUnavailable,
+	errShardUnavailable:      CodeUnavailable,
+	errBootstrapping:         CodeUnavailable,
+	errNotBootstrapping:      CodeInvalidArgument,
+	errNotSuccessor:          CodeInvalidArgument,
+	errHostUnreachable:       CodeUnavailable,
+	errRequestDropped:        CodeUnavailable,
+	errExportChanged:         CodeUnavailable,
+	context.DeadlineExceeded: CodeUnavailable,
+	ErrReservedKey:           CodeInvalidArgument,
+	errInvalidPublicKey:      CodeInvalidArgument,
+	errRevokeLocalKey:        CodeInvalidArgument,
+	errNotErasureCoded:       CodeInvalidArgument,
+	errNoBlockCipher:         CodeInvalidArgument,
+	ErrConditionFailed:       CodeConditionFailed,
+}
+
+// ErrorCodeOf returns the code for the error.  Errors not carrying a code are classified
+// by identity and finally grpc status.
+func ErrorCodeOf(err error) ErrorCode {
+	if err == nil {
+		return CodeUnknown
+	}
+
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	if code, ok := errorCodes[err]; ok {
+		return code
+	}
+	if txlog.IsPrevHashError(err) {
+		return CodeConflict
+	}
+
+	switch grpc.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return CodeUnavailable
+	case codes.NotFound:
+		return CodeNotFound
+	case codes.InvalidArgument:
+		return CodeInvalidArgument
+	}
+	return CodeUnknown
+}
+
+// invalidConsistencyError returns the error for an unsupported consistency level.
+func invalidConsistencyError(c ConsistencyLevel) error {
+	return &Error{Code: CodeInvalidArgument, Msg: fmt.Sprintf(errInvalidConsistencyLevel, c)}
+}
@@ -0,0 +1,83 @@
+package difuse
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/ipkg/difuse/store"
+	"github.com/ipkg/difuse/txlog"
+)
+
+func TestErrorCodeOf(t *testing.T) {
+	cases := []struct {
+		err  error
+		code ErrorCode
+	}{
+		{nil, CodeUnknown},
+		{fmt.Errorf("boom"), CodeUnknown},
+		{store.ErrKeyNotFound, CodeNotFound},
+		{remoteError(store.ErrBlockNotFound), CodeNotFound},
+		{ErrNotLeader, CodeNotLeader},
+		{remoteError(ErrNotLeader), CodeNotLeader},
+		{fmt.Errorf("%s", ErrNotLeader), CodeUnknown},
+		{ErrLeaderNotReady, CodeUnavailable},
+		{&txlog.PrevHashError{Want: []byte{0}, Have: []byte{1}}, CodeConflict},
+		{remoteError(&txlog.PrevHashError{Want: []byte{0}, Have: []byte{1}}), CodeConflict},
+		{invalidConsistencyError(9), CodeInvalidArgument},
+		{ErrReservedKey, CodeInvalidArgument},
+		{remoteError(ErrConditionFailed), CodeConditionFailed},
+		{grpc.Errorf(codes.Unavailable, "transport is closing"), CodeUnavailable},
+		{grpc.Errorf(codes.DeadlineExceeded, "timeout"), CodeUnavailable},
+		{&Error{Code: CodeConflict, Msg: "remote"}, CodeConflict},
+	}
+
+	for i, c := range cases {
+		if code := ErrorCodeOf(c.err); code != c.code {
+			t.Errorf("case %d: want=%s have=%s", i, c.code, code)
+		}
+	}
+}
+
+func TestErrorCodeWire(t *testing.T) {
+	rsps := []*VnodeResponse{
+		{Id: []byte("vn1"), Err: store.ErrKeyNotFound},
+		{Id: []byte("vn2"), Data: []byte("data")},
+		{Id: []byte("vn3"), Err: ErrNotLeader},
+	}
+
+	out := deserializeVnodeIdBytesErrList(serializeVnodeIdBytesErrList(rsps))
+	if len(out) != 3 {
+		t.Fatal("wrong number of responses", len(out))
+	}
+
+	if out[0].Code() != CodeNotFound || out[0].Err.Error() != store.ErrKeyNotFound.Error() {
+		t.Fatal("wrong error", out[0].Code(), out[0].Err)
+	}
+	if out[1].Err != nil || string(out[1].Data.([]byte)) != "data" {
+		t.Fatal("wrong data", out[1].Err)
+	}
+	if _, ok := out[2].Err.(*Error); !ok || out[2].Code() != CodeNotLeader {
+		t.Fatal("wrong error", out[2].Code(), out[2].Err)
+	}
+}
+
+func TestErrorCodeHeader(t *testing.T) {
+	err := fmt.Errorf("%s", ErrNotLeader)
+	if errorWithCode(err, metadata.MD{}) != err {
+		t.Fatal("error without a code header should be returned as is")
+	}
+
+	md := metadata.Pairs(errorCodeMetadataKey, strconv.Itoa(int(CodeNotLeader)))
+	rerr := errorWithCode(err, md)
+	if ErrorCodeOf(rerr) != CodeNotLeader || rerr.Error() != ErrNotLeader.Error() {
+		t.Fatal("wrong error", ErrorCodeOf(rerr), rerr)
+	}
+	if errorWithCode(nil, md) != nil {
+		t.Fatal("should be nil")
+	}
+}
@@ -0,0 +1,58 @@
+package difuse
+
+import (
+	"errors"
+	"io"
+	"log"
+
+	"golang.org/x/net/context"
+
+	"github.com/ipkg/difuse/txlog"
+)
+
+// attempts to read the inode and transactions of a key without a write in between
+const exportAttempts = 3
+
+var errExportChanged = errors.New("key changed during export")
+
+// Export writes the transactions of the key from its leader so the chain can be verified
+// offline with txlog.VerifyChain.  The header holds the txroot of the inode and the public
+// keys in the cluster keyring.  Nothing is written if the key keeps changing while it is
+// read.
+func (s *Difuse) Export(ctx context.Context, key []byte, w io.Writer) error {
+	signers, err := s.Signers(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < exportAttempts; i++ {
+		hdr, txs, err := s.export(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		// A write between reading the inode and the transactions changes the root
+		root, _ := txs.MerkleRoot()
+		if txlog.EqualBytes(root, hdr.TxRoot) {
+			hdr.Signers = signers
+			return txlog.WriteExport(w, hdr, txs)
+		}
+		log.Printf("action=export status=retry key='%s' attempt=%d", key, i+1)
+	}
+
+	return errExportChanged
+}
+
+func (s *Difuse) export(ctx context.Context, key []byte) (*txlog.ExportHeader, txlog.TxSlice, error) {
+	inode, meta, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	txs, err := s.transport.Transactions(ctx, key, nil, meta.Vnode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &txlog.ExportHeader{Key: key, TxRoot: inode.TxRoot()}, txs, nil
+}
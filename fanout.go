@@ -0,0 +1,141 @@
+package difuse
+
+import (
+	"errors"
+
+	"golang.org/x/net/context"
+
+	chord "github.com/ipkg/go-chord"
+)
+
+var errMissingResponse = errors.New("missing vnode response")
+
+// fanoutRule is the completion rule of a fan-out.
+type fanoutRule uint8
+
+const (
+	// fanoutFirst completes on the first successful vnode response
+	fanoutFirst fanoutRule = iota
+	// fanoutQuorum completes once a majority of the vnodes succeed
+	fanoutQuorum
+	// fanoutAll waits for the responses of all vnodes
+	fanoutAll
+)
+
+// needed returns the number of successful responses out of n required by the rule.
+func (r fanoutRule) needed(n int) int {
+	switch r {
+	case fanoutFirst:
+		return 1
+	case fanoutQuorum:
+		return (n / 2) + 1
+	}
+	return n
+}
+
+// hostRequest makes a request to the given vnodes all belonging to a single host
+// returning a response per vnode.
+type hostRequest func(ctx context.Context, vns ...*chord.Vnode) ([]*VnodeResponse, error)
+
+// hostResult is the result of a hostRequest for the vnodes at the indexes.
+type hostResult struct {
+	idx  []int
+	resp []*VnodeResponse
+	err  error
+}
+
+// fanout makes the request to each host holding the vnodes concurrently.  It returns a
+// response per vnode in the order of vl.  Once the rule is met or can no longer be met
+// the requests still in flight are cancelled and their vnodes get a context.Canceled
+// error.  The error is nil if the rule was met, otherwise it is the first vnode error.
+func fanout(ctx context.Context, vl []*chord.Vnode, rule fanoutRule, req hostRequest) ([]*VnodeResponse, error) {
+	// Group vnode indexes by host keeping the order of the hosts
+	var (
+		hosts []string
+		idx   = make(map[string][]int)
+	)
+	for i, vn := range vl {
+		if _, ok := idx[vn.Host]; !ok {
+			hosts = append(hosts, vn.Host)
+		}
+		idx[vn.Host] = append(idx[vn.Host], i)
+	}
+
+	fctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Buffered so requests completing after we return do not block
+	ch := make(chan *hostResult, len(hosts))
+	for _, h := range hosts {
+		go func(is []int) {
+			vns := make([]*chord.Vnode, len(is))
+			for j, i := range is {
+				vns[j] = vl[i]
+			}
+			resp, err := req(fctx, vns...)
+			ch <- &hostResult{idx: is, resp: resp, err: err}
+		}(idx[h])
+	}
+
+	var (
+		out       = make([]*VnodeResponse, len(vl))
+		need      = rule.needed(len(vl))
+		succeeded int
+		failed    int
+		aborted   error
+	)
+
+	for pending := len(hosts); pending > 0; pending-- {
+		if succeeded >= need || (rule != fanoutAll && failed > len(vl)-need) {
+			break
+		}
+
+		var hr *hostResult
+		select {
+		case hr = <-ch:
+		case <-ctx.Done():
+			aborted = ctx.Err()
+		}
+		if aborted != nil {
+			break
+		}
+
+		for j, i := range hr.idx {
+			var r *VnodeResponse
+			switch {
+			case hr.err != nil:
+				r = &VnodeResponse{Id: vl[i].Id, Err: hr.err}
+			case j < len(hr.resp):
+				r = hr.resp[j]
+			default:
+				r = &VnodeResponse{Id: vl[i].Id, Err: errMissingResponse}
+			}
+
+			if r.Err == nil {
+				succeeded++
+			} else {
+				failed++
+			}
+			out[i] = r
+		}
+	}
+
+	if aborted == nil {
+		aborted = context.Canceled
+	}
+	for i, r := range out {
+		if r == nil {
+			out[i] = &VnodeResponse{Id: vl[i].Id, Err: aborted}
+		}
+	}
+
+	if succeeded >= need {
+		return out, nil
+	}
+	for _, r := range out {
+		if r.Err != nil {
+			return out, r.Err
+		}
+	}
+	return out, nil
+}
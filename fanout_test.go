@@ -0,0 +1,138 @@
+package difuse
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	chord "github.com/ipkg/go-chord"
+)
+
+// testFanoutHosts returns 2 vnodes on each host with the given behaviour.  A host
+// behaviour is "ok", "fail", "err" for a host level error or "hang" until cancelled.
+func testFanoutHosts(behaviours ...string) ([]*chord.Vnode, hostRequest, chan string) {
+	var (
+		vl        []*chord.Vnode
+		hosts     = make(map[string]string)
+		cancelled = make(chan string, len(behaviours))
+	)
+	for i, b := range behaviours {
+		host := fmt.Sprintf("host%d", i)
+		hosts[host] = b
+		vl = append(vl, &chord.Vnode{Id: []byte(host + "-a"), Host: host}, &chord.Vnode{Id: []byte(host + "-b"), Host: host})
+	}
+
+	req := func(ctx context.Context, vns ...*chord.Vnode) ([]*VnodeResponse, error) {
+		host := vns[0].Host
+		out := make([]*VnodeResponse, len(vns))
+		switch hosts[host] {
+		case "err":
+			return nil, errors.New(host)
+		case "hang":
+			<-ctx.Done()
+			cancelled <- host
+			return nil, ctx.Err()
+		}
+
+		for i, vn := range vns {
+			out[i] = &VnodeResponse{Id: vn.Id, Data: vn.Host}
+			if hosts[host] == "fail" {
+				out[i].Err = errors.New(host)
+			}
+		}
+		return out, nil
+	}
+	return vl, req, cancelled
+}
+
+func TestFanoutFirst(t *testing.T) {
+	vl, req, cancelled := testFanoutHosts("hang", "fail", "ok")
+
+	resp, err := fanout(context.Background(), vl, fanoutFirst, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp) != len(vl) {
+		t.Fatal("wrong number of responses", len(resp))
+	}
+	for i, r := range resp {
+		if string(r.Id) != string(vl[i].Id) {
+			t.Fatal("response out of order", i)
+		}
+	}
+	if resp[0].Err != context.Canceled || resp[2].Err == nil || resp[4].Data != "host2" {
+		t.Fatal("wrong responses", resp[0].Err, resp[2].Err, resp[4].Data)
+	}
+
+	select {
+	case h := <-cancelled:
+		if h != "host0" {
+			t.Fatal("wrong host cancelled", h)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("losing request not cancelled")
+	}
+
+	// All failing
+	vl, req, _ = testFanoutHosts("fail", "err")
+	if resp, err = fanout(context.Background(), vl, fanoutFirst, req); err == nil || err.Error() != "host0" {
+		t.Fatal("should fail with first error", err)
+	}
+	if resp[3].Err == nil || resp[3].Err.Error() != "host1" {
+		t.Fatal("host error not set on vnode", resp[3].Err)
+	}
+}
+
+func TestFanoutQuorum(t *testing.T) {
+	vl, req, _ := testFanoutHosts("ok", "hang", "ok")
+	if _, err := fanout(context.Background(), vl, fanoutQuorum, req); err != nil {
+		t.Fatal(err)
+	}
+
+	// Quorum can no longer be met once 2 of 3 hosts fail
+	vl, req, cancelled := testFanoutHosts("fail", "hang", "err")
+	resp, err := fanout(context.Background(), vl, fanoutQuorum, req)
+	if err == nil {
+		t.Fatal("should fail")
+	}
+	if resp[2].Err != context.Canceled {
+		t.Fatal("should be cancelled", resp[2].Err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("losing request not cancelled")
+	}
+}
+
+func TestFanoutAll(t *testing.T) {
+	vl, req, _ := testFanoutHosts("ok", "fail", "ok")
+	resp, err := fanout(context.Background(), vl, fanoutAll, req)
+	if err == nil || err.Error() != "host1" {
+		t.Fatal("should fail", err)
+	}
+	for i, r := range resp {
+		if r.Data == nil {
+			t.Fatal("response not gathered", i, r.Err)
+		}
+	}
+
+	vl, req, _ = testFanoutHosts("ok", "ok")
+	if _, err = fanout(context.Background(), vl, fanoutAll, req); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bound by the parent context
+	vl, req, _ = testFanoutHosts("ok", "hang")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if resp, err = fanout(ctx, vl, fanoutAll, req); err != context.DeadlineExceeded {
+		t.Fatal("should exceed deadline", err)
+	}
+	if resp[0].Err != nil {
+		t.Fatal("completed response should be kept", resp[0].Err)
+	}
+}
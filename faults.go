@@ -0,0 +1,537 @@
+package difuse
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	chord "github.com/ipkg/go-chord"
+
+	"github.com/ipkg/difuse/store"
+	"github.com/ipkg/difuse/txlog"
+)
+
+var errRequestDropped = errors.New("request dropped")
+
+// faultRPCs maps the transport methods faults can be injected into to the rpc they are
+// served by.  Errors can be set for either name.
+var faultRPCs = map[string]string{
+	"Stat":                  "StatServe",
+	"SetInode":              "SetInodeServe",
+	"DeleteInode":           "DeleteInodeServe",
+	"SetBlock":              "SetBlockServe",
+	"GetBlock":              "GetBlockServe",
+	"DeleteBlock":           "DeleteBlockServe",
+	"ReplicateBlocks":       "ReplicateBlocksServe",
+	"AppendTx":              "AppendTxServe",
+	"AppendTxs":             "AppendTxsServe",
+	"GetTx":                 "GetTxServe",
+	"LastTx":                "LastTxServe",
+	"MerkleRootTx":          "MerkleRootTxServe",
+	"ProofTx":               "ProofTxServe",
+	"ReplicateTransactions": "TransactionsServe",
+	"Transactions":          "TransactionsServe",
+	"TransferKeys":          "TransferKeysServe",
+	"Bootstrap":             "BootstrapServe",
+	"LookupLeader":          "LookupLeaderServe",
+	"BatchGet":              "BatchGetServe",
+	"BatchSet":              "BatchSetServe",
+	"Snapshot":              "SnapshotServe",
+}
+
+// Faults holds the faults injected into the requests a node sends to other nodes.
+type Faults struct {
+	// Sets of hosts only reachable from hosts in the same set.  Hosts not in any set are
+	// reachable from all hosts.
+	Partitions [][]string
+	// Delay added to each request along with a random delay of up to the jitter
+	Latency time.Duration
+	Jitter  time.Duration
+	// Probability a request is dropped rather than sent
+	Drop float64
+	// Probability a unary request is sent twice
+	Duplicate float64
+	// Error returned by a method or rpc rather than sending the request e.g.
+	// "AppendTx" or "TransactionsServe".  The error is either the message of a known
+	// error or the name of an error code e.g. "unavailable".
+	Errors map[string]string
+}
+
+// faultsJSON is the json form of the faults with durations as strings e.g. "150ms".
+type faultsJSON struct {
+	Partitions [][]string        `json:"partitions,omitempty"`
+	Latency    string            `json:"latency,omitempty"`
+	Jitter     string            `json:"jitter,omitempty"`
+	Drop       float64           `json:"drop,omitempty"`
+	Duplicate  float64           `json:"duplicate,omitempty"`
+	Errors     map[string]string `json:"errors,omitempty"`
+}
+
+// MarshalJSON is a custom json encoder writing durations as strings.
+func (f *Faults) MarshalJSON() ([]byte, error) {
+	fj := &faultsJSON{Partitions: f.Partitions, Drop: f.Drop, Duplicate: f.Duplicate, Errors: f.Errors}
+	if f.Latency > 0 {
+		fj.Latency = f.Latency.String()
+	}
+	if f.Jitter > 0 {
+		fj.Jitter = f.Jitter.String()
+	}
+	return json.Marshal(fj)
+}
+
+// UnmarshalJSON is a custom json decoder parsing durations from strings.
+func (f *Faults) UnmarshalJSON(b []byte) error {
+	var fj faultsJSON
+	if err := json.Unmarshal(b, &fj); err != nil {
+		return err
+	}
+
+	*f = Faults{Partitions: fj.Partitions, Drop: fj.Drop, Duplicate: fj.Duplicate, Errors: fj.Errors}
+
+	var err error
+	if fj.Latency != "" {
+		if f.Latency, err = time.ParseDuration(fj.Latency); err != nil {
+			return err
+		}
+	}
+	if fj.Jitter != "" {
+		f.Jitter, err = time.ParseDuration(fj.Jitter)
+	}
+	return err
+}
+
+// Validate returns an error if the faults are not valid.
+func (f *Faults) Validate() error {
+	if f.Latency < 0 || f.Jitter < 0 {
+		return invalidFaultsError("negative latency")
+	}
+	if f.Drop < 0 || f.Drop > 1 || f.Duplicate < 0 || f.Duplicate > 1 {
+		return invalidFaultsError("probabilities must be between 0 and 1")
+	}
+
+	seen := make(map[string]bool)
+	for _, set := range f.Partitions {
+		for _, host := range set {
+			if seen[host] {
+				return invalidFaultsError("host in multiple partitions: %s", host)
+			}
+			seen[host] = true
+		}
+	}
+
+	for name := range f.Errors {
+		if !isFaultRPC(name) {
+			return invalidFaultsError("unknown rpc: %s", name)
+		}
+	}
+	return nil
+}
+
+// invalidFaultsError returns the error for faults that cannot be set.
+func invalidFaultsError(format string, args ...interface{}) error {
+	return &Error{Code: CodeInvalidArgument, Msg: "invalid faults: " + fmt.Sprintf(format, args...)}
+}
+
+func isFaultRPC(name string) bool {
+	for method, rpc := range faultRPCs {
+		if name == method || name == rpc {
+			return true
+		}
+	}
+	return false
+}
+
+// clone returns a deep copy of the faults.
+func (f *Faults) clone() *Faults {
+	c := *f
+	c.Partitions = make([][]string, len(f.Partitions))
+	for i, set := range f.Partitions {
+		c.Partitions[i] = append([]string(nil), set...)
+	}
+	c.Errors = make(map[string]string, len(f.Errors))
+	for k, v := range f.Errors {
+		c.Errors[k] = v
+	}
+	return &c
+}
+
+// partitioned returns whether the hosts are in different partitions.
+func (f *Faults) partitioned(h1, h2 string) bool {
+	p1, p2 := -1, -1
+	for i, set := range f.Partitions {
+		for _, host := range set {
+			if host == h1 {
+				p1 = i
+			}
+			if host == h2 {
+				p2 = i
+			}
+		}
+	}
+	return p1 >= 0 && p2 >= 0 && p1 != p2
+}
+
+// err returns the error set for the method or the rpc serving it if any.
+func (f *Faults) err(method string) error {
+	msg, ok := f.Errors[method]
+	if !ok {
+		if msg, ok = f.Errors[faultRPCs[method]]; !ok {
+			return nil
+		}
+	}
+
+	for c := CodeUnknown; c <= CodeConditionFailed; c++ {
+		if c.String() == msg {
+			return &Error{Code: c, Msg: msg}
+		}
+	}
+	for e, c := range errorCodes {
+		if e.Error() == msg {
+			return &Error{Code: c, Msg: msg}
+		}
+	}
+	return &Error{Code: CodeUnknown, Msg: msg}
+}
+
+// FaultTransport wraps a transport injecting faults into the requests sent to other
+// nodes.  Faults can be changed at any time and apply to requests started from then on.
+// It works with any transport e.g. the net rpc or memory transport.
+type FaultTransport struct {
+	trans Transport
+
+	mu     sync.Mutex
+	host   string // local host
+	faults *Faults
+	rnd    *rand.Rand
+}
+
+// NewFaultTransport instantiates a transport wrapping the given one without any faults.
+func NewFaultTransport(trans Transport) *FaultTransport {
+	return &FaultTransport{trans: trans, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// SetFaults validates and sets the faults replacing any set before.  Nil clears them.
+func (ft *FaultTransport) SetFaults(f *Faults) error {
+	if f != nil {
+		if err := f.Validate(); err != nil {
+			return err
+		}
+		f = f.clone()
+	}
+
+	ft.mu.Lock()
+	ft.faults = f
+	ft.mu.Unlock()
+	return nil
+}
+
+// Faults returns a copy of the faults currently set.
+func (ft *FaultTransport) Faults() *Faults {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	if ft.faults == nil {
+		return &Faults{}
+	}
+	return ft.faults.clone()
+}
+
+// inject applies the faults to a request of the method to the host.  It returns an error
+// if the request is to fail rather than be sent and whether a unary request is to be sent
+// twice.
+func (ft *FaultTransport) inject(ctx context.Context, method, host string) (bool, error) {
+	ft.mu.Lock()
+	f := ft.faults
+	if f == nil {
+		ft.mu.Unlock()
+		return false, nil
+	}
+
+	delay := f.Latency
+	if f.Jitter > 0 {
+		delay += time.Duration(ft.rnd.Int63n(int64(f.Jitter)))
+	}
+	drop := f.Drop > 0 && ft.rnd.Float64() < f.Drop
+	dup := f.Duplicate > 0 && ft.rnd.Float64() < f.Duplicate
+	local := ft.host
+	ft.mu.Unlock()
+
+	if f.partitioned(local, host) {
+		return false, errHostUnreachable
+	}
+	if err := f.err(method); err != nil {
+		return false, err
+	}
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+
+	if drop {
+		return false, errRequestDropped
+	}
+	return dup, nil
+}
+
+// Stat makes a stat request to the provided vnodes of a single host.
+func (ft *FaultTransport) Stat(ctx context.Context, key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	dup, err := ft.inject(ctx, "Stat", vs[0].Host)
+	if err != nil {
+		return nil, err
+	}
+	if dup {
+		ft.trans.Stat(ctx, key, options, vs...)
+	}
+	return ft.trans.Stat(ctx, key, options, vs...)
+}
+
+// SetInode sets the given inode on the host.
+func (ft *FaultTransport) SetInode(ctx context.Context, host string, inode *store.Inode, options *RequestOptions) (*ResponseMeta, error) {
+	dup, err := ft.inject(ctx, "SetInode", host)
+	if err != nil {
+		return nil, err
+	}
+	if dup {
+		ft.trans.SetInode(ctx, host, inode, options)
+	}
+	return ft.trans.SetInode(ctx, host, inode, options)
+}
+
+// DeleteInode deletes the given inode on the host.
+func (ft *FaultTransport) DeleteInode(ctx context.Context, host string, inode *store.Inode, options *RequestOptions) (*ResponseMeta, error) {
+	dup, err := ft.inject(ctx, "DeleteInode", host)
+	if err != nil {
+		return nil, err
+	}
+	if dup {
+		ft.trans.DeleteInode(ctx, host, inode, options)
+	}
+	return ft.trans.DeleteInode(ctx, host, inode, options)
+}
+
+// SetBlock sets the block on the provided vnodes of a single host.
+func (ft *FaultTransport) SetBlock(ctx context.Context, data []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	dup, err := ft.inject(ctx, "SetBlock", vs[0].Host)
+	if err != nil {
+		return nil, err
+	}
+	if dup {
+		ft.trans.SetBlock(ctx, data, options, vs...)
+	}
+	return ft.trans.SetBlock(ctx, data, options, vs...)
+}
+
+// GetBlock gets the block from the provided vnodes of a single host.
+func (ft *FaultTransport) GetBlock(ctx context.Context, hash []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	dup, err := ft.inject(ctx, "GetBlock", vs[0].Host)
+	if err != nil {
+		return nil, err
+	}
+	if dup {
+		ft.trans.GetBlock(ctx, hash, options, vs...)
+	}
+	return ft.trans.GetBlock(ctx, hash, options, vs...)
+}
+
+// DeleteBlock deletes the block from the provided vnodes of a single host.
+func (ft *FaultTransport) DeleteBlock(ctx context.Context, hash []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	dup, err := ft.inject(ctx, "DeleteBlock", vs[0].Host)
+	if err != nil {
+		return nil, err
+	}
+	if dup {
+		ft.trans.DeleteBlock(ctx, hash, options, vs...)
+	}
+	return ft.trans.DeleteBlock(ctx, hash, options, vs...)
+}
+
+// ReplicateBlocks replicates blocks from the local vnode to the remote one.
+func (ft *FaultTransport) ReplicateBlocks(ctx context.Context, src, dst *chord.Vnode) error {
+	if _, err := ft.inject(ctx, "ReplicateBlocks", dst.Host); err != nil {
+		return err
+	}
+	return ft.trans.ReplicateBlocks(ctx, src, dst)
+}
+
+// AppendTx appends the tx to the provided vnodes of a single host.
+func (ft *FaultTransport) AppendTx(ctx context.Context, tx *txlog.Tx, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	dup, err := ft.inject(ctx, "AppendTx", vs[0].Host)
+	if err != nil {
+		return nil, err
+	}
+	if dup {
+		ft.trans.AppendTx(ctx, tx, options, vs...)
+	}
+	return ft.trans.AppendTx(ctx, tx, options, vs...)
+}
+
+// AppendTxs appends the txs of each batch to its vnode on a single host.
+func (ft *FaultTransport) AppendTxs(ctx context.Context, batches []*VnodeTxs, options *RequestOptions) ([]*VnodeResponse, error) {
+	dup, err := ft.inject(ctx, "AppendTxs", batches[0].Vnode.Host)
+	if err != nil {
+		return nil, err
+	}
+	if dup {
+		ft.trans.AppendTxs(ctx, batches, options)
+	}
+	return ft.trans.AppendTxs(ctx, batches, options)
+}
+
+// GetTx gets the tx from the provided vnodes of a single host.
+func (ft *FaultTransport) GetTx(ctx context.Context, key, txhash []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	dup, err := ft.inject(ctx, "GetTx", vs[0].Host)
+	if err != nil {
+		return nil, err
+	}
+	if dup {
+		ft.trans.GetTx(ctx, key, txhash, options, vs...)
+	}
+	return ft.trans.GetTx(ctx, key, txhash, options, vs...)
+}
+
+// LastTx gets the last tx of the key from the provided vnodes of a single host.
+func (ft *FaultTransport) LastTx(ctx context.Context, key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	dup, err := ft.inject(ctx, "LastTx", vs[0].Host)
+	if err != nil {
+		return nil, err
+	}
+	if dup {
+		ft.trans.LastTx(ctx, key, options, vs...)
+	}
+	return ft.trans.LastTx(ctx, key, options, vs...)
+}
+
+// MerkleRootTx gets the merkle root of the key's transactions from the provided vnodes of
+// a single host.
+func (ft *FaultTransport) MerkleRootTx(ctx context.Context, key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	dup, err := ft.inject(ctx, "MerkleRootTx", vs[0].Host)
+	if err != nil {
+		return nil, err
+	}
+	if dup {
+		ft.trans.MerkleRootTx(ctx, key, options, vs...)
+	}
+	return ft.trans.MerkleRootTx(ctx, key, options, vs...)
+}
+
+// ProofTx gets the inclusion proofs of the tx from the provided vnodes of a single host.
+func (ft *FaultTransport) ProofTx(ctx context.Context, key, txhash []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	dup, err := ft.inject(ctx, "ProofTx", vs[0].Host)
+	if err != nil {
+		return nil, err
+	}
+	if dup {
+		ft.trans.ProofTx(ctx, key, txhash, options, vs...)
+	}
+	return ft.trans.ProofTx(ctx, key, txhash, options, vs...)
+}
+
+// NewTx is passed through as transactions cannot be created remotely.
+func (ft *FaultTransport) NewTx(key []byte, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	return ft.trans.NewTx(key, vs...)
+}
+
+// ReplicateTransactions replicates transactions from the remote vnode to the local one.
+func (ft *FaultTransport) ReplicateTransactions(ctx context.Context, key, seek []byte, remote, local *chord.Vnode) error {
+	if _, err := ft.inject(ctx, "ReplicateTransactions", remote.Host); err != nil {
+		return err
+	}
+	return ft.trans.ReplicateTransactions(ctx, key, seek, remote, local)
+}
+
+// Transactions returns the transactions for the key from the remote vnode.
+func (ft *FaultTransport) Transactions(ctx context.Context, key, seek []byte, vn *chord.Vnode) (txlog.TxSlice, error) {
+	if _, err := ft.inject(ctx, "Transactions", vn.Host); err != nil {
+		return nil, err
+	}
+	return ft.trans.Transactions(ctx, key, seek, vn)
+}
+
+// TransferKeys transfers keys from the local vnode to the remote one.
+func (ft *FaultTransport) TransferKeys(ctx context.Context, src, dst *chord.Vnode) error {
+	if _, err := ft.inject(ctx, "TransferKeys", dst.Host); err != nil {
+		return err
+	}
+	return ft.trans.TransferKeys(ctx, src, dst)
+}
+
+// Bootstrap bootstraps the new remote vnode from the local one.
+func (ft *FaultTransport) Bootstrap(ctx context.Context, src, dst *chord.Vnode, filter *store.SnapshotFilter) error {
+	if _, err := ft.inject(ctx, "Bootstrap", dst.Host); err != nil {
+		return err
+	}
+	return ft.trans.Bootstrap(ctx, src, dst, filter)
+}
+
+// LookupLeader looks up the leader for a key on the given host.
+func (ft *FaultTransport) LookupLeader(ctx context.Context, host string, key []byte) (*chord.Vnode, []*chord.Vnode, map[string][]*chord.Vnode, error) {
+	dup, err := ft.inject(ctx, "LookupLeader", host)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if dup {
+		ft.trans.LookupLeader(ctx, host, key)
+	}
+	return ft.trans.LookupLeader(ctx, host, key)
+}
+
+// BatchGet gets the keys of the entries on the given host.
+func (ft *FaultTransport) BatchGet(ctx context.Context, host string, entries []*BatchEntry, options *RequestOptions) ([]*BatchEntry, error) {
+	dup, err := ft.inject(ctx, "BatchGet", host)
+	if err != nil {
+		return nil, err
+	}
+	if dup {
+		ft.trans.BatchGet(ctx, host, entries, options)
+	}
+	return ft.trans.BatchGet(ctx, host, entries, options)
+}
+
+// BatchSet sets the entries on the given host.
+func (ft *FaultTransport) BatchSet(ctx context.Context, host string, entries []*BatchEntry, options *RequestOptions) ([]*BatchEntry, error) {
+	dup, err := ft.inject(ctx, "BatchSet", host)
+	if err != nil {
+		return nil, err
+	}
+	if dup {
+		ft.trans.BatchSet(ctx, host, entries, options)
+	}
+	return ft.trans.BatchSet(ctx, host, entries, options)
+}
+
+// Snapshot returns the snapshot of the range from the remote vnode.
+func (ft *FaultTransport) Snapshot(ctx context.Context, src, rng *chord.Vnode) (io.ReadCloser, error) {
+	if _, err := ft.inject(ctx, "Snapshot", src.Host); err != nil {
+		return nil, err
+	}
+	return ft.trans.Snapshot(ctx, src, rng)
+}
+
+// RegisterVnode registers a datastore for a vnode.
+func (ft *FaultTransport) RegisterVnode(vn *chord.Vnode, vs VnodeStore) {
+	ft.mu.Lock()
+	ft.host = vn.Host
+	ft.mu.Unlock()
+
+	ft.trans.RegisterVnode(vn, vs)
+}
+
+// Register registers a consistent store to the wrapped transport.
+func (ft *FaultTransport) Register(cs ConsistentStore) {
+	ft.trans.Register(cs)
+}
+
+// RegisterReplicationQ registers the replication channel to the wrapped transport.
+func (ft *FaultTransport) RegisterReplicationQ(rq chan<- *ReplRequest) {
+	ft.trans.RegisterReplicationQ(rq)
+}
@@ -0,0 +1,104 @@
+package difuse
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	chord "github.com/ipkg/go-chord"
+
+	"github.com/ipkg/difuse/store"
+	"github.com/ipkg/difuse/txlog"
+)
+
+func TestFaultsJSON(t *testing.T) {
+	var f Faults
+	err := json.Unmarshal([]byte(`{"partitions":[["a"],["b","c"]],"latency":"10ms","jitter":"5ms","drop":0.5,"errors":{"AppendTx":"unavailable"}}`), &f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Latency != 10*time.Millisecond || f.Jitter != 5*time.Millisecond || f.Drop != 0.5 || len(f.Partitions) != 2 {
+		t.Fatal("wrong faults", f)
+	}
+	if err = f.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := json.Marshal(&f)
+	var f2 Faults
+	json.Unmarshal(b, &f2)
+	if f2.Latency != f.Latency || f2.Errors["AppendTx"] != "unavailable" {
+		t.Fatal("should round trip", string(b))
+	}
+
+	for _, bad := range []*Faults{
+		{Drop: 1.5},
+		{Latency: -1},
+		{Partitions: [][]string{{"a"}, {"a", "b"}}},
+		{Errors: map[string]string{"Unknown": "unavailable"}},
+	} {
+		if err = bad.Validate(); ErrorCodeOf(err) != CodeInvalidArgument {
+			t.Fatal("should be invalid", bad, err)
+		}
+	}
+}
+
+func TestFaultTransport(t *testing.T) {
+	kp, _ := txlog.GenerateECDSAKeypair()
+	mn := NewMemNetwork()
+	ft := NewFaultTransport(mn.Transport("host-1"))
+	t2 := mn.Transport("host-2")
+
+	local := &chord.Vnode{Id: []byte("local"), Host: "host-1"}
+	ft.RegisterVnode(local, store.NewMemLoggedStore(local, kp))
+	vn := &chord.Vnode{Id: []byte("vnode-id"), Host: "host-2"}
+	t2.RegisterVnode(vn, store.NewMemLoggedStore(vn, kp))
+
+	ctx := context.Background()
+	if _, err := ft.Stat(ctx, []byte("key"), nil, vn); err != nil {
+		t.Fatal(err)
+	}
+
+	ft.SetFaults(&Faults{Partitions: [][]string{{"host-1"}, {"host-2"}}})
+	if _, err := ft.Transactions(ctx, []byte("key"), nil, vn); ErrorCodeOf(err) != CodeUnavailable {
+		t.Fatal("should be partitioned", err)
+	}
+
+	ft.SetFaults(&Faults{Errors: map[string]string{"TransactionsServe": "not leader", "AppendTx": "unavailable"}})
+	if _, err := ft.Transactions(ctx, []byte("key"), nil, vn); ErrorCodeOf(err) != CodeNotLeader {
+		t.Fatal("should fail with the error", err)
+	}
+	if _, err := ft.AppendTx(ctx, &txlog.Tx{}, nil, vn); ErrorCodeOf(err) != CodeUnavailable {
+		t.Fatal("should fail with the code", err)
+	}
+	if _, err := ft.Stat(ctx, []byte("key"), nil, vn); err != nil {
+		t.Fatal("other rpcs should not fail", err)
+	}
+
+	ft.SetFaults(&Faults{Drop: 1})
+	if _, err := ft.Stat(ctx, []byte("key"), nil, vn); ErrorCodeOf(err) != CodeUnavailable {
+		t.Fatal("should be dropped", err)
+	}
+
+	ft.SetFaults(&Faults{Latency: 20 * time.Millisecond})
+	start := time.Now()
+	if _, err := ft.Stat(ctx, []byte("key"), nil, vn); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatal("should be delayed")
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, 5*time.Millisecond)
+	defer cancel()
+	if _, err := ft.Stat(cctx, []byte("key"), nil, vn); err != context.DeadlineExceeded {
+		t.Fatal("should time out", err)
+	}
+
+	ft.SetFaults(nil)
+	if f := ft.Faults(); f.Latency != 0 {
+		t.Fatal("should be cleared")
+	}
+}
@@ -0,0 +1,282 @@
+package difuse
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+
+	"golang.org/x/net/context"
+
+	"github.com/ipkg/difuse/txlog"
+	chord "github.com/ipkg/go-chord"
+)
+
+// ForkRule is the deterministic rule used to pick the winning chain for a forked key.
+type ForkRule uint8
+
+const (
+	// ForkRuleMajority picks the chain held by the most vnodes.  Ties are broken by the
+	// chain length.
+	ForkRuleMajority ForkRule = iota
+	// ForkRuleEpoch picks the chain written in the latest leader epoch i.e. by the leader
+	// that took over the key last.  Ties are broken by the chain length then the number
+	// of vnodes holding the chain.
+	ForkRuleEpoch
+)
+
+func (r ForkRule) String() string {
+	switch r {
+	case ForkRuleMajority:
+		return "majority"
+	case ForkRuleEpoch:
+		return "epoch"
+	}
+	return "unknown"
+}
+
+// Set parses the name of the rule.  It implements flag.Value.
+func (r *ForkRule) Set(name string) error {
+	switch name {
+	case "majority":
+		*r = ForkRuleMajority
+	case "epoch":
+		*r = ForkRuleEpoch
+	default:
+		return fmt.Errorf("unsupported fork rule: %s", name)
+	}
+	return nil
+}
+
+// ForkBranch is a distinct chain for a key along with the vnodes holding it.
+type ForkBranch struct {
+	Tip    []byte
+	Vnodes []*chord.Vnode
+
+	txs txlog.TxSlice
+}
+
+// MarshalJSON is a custom json encoder for legibility
+func (fb *ForkBranch) MarshalJSON() ([]byte, error) {
+	vns := make([]string, len(fb.Vnodes))
+	for i, vn := range fb.Vnodes {
+		vns[i] = ShortVnodeID(vn)
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"tip":    hex.EncodeToString(fb.Tip),
+		"epoch":  fb.epoch(),
+		"length": len(fb.txs),
+		"vnodes": vns,
+	})
+}
+
+// epoch returns the epoch of the tip of the branch
+func (fb *ForkBranch) epoch() uint64 {
+	if len(fb.txs) == 0 {
+		return 0
+	}
+	return fb.txs.Last().Epoch
+}
+
+// ForkReport describes the chains of a key across its replicas.
+type ForkReport struct {
+	Key    []byte
+	Rule   ForkRule
+	Forked bool
+	// Last tx common to all branches.  Zero hash if there is none.
+	Ancestor []byte
+	// Tip of the chain picked by the fork rule.
+	Winner   []byte
+	Branches []*ForkBranch
+	// Side branches orphaned on local vnodes
+	Orphans []*VnodeResponse
+}
+
+// MarshalJSON is a custom json encoder for legibility
+func (fr *ForkReport) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"key":      string(fr.Key),
+		"rule":     fr.Rule.String(),
+		"forked":   fr.Forked,
+		"ancestor": hex.EncodeToString(fr.Ancestor),
+		"winner":   hex.EncodeToString(fr.Winner),
+		"branches": fr.Branches,
+		"orphans":  fr.Orphans,
+	})
+}
+
+// winner returns the winning branch
+func (fr *ForkReport) winner() *ForkBranch {
+	for _, b := range fr.Branches {
+		if txlog.EqualBytes(b.Tip, fr.Winner) {
+			return b
+		}
+	}
+	return nil
+}
+
+// DetectFork gathers the chain for the key from each replica vnode, grouping them into
+// branches by their tip.  The key is forked if any two branches have transactions after
+// their common ancestor.
+func (s *Difuse) DetectFork(ctx context.Context, key []byte) (*ForkReport, error) {
+	vs, err := s.ring.Lookup(s.config.Chord.NumSuccessors, key)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ForkReport{Key: key, Rule: s.config.ForkRule, Ancestor: txlog.ZeroHash()}
+	branches := make(map[string]*ForkBranch)
+
+	for _, vn := range vs {
+		txs, er := s.transport.Transactions(ctx, key, nil, vn)
+		if er != nil || len(txs) == 0 {
+			continue
+		}
+
+		tip := txs.Last().Hash()
+		if b, ok := branches[string(tip)]; ok {
+			b.Vnodes = append(b.Vnodes, vn)
+			continue
+		}
+
+		b := &ForkBranch{Tip: tip, Vnodes: []*chord.Vnode{vn}, txs: txs}
+		branches[string(tip)] = b
+		report.Branches = append(report.Branches, b)
+	}
+
+	if len(report.Branches) == 0 {
+		return report, nil
+	}
+
+	// Compute the ancestor common to all branches and check for a fork.
+	base := report.Branches[0].txs
+	ai := len(base) - 1
+	for _, b := range report.Branches[1:] {
+		i, _ := txlog.CommonAncestor(base, b.txs)
+		if i < ai {
+			ai = i
+		}
+		for _, o := range report.Branches {
+			if txlog.IsForked(o.txs, b.txs) {
+				report.Forked = true
+			}
+		}
+	}
+	if ai >= 0 {
+		report.Ancestor = base[ai].Hash()
+	}
+
+	report.Winner = pickBranch(s.config.ForkRule, report.Branches).Tip
+
+	// Add local orphans
+	for _, vn := range vs {
+		if vn.Host != s.config.Chord.Hostname {
+			continue
+		}
+		st, er := s.transport.local.GetStore(vn.Id)
+		if er != nil {
+			continue
+		}
+		if orphans, er := st.OrphanedTx(key); er == nil && len(orphans) > 0 {
+			report.Orphans = append(report.Orphans, &VnodeResponse{Id: vn.Id, Data: orphans})
+		}
+	}
+
+	return report, nil
+}
+
+// ResolveFork detects a fork for the key and rebases the chains of the local vnodes onto
+// the winning branch.  The transactions replaced on each vnode are kept as an orphaned
+// side branch.  Remote vnodes resolve the fork when their own node detects it.
+func (s *Difuse) ResolveFork(ctx context.Context, key []byte) (*ForkReport, error) {
+	report, err := s.DetectFork(ctx, key)
+	if err != nil || !report.Forked {
+		return report, err
+	}
+
+	winner := report.winner()
+	if winner == nil {
+		return report, fmt.Errorf("winning branch not found: %x", report.Winner)
+	}
+
+	for _, b := range report.Branches {
+		if b == winner || !txlog.IsForked(b.txs, winner.txs) {
+			continue
+		}
+
+		_, wi := txlog.CommonAncestor(b.txs, winner.txs)
+		ancestor := txlog.ZeroHash()
+		if wi >= 0 {
+			ancestor = winner.txs[wi].Hash()
+		}
+
+		for _, vn := range b.Vnodes {
+			if vn.Host != s.config.Chord.Hostname {
+				continue
+			}
+			st, er := s.transport.local.GetStore(vn.Id)
+			if er != nil {
+				err = er
+				continue
+			}
+
+			orphaned, er := st.RebaseTx(key, ancestor, winner.txs[wi+1:])
+			if er != nil {
+				err = er
+				continue
+			}
+			log.Printf("action=resolve-fork key='%s' vn=%s rule=%s orphaned=%d", key, shortID(vn), report.Rule, len(orphaned))
+		}
+	}
+
+	return report, err
+}
+
+// pickBranch deterministically picks the winning branch based on the rule.  Remaining
+// ties are broken by the lowest tip hash.
+func pickBranch(rule ForkRule, branches []*ForkBranch) *ForkBranch {
+	bs := &branchSorter{rule: rule, b: make([]*ForkBranch, len(branches))}
+	copy(bs.b, branches)
+	sort.Sort(bs)
+	return bs.b[0]
+}
+
+// branchSorter sorts branches by preference based on the fork rule.
+type branchSorter struct {
+	rule ForkRule
+	b    []*ForkBranch
+}
+
+func (bs *branchSorter) Len() int      { return len(bs.b) }
+func (bs *branchSorter) Swap(i, j int) { bs.b[i], bs.b[j] = bs.b[j], bs.b[i] }
+
+func (bs *branchSorter) Less(i, j int) bool {
+	a, b := bs.b[i], bs.b[j]
+
+	av, bv := len(a.Vnodes), len(b.Vnodes)
+	al, bl := len(a.txs), len(b.txs)
+
+	switch bs.rule {
+	case ForkRuleEpoch:
+		if ae, be := a.epoch(), b.epoch(); ae != be {
+			return ae > be
+		}
+		if al != bl {
+			return al > bl
+		}
+		if av != bv {
+			return av > bv
+		}
+	default:
+		if av != bv {
+			return av > bv
+		}
+		if al != bl {
+			return al > bl
+		}
+	}
+	return bytes.Compare(a.Tip, b.Tip) < 0
+}
@@ -0,0 +1,65 @@
+package difuse
+
+import (
+	"testing"
+
+	"github.com/ipkg/difuse/txlog"
+	chord "github.com/ipkg/go-chord"
+)
+
+// testChain returns a chain of n txs whose tip has the given epoch
+func testChain(n int, epoch uint64) txlog.TxSlice {
+	txs := make(txlog.TxSlice, n)
+	for i := range txs {
+		txs[i] = txlog.NewTx([]byte("key"), txlog.ZeroHash(), nil)
+	}
+	txs[n-1].Epoch = epoch
+	return txs
+}
+
+func TestPickBranch(t *testing.T) {
+	long := &ForkBranch{
+		Tip:    []byte{2},
+		Vnodes: []*chord.Vnode{&chord.Vnode{}},
+		txs:    testChain(5, 1),
+	}
+	major := &ForkBranch{
+		Tip:    []byte{3},
+		Vnodes: []*chord.Vnode{&chord.Vnode{}, &chord.Vnode{}, &chord.Vnode{}},
+		txs:    testChain(3, 1),
+	}
+	tied := &ForkBranch{
+		Tip:    []byte{1},
+		Vnodes: []*chord.Vnode{&chord.Vnode{}, &chord.Vnode{}, &chord.Vnode{}},
+		txs:    testChain(3, 1),
+	}
+	latest := &ForkBranch{
+		Tip:    []byte{4},
+		Vnodes: []*chord.Vnode{&chord.Vnode{}},
+		txs:    testChain(2, 2),
+	}
+
+	if b := pickBranch(ForkRuleMajority, []*ForkBranch{long, major}); b != major {
+		t.Error("majority branch should win")
+	}
+	if b := pickBranch(ForkRuleEpoch, []*ForkBranch{major, long, latest}); b != latest {
+		t.Error("latest epoch should win")
+	}
+	if b := pickBranch(ForkRuleEpoch, []*ForkBranch{major, long}); b != long {
+		t.Error("longest branch should win a tied epoch")
+	}
+	// ties are broken by the lowest tip
+	if b := pickBranch(ForkRuleMajority, []*ForkBranch{major, tied, long}); b != tied {
+		t.Error("lowest tip should win a tie")
+	}
+}
+
+func TestForkRuleSet(t *testing.T) {
+	var r ForkRule
+	if err := r.Set("epoch"); err != nil || r != ForkRuleEpoch {
+		t.Fatal("should parse epoch", err)
+	}
+	if err := r.Set("longest"); err == nil {
+		t.Fatal("should fail for an unknown rule")
+	}
+}
@@ -172,8 +172,16 @@ func (rcv *Tx) DataBytes() []byte {
 	return nil
 }
 
+func (rcv *Tx) Epoch() uint64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(16))
+	if o != 0 {
+		return rcv._tab.GetUint64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
 func TxStart(builder *flatbuffers.Builder) {
-	builder.StartObject(6)
+	builder.StartObject(7)
 }
 func TxAddKey(builder *flatbuffers.Builder, Key flatbuffers.UOffsetT) {
 	builder.PrependUOffsetTSlot(0, flatbuffers.UOffsetT(Key), 0)
@@ -211,6 +219,9 @@ func TxAddData(builder *flatbuffers.Builder, Data flatbuffers.UOffsetT) {
 func TxStartDataVector(builder *flatbuffers.Builder, numElems int) flatbuffers.UOffsetT {
 	return builder.StartVector(1, numElems, 1)
 }
+func TxAddEpoch(builder *flatbuffers.Builder, Epoch uint64) {
+	builder.PrependUint64Slot(6, Epoch, 0)
+}
 func TxEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
 	return builder.EndObject()
 }
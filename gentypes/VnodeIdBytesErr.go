@@ -80,8 +80,16 @@ func (rcv *VnodeIdBytesErr) E() []byte {
 	return nil
 }
 
+func (rcv *VnodeIdBytesErr) C() byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(10))
+	if o != 0 {
+		return rcv._tab.GetByte(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
 func VnodeIdBytesErrStart(builder *flatbuffers.Builder) {
-	builder.StartObject(3)
+	builder.StartObject(4)
 }
 func VnodeIdBytesErrAddId(builder *flatbuffers.Builder, Id flatbuffers.UOffsetT) {
 	builder.PrependUOffsetTSlot(0, flatbuffers.UOffsetT(Id), 0)
@@ -98,6 +106,9 @@ func VnodeIdBytesErrStartBVector(builder *flatbuffers.Builder, numElems int) fla
 func VnodeIdBytesErrAddE(builder *flatbuffers.Builder, E flatbuffers.UOffsetT) {
 	builder.PrependUOffsetTSlot(2, flatbuffers.UOffsetT(E), 0)
 }
+func VnodeIdBytesErrAddC(builder *flatbuffers.Builder, C byte) {
+	builder.PrependByteSlot(3, C, 0)
+}
 func VnodeIdBytesErrEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
 	return builder.EndObject()
 }
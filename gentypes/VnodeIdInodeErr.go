@@ -68,8 +68,16 @@ func (rcv *VnodeIdInodeErr) E() []byte {
 	return nil
 }
 
+func (rcv *VnodeIdInodeErr) C() byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(10))
+	if o != 0 {
+		return rcv._tab.GetByte(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
 func VnodeIdInodeErrStart(builder *flatbuffers.Builder) {
-	builder.StartObject(3)
+	builder.StartObject(4)
 }
 func VnodeIdInodeErrAddId(builder *flatbuffers.Builder, Id flatbuffers.UOffsetT) {
 	builder.PrependUOffsetTSlot(0, flatbuffers.UOffsetT(Id), 0)
@@ -83,6 +91,9 @@ func VnodeIdInodeErrAddInode(builder *flatbuffers.Builder, Inode flatbuffers.UOf
 func VnodeIdInodeErrAddE(builder *flatbuffers.Builder, E flatbuffers.UOffsetT) {
 	builder.PrependUOffsetTSlot(2, flatbuffers.UOffsetT(E), 0)
 }
+func VnodeIdInodeErrAddC(builder *flatbuffers.Builder, C byte) {
+	builder.PrependByteSlot(3, C, 0)
+}
 func VnodeIdInodeErrEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
 	return builder.EndObject()
 }
@@ -68,8 +68,16 @@ func (rcv *VnodeIdTxErr) E() []byte {
 	return nil
 }
 
+func (rcv *VnodeIdTxErr) C() byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(10))
+	if o != 0 {
+		return rcv._tab.GetByte(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
 func VnodeIdTxErrStart(builder *flatbuffers.Builder) {
-	builder.StartObject(3)
+	builder.StartObject(4)
 }
 func VnodeIdTxErrAddId(builder *flatbuffers.Builder, Id flatbuffers.UOffsetT) {
 	builder.PrependUOffsetTSlot(0, flatbuffers.UOffsetT(Id), 0)
@@ -83,6 +91,9 @@ func VnodeIdTxErrAddTx(builder *flatbuffers.Builder, Tx flatbuffers.UOffsetT) {
 func VnodeIdTxErrAddE(builder *flatbuffers.Builder, E flatbuffers.UOffsetT) {
 	builder.PrependUOffsetTSlot(2, flatbuffers.UOffsetT(E), 0)
 }
+func VnodeIdTxErrAddC(builder *flatbuffers.Builder, C byte) {
+	builder.PrependByteSlot(3, C, 0)
+}
 func VnodeIdTxErrEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
 	return builder.EndObject()
 }
@@ -0,0 +1,429 @@
+// Package jepsen runs Jepsen style tests against a test cluster.  Clients concurrently
+// get, set and compare-and-set keys on random nodes while a nemesis partitions, kills and
+// restarts nodes.  The history of the operations is then checked for linearizability.
+package jepsen
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/ipkg/difuse"
+	"github.com/ipkg/difuse/lincheck"
+	"github.com/ipkg/difuse/testcluster"
+)
+
+// Fault is a fault injected by the nemesis
+type Fault string
+
+const (
+	// FaultPartition splits the nodes into two sets that cannot reach each other
+	FaultPartition Fault = "partition"
+	// FaultKill kills a node starting it again once the fault is healed
+	FaultKill Fault = "kill"
+	// FaultRestart kills a node and starts it again with its stores as soon as the ring
+	// has stabilized
+	FaultRestart Fault = "restart"
+)
+
+// Config of a test run
+type Config struct {
+	Nodes   int
+	Clients int
+	Keys    int
+	// Duration clients run for
+	Duration time.Duration
+	// Maximum time a client pauses between operations.  Longer pauses give shorter
+	// histories that are quicker to check.
+	Pause time.Duration
+
+	// Consistency of sets and compare-and-sets which always wait for the apply
+	Write difuse.ConsistencyLevel
+	// Consistency of gets
+	Read difuse.ConsistencyLevel
+
+	// Faults the nemesis picks from at random.  No faults are injected if empty.
+	Faults []Fault
+	// Time each fault lasts and the time between faults
+	FaultInterval time.Duration
+
+	// Timeout of each operation
+	OpTimeout time.Duration
+	// Time given to the checker after which the outcome is unknown
+	CheckTimeout time.Duration
+
+	// Seed of the random choices of the clients and the nemesis
+	Seed int64
+
+	// Options of the cluster.  Defaults are used if nil.
+	Cluster *testcluster.Options
+}
+
+// DefaultConfig returns a config for a short run of 5 nodes and clients against 3 keys
+// with all faults.
+func DefaultConfig() *Config {
+	return &Config{
+		Nodes:         5,
+		Clients:       5,
+		Keys:          3,
+		Duration:      5 * time.Second,
+		Pause:         10 * time.Millisecond,
+		Write:         difuse.ConsistencyAll,
+		Read:          difuse.ConsistencyLeader,
+		Faults:        []Fault{FaultPartition, FaultKill, FaultRestart},
+		FaultInterval: 500 * time.Millisecond,
+		OpTimeout:     time.Second,
+		CheckTimeout:  30 * time.Second,
+		Seed:          time.Now().UnixNano(),
+	}
+}
+
+// Event is a fault injected or healed by the nemesis
+type Event struct {
+	Time  int64  `json:"time"`
+	Fault Fault  `json:"fault,omitempty"`
+	Heal  bool   `json:"heal,omitempty"`
+	Desc  string `json:"desc"`
+	Error string `json:"error,omitempty"`
+}
+
+// Stats counts the operations of a history by outcome
+type Stats struct {
+	Ok      int `json:"ok"`
+	Failed  int `json:"failed"`
+	Unknown int `json:"unknown"`
+	// Gets that failed and are left out of the history
+	Dropped int `json:"dropped"`
+}
+
+// Report is the report of a test run.
+type Report struct {
+	Name   string               `json:"name"`
+	Seed   int64                `json:"seed"`
+	Write  string               `json:"write"`
+	Read   string               `json:"read"`
+	Stats  Stats                `json:"stats"`
+	Events []Event              `json:"events"`
+	Result *lincheck.Result     `json:"result"`
+	Ops    []lincheck.Operation `json:"history"`
+}
+
+// Save writes the report as JSON to a new file in the directory returning its path.
+func (r *Report) Save(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("%s-%s-%d.json", r.Name, r.Result.Outcome, r.Seed)
+	p := filepath.Join(dir, name)
+	return p, ioutil.WriteFile(p, b, 0644)
+}
+
+// consistencyName returns the name of the consistency level as used by the http api
+func consistencyName(c difuse.ConsistencyLevel) string {
+	switch c {
+	case difuse.ConsistencyLeader:
+		return "leader"
+	case difuse.ConsistencyQuorum:
+		return "quorum"
+	case difuse.ConsistencyAll:
+		return "all"
+	case difuse.ConsistencyLazy:
+		return "lazy"
+	}
+	return fmt.Sprintf("%d", c)
+}
+
+// run holds the state of a test run
+type run struct {
+	conf    *Config
+	cluster *testcluster.Cluster
+	start   time.Time
+
+	mu     sync.Mutex
+	ops    []lincheck.Operation
+	events []Event
+	stats  Stats
+}
+
+// now returns the time since the start of the run
+func (r *run) now() int64 {
+	return int64(time.Since(r.start))
+}
+
+func (r *run) record(op lincheck.Operation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch {
+	case op.Return == lincheck.Forever:
+		r.stats.Unknown++
+	case op.Output.(*lincheck.KVOutput).Ok || op.Input.(lincheck.KVInput).Op != lincheck.KVCas:
+		r.stats.Ok++
+	default:
+		r.stats.Failed++
+	}
+	r.ops = append(r.ops, op)
+}
+
+func (r *run) drop() {
+	r.mu.Lock()
+	r.stats.Dropped++
+	r.mu.Unlock()
+}
+
+func (r *run) event(ev Event, err error) {
+	ev.Time = r.now()
+	if err != nil {
+		ev.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	r.events = append(r.events, ev)
+	r.mu.Unlock()
+}
+
+// Run starts a cluster running the clients and nemesis against it for the duration of
+// the config and checks the history.  The name identifies the run in the report.
+func Run(name string, conf *Config) (*Report, error) {
+	c, err := testcluster.New(conf.Nodes, conf.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	if err = c.WaitStable(10 * time.Second); err != nil {
+		return nil, err
+	}
+
+	r := &run{conf: conf, cluster: c, start: time.Now()}
+	rnd := rand.New(rand.NewSource(conf.Seed))
+
+	ctx, cancel := context.WithTimeout(context.Background(), conf.Duration)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < conf.Clients; i++ {
+		wg.Add(1)
+		go func(cl *client) {
+			defer wg.Done()
+			cl.run(ctx)
+		}(&client{id: i, r: r, rnd: rand.New(rand.NewSource(rnd.Int63())), last: make(map[string]*string)})
+	}
+
+	var nwg sync.WaitGroup
+	if len(conf.Faults) > 0 {
+		nwg.Add(1)
+		go func(rnd *rand.Rand) {
+			defer nwg.Done()
+			r.nemesis(ctx, rnd)
+		}(rand.New(rand.NewSource(rnd.Int63())))
+	}
+
+	wg.Wait()
+	nwg.Wait()
+
+	report := &Report{
+		Name:   name,
+		Seed:   conf.Seed,
+		Write:  consistencyName(conf.Write),
+		Read:   consistencyName(conf.Read),
+		Stats:  r.stats,
+		Events: r.events,
+		Ops:    r.ops,
+	}
+	report.Result = lincheck.Check(lincheck.KVModel(), r.ops, conf.CheckTimeout)
+	return report, nil
+}
+
+// nemesis injects a random fault every interval healing it an interval later until the
+// context is done.
+func (r *run) nemesis(ctx context.Context, rnd *rand.Rand) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.conf.FaultInterval):
+		}
+
+		heal := r.inject(r.conf.Faults[rnd.Intn(len(r.conf.Faults))], rnd)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(r.conf.FaultInterval):
+		}
+		heal()
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// inject injects the fault returning the func healing it.
+func (r *run) inject(f Fault, rnd *rand.Rand) func() {
+	c := r.cluster
+	nodes := c.Nodes()
+	hosts := make([]string, len(nodes))
+	for i, j := range rnd.Perm(len(nodes)) {
+		hosts[i] = nodes[j].Host
+	}
+
+	switch f {
+	case FaultPartition:
+		i := 1 + rnd.Intn(len(hosts)-1)
+		sets := [][]string{hosts[:i], hosts[i:]}
+		r.event(Event{Fault: f, Desc: fmt.Sprintf("%v", sets)}, c.Partition(sets...))
+		return func() {
+			c.Heal()
+			r.event(Event{Fault: f, Heal: true, Desc: "healed"}, c.WaitStable(10*r.conf.FaultInterval))
+		}
+
+	case FaultKill:
+		host := hosts[0]
+		r.event(Event{Fault: f, Desc: host}, c.Kill(host))
+		return func() {
+			err := c.WaitStable(10 * r.conf.FaultInterval)
+			if err == nil {
+				_, err = c.Start(host)
+			}
+			r.event(Event{Fault: f, Heal: true, Desc: host}, err)
+		}
+
+	case FaultRestart:
+		host := hosts[0]
+		_, err := c.Restart(host)
+		r.event(Event{Fault: f, Desc: host}, err)
+	}
+
+	return func() {}
+}
+
+// client runs operations one at a time against random nodes.
+type client struct {
+	id  int
+	r   *run
+	rnd *rand.Rand
+	n   int
+	// last value read or written by the client for each key used for the next cas
+	last map[string]*string
+}
+
+func (cl *client) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		nodes := cl.r.cluster.Nodes()
+		node := nodes[cl.rnd.Intn(len(nodes))]
+		key := fmt.Sprintf("jepsen/%d", cl.rnd.Intn(cl.r.conf.Keys))
+
+		switch p := cl.rnd.Intn(10); {
+		case p < 4:
+			cl.get(node.Difuse, key)
+		case p < 7:
+			cl.set(node.Difuse, key)
+		default:
+			cl.cas(node.Difuse, key)
+		}
+
+		if cl.r.conf.Pause > 0 {
+			time.Sleep(time.Duration(cl.rnd.Int63n(int64(cl.r.conf.Pause))))
+		}
+	}
+}
+
+// value returns a new value unique to the client
+func (cl *client) value() string {
+	cl.n++
+	return fmt.Sprintf("%d-%d", cl.id, cl.n)
+}
+
+func (cl *client) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), cl.r.conf.OpTimeout)
+}
+
+func (cl *client) get(d *difuse.Difuse, key string) {
+	op := lincheck.Operation{Client: cl.id, Input: lincheck.KVInput{Op: lincheck.KVGet, Key: key}}
+	ctx, cancel := cl.context()
+	defer cancel()
+
+	op.Call = cl.r.now()
+	val, _, err := d.Get(ctx, []byte(key), difuse.RequestOptions{Consistency: cl.r.conf.Read})
+	op.Return = cl.r.now()
+
+	out := &lincheck.KVOutput{}
+	switch {
+	case err == nil:
+		v := string(val)
+		out.Value = &v
+	case difuse.ErrorCodeOf(err) == difuse.CodeNotFound:
+	default:
+		// A failed read has no effect
+		cl.r.drop()
+		return
+	}
+	op.Output = out
+	cl.last[key] = out.Value
+	cl.r.record(op)
+}
+
+func (cl *client) set(d *difuse.Difuse, key string) {
+	in := lincheck.KVInput{Op: lincheck.KVSet, Key: key, Value: cl.value()}
+	op := lincheck.Operation{Client: cl.id, Input: in}
+	ctx, cancel := cl.context()
+	defer cancel()
+
+	op.Call = cl.r.now()
+	_, err := d.Set(ctx, []byte(key), []byte(in.Value), cl.writeOptions())
+	op.Return = cl.r.now()
+
+	if err != nil {
+		// The write may still take effect
+		op.Return = lincheck.Forever
+	} else {
+		op.Output = &lincheck.KVOutput{}
+		cl.last[key] = &in.Value
+	}
+	cl.r.record(op)
+}
+
+func (cl *client) cas(d *difuse.Difuse, key string) {
+	in := lincheck.KVInput{Op: lincheck.KVCas, Key: key, Old: cl.last[key], Value: cl.value()}
+	op := lincheck.Operation{Client: cl.id, Input: in}
+	ctx, cancel := cl.context()
+	defer cancel()
+
+	var old []byte
+	if in.Old != nil {
+		old = []byte(*in.Old)
+	}
+
+	op.Call = cl.r.now()
+	_, err := d.CompareAndSet(ctx, []byte(key), old, []byte(in.Value), cl.writeOptions())
+	op.Return = cl.r.now()
+
+	switch {
+	case err == nil:
+		op.Output = &lincheck.KVOutput{Ok: true}
+		cl.last[key] = &in.Value
+	case difuse.ErrorCodeOf(err) == difuse.CodeConditionFailed:
+		op.Output = &lincheck.KVOutput{}
+	default:
+		op.Return = lincheck.Forever
+	}
+	cl.r.record(op)
+}
+
+func (cl *client) writeOptions() difuse.RequestOptions {
+	return difuse.RequestOptions{Consistency: cl.r.conf.Write, WaitApply: true}
+}
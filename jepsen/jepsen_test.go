@@ -0,0 +1,90 @@
+package jepsen
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipkg/difuse"
+	"github.com/ipkg/difuse/lincheck"
+)
+
+var (
+	duration = flag.Duration("jepsen.duration", 5*time.Second, "time clients run for in each test")
+	seed     = flag.Int64("jepsen.seed", 0, "seed of the clients and nemesis, random if zero")
+	faults   = flag.String("jepsen.faults", "partition,kill,restart", "comma separated faults to inject")
+	out      = flag.String("jepsen.out", "artifacts", "directory failing histories are saved to")
+)
+
+func parseFaults(s string) []Fault {
+	var out []Fault
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, Fault(f))
+		}
+	}
+	return out
+}
+
+func TestJepsen(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping jepsen tests in short mode")
+	}
+
+	// Writes to all replicas read back from the leader or a quorum must be linearizable.  Writes to
+	// the leader alone may be lost when a new leader is elected, and lazy reads may be
+	// served by a lagging replica, so the other levels are only reported.
+	cases := []struct {
+		name         string
+		write, read  difuse.ConsistencyLevel
+		linearizable bool
+		// reason the case is skipped, if any
+		skip string
+	}{
+		{"all-leader", difuse.ConsistencyAll, difuse.ConsistencyLeader, true, "leaders can serve writes that are later orphaned, see the roadmap"},
+		{"all-quorum", difuse.ConsistencyAll, difuse.ConsistencyQuorum, true, "quorum reads are not implemented, see the roadmap"},
+		{"all-lazy", difuse.ConsistencyAll, difuse.ConsistencyLazy, false, ""},
+		{"leader-leader", difuse.ConsistencyLeader, difuse.ConsistencyLeader, false, ""},
+		{"leader-quorum", difuse.ConsistencyLeader, difuse.ConsistencyQuorum, false, "quorum reads are not implemented, see the roadmap"},
+		{"leader-lazy", difuse.ConsistencyLeader, difuse.ConsistencyLazy, false, ""},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			if c.skip != "" {
+				t.Skip(c.skip)
+			}
+
+			conf := DefaultConfig()
+			conf.Duration = *duration
+			conf.Faults = parseFaults(*faults)
+			conf.Write = c.write
+			conf.Read = c.read
+			if *seed != 0 {
+				conf.Seed = *seed
+			}
+
+			rep, err := Run(c.name, conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Logf("seed=%d outcome=%s ok=%d failed=%d unknown=%d dropped=%d faults=%d", rep.Seed,
+				rep.Result.Outcome, rep.Stats.Ok, rep.Stats.Failed, rep.Stats.Unknown, rep.Stats.Dropped, len(rep.Events))
+
+			if rep.Result.Outcome == lincheck.Ok {
+				return
+			}
+			p, err := rep.Save(*out)
+			if err != nil {
+				t.Error(err)
+			} else {
+				t.Logf("history saved to %s", p)
+			}
+			if c.linearizable && rep.Result.Outcome == lincheck.Illegal {
+				t.Error("history is not linearizable")
+			}
+		})
+	}
+}
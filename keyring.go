@@ -0,0 +1,212 @@
+package difuse
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"time"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"golang.org/x/net/context"
+
+	"github.com/ipkg/difuse/store"
+	"github.com/ipkg/difuse/txlog"
+	chord "github.com/ipkg/go-chord"
+)
+
+// interval at which reading the keyring is retried until it first succeeds
+const keyringRetryInterval = 250 * time.Millisecond
+
+var (
+	// KeyringKey is the reserved key holding the cluster keyring of authorized signers.
+	// The keyring is replicated like any other key.
+	KeyringKey = []byte("_difuse/keyring")
+
+	// ErrReservedKey is returned when writing a key reserved for cluster settings
+	ErrReservedKey = errors.New("reserved key")
+
+	errInvalidPublicKey = errors.New("invalid public key")
+	errRevokeLocalKey   = errors.New("cannot revoke local signer")
+)
+
+// IsReservedKey returns whether the key holds a cluster setting and cannot be written
+// directly.  Writes of reserved keys through the inode write paths are rejected by the
+// leader.  Settings are written as txs signed by the node changing them which replicas
+// only accept from authorized signers.
+func IsReservedKey(key []byte) bool {
+	return bytes.Equal(key, KeyringKey) || bytes.Equal(key, CompressionKey)
+}
+
+// Signers returns the public keys in the cluster keyring.  The keyring is seeded when the
+// cluster is created so a missing keyring is returned as an error.
+func (s *Difuse) Signers(ctx context.Context) ([][]byte, error) {
+	val, _, err := s.Get(ctx, KeyringKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeKeyring(val), nil
+}
+
+// AddSigner adds the public key to the cluster keyring.
+func (s *Difuse) AddSigner(ctx context.Context, pubkey []byte) error {
+	if len(pubkey) == 0 {
+		return errInvalidPublicKey
+	}
+
+	keys, err := s.Signers(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if bytes.Equal(k, pubkey) {
+			return nil
+		}
+	}
+
+	return s.setSigners(ctx, append(keys, pubkey))
+}
+
+// RevokeSigner removes the public key from the cluster keyring.  The public key of this
+// node cannot be revoked from itself.
+func (s *Difuse) RevokeSigner(ctx context.Context, pubkey []byte) error {
+	if bytes.Equal(pubkey, s.PublicKey()) {
+		return errRevokeLocalKey
+	}
+
+	keys, err := s.Signers(ctx)
+	if err != nil {
+		return err
+	}
+
+	out := make([][]byte, 0, len(keys))
+	for _, k := range keys {
+		if !bytes.Equal(k, pubkey) {
+			out = append(out, k)
+		}
+	}
+	if len(out) == len(keys) {
+		return nil
+	}
+
+	return s.setSigners(ctx, out)
+}
+
+// setSigners writes the keyring to all replicas and updates the local keyring.
+func (s *Difuse) setSigners(ctx context.Context, keys [][]byte) error {
+	if err := s.setSetting(ctx, KeyringKey, encodeKeyring(keys)); err != nil {
+		return err
+	}
+
+	s.keyring.Set(keys)
+	return nil
+}
+
+// setSetting sets the value of a reserved key.  The tx is built and signed by this node
+// extending the last tx of the key on the leader and appended to the leader then all
+// other replicas.
+func (s *Difuse) setSetting(ctx context.Context, key, value []byte) error {
+	l, vs, vm, err := s.LookupLeader(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	tx := txlog.NewTx(key, txlog.ZeroHash(), nil)
+	rsp, err := s.transport.LastTx(ctx, key, nil, l)
+	if err != nil {
+		return err
+	}
+	if ltx, ok := rsp[0].Data.(*txlog.Tx); ok && rsp[0].Err == nil {
+		tx.PrevHash, tx.Epoch = ltx.Hash(), ltx.Epoch
+	}
+
+	inode := store.NewKeyInodeWithValue(key, value)
+	fb := flatbuffers.NewBuilder(0)
+	fb.Finish(inode.Serialize(fb))
+	tx.Data = append([]byte{store.TxTypeSet}, fb.Bytes[fb.Head():]...)
+	if err = tx.Sign(s.signator); err != nil {
+		return err
+	}
+
+	opts := &RequestOptions{Consistency: ConsistencyAll, WaitApply: true}
+	if rsp, err = s.transport.AppendTx(ctx, tx, opts, vm[l.Host]...); err != nil {
+		return err
+	}
+	if rsp[0].Err != nil {
+		return rsp[0].Err
+	}
+
+	rvs := make([]*chord.Vnode, 0, len(vs))
+	for _, vn := range vs {
+		if vn.Host != l.Host {
+			rvs = append(rvs, vn)
+		}
+	}
+	_, err = fanout(ctx, rvs, fanoutAll, func(ctx context.Context, vns ...*chord.Vnode) ([]*VnodeResponse, error) {
+		return s.transport.AppendTx(ctx, tx, opts, vns...)
+	})
+	return err
+}
+
+// seedKeyring writes the keyring of a newly created cluster authorizing this node and the
+// signers from the config.  An existing keyring e.g. in restored stores is kept.
+func (s *Difuse) seedKeyring() error {
+	ctx := context.Background()
+	if _, err := s.Signers(ctx); ErrorCodeOf(err) != CodeNotFound {
+		return err
+	}
+
+	keys := append([][]byte{s.PublicKey()}, s.config.Signers...)
+	// The local replicas must accept the keyring signed by this node
+	s.keyring.Set(keys)
+	return s.setSigners(ctx, keys)
+}
+
+// syncKeyring refreshes the local keyring from the cluster keyring.  The keyring stays
+// pending, authorizing no signers, until it is read.
+func (s *Difuse) syncKeyring() error {
+	keys, err := s.Signers(context.Background())
+	if err == nil {
+		s.keyring.Set(keys)
+	}
+	return err
+}
+
+// startClusterSync retries reading the keyring until it succeeds then periodically
+// refreshes cluster wide settings i.e. the keyring and compression codec.
+func (s *Difuse) startClusterSync() {
+	for s.keyring.Pending() {
+		time.Sleep(keyringRetryInterval)
+		if err := s.syncKeyring(); err != nil {
+			log.Printf("action=keyring-sync status=failed msg='%v'", err)
+		}
+	}
+
+	if s.config.KeyringRefresh <= 0 {
+		return
+	}
+	for range time.Tick(s.config.KeyringRefresh) {
+		if err := s.syncKeyring(); err != nil {
+			log.Printf("action=keyring-sync status=failed msg='%v'", err)
+		}
+		if err := s.syncCompression(); err != nil {
+			log.Printf("action=compression-sync status=failed msg='%v'", err)
+		}
+	}
+}
+
+// encodeKeyring encodes the public keys as newline separated values
+func encodeKeyring(keys [][]byte) []byte {
+	return bytes.Join(keys, []byte("\n"))
+}
+
+func decodeKeyring(b []byte) [][]byte {
+	keys := [][]byte{}
+	for _, k := range bytes.Split(b, []byte("\n")) {
+		if k = bytes.TrimSpace(k); len(k) > 0 {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
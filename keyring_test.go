@@ -0,0 +1,28 @@
+package difuse
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestKeyringEncoding(t *testing.T) {
+	keys := [][]byte{[]byte("key1"), []byte("key2")}
+
+	dec := decodeKeyring(encodeKeyring(keys))
+	if len(dec) != 2 {
+		t.Fatal("wrong key count")
+	}
+	for i := range keys {
+		if !bytes.Equal(keys[i], dec[i]) {
+			t.Fatal("key mismatch")
+		}
+	}
+
+	if len(decodeKeyring(nil)) != 0 {
+		t.Fatal("should be empty")
+	}
+
+	if !IsReservedKey(KeyringKey) || !IsReservedKey(CompressionKey) || IsReservedKey([]byte("key")) {
+		t.Fatal("reserved key check failed")
+	}
+}
@@ -1,18 +1,118 @@
 package difuse
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
+	"sort"
+	"sync"
 
+	"golang.org/x/net/context"
+
+	"github.com/ipkg/difuse/gentypes"
+	"github.com/ipkg/difuse/store"
 	"github.com/ipkg/difuse/txlog"
 	chord "github.com/ipkg/go-chord"
 )
 
+var (
+	// ErrLeaderNotReady is returned when the elected leader has not yet caught up to the
+	// chain held by the majority of the replicas.
+	ErrLeaderNotReady = errors.New("leader not ready")
+)
+
+// maxLeaderKeys is the number of keys tracked before all are forgotten bounding the
+// memory used.  Forgotten keys are caught up again on their next write.
+const maxLeaderKeys = 1 << 16
+
+// leaderKey is the vnode that caught up as the leader of a key and the tip of the chain
+// it last appended.
+type leaderKey struct {
+	vnode string
+	tip   []byte
+}
+
+// leaderKeys tracks the keys for which a local vnode has caught up and is accepting
+// writes as the leader.  A key is only ready while the chain of the leader still ends
+// with the tx it last appended, so a chain advanced by another leader in the meantime
+// is caught up again.
+type leaderKeys struct {
+	mu sync.RWMutex
+	m  map[string]leaderKey
+}
+
+func newLeaderKeys() *leaderKeys {
+	return &leaderKeys{m: make(map[string]leaderKey)}
+}
+
+// isReady returns whether the given vnode has caught up as the leader for the key and
+// its chain still ends with tip.
+func (lk *leaderKeys) isReady(key []byte, vn *chord.Vnode, tip []byte) bool {
+	lk.mu.RLock()
+	defer lk.mu.RUnlock()
+
+	k, ok := lk.m[string(key)]
+	return ok && k.vnode == vn.String() && txlog.EqualBytes(k.tip, tip)
+}
+
+// setReady marks the vnode as the caught up leader for the key whose chain ends with tip.
+func (lk *leaderKeys) setReady(key []byte, vn *chord.Vnode, tip []byte) {
+	lk.mu.Lock()
+	if _, ok := lk.m[string(key)]; !ok && len(lk.m) >= maxLeaderKeys {
+		lk.m = make(map[string]leaderKey)
+	}
+	lk.m[string(key)] = leaderKey{vnode: vn.String(), tip: tip}
+	lk.mu.Unlock()
+}
+
+// remove the key, forcing a catch up the next time a local vnode becomes its leader.
+func (lk *leaderKeys) remove(key []byte) {
+	lk.mu.Lock()
+	delete(lk.m, string(key))
+	lk.mu.Unlock()
+}
+
+// reset removes all keys.  It is called on ring changes as leadership of any key may
+// have moved.
+func (lk *leaderKeys) reset() {
+	lk.mu.Lock()
+	lk.m = make(map[string]leaderKey)
+	lk.mu.Unlock()
+}
+
+// chainTip returns the hash of the last tx of the key in the store or the zero hash if it
+// has none.
+func chainTip(st VnodeStore, key []byte) []byte {
+	if ltx, err := st.LastTx(key); err == nil {
+		return ltx.Hash()
+	}
+	return txlog.ZeroHash()
+}
+
+// isRetryableLeaderErr returns whether a redirected write should be retried against a
+// newly looked up leader.  This is the case when the leader is unreachable, is no longer
+// the leader or has not caught up yet.
+func isRetryableLeaderErr(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch ErrorCodeOf(err) {
+	case CodeNotLeader, CodeUnavailable:
+		return true
+	}
+	return false
+}
+
 // isLeader returns whether this host owns the provided vnode
 func (s *Difuse) isLeader(vn *chord.Vnode) bool {
 	return s.config.Chord.Hostname == vn.Host
 }
 
-func (s *Difuse) keyleader(key []byte, vs []*chord.Vnode) (lvn *chord.Vnode, vm map[string][]*chord.Vnode, err error) {
+// keyleader elects the leader for the key by a vote on the merkle roots of the replicas.
+// The vote is settled if a majority of the hosts answered and all agree on the root.
+func (s *Difuse) keyleader(ctx context.Context, key []byte, vs []*chord.Vnode) (lvn *chord.Vnode, vm map[string][]*chord.Vnode, settled bool, err error) {
 	vm = vnodesByHost(vs)
 
 	quorum := (len(vs) / 2) + 1
@@ -32,7 +132,7 @@ func (s *Difuse) keyleader(key []byte, vs []*chord.Vnode) (lvn *chord.Vnode, vm
 		// Get the last tx for all vn's on the host
 		vns := vm[vn.Host]
 		//resp, e := s.transport.LastTx(key, nil, vns...)
-		resp, e := s.transport.MerkleRootTx(key, nil, vns...)
+		resp, e := s.transport.MerkleRootTx(ctx, key, nil, vns...)
 		if e != nil {
 			continue
 		}
@@ -65,6 +165,7 @@ func (s *Difuse) keyleader(key []byte, vs []*chord.Vnode) (lvn *chord.Vnode, vm
 
 	// Get tx with max votes
 	leaderTx, _ := maxVotes(txvote)
+	settled = len(txvote) == 1 && len(lm) > len(vm)/2
 	// Get all hosts with this tx hash
 	candidates := txvote[leaderTx]
 	// Get first vn in the supplied list and candidates to elect as leader
@@ -79,92 +180,433 @@ func (s *Difuse) keyleader(key []byte, vs []*chord.Vnode) (lvn *chord.Vnode, vm
 	return
 }
 
+// leaderTx is a signed tx of a write to be appended by the local leader vnode l to the
+// replicas vs of the key.
+type leaderTx struct {
+	tx *txlog.Tx
+	l  *chord.Vnode
+	vs []*chord.Vnode
+	vm map[string][]*chord.Vnode
+	// meta of the write given the commit token once the leader has appended the tx
+	meta *ResponseMeta
+}
+
 // appendTx appends a transaction to the log based on the consistency.  If this node is not the leader
-// for the key, the leader vnode and error are returned otherwise just the leader vnode. This always
-// processes leader first then remainder based on consistency
-func (s *Difuse) appendTx(txtype byte, key, data []byte, opts *RequestOptions) (*chord.Vnode, error) {
+// for the key, the leader vnode and error are returned otherwise the leader vnode and the commit token
+// of the tx. This always processes leader first then remainder based on consistency.  The returned
+// meta is never nil.
+func (s *Difuse) appendTx(ctx context.Context, txtype byte, key, data []byte, opts *RequestOptions) (*ResponseMeta, error) {
+	lt, meta, err := s.newLeaderTx(ctx, txtype, key, data, opts)
+	if err != nil {
+		return meta, err
+	}
+	tx, l, vs, vm := lt.tx, lt.l, lt.vs, lt.vm
+
+	// Append the new tx
+	vns := vm[l.Host]
+	resp, err := s.transport.AppendTx(ctx, tx, opts, vns...)
+	if err != nil {
+		return meta, err
+	}
+	if resp[0].Err != nil {
+		return meta, resp[0].Err
+	}
+	meta.Token = tx.Hash()
+	s.lkeys.setReady(key, l, meta.Token)
+
+	delete(vm, l.Host)
+
+	switch opts.Consistency {
+	case ConsistencyLeader:
+		// Replicas are updated after the request returns so are not bound by its context.
+		// They do not need to wait for the apply as no one waits for them.
+		go func(vmap map[string][]*chord.Vnode, ktx *txlog.Tx, options RequestOptions) {
+
+			for _, vns := range vmap {
+				/*resp, err := s.transport.AppendTx(ktx, &options, vns...)
+				if err != nil {
+					log.Printf("action=appendtx status=failed key=%s msg='%v'", ktx.Key, err)
+					continue
+				}
+
+				for _, rsp := range resp {
+					if rsp.Err != nil {
+						log.Printf("action=appendtx status=failed key=%s vn=%x msg='%v'", ktx.Key, rsp.Id[:8], rsp.Err)
+					}
+				}*/
+				s.transport.AppendTx(context.Background(), ktx, &options, vns...)
+
+			}
 
-	l, _, vm, err := s.LookupLeader(key)
+		}(vm, tx, RequestOptions{Consistency: opts.Consistency})
+
+		return meta, nil
+
+	case ConsistencyAll:
+		rvs := make([]*chord.Vnode, 0, len(vs))
+		for _, vn := range vs {
+			if vn.Host != l.Host {
+				rvs = append(rvs, vn)
+			}
+		}
+
+		_, err = fanout(ctx, rvs, fanoutAll, func(ctx context.Context, vns ...*chord.Vnode) ([]*VnodeResponse, error) {
+			return s.transport.AppendTx(ctx, tx, opts, vns...)
+		})
+		return meta, err
+	}
+
+	return &ResponseMeta{}, invalidConsistencyError(opts.Consistency)
+}
+
+// newLeaderTx elects the leader for the key and if it is a local vnode catches it up and
+// returns the signed tx of the write.  If this node is not the leader the leader vnode and
+// a not-leader error are returned.  The returned meta is never nil.
+func (s *Difuse) newLeaderTx(ctx context.Context, txtype byte, key, data []byte, opts *RequestOptions) (*leaderTx, *ResponseMeta, error) {
+	meta := &ResponseMeta{}
+
+	// All inode writes signed on behalf of a request pass here
+	if IsReservedKey(key) {
+		return nil, meta, ErrReservedKey
+	}
+
+	l, vs, vm, settled, err := s.lookupLeader(ctx, key)
 	if err != nil {
-		return nil, err
+		return nil, meta, err
 	}
+	meta.Vnode = l
 
 	// If we are not the leader return the leader and a not-leader error
 	if !s.isLeader(l) {
-		return l, ErrNotLeader
+		s.lkeys.remove(key)
+		return nil, meta, ErrNotLeader
+	}
+
+	// Make sure we have the majority chain before accepting writes as a new leader.  A
+	// vote that is not settled may have missed replicas ahead of us.
+	lst, err := s.transport.local.GetStore(l.Id)
+	if err != nil {
+		return nil, meta, err
+	}
+	var epoch uint64
+	if !settled || !s.lkeys.isReady(key, l, chainTip(lst, key)) {
+		if epoch, err = s.catchupLeader(ctx, key, l, vm); err != nil {
+			return nil, meta, err
+		}
+		// Taking over the key starts a new epoch
+		epoch++
 	}
 
 	// Get new tx from leader
 	rsp, err := s.transport.NewTx(key, l)
 	if err != nil {
-		return l, err
+		return nil, meta, err
 	} else if rsp[0].Err != nil {
-		return l, rsp[0].Err
+		return nil, meta, rsp[0].Err
 	}
 
 	tx, _ := rsp[0].Data.(*txlog.Tx)
 	//if !ok {
 	//	return l, fmt.Errorf(errInvalidDataType, tx)
 	//}
+	if epoch > tx.Epoch {
+		tx.Epoch = epoch
+	}
+	if opts.If != nil {
+		if err = s.checkCondition(ctx, l, key, tx.PrevHash, opts.If); err != nil {
+			return nil, meta, err
+		}
+	}
 	tx.Data = append([]byte{txtype}, data...)
 	if err = tx.Sign(s.signator); err != nil {
-		return l, err
+		return nil, meta, err
 	}
 
-	// Append the new tx
-	vns := vm[l.Host]
-	resp, err := s.transport.AppendTx(tx, opts, vns...)
-	if err != nil {
-		return l, err
+	return &leaderTx{tx: tx, l: l, vs: vs, vm: vm, meta: meta}, meta, nil
+}
+
+// appendTxs appends the txs of a batch of writes as appendTx does for a single write.  The
+// txs are sent with one request per host carrying the txs of each of its vnodes.  Nil
+// entries are skipped.  It returns an error per entry.
+func (s *Difuse) appendTxs(ctx context.Context, lts []*leaderTx, opts *RequestOptions) []error {
+	errs := make([]error, len(lts))
+
+	// Append to the leaders first, all of which are local
+	lb := newVnodeBatches()
+	for i, lt := range lts {
+		if lt != nil {
+			lb.add(i, lt.tx, lt.vm[lt.l.Host]...)
+		}
 	}
-	if resp[0].Err != nil {
-		return l, resp[0].Err
+	for _, host := range lb.hosts {
+		lb.send(ctx, s.transport, host, opts, func(i int, vn *chord.Vnode, err error) {
+			if vn.String() == lts[i].l.String() {
+				errs[i] = err
+			}
+		})
 	}
 
-	delete(vm, l.Host)
+	rb := newVnodeBatches()
+	for i, lt := range lts {
+		if lt == nil || errs[i] != nil {
+			continue
+		}
+		lt.meta.Token = lt.tx.Hash()
+		s.lkeys.setReady(lt.tx.Key, lt.l, lt.meta.Token)
+
+		for host, vns := range lt.vm {
+			if host != lt.l.Host {
+				rb.add(i, lt.tx, vns...)
+			}
+		}
+	}
 
 	switch opts.Consistency {
 	case ConsistencyLeader:
+		// Replicas are updated after the request returns as for a single write
+		go func(options RequestOptions) {
+			for _, host := range rb.hosts {
+				rb.send(context.Background(), s.transport, host, &options, func(int, *chord.Vnode, error) {})
+			}
+		}(RequestOptions{Consistency: opts.Consistency})
 
-		go func(vmap map[string][]*chord.Vnode, ktx *txlog.Tx, options RequestOptions) {
+	case ConsistencyAll:
+		var (
+			mu sync.Mutex
+			wg sync.WaitGroup
+		)
+		for _, host := range rb.hosts {
+			wg.Add(1)
+			go func(host string) {
+				defer wg.Done()
+				rb.send(ctx, s.transport, host, opts, func(i int, vn *chord.Vnode, err error) {
+					mu.Lock()
+					if errs[i] == nil {
+						errs[i] = err
+					}
+					mu.Unlock()
+				})
+			}(host)
+		}
+		wg.Wait()
+	}
 
-			for _, vns := range vmap {
-				/*resp, err := s.transport.AppendTx(ktx, &options, vns...)
-				if err != nil {
-					log.Printf("action=appendtx status=failed key=%s msg='%v'", ktx.Key, err)
-					continue
+	return errs
+}
+
+// vnodeBatches groups the txs of a batch of writes into one batch per vnode keeping the
+// hosts in the order they were added.
+type vnodeBatches struct {
+	hosts   []string
+	batches map[string][]*VnodeTxs
+	// batch of each vnode on its host
+	idx map[string]int
+	// index of the write of each tx in a batch
+	owners map[*VnodeTxs][]int
+}
+
+func newVnodeBatches() *vnodeBatches {
+	return &vnodeBatches{
+		batches: make(map[string][]*VnodeTxs),
+		idx:     make(map[string]int),
+		owners:  make(map[*VnodeTxs][]int),
+	}
+}
+
+// add adds the tx of the write at index i to the batches of the vnodes.
+func (vb *vnodeBatches) add(i int, tx *txlog.Tx, vns ...*chord.Vnode) {
+	for _, vn := range vns {
+		j, ok := vb.idx[vn.String()]
+		if !ok {
+			if _, ok := vb.batches[vn.Host]; !ok {
+				vb.hosts = append(vb.hosts, vn.Host)
+			}
+			j = len(vb.batches[vn.Host])
+			vb.idx[vn.String()] = j
+			vb.batches[vn.Host] = append(vb.batches[vn.Host], &VnodeTxs{Vnode: vn})
+		}
+
+		b := vb.batches[vn.Host][j]
+		b.Txs = append(b.Txs, tx)
+		vb.owners[b] = append(vb.owners[b], i)
+	}
+}
+
+// send appends the batches of the host in a single request calling fn with the index of
+// the write, the vnode and the error of each tx.
+func (vb *vnodeBatches) send(ctx context.Context, trans *localTransport, host string, opts *RequestOptions, fn func(int, *chord.Vnode, error)) {
+	batches := vb.batches[host]
+	resp, err := trans.AppendTxs(ctx, batches, opts)
+
+	var k int
+	for _, b := range batches {
+		for j := range b.Txs {
+			e := err
+			if e == nil {
+				if k < len(resp) {
+					e = resp[k].Err
+				} else {
+					e = errMissingResponse
 				}
+			}
+			fn(vb.owners[b][j], b.Vnode, e)
+			k++
+		}
+	}
+}
 
-				for _, rsp := range resp {
-					if rsp.Err != nil {
-						log.Printf("action=appendtx status=failed key=%s vn=%x msg='%v'", ktx.Key, rsp.Id[:8], rsp.Err)
-					}
-				}*/
-				s.transport.AppendTx(ktx, &options, vns...)
+// checkCondition checks the condition of a write against the value set by the tx preceding
+// it on the leader.  The preceding tx is first applied so reads on the leader see the
+// value the condition was checked against.  A concurrent write to the key makes the
+// append of the new tx fail so the condition cannot change before the write is appended.
+func (s *Difuse) checkCondition(ctx context.Context, l *chord.Vnode, key, prev []byte, cond *Condition) error {
+	var value []byte
+	if !isZeroHash(prev) {
+		st, err := s.transport.local.GetStore(l.Id)
+		if err != nil {
+			return err
+		}
+		// The tx may still be queued so it is looked up as the last tx.
+		ptx, err := st.LastTx(key)
+		if err != nil {
+			return err
+		}
+		if lh := ptx.Hash(); !txlog.EqualBytes(lh, prev) {
+			return &txlog.PrevHashError{Key: key, Want: lh, Have: prev}
+		}
+		if err = st.WaitTx(ctx, key, prev); err != nil {
+			return err
+		}
 
+		if len(ptx.Data) > 0 && ptx.Data[0] == store.TxTypeSet {
+			inode := &store.Inode{}
+			inode.Deserialize(gentypes.GetRootAsInode(ptx.Data[1:], 0))
+			if value, err = s.readInode(ctx, inode); err != nil {
+				return err
 			}
+			if value == nil {
+				value = []byte{}
+			}
+		}
+	}
 
-		}(vm, tx, *opts)
+	if !cond.holds(value) {
+		return ErrConditionFailed
+	}
+	return nil
+}
 
-		return l, nil
+// catchupLeader brings the local leader vnode up to the longest chain prefix held by a
+// majority of the replicas before it accepts writes for the key.  This prevents a newly
+// elected leader with a shorter chain from forking the log.  Replicas on the same chain
+// at different lengths all hold the prefix so a majority is found during failover.  It
+// returns the highest epoch of the last txs of the replicas or ErrLeaderNotReady if the
+// majority prefix could not be obtained.
+func (s *Difuse) catchupLeader(ctx context.Context, key []byte, l *chord.Vnode, vm map[string][]*chord.Vnode) (uint64, error) {
+	st, err := s.transport.local.GetStore(l.Id)
+	if err != nil {
+		return 0, err
+	}
 
-	case ConsistencyAll:
-		for _, vns := range vm {
+	type replicaChain struct {
+		vn  *chord.Vnode
+		txs txlog.TxSlice
+	}
+
+	var (
+		counts  = make(map[string]int)           // tx hash to number of chains holding it
+		chains  = make(map[string]txlog.TxSlice) // tx hash to a chain holding it
+		remotes []replicaChain
+		total   int
+		epoch   uint64
+	)
+
+	addChain := func(txs txlog.TxSlice) {
+		total++
+		for _, tx := range txs {
+			hk := hex.EncodeToString(tx.Hash())
+			counts[hk]++
+			chains[hk] = txs
+		}
+		if n := len(txs); n > 0 && txs[n-1].Epoch > epoch {
+			epoch = txs[n-1].Epoch
+		}
+	}
+
+	// The local chain counts as the chain of the leader host.
+	ltxs, _ := st.Transactions(key, nil)
+	addChain(ltxs)
 
-			resp, e := s.transport.AppendTx(tx, opts, vns...)
-			if e != nil {
-				err = e
+	// Get the chains of all replica vnodes.  Replicas without the key hold an empty chain
+	// while those not reachable are left out.
+	for host, vns := range vm {
+		if host == l.Host {
+			continue
+		}
+		for _, vn := range vns {
+			txs, er := s.transport.Transactions(ctx, key, nil, vn)
+			if er != nil && ErrorCodeOf(er) == CodeUnavailable {
 				continue
 			}
-			for _, r := range resp {
-				if r.Err != nil {
-					err = r.Err
-				}
+			addChain(txs)
+			remotes = append(remotes, replicaChain{vn: vn, txs: txs})
+		}
+	}
+
+	// The highest tx held by more than half of the chains.  A tx commits to all txs
+	// before it so every chain holding it holds the whole prefix.
+	var (
+		mh  string
+		pos = -1
+	)
+	for hk, c := range counts {
+		if c < (total/2)+1 {
+			continue
+		}
+		for i, tx := range chains[hk] {
+			if i > pos && hex.EncodeToString(tx.Hash()) == hk {
+				mh, pos = hk, i
+				break
 			}
+		}
+	}
 
+	// Pull missing transactions from the replicas, longest chain first.  Replicas on a
+	// diverging chain will simply fail to replicate.
+	sort.Slice(remotes, func(i, j int) bool { return len(remotes[i].txs) > len(remotes[j].txs) })
+	for _, r := range remotes {
+		n := len(r.txs)
+		if n == 0 || storeHasTx(st, key, hex.EncodeToString(r.txs[n-1].Hash())) {
+			continue
 		}
-		return l, err
+
+		var seek []byte
+		if ltx, er := st.LastTx(key); er == nil {
+			seek = ltx.Hash()
+		}
+		if err = s.transport.ReplicateTransactions(ctx, key, seek, r.vn, l); err != nil {
+			log.Printf("action=catchup status=failed key=%q src=%s msg='%v'", key, ShortVnodeID(r.vn), err)
+		}
+	}
+
+	// The majority holds no transactions or we have its prefix.
+	if pos < 0 || storeHasTx(st, key, mh) {
+		return epoch, nil
+	}
+	return 0, ErrLeaderNotReady
+}
+
+// storeHasTx returns whether the store has the tx with the given hex encoded hash either
+// as its last (possibly queued) tx or in its stable store.
+func storeHasTx(st VnodeStore, key []byte, hk string) bool {
+	if ltx, err := st.LastTx(key); err == nil && hex.EncodeToString(ltx.Hash()) == hk {
+		return true
 	}
 
-	return nil, fmt.Errorf(errInvalidConsistencyLevel, opts.Consistency)
+	h, err := hex.DecodeString(hk)
+	if err != nil {
+		return false
+	}
+	_, err = st.GetTx(key, h)
+	return err == nil
 }
@@ -0,0 +1,63 @@
+package difuse
+
+import (
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	chord "github.com/ipkg/go-chord"
+)
+
+func TestLeaderKeys(t *testing.T) {
+	lk := newLeaderKeys()
+	vn1 := &chord.Vnode{Id: []byte("vnode-1"), Host: "127.0.0.1:1234"}
+	vn2 := &chord.Vnode{Id: []byte("vnode-2"), Host: "127.0.0.1:1234"}
+	tip1 := []byte("tip-1")
+	tip2 := []byte("tip-2")
+
+	if lk.isReady([]byte("key"), vn1, tip1) {
+		t.Fatal("should not be ready")
+	}
+
+	lk.setReady([]byte("key"), vn1, tip1)
+	if !lk.isReady([]byte("key"), vn1, tip1) {
+		t.Fatal("should be ready")
+	}
+	if lk.isReady([]byte("key"), vn2, tip1) {
+		t.Fatal("should not be ready for a different vnode")
+	}
+	if lk.isReady([]byte("key"), vn1, tip2) {
+		t.Fatal("should not be ready once the chain advanced")
+	}
+
+	lk.remove([]byte("key"))
+	if lk.isReady([]byte("key"), vn1, tip1) {
+		t.Fatal("should not be ready")
+	}
+
+	lk.setReady([]byte("key"), vn1, tip1)
+	lk.reset()
+	if lk.isReady([]byte("key"), vn1, tip1) {
+		t.Fatal("should not be ready after a ring change")
+	}
+}
+
+func TestIsRetryableLeaderErr(t *testing.T) {
+	if isRetryableLeaderErr(nil) {
+		t.Error("nil should not be retryable")
+	}
+	if !isRetryableLeaderErr(ErrNotLeader) {
+		t.Error("not leader should be retryable")
+	}
+	if !isRetryableLeaderErr(remoteError(ErrLeaderNotReady)) {
+		t.Error("remote leader not ready should be retryable")
+	}
+	if !isRetryableLeaderErr(grpc.Errorf(codes.Unavailable, "transport is closing")) {
+		t.Error("unavailable should be retryable")
+	}
+	if isRetryableLeaderErr(fmt.Errorf("previous hash want=00 have=01")) {
+		t.Error("prev hash mismatch should not be retryable")
+	}
+}
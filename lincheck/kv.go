@@ -0,0 +1,96 @@
+package lincheck
+
+const (
+	// KVGet reads a key
+	KVGet = "get"
+	// KVSet sets a key
+	KVSet = "set"
+	// KVCas sets a key if it has the old value
+	KVCas = "cas"
+)
+
+// KVInput is the input of an operation on a key value store.
+type KVInput struct {
+	Op  string `json:"op"`
+	Key string `json:"key"`
+	// Value set by a set or cas
+	Value string `json:"value,omitempty"`
+	// Old value expected by a cas.  Nil expects the key to not exist.
+	Old *string `json:"old,omitempty"`
+}
+
+// KVOutput is the output of an operation on a key value store.  Operations of unknown
+// outcome have a nil output.
+type KVOutput struct {
+	// Value read by a get.  Nil if the key does not exist.
+	Value *string `json:"value,omitempty"`
+	// Whether a cas set the key
+	Ok bool `json:"ok"`
+}
+
+// kvState is the state of a key
+type kvState struct {
+	value  string
+	exists bool
+}
+
+func (s kvState) is(v *string) bool {
+	if v == nil {
+		return !s.exists
+	}
+	return s.exists && s.value == *v
+}
+
+// KVModel returns the model of a key value store supporting get, set and cas.  Each key
+// is a register checked independently.  Inputs are KVInput and outputs *KVOutput.
+func KVModel() Model {
+	return Model{
+		Partition: partitionKeys,
+		Init: func() interface{} {
+			return kvState{}
+		},
+		Step: func(state, input, output interface{}) (bool, interface{}) {
+			st := state.(kvState)
+			in := input.(KVInput)
+			out, _ := output.(*KVOutput)
+
+			switch in.Op {
+			case KVGet:
+				return out == nil || st.is(out.Value), st
+			case KVSet:
+				return true, kvState{value: in.Value, exists: true}
+			case KVCas:
+				// A cas of unknown outcome only needs to be linearized if it set the key.
+				if out == nil || out.Ok {
+					if st.is(in.Old) {
+						return true, kvState{value: in.Value, exists: true}
+					}
+					return false, st
+				}
+				return !st.is(in.Old), st
+			}
+			return false, st
+		},
+	}
+}
+
+// partitionKeys splits the history by key keeping the order of the operations.
+func partitionKeys(history []Operation) [][]Operation {
+	var (
+		keys  []string
+		byKey = make(map[string][]Operation)
+	)
+	for _, op := range history {
+		k := op.Input.(KVInput).Key
+		if _, ok := byKey[k]; !ok {
+			keys = append(keys, k)
+		}
+		byKey[k] = append(byKey[k], op)
+	}
+
+	out := make([][]Operation, len(keys))
+	for i, k := range keys {
+		out[i] = byKey[k]
+	}
+	return out
+}
@@ -0,0 +1,304 @@
+// Package lincheck checks histories of operations on a concurrent object for
+// linearizability.  It implements the search of Wing & Gong with the state cache of Lowe
+// as used by Knossos and Porcupine.  A history is linearizable if every operation can be
+// ordered to take effect at a single point between its call and return such that the
+// order is legal for a sequential model of the object.
+package lincheck
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Forever is the return time of an operation whose outcome is unknown e.g. a write that
+// timed out.  It may take effect at any point after its call or not at all.
+const Forever int64 = math.MaxInt64
+
+// Operation is an operation of a history.
+type Operation struct {
+	Client int         `json:"client"`
+	Input  interface{} `json:"input"`
+	Output interface{} `json:"output"`
+	// Call and return times of the operation
+	Call   int64 `json:"call"`
+	Return int64 `json:"return"`
+}
+
+// Model is a sequential model of an object.
+type Model struct {
+	// Partition splits a history into histories that are checked independently e.g. per
+	// key.  The history is checked as a whole if nil.
+	Partition func(history []Operation) [][]Operation
+	// Init returns the initial state of the object.
+	Init func() interface{}
+	// Step returns whether the operation with the input and output is legal in the state
+	// and the state after it.  Output is nil for an operation whose outcome is unknown.
+	Step func(state, input, output interface{}) (bool, interface{})
+	// Equal returns whether two states are equal.  States are compared with == if nil.
+	Equal func(s1, s2 interface{}) bool
+}
+
+func (m *Model) equal(s1, s2 interface{}) bool {
+	if m.Equal != nil {
+		return m.Equal(s1, s2)
+	}
+	return s1 == s2
+}
+
+// Outcome is the outcome of a check
+type Outcome uint8
+
+const (
+	// Ok is a linearizable history
+	Ok Outcome = iota
+	// Illegal is a history that is not linearizable
+	Illegal
+	// Unknown is a history that could not be checked within the timeout
+	Unknown
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Ok:
+		return "ok"
+	case Illegal:
+		return "illegal"
+	}
+	return "unknown"
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (o Outcome) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+// Result is the result of a check.
+type Result struct {
+	Outcome Outcome `json:"outcome"`
+	// Operations of the first partition that is not linearizable or could not be checked
+	Ops []Operation `json:"ops,omitempty"`
+	// Longest linearization of Ops found as indices into Ops
+	Linearized []int `json:"linearized,omitempty"`
+}
+
+// Check checks whether the history is linearizable under the model.  It gives up with
+// Unknown once the timeout has elapsed.  A zero timeout never gives up.
+func Check(m Model, history []Operation, timeout time.Duration) *Result {
+	parts := [][]Operation{history}
+	if m.Partition != nil {
+		parts = m.Partition(history)
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	var unknown *Result
+	for _, ops := range parts {
+		out, lin := checkOps(&m, ops, deadline)
+		switch out {
+		case Illegal:
+			return &Result{Outcome: Illegal, Ops: ops, Linearized: lin}
+		case Unknown:
+			if unknown == nil {
+				unknown = &Result{Outcome: Unknown, Ops: ops, Linearized: lin}
+			}
+		}
+	}
+
+	if unknown != nil {
+		return unknown
+	}
+	return &Result{Outcome: Ok}
+}
+
+// entry is a call or return of an operation in the doubly linked list searched.
+type entry struct {
+	id     int
+	call   bool
+	time   int64
+	match  *entry // return entry of a call
+	output interface{}
+	input  interface{}
+	prev   *entry
+	next   *entry
+}
+
+// makeEntries returns the head of the list of the call and return entries of the
+// operations ordered by time.  Calls are ordered before returns at the same time so the
+// operations are taken as concurrent.
+func makeEntries(ops []Operation) *entry {
+	entries := make([]*entry, 0, 2*len(ops))
+	for i, op := range ops {
+		ret := &entry{id: i, time: op.Return, output: op.Output}
+		call := &entry{id: i, call: true, time: op.Call, match: ret, input: op.Input, output: op.Output}
+		entries = append(entries, call, ret)
+	}
+	sort.Stable(byTime(entries))
+
+	head := &entry{id: -1}
+	prev := head
+	for _, e := range entries {
+		e.prev = prev
+		prev.next = e
+		prev = e
+	}
+	return head
+}
+
+type byTime []*entry
+
+func (b byTime) Len() int      { return len(b) }
+func (b byTime) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byTime) Less(i, j int) bool {
+	if b[i].time != b[j].time {
+		return b[i].time < b[j].time
+	}
+	return b[i].call && !b[j].call
+}
+
+// lift removes the call entry and its return from the list.
+func lift(e *entry) {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	m := e.match
+	m.prev.next = m.next
+	if m.next != nil {
+		m.next.prev = m.prev
+	}
+}
+
+// unlift puts back a lifted call entry and its return.
+func unlift(e *entry) {
+	m := e.match
+	m.prev.next = m
+	if m.next != nil {
+		m.next.prev = m
+	}
+	e.prev.next = e
+	e.next.prev = e
+}
+
+// bitset is the set of operations linearized so far
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) set(i int)   { b[i/64] |= 1 << uint(i%64) }
+func (b bitset) clear(i int) { b[i/64] &^= 1 << uint(i%64) }
+
+func (b bitset) clone() bitset {
+	return append(bitset(nil), b...)
+}
+
+func (b bitset) equal(o bitset) bool {
+	for i := range b {
+		if b[i] != o[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (b bitset) hash() uint64 {
+	// FNV-1a over the words
+	h := uint64(14695981039346656037)
+	for _, w := range b {
+		h ^= w
+		h *= 1099511628211
+	}
+	return h
+}
+
+type cacheEntry struct {
+	linearized bitset
+	state      interface{}
+}
+
+type frame struct {
+	entry *entry
+	state interface{}
+}
+
+// interval in iterations at which the deadline is checked
+const deadlineCheckInterval = 1 << 12
+
+// checkOps searches for a linearization of the operations returning the outcome and the
+// longest linearization found.
+func checkOps(m *Model, ops []Operation, deadline time.Time) (Outcome, []int) {
+	head := makeEntries(ops)
+	linearized := newBitset(len(ops))
+	cache := make(map[uint64][]cacheEntry)
+	state := m.Init()
+
+	var (
+		stack   []frame
+		longest []int
+	)
+
+	cached := func(lin bitset, st interface{}) bool {
+		for _, ce := range cache[lin.hash()] {
+			if ce.linearized.equal(lin) && m.equal(ce.state, st) {
+				return true
+			}
+		}
+		return false
+	}
+
+	e := head.next
+	for i := 0; head.next != nil; i++ {
+		if i%deadlineCheckInterval == 0 && !deadline.IsZero() && time.Now().After(deadline) {
+			return Unknown, longest
+		}
+
+		if e.call {
+			ok, next := m.Step(state, e.input, e.output)
+			if ok {
+				lin := linearized.clone()
+				lin.set(e.id)
+				if !cached(lin, next) {
+					h := lin.hash()
+					cache[h] = append(cache[h], cacheEntry{linearized: lin, state: next})
+
+					stack = append(stack, frame{entry: e, state: state})
+					if len(stack) > len(longest) {
+						longest = longest[:0]
+						for _, f := range stack {
+							longest = append(longest, f.entry.id)
+						}
+					}
+
+					state = next
+					linearized.set(e.id)
+					lift(e)
+					e = head.next
+					continue
+				}
+			}
+			e = e.next
+			continue
+		}
+
+		// Only operations of unknown outcome remain which need not take effect.
+		if e.time == Forever {
+			break
+		}
+
+		// The operation returned before it could be linearized so undo the last one.
+		if len(stack) == 0 {
+			return Illegal, longest
+		}
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		state = f.state
+		linearized.clear(f.entry.id)
+		unlift(f.entry)
+		e = f.entry.next
+	}
+
+	return Ok, nil
+}
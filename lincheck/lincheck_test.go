@@ -0,0 +1,159 @@
+package lincheck
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func str(s string) *string {
+	return &s
+}
+
+func get(client int, key string, value *string, call, ret int64) Operation {
+	return Operation{Client: client, Input: KVInput{Op: KVGet, Key: key}, Output: &KVOutput{Value: value}, Call: call, Return: ret}
+}
+
+func set(client int, key, value string, call, ret int64) Operation {
+	op := Operation{Client: client, Input: KVInput{Op: KVSet, Key: key, Value: value}, Call: call, Return: ret}
+	if ret != Forever {
+		op.Output = &KVOutput{}
+	}
+	return op
+}
+
+func cas(client int, key string, old *string, value string, ok bool, call, ret int64) Operation {
+	op := Operation{Client: client, Input: KVInput{Op: KVCas, Key: key, Old: old, Value: value}, Call: call, Return: ret}
+	if ret != Forever {
+		op.Output = &KVOutput{Ok: ok}
+	}
+	return op
+}
+
+func TestCheckKV(t *testing.T) {
+	cases := []struct {
+		name    string
+		history []Operation
+		outcome Outcome
+	}{
+		{"sequential", []Operation{
+			get(0, "k", nil, 0, 1),
+			set(0, "k", "a", 2, 3),
+			get(1, "k", str("a"), 4, 5),
+			cas(1, "k", str("a"), "b", true, 6, 7),
+			cas(0, "k", str("a"), "c", false, 8, 9),
+			get(0, "k", str("b"), 10, 11),
+		}, Ok},
+		{"concurrent read", []Operation{
+			set(0, "k", "a", 0, 10),
+			get(1, "k", str("a"), 1, 2),
+			get(2, "k", nil, 3, 4),
+		}, Illegal},
+		{"concurrent reads", []Operation{
+			set(0, "k", "a", 0, 10),
+			get(1, "k", nil, 1, 2),
+			get(2, "k", str("a"), 3, 4),
+			get(1, "k", str("a"), 11, 12),
+		}, Ok},
+		{"stale read", []Operation{
+			set(0, "k", "a", 0, 1),
+			set(0, "k", "b", 2, 3),
+			get(1, "k", str("a"), 4, 5),
+		}, Illegal},
+		{"lost read", []Operation{
+			set(0, "k", "a", 0, 1),
+			get(1, "k", nil, 2, 3),
+		}, Illegal},
+		{"double cas", []Operation{
+			cas(0, "k", nil, "a", true, 0, 10),
+			cas(1, "k", nil, "b", true, 1, 11),
+		}, Illegal},
+		{"single cas", []Operation{
+			cas(0, "k", nil, "a", true, 0, 10),
+			cas(1, "k", nil, "b", false, 1, 11),
+			get(2, "k", str("a"), 12, 13),
+		}, Ok},
+		{"failed cas", []Operation{
+			cas(0, "k", nil, "a", false, 0, 1),
+		}, Illegal},
+		{"unknown set applied", []Operation{
+			set(0, "k", "a", 0, Forever),
+			get(1, "k", nil, 5, 6),
+			get(1, "k", str("a"), 10, 11),
+		}, Ok},
+		{"unknown set not applied", []Operation{
+			set(0, "k", "a", 0, Forever),
+			get(1, "k", nil, 5, 6),
+		}, Ok},
+		{"unknown set reverted", []Operation{
+			set(0, "k", "a", 0, Forever),
+			get(1, "k", str("a"), 5, 6),
+			get(1, "k", nil, 10, 11),
+		}, Illegal},
+		{"unknown cas", []Operation{
+			set(0, "k", "a", 0, 1),
+			cas(0, "k", str("b"), "c", false, 2, Forever),
+			get(1, "k", str("c"), 5, 6),
+		}, Illegal},
+		{"independent keys", []Operation{
+			set(0, "k1", "a", 0, 1),
+			set(1, "k2", "b", 0, 1),
+			get(0, "k1", str("a"), 2, 3),
+			get(1, "k2", str("b"), 2, 3),
+			get(0, "k1", nil, 4, 5),
+		}, Illegal},
+	}
+
+	for _, c := range cases {
+		res := Check(KVModel(), c.history, 0)
+		if res.Outcome != c.outcome {
+			t.Errorf("%s: want=%s have=%s", c.name, c.outcome, res.Outcome)
+		}
+	}
+}
+
+func TestCheckResult(t *testing.T) {
+	history := []Operation{
+		set(0, "k1", "a", 0, 1),
+		set(0, "k2", "a", 0, 1),
+		set(1, "k2", "b", 2, 3),
+		get(2, "k2", str("a"), 4, 5),
+	}
+
+	res := Check(KVModel(), history, 0)
+	if res.Outcome != Illegal {
+		t.Fatal("should be illegal", res.Outcome)
+	}
+	if len(res.Ops) != 3 || res.Ops[0].Input.(KVInput).Key != "k2" {
+		t.Fatal("should return the illegal partition", res.Ops)
+	}
+	if len(res.Linearized) != 2 || res.Linearized[0] != 0 || res.Linearized[1] != 1 {
+		t.Fatal("wrong linearization", res.Linearized)
+	}
+
+	b, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Outcome string
+	}
+	json.Unmarshal(b, &out)
+	if out.Outcome != "illegal" {
+		t.Fatal("wrong outcome", string(b))
+	}
+}
+
+func TestCheckLarge(t *testing.T) {
+	// Many overlapping writes of distinct values read back at the end
+	var history []Operation
+	for i := 0; i < 200; i++ {
+		c := i % 8
+		v := string(rune('a' + i%26))
+		history = append(history, set(c, "k", v+string(rune('0'+c)), int64(i), int64(i+8)))
+	}
+	history = append(history, get(0, "k", str("r7"), 300, 301))
+
+	if res := Check(KVModel(), history, 0); res.Outcome != Ok {
+		t.Fatal("should be linearizable", res.Outcome)
+	}
+}
@@ -1,55 +1,43 @@
 package difuse
 
-import "fmt"
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+
+	chord "github.com/ipkg/go-chord"
+)
 
 // LocateLastTx locates all last transactions for the given key from each vnode.
-func (s *Difuse) LocateLastTx(key []byte) ([]*VnodeResponse, error) {
+// Vnodes that could not be reached are included with their error.
+func (s *Difuse) LocateLastTx(ctx context.Context, key []byte) ([]*VnodeResponse, error) {
 	vs, err := s.ring.Lookup(s.config.Chord.NumSuccessors, key)
 	if err != nil {
 		return nil, err
 	}
 
-	vbh := vnodesByHost(vs)
-	out := []*VnodeResponse{}
-
-	for h, v := range vbh {
-		ltx, er := s.transport.LastTx(key, &RequestOptions{Consistency: ConsistencyAll}, v...)
-		if er != nil {
-			err = er
-			continue
-		}
-
-		for i, t := range ltx {
-			ltx[i].Id = []byte(fmt.Sprintf("%s/%x", h, t.Id))
-		}
-
-		out = append(out, ltx...)
+	out, _ := fanout(ctx, vs, fanoutAll, func(ctx context.Context, vns ...*chord.Vnode) ([]*VnodeResponse, error) {
+		return s.transport.LastTx(ctx, key, &RequestOptions{Consistency: ConsistencyAll}, vns...)
+	})
+	for i, vn := range vs {
+		out[i].Id = []byte(fmt.Sprintf("%s/%x", vn.Host, vn.Id))
 	}
-	return out, err
+	return out, nil
 }
 
-// LocateInode locates all inodes for the given key from each vnode.
-func (s *Difuse) LocateInode(key []byte) ([]*VnodeResponse, error) {
+// LocateInode locates all inodes for the given key from each vnode.  Vnodes that
+// could not be reached are included with their error.
+func (s *Difuse) LocateInode(ctx context.Context, key []byte) ([]*VnodeResponse, error) {
 	vs, err := s.ring.Lookup(s.config.Chord.NumSuccessors, key)
 	if err != nil {
 		return nil, err
 	}
 
-	vbh := vnodesByHost(vs)
-	out := []*VnodeResponse{}
-
-	for h, v := range vbh {
-		ltx, er := s.transport.Stat(key, &RequestOptions{Consistency: ConsistencyAll}, v...)
-		if er != nil {
-			err = er
-			continue
-		}
-
-		for i, t := range ltx {
-			ltx[i].Id = []byte(fmt.Sprintf("%s/%x", h, t.Id))
-		}
-
-		out = append(out, ltx...)
+	out, _ := fanout(ctx, vs, fanoutAll, func(ctx context.Context, vns ...*chord.Vnode) ([]*VnodeResponse, error) {
+		return s.transport.Stat(ctx, key, &RequestOptions{Consistency: ConsistencyAll}, vns...)
+	})
+	for i, vn := range vs {
+		out[i].Id = []byte(fmt.Sprintf("%s/%x", vn.Host, vn.Id))
 	}
-	return out, err
+	return out, nil
 }
@@ -0,0 +1,476 @@
+package difuse
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"golang.org/x/net/context"
+
+	chord "github.com/ipkg/go-chord"
+
+	"github.com/ipkg/difuse/erasure"
+	"github.com/ipkg/difuse/gentypes"
+	"github.com/ipkg/difuse/store"
+	"github.com/ipkg/difuse/txlog"
+)
+
+var errHostUnreachable = errors.New("host unreachable")
+
+// MemNetwork connects the memory transports of nodes running in a single process.
+type MemNetwork struct {
+	mu    sync.RWMutex
+	hosts map[string]*MemTransport
+}
+
+// NewMemNetwork instantiates an empty network.
+func NewMemNetwork() *MemNetwork {
+	return &MemNetwork{hosts: make(map[string]*MemTransport)}
+}
+
+// Transport returns a new transport for the host attached to the network.  It replaces
+// any transport previously attached for the host.
+func (n *MemNetwork) Transport(host string) *MemTransport {
+	t := &MemTransport{host: host, net: n, local: make(localStore)}
+
+	n.mu.Lock()
+	n.hosts[host] = t
+	n.mu.Unlock()
+
+	return t
+}
+
+// Remove detaches the transport of the host from the network.  Requests to and from the
+// host fail from then on.
+func (n *MemNetwork) Remove(host string) {
+	n.mu.Lock()
+	delete(n.hosts, host)
+	n.mu.Unlock()
+}
+
+// MemTransport is an in-process transport calling the stores of the other nodes attached
+// to the network directly.  Requests and responses are passed through the same encoding
+// as the net rpc transport so data is never shared between nodes and errors arrive as
+// they would over the network.
+type MemTransport struct {
+	host string
+	net  *MemNetwork
+
+	lock  sync.Mutex
+	local localStore
+
+	cs    ConsistentStore     // consistent storage interface
+	replq chan<- *ReplRequest // q to send replication requests to
+}
+
+// remote returns the transport of the host if both it and this transport are attached to
+// the network.
+func (t *MemTransport) remote(ctx context.Context, host string) (*MemTransport, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	t.net.mu.RLock()
+	defer t.net.mu.RUnlock()
+
+	r, ok := t.net.hosts[host]
+	if !ok || t.net.hosts[t.host] != t {
+		return nil, errHostUnreachable
+	}
+	return r, nil
+}
+
+// remoteError returns the error as received from a remote node.  Only the message and
+// code cross the wire.
+func remoteError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: ErrorCodeOf(err), Msg: err.Error()}
+}
+
+// copyInode returns a copy of the inode as received by a remote node.
+func copyInode(inode *store.Inode) *store.Inode {
+	fb := flatbuffers.NewBuilder(0)
+	fb.Finish(inode.Serialize(fb))
+
+	out := &store.Inode{}
+	out.Deserialize(gentypes.GetRootAsInode(fb.Bytes[fb.Head():], 0))
+	return out
+}
+
+// copyTx returns a copy of the tx as received by a remote node.
+func copyTx(tx *txlog.Tx) *txlog.Tx {
+	fb := flatbuffers.NewBuilder(0)
+	fb.Finish(serializeTx(fb, tx))
+	return deserializeTx(gentypes.GetRootAsTx(fb.Bytes[fb.Head():], 0))
+}
+
+// copyResponseMeta returns the meta of an inode write as received by a remote node.
+func copyResponseMeta(meta *ResponseMeta, err error) (*ResponseMeta, error) {
+	out := &ResponseMeta{}
+	if meta != nil {
+		out.Token = append([]byte(nil), meta.Token...)
+		if meta.Vnode != nil {
+			out.Vnode = &chord.Vnode{Id: append([]byte(nil), meta.Vnode.Id...), Host: meta.Vnode.Host}
+		}
+	}
+	return out, remoteError(err)
+}
+
+// SetInode sets the given inode on the host returning the leader for the inode and commit
+// token or error
+func (t *MemTransport) SetInode(ctx context.Context, host string, inode *store.Inode, options *RequestOptions) (*ResponseMeta, error) {
+	r, err := t.remote(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	return copyResponseMeta(r.cs.SetInode(ctx, copyInode(inode), options))
+}
+
+// DeleteInode deletes the given inode on the host returning the leader for the inode and
+// commit token or error
+func (t *MemTransport) DeleteInode(ctx context.Context, host string, inode *store.Inode, options *RequestOptions) (*ResponseMeta, error) {
+	r, err := t.remote(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	return copyResponseMeta(r.cs.DeleteInode(ctx, copyInode(inode), options))
+}
+
+// Stat makes a stat request to the provided vnodes of a single host.
+func (t *MemTransport) Stat(ctx context.Context, key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	r, err := t.remote(ctx, vs[0].Host)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, _ := r.local.Stat(ctx, key, options, vs...)
+	return deserializeVnodeIdInodeErrList(serializeVnodeIdInodeErrList(rsp)), nil
+}
+
+// SetBlock sets the block on the provided vnodes of a single host.
+func (t *MemTransport) SetBlock(ctx context.Context, blkdata []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	r, err := t.remote(ctx, vs[0].Host)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, _ := r.local.SetBlock(append([]byte(nil), blkdata...), nil, vs...)
+	return deserializeVnodeIdBytesErrList(serializeVnodeIdBytesErrList(rsp)), nil
+}
+
+// GetBlock gets the block from the provided vnodes of a single host.
+func (t *MemTransport) GetBlock(ctx context.Context, key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	r, err := t.remote(ctx, vs[0].Host)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, _ := r.local.GetBlock(key, nil, vs...)
+	return deserializeVnodeIdBytesErrList(serializeVnodeIdBytesErrList(rsp)), nil
+}
+
+// DeleteBlock deletes the block from the provided vnodes of a single host.
+func (t *MemTransport) DeleteBlock(ctx context.Context, key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	r, err := t.remote(ctx, vs[0].Host)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, _ := r.local.DeleteBlock(key, nil, vs...)
+	return deserializeVnodeIdBytesErrList(serializeVnodeIdBytesErrList(rsp)), nil
+}
+
+// MerkleRootTx returns the merkle root of the key's transactions from the provided vnodes
+// of a single host.
+func (t *MemTransport) MerkleRootTx(ctx context.Context, key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	r, err := t.remote(ctx, vs[0].Host)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, _ := r.local.MerkleRootTx(key, nil, vs...)
+	return deserializeVnodeIdBytesErrList(serializeVnodeIdBytesErrList(rsp)), nil
+}
+
+// ProofTx returns the inclusion proofs of the tx from the provided vnodes of a single
+// host.
+func (t *MemTransport) ProofTx(ctx context.Context, key, txhash []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	r, err := t.remote(ctx, vs[0].Host)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, _ := r.local.ProofTx(key, txhash, nil, vs...)
+	return deserializeVnodeProofList(serializeVnodeProofList(rsp)), nil
+}
+
+// AppendTx appends the tx to the provided vnodes of a single host.
+func (t *MemTransport) AppendTx(ctx context.Context, tx *txlog.Tx, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	r, err := t.remote(ctx, vs[0].Host)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, _ := r.local.AppendTx(ctx, copyTx(tx), options, vs...)
+	return deserializeVnodeIdBytesErrList(serializeVnodeIdBytesErrList(rsp)), nil
+}
+
+// AppendTxs appends the txs of each batch to its vnode on a single host.
+func (t *MemTransport) AppendTxs(ctx context.Context, batches []*VnodeTxs, options *RequestOptions) ([]*VnodeResponse, error) {
+	r, err := t.remote(ctx, batches[0].Vnode.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, _ := r.local.AppendTxs(ctx, deserializeVnodeTxsList(serializeVnodeTxsList(batches)), options)
+	return deserializeVnodeIdBytesErrList(serializeVnodeIdBytesErrList(rsp)), nil
+}
+
+// ReplicateTransactions replicates transactions from the remote vnode to the local one.
+func (t *MemTransport) ReplicateTransactions(ctx context.Context, key, seek []byte, remote, local *chord.Vnode) error {
+	st, err := t.local.GetStore(local.Id)
+	if err != nil {
+		return err
+	}
+
+	txs, err := t.Transactions(ctx, key, seek, remote)
+	for _, tx := range txs {
+		if e := st.ReplicateTx(tx); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Transactions returns the transactions for the key from the remote vnode starting at the
+// seek hash.
+func (t *MemTransport) Transactions(ctx context.Context, key, seek []byte, vn *chord.Vnode) (txlog.TxSlice, error) {
+	r, err := t.remote(ctx, vn.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := r.local.readStore(vn.Id)
+	if err != nil {
+		return nil, remoteError(err)
+	}
+	txs, err := st.Transactions(key, seek)
+	if err != nil {
+		return nil, remoteError(err)
+	}
+
+	out := make(txlog.TxSlice, len(txs))
+	for i, tx := range txs {
+		out[i] = copyTx(tx)
+	}
+	return out, nil
+}
+
+// GetTx gets the tx from the provided vnodes of a single host.
+func (t *MemTransport) GetTx(ctx context.Context, key, txhash []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	r, err := t.remote(ctx, vs[0].Host)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, _ := r.local.GetTx(key, txhash, nil, vs...)
+	return deserializeVnodeIdTxErrList(serializeVnodeIdTxErrList(rsp)), nil
+}
+
+// LastTx gets the last tx of the key from the provided vnodes of a single host.
+func (t *MemTransport) LastTx(ctx context.Context, key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	r, err := t.remote(ctx, vs[0].Host)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, _ := r.local.LastTx(key, nil, vs...)
+	return deserializeVnodeIdTxErrList(serializeVnodeIdTxErrList(rsp)), nil
+}
+
+// NewTx is a stub to satisfy the Transport interface as transactions cannot be created
+// remotely
+func (t *MemTransport) NewTx(key []byte, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	return nil, fmt.Errorf("cannot create new transactions remotely")
+}
+
+// LookupLeader looks up the leader for a key on the given host
+func (t *MemTransport) LookupLeader(ctx context.Context, host string, key []byte) (*chord.Vnode, []*chord.Vnode, map[string][]*chord.Vnode, error) {
+	r, err := t.remote(ctx, host)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	l, vl, _, err := r.cs.LookupLeader(ctx, key)
+	if err != nil {
+		return nil, nil, nil, remoteError(err)
+	}
+	return l, vl, vnodesByHost(vl), nil
+}
+
+// BatchGet gets the keys of the entries on the given host.
+func (t *MemTransport) BatchGet(ctx context.Context, host string, entries []*BatchEntry, options *RequestOptions) ([]*BatchEntry, error) {
+	r, err := t.remote(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := batchGet(ctx, r.cs, deserializeBatchEntryList(serializeBatchEntryList(entries)), options)
+	return deserializeBatchEntryList(serializeBatchEntryList(rsp)), nil
+}
+
+// BatchSet sets the entries on the given host.
+func (t *MemTransport) BatchSet(ctx context.Context, host string, entries []*BatchEntry, options *RequestOptions) ([]*BatchEntry, error) {
+	r, err := t.remote(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := r.cs.SetBatch(ctx, deserializeBatchEntryList(serializeBatchEntryList(entries)), options)
+	return deserializeBatchEntryList(serializeBatchEntryList(rsp)), nil
+}
+
+// Snapshot returns the snapshot of the range from the remote vnode.
+func (t *MemTransport) Snapshot(ctx context.Context, src, rng *chord.Vnode) (io.ReadCloser, error) {
+	r, err := t.remote(ctx, src.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := r.local.Snapshot(src, r.cs.RangeFilter(rng))
+	if err != nil {
+		return nil, remoteError(err)
+	}
+	return rc, nil
+}
+
+// TransferKeys queues the replication of each key of the local vnode to the remote one.
+func (t *MemTransport) TransferKeys(ctx context.Context, local, remote *chord.Vnode) error {
+	return t.transferKeys(ctx, local, remote, nil)
+}
+
+// transferKeys transfers the keys of the local vnode selected by the filter to the remote
+// vnode.  All keys are transferred if the filter is nil.
+func (t *MemTransport) transferKeys(ctx context.Context, local, remote *chord.Vnode, filter func([]byte, *store.Inode) bool) error {
+	st, err := t.local.GetStore(local.Id)
+	if err != nil {
+		return err
+	}
+	r, err := t.remote(ctx, remote.Host)
+	if err != nil {
+		return err
+	}
+
+	// Collect the keys first as the replication engine reads from the store
+	var keys [][]byte
+	st.IterInodes(func(key []byte, inode *store.Inode) error {
+		if filter == nil || filter(key, inode) {
+			keys = append(keys, append([]byte(nil), key...))
+		}
+		return nil
+	})
+
+	for _, key := range keys {
+		r.replq <- &ReplRequest{Src: local, Dst: remote, Key: key}
+	}
+
+	log.Printf("action=transfer entity=tx status=ok count=%d src=%s dst=%s", len(keys), shortID(local), shortID(remote))
+	return nil
+}
+
+// Bootstrap restores a snapshot of the data selected by the filter from the local vnode
+// into the new remote vnode.  Keys whose transactions changed after the snapshot was
+// taken are then transferred as with TransferKeys.
+func (t *MemTransport) Bootstrap(ctx context.Context, local, remote *chord.Vnode, filter *store.SnapshotFilter) error {
+	st, err := t.local.GetStore(local.Id)
+	if err != nil {
+		return err
+	}
+	r, err := t.remote(ctx, remote.Host)
+	if err != nil {
+		return err
+	}
+	dst, err := r.local.GetStore(remote.Id)
+	if err != nil {
+		return remoteError(err)
+	}
+	if !r.cs.IsSuccessor(remote, local) {
+		return remoteError(errNotSuccessor)
+	}
+
+	roots := bootstrapRoots(st, filter)
+	rc, err := st.FilteredSnapshot(filter)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if err = restoreBootstrap(dst, rc); err != nil {
+		return remoteError(err)
+	}
+	log.Printf("action=bootstrap status=ok keys=%d src=%s dst=%s", len(roots), shortID(local), shortID(remote))
+
+	// Catch up the keys written to while bootstrapping
+	return t.transferKeys(ctx, local, remote, catchupFilter(roots, filter))
+}
+
+// ReplicateBlocks copies all blocks from the local vnode to the remote vnode.
+func (t *MemTransport) ReplicateBlocks(ctx context.Context, local, remote *chord.Vnode) error {
+	st, err := t.local.GetStore(local.Id)
+	if err != nil {
+		return err
+	}
+	r, err := t.remote(ctx, remote.Host)
+	if err != nil {
+		return err
+	}
+	dst, err := r.local.GetStore(remote.Id)
+	if err != nil {
+		return remoteError(err)
+	}
+
+	// The last error is returned as with the net rpc transport
+	var serr error
+	cnt := 0
+	st.IterBlocks(func(h []byte, data []byte) error {
+		// Shards are placed on specific hosts and regenerated by repair rather than
+		// copied
+		if erasure.IsShard(data) {
+			return nil
+		}
+		cnt++
+		if _, e := dst.SetBlock(append([]byte(nil), data...)); e != nil {
+			serr = remoteError(e)
+		}
+		return nil
+	})
+	if serr != nil {
+		return serr
+	}
+
+	log.Printf("action=replicate entity=blocks status=ok count=%d src=%s dst=%s", cnt, shortID(local), shortID(remote))
+	return nil
+}
+
+// RegisterVnode registers a store to a vnode.
+func (t *MemTransport) RegisterVnode(vn *chord.Vnode, store VnodeStore) {
+	key := vn.String()
+	t.lock.Lock()
+	t.local[key] = store
+	t.lock.Unlock()
+}
+
+// Register registers a consistent store to the transport
+func (t *MemTransport) Register(cs ConsistentStore) {
+	t.cs = cs
+}
+
+// RegisterReplicationQ registers the replication channel to the transport.
+func (t *MemTransport) RegisterReplicationQ(rq chan<- *ReplRequest) {
+	t.replq = rq
+}
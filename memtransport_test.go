@@ -0,0 +1,52 @@
+package difuse
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+
+	chord "github.com/ipkg/go-chord"
+
+	"github.com/ipkg/difuse/store"
+	"github.com/ipkg/difuse/txlog"
+)
+
+func TestMemTransport(t *testing.T) {
+	kp, _ := txlog.GenerateECDSAKeypair()
+	mn := NewMemNetwork()
+	t1 := mn.Transport("host-1")
+	t2 := mn.Transport("host-2")
+
+	vn := &chord.Vnode{Id: []byte("vnode-id"), Host: "host-2"}
+	st := store.NewMemLoggedStore(vn, kp)
+	t2.RegisterVnode(vn, st)
+
+	tx, _ := st.NewTx([]byte("key"))
+	tx.Data = []byte{0xff}
+	tx.Sign(kp)
+	rsp, err := t1.AppendTx(context.Background(), tx, &RequestOptions{WaitApply: true}, vn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp[0].Err == nil {
+		t.Fatal("should fail to apply an invalid tx")
+	}
+
+	txs, err := t1.Transactions(context.Background(), []byte("key"), nil, vn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 1 || txs[0] == tx || !txlog.EqualBytes(txs[0].Hash(), tx.Hash()) {
+		t.Fatal("should return a copy of the tx")
+	}
+
+	rsp, _ = t1.Stat(context.Background(), []byte("missing"), nil, vn)
+	if ErrorCodeOf(rsp[0].Err) != CodeNotFound {
+		t.Fatal("should be not found", rsp[0].Err)
+	}
+
+	mn.Remove("host-2")
+	if _, err = t1.Transactions(context.Background(), []byte("key"), nil, vn); ErrorCodeOf(err) != CodeUnavailable {
+		t.Fatal("host should be unreachable", err)
+	}
+}
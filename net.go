@@ -1,17 +1,23 @@
 package difuse
 
 import (
+	"encoding/hex"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"strconv"
 	"sync"
 
 	flatbuffers "github.com/google/flatbuffers/go"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
 
 	chord "github.com/ipkg/go-chord"
 
+	"github.com/ipkg/difuse/erasure"
 	"github.com/ipkg/difuse/gentypes"
 	"github.com/ipkg/difuse/netrpc"
 	"github.com/ipkg/difuse/store"
@@ -33,19 +39,35 @@ type NetTransport struct {
 	clock sync.RWMutex        // outbound connection lock
 	out   map[string]*outConn // outbound connections
 	replq chan<- *ReplRequest // q to send replication requests to
+
+	certs    *CertReloader   // tls certificates.  nil for plaintext
+	timeouts *NetTimeouts    // dial and rpc timeouts
+	chord    *ChordTransport // chord rpc's served for local vnodes
 }
 
-// NewNetTransport instantiates a new network transport.
+// NewNetTransport instantiates a new network transport using the default timeouts.
 func NewNetTransport() *NetTransport {
 	return &NetTransport{
-		local: make(localStore),
-		out:   make(map[string]*outConn),
+		local:    make(localStore),
+		out:      make(map[string]*outConn),
+		timeouts: DefaultNetTimeouts(),
 	}
 }
 
-// SetInode sets the given inode returning the leader for the inode and error
-func (t *NetTransport) SetInode(host string, inode *store.Inode, options *RequestOptions) (*chord.Vnode, error) {
-	out, err := t.getConn(host)
+// SetTimeouts sets the timeouts for dialing peers and unary rpcs.  They apply to requests
+// whose context has no earlier deadline.  Streams are only bound by their context.
+func (t *NetTransport) SetTimeouts(timeouts *NetTimeouts) {
+	t.timeouts = timeouts
+}
+
+// UseTLS sets the transport to dial peers using mutual TLS with the certificates.
+func (t *NetTransport) UseTLS(certs *CertReloader) {
+	t.certs = certs
+}
+
+// SetInode sets the given inode returning the leader for the inode and commit token or error
+func (t *NetTransport) SetInode(ctx context.Context, host string, inode *store.Inode, options *RequestOptions) (*ResponseMeta, error) {
+	out, err := t.getConn(ctx, host)
 	if err != nil {
 		return nil, err
 	}
@@ -55,18 +77,22 @@ func (t *NetTransport) SetInode(host string, inode *store.Inode, options *Reques
 	fb.Finish(ofs)
 	payload := &chord.Payload{Data: fb.Bytes[fb.Head():]}
 
-	resp, err := out.client.SetInodeServe(context.Background(), payload)
+	rctx, cancel := t.rpcContext(ctx)
+	defer cancel()
+
+	var md metadata.MD
+	resp, err := out.client.SetInodeServe(optionsContext(rctx, options), payload, grpc.Header(&md))
 	if err != nil {
-		t.reapConn(out)
+		t.reapConn(rctx, out)
 		return nil, err
 	}
 
-	return chord.DeserializeVnodeErr(resp.Data)
+	return deserializeResponseMeta(resp.Data, md)
 }
 
-// DeleteInode deletes the given inode returning the leader for the inode and error
-func (t *NetTransport) DeleteInode(host string, inode *store.Inode, options *RequestOptions) (*chord.Vnode, error) {
-	out, err := t.getConn(host)
+// DeleteInode deletes the given inode returning the leader for the inode and commit token or error
+func (t *NetTransport) DeleteInode(ctx context.Context, host string, inode *store.Inode, options *RequestOptions) (*ResponseMeta, error) {
+	out, err := t.getConn(ctx, host)
 	if err != nil {
 		return nil, err
 	}
@@ -76,19 +102,23 @@ func (t *NetTransport) DeleteInode(host string, inode *store.Inode, options *Req
 	fb.Finish(ofs)
 	payload := &chord.Payload{Data: fb.Bytes[fb.Head():]}
 
-	resp, err := out.client.DeleteInodeServe(context.Background(), payload)
+	rctx, cancel := t.rpcContext(ctx)
+	defer cancel()
+
+	var md metadata.MD
+	resp, err := out.client.DeleteInodeServe(optionsContext(rctx, options), payload, grpc.Header(&md))
 	if err != nil {
-		t.reapConn(out)
+		t.reapConn(rctx, out)
 		return nil, err
 	}
 
-	return chord.DeserializeVnodeErr(resp.Data)
+	return deserializeResponseMeta(resp.Data, md)
 }
 
 // Stat makes a stat request to the provided vnodes.  All vnodes per request should be long to the same host.
 // This is to allow the same query to be run on multiple vnodes on a single host.
-func (t *NetTransport) Stat(key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
-	out, err := t.getConn(vs[0].Host)
+func (t *NetTransport) Stat(ctx context.Context, key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	out, err := t.getConn(ctx, vs[0].Host)
 	if err != nil {
 		return nil, err
 	}
@@ -96,9 +126,12 @@ func (t *NetTransport) Stat(key []byte, options *RequestOptions, vs ...*chord.Vn
 	data := serializeVnodeIdsBytes(key, vs)
 	payload := &chord.Payload{Data: data}
 
-	resp, err := out.client.StatServe(context.Background(), payload)
+	rctx, cancel := t.rpcContext(ctx)
+	defer cancel()
+
+	resp, err := out.client.StatServe(optionsContext(rctx, options), payload)
 	if err != nil {
-		t.reapConn(out)
+		t.reapConn(rctx, out)
 		return nil, err
 	}
 
@@ -107,9 +140,9 @@ func (t *NetTransport) Stat(key []byte, options *RequestOptions, vs ...*chord.Vn
 
 // SetBlock sets blocks on the remote vnodes.   All vnodes per request should be long to the same host.
 // This is to allow the same query to be run on multiple vnodes on a single host.
-func (t *NetTransport) SetBlock(blkdata []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+func (t *NetTransport) SetBlock(ctx context.Context, blkdata []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
 
-	out, err := t.getConn(vs[0].Host)
+	out, err := t.getConn(ctx, vs[0].Host)
 	if err != nil {
 		return nil, err
 	}
@@ -117,18 +150,21 @@ func (t *NetTransport) SetBlock(blkdata []byte, options *RequestOptions, vs ...*
 	data := serializeVnodeIdsBytes(blkdata, vs)
 	payload := &chord.Payload{Data: data}
 
-	resp, err := out.client.SetBlockServe(context.Background(), payload)
+	rctx, cancel := t.rpcContext(ctx)
+	defer cancel()
+
+	resp, err := out.client.SetBlockServe(rctx, payload)
 	if err != nil {
-		t.reapConn(out)
+		t.reapConn(rctx, out)
 		return nil, err
 	}
 
 	return deserializeVnodeIdBytesErrList(resp.Data), nil
 }
 
-func (t *NetTransport) GetBlock(key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+func (t *NetTransport) GetBlock(ctx context.Context, key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
 
-	out, err := t.getConn(vs[0].Host)
+	out, err := t.getConn(ctx, vs[0].Host)
 	if err != nil {
 		return nil, err
 	}
@@ -136,18 +172,21 @@ func (t *NetTransport) GetBlock(key []byte, options *RequestOptions, vs ...*chor
 	data := serializeVnodeIdsBytes(key, vs)
 	payload := &chord.Payload{Data: data}
 
-	resp, err := out.client.GetBlockServe(context.Background(), payload)
+	rctx, cancel := t.rpcContext(ctx)
+	defer cancel()
+
+	resp, err := out.client.GetBlockServe(rctx, payload)
 	if err != nil {
-		t.reapConn(out)
+		t.reapConn(rctx, out)
 		return nil, err
 	}
 
 	return deserializeVnodeIdBytesErrList(resp.Data), nil
 }
 
-func (t *NetTransport) DeleteBlock(key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+func (t *NetTransport) DeleteBlock(ctx context.Context, key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
 
-	out, err := t.getConn(vs[0].Host)
+	out, err := t.getConn(ctx, vs[0].Host)
 	if err != nil {
 		return nil, err
 	}
@@ -155,9 +194,12 @@ func (t *NetTransport) DeleteBlock(key []byte, options *RequestOptions, vs ...*c
 	data := serializeVnodeIdsBytes(key, vs)
 	payload := &chord.Payload{Data: data}
 
-	resp, err := out.client.DeleteBlockServe(context.Background(), payload)
+	rctx, cancel := t.rpcContext(ctx)
+	defer cancel()
+
+	resp, err := out.client.DeleteBlockServe(rctx, payload)
 	if err != nil {
-		t.reapConn(out)
+		t.reapConn(rctx, out)
 		return nil, err
 	}
 
@@ -165,9 +207,9 @@ func (t *NetTransport) DeleteBlock(key []byte, options *RequestOptions, vs ...*c
 }
 
 // MerkleRootTx requests the transaction merkle root for the key.
-func (t *NetTransport) MerkleRootTx(key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+func (t *NetTransport) MerkleRootTx(ctx context.Context, key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
 
-	out, err := t.getConn(vs[0].Host)
+	out, err := t.getConn(ctx, vs[0].Host)
 	if err != nil {
 		return nil, err
 	}
@@ -175,19 +217,44 @@ func (t *NetTransport) MerkleRootTx(key []byte, options *RequestOptions, vs ...*
 	data := serializeVnodeIdsBytes(key, vs)
 	payload := &chord.Payload{Data: data}
 
-	resp, err := out.client.MerkleRootTxServe(context.Background(), payload)
+	rctx, cancel := t.rpcContext(ctx)
+	defer cancel()
+
+	resp, err := out.client.MerkleRootTxServe(rctx, payload)
 	if err != nil {
-		t.reapConn(out)
+		t.reapConn(rctx, out)
 		return nil, err
 	}
 
 	return deserializeVnodeIdBytesErrList(resp.Data), nil
 }
 
+// ProofTx requests the inclusion proofs of the tx from the vnodes of a host.
+func (t *NetTransport) ProofTx(ctx context.Context, key, txhash []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	out, err := t.getConn(ctx, vs[0].Host)
+	if err != nil {
+		return nil, err
+	}
+
+	data := serializeVnodeIdsTwoByteSlices(key, txhash, vs)
+	payload := &chord.Payload{Data: data}
+
+	rctx, cancel := t.rpcContext(ctx)
+	defer cancel()
+
+	resp, err := out.client.ProofTxServe(rctx, payload)
+	if err != nil {
+		t.reapConn(rctx, out)
+		return nil, err
+	}
+
+	return deserializeVnodeProofList(resp.Data), nil
+}
+
 // AppendTx sends a append transaction request to all vnodes on a given host
-func (t *NetTransport) AppendTx(tx *txlog.Tx, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+func (t *NetTransport) AppendTx(ctx context.Context, tx *txlog.Tx, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
 
-	out, err := t.getConn(vs[0].Host)
+	out, err := t.getConn(ctx, vs[0].Host)
 	if err != nil {
 		return nil, err
 	}
@@ -195,9 +262,34 @@ func (t *NetTransport) AppendTx(tx *txlog.Tx, options *RequestOptions, vs ...*ch
 	data := serializeVnodeIdsTx(tx, vs)
 	payload := &chord.Payload{Data: data}
 
-	resp, err := out.client.AppendTxServe(context.Background(), payload)
+	rctx, cancel := t.rpcContext(ctx)
+	defer cancel()
+
+	resp, err := out.client.AppendTxServe(optionsContext(rctx, options), payload)
 	if err != nil {
-		t.reapConn(out)
+		t.reapConn(rctx, out)
+		return nil, err
+	}
+
+	return deserializeVnodeIdBytesErrList(resp.Data), nil
+}
+
+// AppendTxs sends the txs of each batch to its vnode in a single request to the host of
+// the vnodes
+func (t *NetTransport) AppendTxs(ctx context.Context, batches []*VnodeTxs, options *RequestOptions) ([]*VnodeResponse, error) {
+	out, err := t.getConn(ctx, batches[0].Vnode.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &chord.Payload{Data: serializeVnodeTxsList(batches)}
+
+	rctx, cancel := t.rpcContext(ctx)
+	defer cancel()
+
+	resp, err := out.client.AppendTxsServe(optionsContext(rctx, options), payload)
+	if err != nil {
+		t.reapConn(rctx, out)
 		return nil, err
 	}
 
@@ -205,13 +297,13 @@ func (t *NetTransport) AppendTx(tx *txlog.Tx, options *RequestOptions, vs ...*ch
 }
 
 // ReplicateTransactions replicates transactions from the remote vnode to the local one.
-func (t *NetTransport) ReplicateTransactions(key, seek []byte, remote, local *chord.Vnode) error {
+func (t *NetTransport) ReplicateTransactions(ctx context.Context, key, seek []byte, remote, local *chord.Vnode) error {
 	st, err := t.local.GetStore(local.Id)
 	if err != nil {
 		return err
 	}
 
-	out, err := t.getConn(remote.Host)
+	out, err := t.getConn(ctx, remote.Host)
 	if err != nil {
 		return err
 	}
@@ -221,9 +313,12 @@ func (t *NetTransport) ReplicateTransactions(key, seek []byte, remote, local *ch
 
 	req := &chord.Payload{Data: fb.Bytes[fb.Head():]}
 
-	stream, err := out.client.TransactionsServe(context.Background(), req)
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := out.client.TransactionsServe(sctx, req)
 	if err != nil {
-		t.reapConn(out)
+		t.reapConn(sctx, out)
 		return err
 	}
 
@@ -240,7 +335,7 @@ func (t *NetTransport) ReplicateTransactions(key, seek []byte, remote, local *ch
 		fbtx := gentypes.GetRootAsTx(payload.Data, 0)
 		tx := deserializeTx(fbtx)
 		// Append tx to local vnode
-		if e := st.AppendTx(tx); e != nil {
+		if e := st.ReplicateTx(tx); e != nil {
 			err = e
 		}
 	}
@@ -249,9 +344,47 @@ func (t *NetTransport) ReplicateTransactions(key, seek []byte, remote, local *ch
 	//return deserializeTxListErr(resp.Data)
 }
 
-func (t *NetTransport) GetTx(key, txhash []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+// Transactions returns the transactions for the key from the remote vnode starting at the
+// seek hash.
+func (t *NetTransport) Transactions(ctx context.Context, key, seek []byte, vn *chord.Vnode) (txlog.TxSlice, error) {
+	out, err := t.getConn(ctx, vn.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	fb := flatbuffers.NewBuilder(0)
+	fb.Finish(serializeTxRequest(fb, key, seek, vn))
+
+	req := &chord.Payload{Data: fb.Bytes[fb.Head():]}
+
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := out.client.TransactionsServe(sctx, req)
+	if err != nil {
+		t.reapConn(sctx, out)
+		return nil, err
+	}
+
+	txs := txlog.TxSlice{}
+	for {
+		payload, e := stream.Recv()
+		if e != nil {
+			if e != io.EOF {
+				err = e
+			}
+			break
+		}
+		fbtx := gentypes.GetRootAsTx(payload.Data, 0)
+		txs = append(txs, deserializeTx(fbtx))
+	}
+
+	return txs, err
+}
+
+func (t *NetTransport) GetTx(ctx context.Context, key, txhash []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
 
-	out, err := t.getConn(vs[0].Host)
+	out, err := t.getConn(ctx, vs[0].Host)
 	if err != nil {
 		return nil, err
 	}
@@ -259,17 +392,20 @@ func (t *NetTransport) GetTx(key, txhash []byte, options *RequestOptions, vs ...
 	data := serializeVnodeIdsTwoByteSlices(key, txhash, vs)
 	payload := &chord.Payload{Data: data}
 
-	resp, err := out.client.GetTxServe(context.Background(), payload)
+	rctx, cancel := t.rpcContext(ctx)
+	defer cancel()
+
+	resp, err := out.client.GetTxServe(rctx, payload)
 	if err != nil {
-		t.reapConn(out)
+		t.reapConn(rctx, out)
 		return nil, err
 	}
 
 	return deserializeVnodeIdTxErrList(resp.Data), nil
 }
 
-func (t *NetTransport) LastTx(key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
-	out, err := t.getConn(vs[0].Host)
+func (t *NetTransport) LastTx(ctx context.Context, key []byte, options *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	out, err := t.getConn(ctx, vs[0].Host)
 	if err != nil {
 		return nil, err
 	}
@@ -277,9 +413,12 @@ func (t *NetTransport) LastTx(key []byte, options *RequestOptions, vs ...*chord.
 	data := serializeVnodeIdsBytes(key, vs)
 	payload := &chord.Payload{Data: data}
 
-	resp, err := out.client.LastTxServe(context.Background(), payload)
+	rctx, cancel := t.rpcContext(ctx)
+	defer cancel()
+
+	resp, err := out.client.LastTxServe(rctx, payload)
 	if err != nil {
-		t.reapConn(out)
+		t.reapConn(rctx, out)
 		return nil, err
 	}
 
@@ -293,8 +432,8 @@ func (t *NetTransport) NewTx(key []byte, vs ...*chord.Vnode) ([]*VnodeResponse,
 }
 
 // LookupLeader looks up the leader for a key on the given host
-func (t *NetTransport) LookupLeader(host string, key []byte) (*chord.Vnode, []*chord.Vnode, map[string][]*chord.Vnode, error) {
-	out, err := t.getConn(host)
+func (t *NetTransport) LookupLeader(ctx context.Context, host string, key []byte) (*chord.Vnode, []*chord.Vnode, map[string][]*chord.Vnode, error) {
+	out, err := t.getConn(ctx, host)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -304,15 +443,19 @@ func (t *NetTransport) LookupLeader(host string, key []byte) (*chord.Vnode, []*c
 	fb.Finish(p)
 	payload := &chord.Payload{Data: fb.Bytes[fb.Head():]}
 
-	resp, err := out.client.LookupLeaderServe(context.Background(), payload)
+	rctx, cancel := t.rpcContext(ctx)
+	defer cancel()
+
+	var md metadata.MD
+	resp, err := out.client.LookupLeaderServe(rctx, payload, grpc.Header(&md))
 	if err != nil {
-		t.reapConn(out)
+		t.reapConn(rctx, out)
 		return nil, nil, nil, err
 	}
 
 	vl, err := chord.DeserializeVnodeListErr(resp.Data)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, errorWithCode(err, md)
 	}
 
 	// re-generate map from vnode slice locally to save on bandwidth
@@ -320,9 +463,108 @@ func (t *NetTransport) LookupLeader(host string, key []byte) (*chord.Vnode, []*c
 	return vl[0], vl[1:], vm, nil
 }
 
+// BatchGet gets the keys of the entries on the given host.
+func (t *NetTransport) BatchGet(ctx context.Context, host string, entries []*BatchEntry, options *RequestOptions) ([]*BatchEntry, error) {
+	out, err := t.getConn(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &chord.Payload{Data: serializeBatchEntryList(entries)}
+
+	rctx, cancel := t.rpcContext(ctx)
+	defer cancel()
+
+	resp, err := out.client.BatchGetServe(optionsContext(rctx, options), payload)
+	if err != nil {
+		t.reapConn(rctx, out)
+		return nil, err
+	}
+
+	return deserializeBatchEntryList(resp.Data), nil
+}
+
+// BatchSet sets the entries on the given host.
+func (t *NetTransport) BatchSet(ctx context.Context, host string, entries []*BatchEntry, options *RequestOptions) ([]*BatchEntry, error) {
+	out, err := t.getConn(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &chord.Payload{Data: serializeBatchEntryList(entries)}
+
+	rctx, cancel := t.rpcContext(ctx)
+	defer cancel()
+
+	resp, err := out.client.BatchSetServe(optionsContext(rctx, options), payload)
+	if err != nil {
+		t.reapConn(rctx, out)
+		return nil, err
+	}
+
+	return deserializeBatchEntryList(resp.Data), nil
+}
+
+// Snapshot streams the snapshot of the range from the remote vnode.  The snapshot is read
+// from the stream as the returned reader is read.
+func (t *NetTransport) Snapshot(ctx context.Context, src, rng *chord.Vnode) (io.ReadCloser, error) {
+	out, err := t.getConn(ctx, src.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &chord.Payload{Data: serializeVnodeIdsBytes(rng.Id, []*chord.Vnode{src})}
+
+	sctx, cancel := context.WithCancel(ctx)
+	stream, err := out.client.SnapshotServe(sctx, req)
+	if err != nil {
+		cancel()
+		t.reapConn(sctx, out)
+		return nil, err
+	}
+
+	// Wait for the first chunk so errors taking the snapshot are returned here
+	first, err := stream.Recv()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer cancel()
+
+		var (
+			payload = first
+			e       error
+		)
+		for {
+			if _, e = pw.Write(payload.Data); e != nil {
+				return
+			}
+			if payload, e = stream.Recv(); e != nil {
+				break
+			}
+		}
+
+		if e == io.EOF {
+			e = nil
+		}
+		pw.CloseWithError(e)
+	}()
+
+	return pr, nil
+}
+
 // TransferKeys issues a transfer request of keys from the local to remote vnode.
 // This queues a per key replication process on the other end.
-func (t *NetTransport) TransferKeys(local, remote *chord.Vnode) error {
+func (t *NetTransport) TransferKeys(ctx context.Context, local, remote *chord.Vnode) error {
+	return t.transferKeys(ctx, local, remote, nil)
+}
+
+// transferKeys transfers the keys of the local vnode selected by the filter to the remote
+// vnode.  All keys are transferred if the filter is nil.
+func (t *NetTransport) transferKeys(ctx context.Context, local, remote *chord.Vnode, filter func([]byte, *store.Inode) bool) error {
 	// Get local store
 	st, err := t.local.GetStore(local.Id)
 	if err != nil {
@@ -330,14 +572,17 @@ func (t *NetTransport) TransferKeys(local, remote *chord.Vnode) error {
 	}
 
 	// Get remote conn
-	out, err := t.getConn(remote.Host)
+	out, err := t.getConn(ctx, remote.Host)
 	if err != nil {
 		return err
 	}
 
-	stream, err := out.client.TransferKeysServe(context.Background())
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := out.client.TransferKeysServe(sctx)
 	if err != nil {
-		t.reapConn(out)
+		t.reapConn(sctx, out)
 		return err
 	}
 
@@ -357,6 +602,9 @@ func (t *NetTransport) TransferKeys(local, remote *chord.Vnode) error {
 
 	var cnt int
 	err = st.IterInodes(func(key []byte, inode *store.Inode) error {
+		if filter != nil && !filter(key, inode) {
+			return nil
+		}
 		fb.Reset()
 		fb.Finish(serializeIdRoot(fb, key, inode.TxRoot()))
 		pl := &chord.Payload{Data: fb.Bytes[fb.Head():]}
@@ -376,22 +624,88 @@ func (t *NetTransport) TransferKeys(local, remote *chord.Vnode) error {
 	return err
 }
 
+// Bootstrap streams a snapshot of the data selected by the filter from the local vnode to
+// the new remote vnode which restores it before serving reads.  Keys whose transactions
+// changed after the snapshot was taken are then transferred as with TransferKeys.
+func (t *NetTransport) Bootstrap(ctx context.Context, local, remote *chord.Vnode, filter *store.SnapshotFilter) error {
+	st, err := t.local.GetStore(local.Id)
+	if err != nil {
+		return err
+	}
+
+	roots := bootstrapRoots(st, filter)
+	rc, err := st.FilteredSnapshot(filter)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	out, err := t.getConn(ctx, remote.Host)
+	if err != nil {
+		return err
+	}
+
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := out.client.BootstrapServe(sctx)
+	if err != nil {
+		t.reapConn(sctx, out)
+		return err
+	}
+
+	fb := flatbuffers.NewBuilder(0)
+	fb.Finish(serializeTransferRequest(fb, local, remote))
+	if err = stream.Send(&chord.Payload{Data: fb.Bytes[fb.Head():]}); err != nil {
+		return err
+	}
+
+	var n int64
+	for {
+		buf := make([]byte, snapshotChunkSize)
+		c, e := io.ReadFull(rc, buf)
+		if c > 0 {
+			if err = stream.Send(&chord.Payload{Data: buf[:c]}); err != nil {
+				return err
+			}
+			n += int64(c)
+		}
+		if e == io.EOF || e == io.ErrUnexpectedEOF {
+			break
+		}
+		if e != nil {
+			return e
+		}
+	}
+
+	if _, err = stream.CloseAndRecv(); err != nil {
+		return err
+	}
+	log.Printf("action=bootstrap status=ok keys=%d bytes=%d src=%s dst=%s", len(roots), n, shortID(local), shortID(remote))
+
+	// Catch up the keys written to while bootstrapping
+	return t.transferKeys(ctx, local, remote, catchupFilter(roots, filter))
+}
+
 // ReplicateBlocks starts replicating blocks from local vnode to remote vnode.
-func (t *NetTransport) ReplicateBlocks(local, remote *chord.Vnode) error {
+func (t *NetTransport) ReplicateBlocks(ctx context.Context, local, remote *chord.Vnode) error {
 	// Get local store
 	st, err := t.local.GetStore(local.Id)
 	if err != nil {
 		return err
 	}
 	// Get remote conn
-	out, err := t.getConn(remote.Host)
+	out, err := t.getConn(ctx, remote.Host)
 	if err != nil {
 		return err
 	}
 	// Get client
-	stream, err := out.client.ReplicateBlocksServe(context.Background())
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := out.client.ReplicateBlocksServe(sctx)
 	if err != nil {
-		t.reapConn(out)
+		t.reapConn(sctx, out)
 		return err
 	}
 
@@ -411,6 +725,11 @@ func (t *NetTransport) ReplicateBlocks(local, remote *chord.Vnode) error {
 	// Send all blocks
 	cnt := 0
 	err = st.IterBlocks(func(h []byte, data []byte) error {
+		// Shards are placed on specific hosts and regenerated by repair rather than
+		// copied
+		if erasure.IsShard(data) {
+			return nil
+		}
 		fb.Reset()
 		fb.Finish(serializeByteSlice(fb, data))
 		blk := &chord.Payload{Data: fb.Bytes[fb.Head():]}
@@ -448,7 +767,7 @@ func (t *NetTransport) RegisterReplicationQ(rq chan<- *ReplRequest) {
 // TransactionsServe serves transactions for given the key and the seek position in the tx log.
 func (t *NetTransport) TransactionsServe(in *chord.Payload, stream netrpc.DifuseRPC_TransactionsServeServer) error {
 	fbtxr := gentypes.GetRootAsTxRequest(in.Data, 0)
-	st, err := t.local.GetStore(fbtxr.IdBytes())
+	st, err := t.local.readStore(fbtxr.IdBytes())
 	if err != nil {
 		return err
 	}
@@ -499,10 +818,28 @@ func (t *NetTransport) MerkleRootTxServe(ctx context.Context, in *chord.Payload)
 	return &chord.Payload{Data: data}, nil
 }
 
+// ProofTxServe serves a ProofTx request
+func (t *NetTransport) ProofTxServe(ctx context.Context, in *chord.Payload) (*chord.Payload, error) {
+	vns, key, txhash := deserializeVnodeIdsTwoByteSlices(in.Data)
+	rsps, _ := t.local.ProofTx(key, txhash, nil, vns...)
+
+	data := serializeVnodeProofList(rsps)
+	return &chord.Payload{Data: data}, nil
+}
+
 // AppendTxServe serves an AppendTx request
 func (t *NetTransport) AppendTxServe(ctx context.Context, in *chord.Payload) (*chord.Payload, error) {
 	vns, tx := deserializeVnodeIdsTx(in.Data)
-	rsps, _ := t.local.AppendTx(tx, nil, vns...)
+	rsps, _ := t.local.AppendTx(ctx, tx, optionsFromContext(ctx), vns...)
+
+	data := serializeVnodeIdBytesErrList(rsps)
+	return &chord.Payload{Data: data}, nil
+}
+
+// AppendTxsServe serves an AppendTxs request
+func (t *NetTransport) AppendTxsServe(ctx context.Context, in *chord.Payload) (*chord.Payload, error) {
+	batches := deserializeVnodeTxsList(in.Data)
+	rsps, _ := t.local.AppendTxs(ctx, batches, optionsFromContext(ctx))
 
 	data := serializeVnodeIdBytesErrList(rsps)
 	return &chord.Payload{Data: data}, nil
@@ -511,7 +848,7 @@ func (t *NetTransport) AppendTxServe(ctx context.Context, in *chord.Payload) (*c
 // StatServe serves a Stat request
 func (t *NetTransport) StatServe(ctx context.Context, in *chord.Payload) (*chord.Payload, error) {
 	vns, k := deserializeVnodeIdsBytes(in.Data)
-	rsp, _ := t.local.Stat(k, nil, vns...)
+	rsp, _ := t.local.Stat(ctx, k, optionsFromContext(ctx), vns...)
 
 	data := serializeVnodeIdInodeErrList(rsp)
 	return &chord.Payload{Data: data}, nil
@@ -523,10 +860,9 @@ func (t *NetTransport) SetInodeServe(ctx context.Context, in *chord.Payload) (*c
 	ind := gentypes.GetRootAsInode(in.Data, 0)
 	inode.Deserialize(ind)
 
-	// TODO: parse opts
-	vn, err := t.cs.SetInode(inode, nil)
+	meta, err := t.cs.SetInode(ctx, inode, optionsFromContext(ctx))
 
-	data := chord.SerializeVnodeErr(vn, err)
+	data := serializeResponseMeta(ctx, meta, err)
 	return &chord.Payload{Data: data}, nil
 }
 
@@ -536,13 +872,59 @@ func (t *NetTransport) DeleteInodeServe(ctx context.Context, in *chord.Payload)
 	ind := gentypes.GetRootAsInode(in.Data, 0)
 	inode.Deserialize(ind)
 
-	// TODO: parse opts
-	vn, err := t.cs.DeleteInode(inode, nil)
+	meta, err := t.cs.DeleteInode(ctx, inode, optionsFromContext(ctx))
 
-	data := chord.SerializeVnodeErr(vn, err)
+	data := serializeResponseMeta(ctx, meta, err)
 	return &chord.Payload{Data: data}, nil
 }
 
+// serializeResponseMeta serializes the leader and error of an inode write.  The commit
+// token is sent in the response header.
+func serializeResponseMeta(ctx context.Context, meta *ResponseMeta, err error) []byte {
+	setErrorCode(ctx, err)
+	if meta == nil {
+		return chord.SerializeVnodeErr(nil, err)
+	}
+	if len(meta.Token) > 0 {
+		grpc.SetHeader(ctx, metadata.Pairs(tokenMetadataKey, hex.EncodeToString(meta.Token)))
+	}
+	return chord.SerializeVnodeErr(meta.Vnode, err)
+}
+
+// deserializeResponseMeta returns the meta of an inode write from the response and its
+// header.
+func deserializeResponseMeta(data []byte, md metadata.MD) (*ResponseMeta, error) {
+	meta := &ResponseMeta{}
+	if vals := md[tokenMetadataKey]; len(vals) > 0 {
+		meta.Token, _ = hex.DecodeString(vals[0])
+	}
+
+	var err error
+	meta.Vnode, err = chord.DeserializeVnodeErr(data)
+	return meta, errorWithCode(err, md)
+}
+
+// setErrorCode sends the code of the error in the response header.  Errors relayed by
+// chord serialization only carry their message.
+func setErrorCode(ctx context.Context, err error) {
+	if err != nil {
+		grpc.SetHeader(ctx, metadata.Pairs(errorCodeMetadataKey, strconv.Itoa(int(ErrorCodeOf(err)))))
+	}
+}
+
+// errorWithCode returns the relayed error with the code from the response header.
+func errorWithCode(err error, md metadata.MD) error {
+	if err == nil {
+		return nil
+	}
+	vals := md[errorCodeMetadataKey]
+	if len(vals) == 0 {
+		return err
+	}
+	code, _ := strconv.Atoi(vals[0])
+	return &Error{Code: ErrorCode(code), Msg: err.Error()}
+}
+
 // GetBlockServe serves a GetBlock request
 func (t *NetTransport) GetBlockServe(ctx context.Context, in *chord.Payload) (*chord.Payload, error) {
 	vns, k := deserializeVnodeIdsBytes(in.Data)
@@ -570,13 +952,71 @@ func (t *NetTransport) DeleteBlockServe(ctx context.Context, in *chord.Payload)
 
 func (t *NetTransport) LookupLeaderServe(ctx context.Context, in *chord.Payload) (*chord.Payload, error) {
 	fbkey := gentypes.GetRootAsByteSlice(in.Data, 0)
-	l, vl, _, err := t.cs.LookupLeader(fbkey.BBytes())
+	l, vl, _, err := t.cs.LookupLeader(ctx, fbkey.BBytes())
+	setErrorCode(ctx, err)
 	list := append([]*chord.Vnode{l}, vl...)
 	data := chord.SerializeVnodeListErr(list, err)
 
 	return &chord.Payload{Data: data}, nil
 }
 
+// ChordServe serves a chord rpc for a local vnode
+func (t *NetTransport) ChordServe(ctx context.Context, in *chord.Payload) (*chord.Payload, error) {
+	if t.chord == nil {
+		return nil, errChordNotServed
+	}
+	data := t.chord.serve(ctx, deserializeChordRequest(in.Data))
+	return &chord.Payload{Data: data}, nil
+}
+
+// BatchGetServe serves a BatchGet request
+func (t *NetTransport) BatchGetServe(ctx context.Context, in *chord.Payload) (*chord.Payload, error) {
+	entries := deserializeBatchEntryList(in.Data)
+	rsp := batchGet(ctx, t.cs, entries, optionsFromContext(ctx))
+
+	return &chord.Payload{Data: serializeBatchEntryList(rsp)}, nil
+}
+
+// BatchSetServe serves a BatchSet request
+func (t *NetTransport) BatchSetServe(ctx context.Context, in *chord.Payload) (*chord.Payload, error) {
+	entries := deserializeBatchEntryList(in.Data)
+	rsp := t.cs.SetBatch(ctx, entries, optionsFromContext(ctx))
+
+	return &chord.Payload{Data: serializeBatchEntryList(rsp)}, nil
+}
+
+// SnapshotServe streams the snapshot of the keys and blocks of the requested range from
+// the local vnode in chunks.
+func (t *NetTransport) SnapshotServe(in *chord.Payload, stream netrpc.DifuseRPC_SnapshotServeServer) error {
+	vns, rid := deserializeVnodeIdsBytes(in.Data)
+	if len(vns) == 0 {
+		return errStoreNotFound
+	}
+
+	rc, err := t.local.Snapshot(vns[0], t.cs.RangeFilter(&chord.Vnode{Id: rid}))
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	for {
+		// The payload may still be referenced after Send returns
+		buf := make([]byte, snapshotChunkSize)
+		n, e := io.ReadFull(rc, buf)
+		if n > 0 {
+			if err = stream.Send(&chord.Payload{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if e == io.EOF || e == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if e != nil {
+			return e
+		}
+	}
+}
+
 // ReplicateBlocksServe accepts blocks from the stream and adds them the specified vnode. If
 // any errors occur, then the last error is returned i.e. cloning will continue even
 // though some of the blocks may not be written.
@@ -618,6 +1058,63 @@ func (t *NetTransport) ReplicateBlocksServe(stream netrpc.DifuseRPC_ReplicateBlo
 	return stream.SendAndClose(&chord.Payload{})
 }
 
+// BootstrapServe restores the snapshot streamed by the successor of the requested vnode.
+// The vnode serves reads once the snapshot has been restored.  The snapshot is only
+// accepted from the peer holding the successor of a vnode still being bootstrapped.
+func (t *NetTransport) BootstrapServe(stream netrpc.DifuseRPC_BootstrapServeServer) error {
+	var req chord.Payload
+	err := stream.RecvMsg(&req)
+	if err != nil {
+		return err
+	}
+	tr := gentypes.GetRootAsTransferRequest(req.Data, 0)
+	src := tr.Src(nil)
+	sv := &chord.Vnode{Id: src.IdBytes(), Host: string(src.Host())}
+	dst := tr.Dst(nil)
+	dv := &chord.Vnode{Id: dst.IdBytes(), Host: string(dst.Host())}
+
+	if err = verifyPeerHost(stream.Context(), sv.Host); err != nil {
+		return err
+	}
+	if !t.cs.IsSuccessor(dv, sv) {
+		return errNotSuccessor
+	}
+	st, err := t.local.GetStore(dv.Id)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			payload, e := stream.Recv()
+			if e != nil {
+				if e == io.EOF {
+					e = nil
+				}
+				pw.CloseWithError(e)
+				return
+			}
+			if _, e = pw.Write(payload.Data); e != nil {
+				return
+			}
+		}
+	}()
+
+	err = restoreBootstrap(st, pr)
+	// Consume the rest of the stream
+	io.Copy(ioutil.Discard, pr)
+	<-done
+
+	if err != nil {
+		log.Printf("action=bootstrap status=failed src=%s dst=%s msg='%v'", shortID(sv), shortID(dv), err)
+		return err
+	}
+	return stream.SendAndClose(&chord.Payload{})
+}
+
 // TransferKeysServe takes keys from the request and queues them to be replicated to the
 // specified destination vnode.
 func (t *NetTransport) TransferKeysServe(stream netrpc.DifuseRPC_TransferKeysServeServer) error {
@@ -634,6 +1131,10 @@ func (t *NetTransport) TransferKeysServe(stream netrpc.DifuseRPC_TransferKeysSer
 	sv := &chord.Vnode{Id: src.IdBytes(), Host: string(src.Host())}
 	dst := tr.Dst(nil)
 	dv := &chord.Vnode{Id: dst.IdBytes(), Host: string(dst.Host())}
+	// Keys are pulled from the source so it must be the peer
+	if err = verifyPeerHost(stream.Context(), sv.Host); err != nil {
+		return err
+	}
 
 	// Receive replication requests
 	for {
@@ -656,7 +1157,33 @@ func (t *NetTransport) TransferKeysServe(stream netrpc.DifuseRPC_TransferKeysSer
 	return stream.SendAndClose(&chord.Payload{})
 }
 
-func (t *NetTransport) getConn(host string) (*outConn, error) {
+// Close closes all outbound connections.
+func (t *NetTransport) Close() error {
+	t.clock.Lock()
+	defer t.clock.Unlock()
+
+	var err error
+	for host, oc := range t.out {
+		if e := oc.conn.Close(); e != nil {
+			err = e
+		}
+		delete(t.out, host)
+	}
+	return err
+}
+
+// rpcContext returns the context for a unary rpc.  The rpc timeout applies unless the
+// parent has an earlier deadline.
+func (t *NetTransport) rpcContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t.timeouts.RPC > 0 {
+		return context.WithTimeout(ctx, t.timeouts.RPC)
+	}
+	return context.WithCancel(ctx)
+}
+
+// getConn returns the connection to the host dialing it if needed.  Dialing blocks until
+// connected, the dial timeout or the deadline of the context.
+func (t *NetTransport) getConn(ctx context.Context, host string) (*outConn, error) {
 	t.clock.RLock()
 	if v, ok := t.out[host]; ok {
 		defer t.clock.RUnlock()
@@ -664,7 +1191,26 @@ func (t *NetTransport) getConn(host string) (*outConn, error) {
 	}
 	t.clock.RUnlock()
 
-	conn, err := grpc.Dial(host, grpc.WithInsecure(), grpc.WithCodec(&chord.PayloadCodec{}))
+	opts := []grpc.DialOption{grpc.WithCodec(&chord.PayloadCodec{})}
+	if t.certs != nil {
+		tc, err := t.certs.ClientConfig(host)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tc)))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+
+	// Fail right away on errors such as a bad certificate rather than at the timeout
+	opts = append(opts, grpc.WithBlock(), grpc.FailOnNonTempDialError(true))
+	if t.timeouts.Dial > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.timeouts.Dial)
+		defer cancel()
+	}
+
+	conn, err := grpc.DialContext(ctx, host, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -684,8 +1230,12 @@ func (t *NetTransport) getConn(host string) (*outConn, error) {
 }
 
 // reapConn closes and removes the conn from out mem pool.  This should be called
-// when connections go bad.
-func (t *NetTransport) reapConn(conn *outConn) {
+// when connections go bad.  The conn is kept if the request context was cancelled or
+// timed out as only the request failed.
+func (t *NetTransport) reapConn(ctx context.Context, conn *outConn) {
+	if ctx.Err() != nil {
+		return
+	}
 	conn.conn.Close()
 
 	t.clock.Lock()
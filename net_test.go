@@ -9,7 +9,9 @@ import (
 	"time"
 
 	flatbuffers "github.com/google/flatbuffers/go"
+	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
 	chord "github.com/ipkg/go-chord"
 
@@ -71,7 +73,7 @@ func TestNetTransportBlock(t *testing.T) {
 
 	vs := []*chord.Vnode{vn1, vn2}
 
-	resp, err := nt1.SetBlock([]byte("lsdkfjsldkfjsdlfjsldfkjsldkfjslkfjsl"), nil, vs...)
+	resp, err := nt1.SetBlock(context.Background(), []byte("lsdkfjsldkfjsdlfjsldfkjsldkfjslkfjsl"), nil, vs...)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -86,7 +88,7 @@ func TestNetTransportBlock(t *testing.T) {
 	}
 
 	h := resp[0].Data.([]byte)
-	if resp, err = nt1.GetBlock(h, nil, vs...); err != nil {
+	if resp, err = nt1.GetBlock(context.Background(), h, nil, vs...); err != nil {
 		t.Fatal(err)
 	}
 
@@ -98,7 +100,7 @@ func TestNetTransportBlock(t *testing.T) {
 		}
 	}
 
-	if err = nt1.ReplicateBlocks(vn1, vn3); err != nil {
+	if err = nt1.ReplicateBlocks(context.Background(), vn1, vn3); err != nil {
 		t.Fatal(err)
 	}
 
@@ -118,7 +120,7 @@ func TestNetTransportBlock(t *testing.T) {
 		t.Error("clone failed")
 	}
 
-	if resp, err = nt1.DeleteBlock(h, nil, vs...); err != nil {
+	if resp, err = nt1.DeleteBlock(context.Background(), h, nil, vs...); err != nil {
 		t.Fatal(err)
 	}
 
@@ -163,7 +165,7 @@ func TestNetTransportTx(t *testing.T) {
 
 	vs := []*chord.Vnode{vn1, vn2}
 
-	resp, err := nt1.AppendTx(tx, nil, vs...)
+	resp, err := nt1.AppendTx(context.Background(), tx, nil, vs...)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -172,7 +174,7 @@ func TestNetTransportTx(t *testing.T) {
 	}
 
 	<-time.After(300 * time.Millisecond)
-	resp, err = nt1.GetTx([]byte("key"), tx.Hash(), nil, vs...)
+	resp, err = nt1.GetTx(context.Background(), []byte("key"), tx.Hash(), nil, vs...)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -187,7 +189,7 @@ func TestNetTransportTx(t *testing.T) {
 		t.Fatalf("result size mismatch")
 	}
 
-	resp, err = nt1.LastTx([]byte("key"), nil, vs...)
+	resp, err = nt1.LastTx(context.Background(), []byte("key"), nil, vs...)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -203,7 +205,7 @@ func TestNetTransportTx(t *testing.T) {
 		}
 	}
 
-	resp, err = nt1.Stat([]byte("key"), nil, vs...)
+	resp, err = nt1.Stat(context.Background(), []byte("key"), nil, vs...)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -225,7 +227,7 @@ func TestNetTransportTx(t *testing.T) {
 		}
 	}
 
-	if err = nt1.TransferKeys(vn1, vn3); err != nil {
+	if err = nt1.TransferKeys(context.Background(), vn1, vn3); err != nil {
 		t.Fatal(err)
 	}
 
@@ -251,7 +253,7 @@ func TestNetTransportTx(t *testing.T) {
 		t.Fatal("inode id mismatch")
 	}
 
-	mr, err := nt1.MerkleRootTx([]byte("key"), nil, vn1, vn3)
+	mr, err := nt1.MerkleRootTx(context.Background(), []byte("key"), nil, vn1, vn3)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -300,3 +302,155 @@ func TestVnodeResponseMarshalJSON(t *testing.T) {
 		t.Fatal("should have data")
 	}
 }
+
+func TestNetTransportTimeouts(t *testing.T) {
+	nt := NewNetTransport()
+	nt.SetTimeouts(&NetTimeouts{Dial: 100 * time.Millisecond, RPC: time.Second})
+
+	// The rpc timeout applies unless the caller has an earlier deadline
+	ctx, cancel := nt.rpcContext(context.Background())
+	defer cancel()
+	if dl, ok := ctx.Deadline(); !ok || dl.After(time.Now().Add(time.Second)) {
+		t.Fatal("rpc timeout not applied")
+	}
+
+	pctx, pcancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer pcancel()
+	pdl, _ := pctx.Deadline()
+	ctx, cancel = nt.rpcContext(pctx)
+	defer cancel()
+	if dl, _ := ctx.Deadline(); !dl.Equal(pdl) {
+		t.Fatal("caller deadline not kept")
+	}
+
+	// A peer accepting connections but never responding fails at the dial timeout
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	vn := &chord.Vnode{Id: []byte("vnode-silent"), Host: ln.Addr().String()}
+	start := time.Now()
+	if _, err = nt.Stat(context.Background(), []byte("key"), nil, vn); err == nil {
+		t.Fatal("should fail")
+	}
+	if time.Since(start) > 900*time.Millisecond {
+		t.Fatal("dial timeout not applied", time.Since(start))
+	}
+
+	// A cancelled request fails right away
+	cctx, ccancel := context.WithCancel(context.Background())
+	ccancel()
+	nt.SetTimeouts(&NetTimeouts{Dial: 5 * time.Second, RPC: 5 * time.Second})
+	start = time.Now()
+	if _, err = nt.Stat(cctx, []byte("key"), nil, vn); err == nil {
+		t.Fatal("should fail")
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("cancelled request should not wait", time.Since(start))
+	}
+}
+
+func TestOptionsMetadata(t *testing.T) {
+	opts := &RequestOptions{Consistency: ConsistencyAll, WaitApply: true, Token: []byte{0xde, 0xad}}
+
+	md, _ := metadata.FromOutgoingContext(optionsContext(context.Background(), opts))
+	got := optionsFromContext(metadata.NewIncomingContext(context.Background(), md))
+	if got == nil || got.Consistency != ConsistencyAll || !got.WaitApply || string(got.Token) != string(opts.Token) {
+		t.Fatal("options not carried", got)
+	}
+
+	md, _ = metadata.FromOutgoingContext(optionsContext(context.Background(), &RequestOptions{}))
+	got = optionsFromContext(metadata.NewIncomingContext(context.Background(), md))
+	if got.WaitApply || got.Token != nil || got.If != nil {
+		t.Fatal("options should not be set", got)
+	}
+
+	for _, cond := range []*Condition{{}, {Value: []byte{}}, {Value: []byte("value")}} {
+		md, _ = metadata.FromOutgoingContext(optionsContext(context.Background(), &RequestOptions{If: cond}))
+		got = optionsFromContext(metadata.NewIncomingContext(context.Background(), md))
+		if got.If == nil || (got.If.Value == nil) != (cond.Value == nil) || string(got.If.Value) != string(cond.Value) {
+			t.Fatal("condition not carried", cond, got.If)
+		}
+	}
+}
+
+// succStore takes the vnode as the successor of every vnode
+type succStore struct {
+	ConsistentStore
+	succ *chord.Vnode
+}
+
+func (ss *succStore) IsSuccessor(local, vn *chord.Vnode) bool {
+	return vn.String() == ss.succ.String()
+}
+
+func TestNetTransportBootstrap(t *testing.T) {
+	kp1, _ := txlog.GenerateECDSAKeypair()
+
+	ln1, svr1, nt1, err := prepGRPCTransport(32326)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go svr1.Serve(ln1)
+
+	vn1 := &chord.Vnode{Id: []byte("vnode-32326-1"), Host: "127.0.0.1:32326"}
+	vn2 := &chord.Vnode{Id: []byte("vnode-32326-2"), Host: "127.0.0.1:32326"}
+
+	st1 := store.NewMemLoggedStore(vn1, kp1)
+	bs := newBootstrapStore(vn2, store.NewMemLoggedStore(vn2, kp1), time.Minute)
+	nt1.RegisterVnode(vn1, st1)
+	nt1.RegisterVnode(vn2, bs)
+	ss := &succStore{succ: vn2}
+	nt1.Register(ss)
+
+	var last *txlog.Tx
+	for _, k := range []string{"a", "b"} {
+		inode := store.NewKeyInodeWithValue([]byte(k), []byte("value-"+k))
+		fb := flatbuffers.NewBuilder(0)
+		fb.Finish(inode.Serialize(fb))
+
+		tx, _ := st1.NewTx([]byte(k))
+		tx.Data = append([]byte{store.TxTypeSet}, fb.Bytes[fb.Head():]...)
+		tx.Sign(kp1)
+		st1.AppendTx(tx)
+		last = tx
+	}
+	if err = st1.WaitTx(context.Background(), last.Key, last.Hash()); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, _ := nt1.Stat(context.Background(), []byte("a"), nil, vn2)
+	if ErrorCodeOf(resp[0].Err) != CodeUnavailable {
+		t.Fatal("should not serve reads while bootstrapping", resp[0].Err)
+	}
+
+	filter := &store.SnapshotFilter{Key: func(key []byte) bool { return key[0] == 'a' }}
+	if err = nt1.Bootstrap(context.Background(), vn1, vn2, filter); err == nil || bs.isReady() {
+		t.Fatal("should only be bootstrapped by the successor")
+	}
+	ss.succ = vn1
+	if err = nt1.Bootstrap(context.Background(), vn1, vn2, filter); err != nil {
+		t.Fatal(err)
+	}
+	if !bs.isReady() {
+		t.Fatal("should be ready once bootstrapped")
+	}
+	if err = nt1.Bootstrap(context.Background(), vn1, vn2, nil); err == nil {
+		t.Fatal("should not bootstrap a ready vnode")
+	}
+
+	if resp, _ = nt1.Stat(context.Background(), []byte("a"), nil, vn2); resp[0].Err != nil {
+		t.Fatal(resp[0].Err)
+	}
+	if resp, _ = nt1.Stat(context.Background(), []byte("b"), nil, vn2); resp[0].Err == nil {
+		t.Fatal("key outside the filter should not be bootstrapped")
+	}
+
+	r1, _ := st1.MerkleRootTx([]byte("a"))
+	r2, _ := bs.MerkleRootTx([]byte("a"))
+	if !txlog.EqualBytes(r1, r2) {
+		t.Fatal("tx root mismatch")
+	}
+}
@@ -46,8 +46,10 @@ const _ = grpc.SupportPackageIsVersion4
 type DifuseRPCClient interface {
 	GetTxServe(ctx context.Context, in *chord.Payload, opts ...grpc.CallOption) (*chord.Payload, error)
 	AppendTxServe(ctx context.Context, in *chord.Payload, opts ...grpc.CallOption) (*chord.Payload, error)
+	AppendTxsServe(ctx context.Context, in *chord.Payload, opts ...grpc.CallOption) (*chord.Payload, error)
 	LastTxServe(ctx context.Context, in *chord.Payload, opts ...grpc.CallOption) (*chord.Payload, error)
 	MerkleRootTxServe(ctx context.Context, in *chord.Payload, opts ...grpc.CallOption) (*chord.Payload, error)
+	ProofTxServe(ctx context.Context, in *chord.Payload, opts ...grpc.CallOption) (*chord.Payload, error)
 	TransactionsServe(ctx context.Context, in *chord.Payload, opts ...grpc.CallOption) (DifuseRPC_TransactionsServeClient, error)
 	StatServe(ctx context.Context, in *chord.Payload, opts ...grpc.CallOption) (*chord.Payload, error)
 	SetInodeServe(ctx context.Context, in *chord.Payload, opts ...grpc.CallOption) (*chord.Payload, error)
@@ -58,6 +60,11 @@ type DifuseRPCClient interface {
 	ReplicateBlocksServe(ctx context.Context, opts ...grpc.CallOption) (DifuseRPC_ReplicateBlocksServeClient, error)
 	TransferKeysServe(ctx context.Context, opts ...grpc.CallOption) (DifuseRPC_TransferKeysServeClient, error)
 	LookupLeaderServe(ctx context.Context, in *chord.Payload, opts ...grpc.CallOption) (*chord.Payload, error)
+	BatchGetServe(ctx context.Context, in *chord.Payload, opts ...grpc.CallOption) (*chord.Payload, error)
+	BatchSetServe(ctx context.Context, in *chord.Payload, opts ...grpc.CallOption) (*chord.Payload, error)
+	ChordServe(ctx context.Context, in *chord.Payload, opts ...grpc.CallOption) (*chord.Payload, error)
+	SnapshotServe(ctx context.Context, in *chord.Payload, opts ...grpc.CallOption) (DifuseRPC_SnapshotServeClient, error)
+	BootstrapServe(ctx context.Context, opts ...grpc.CallOption) (DifuseRPC_BootstrapServeClient, error)
 }
 
 type difuseRPCClient struct {
@@ -86,6 +93,15 @@ func (c *difuseRPCClient) AppendTxServe(ctx context.Context, in *chord.Payload,
 	return out, nil
 }
 
+func (c *difuseRPCClient) AppendTxsServe(ctx context.Context, in *chord.Payload, opts ...grpc.CallOption) (*chord.Payload, error) {
+	out := new(chord.Payload)
+	err := grpc.Invoke(ctx, "/netrpc.DifuseRPC/AppendTxsServe", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *difuseRPCClient) LastTxServe(ctx context.Context, in *chord.Payload, opts ...grpc.CallOption) (*chord.Payload, error) {
 	out := new(chord.Payload)
 	err := grpc.Invoke(ctx, "/netrpc.DifuseRPC/LastTxServe", in, out, c.cc, opts...)
@@ -104,6 +120,15 @@ func (c *difuseRPCClient) MerkleRootTxServe(ctx context.Context, in *chord.Paylo
 	return out, nil
 }
 
+func (c *difuseRPCClient) ProofTxServe(ctx context.Context, in *chord.Payload, opts ...grpc.CallOption) (*chord.Payload, error) {
+	out := new(chord.Payload)
+	err := grpc.Invoke(ctx, "/netrpc.DifuseRPC/ProofTxServe", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *difuseRPCClient) TransactionsServe(ctx context.Context, in *chord.Payload, opts ...grpc.CallOption) (DifuseRPC_TransactionsServeClient, error) {
 	stream, err := grpc.NewClientStream(ctx, &_DifuseRPC_serviceDesc.Streams[0], c.cc, "/netrpc.DifuseRPC/TransactionsServe", opts...)
 	if err != nil {
@@ -267,13 +292,108 @@ func (c *difuseRPCClient) LookupLeaderServe(ctx context.Context, in *chord.Paylo
 	return out, nil
 }
 
+func (c *difuseRPCClient) BatchGetServe(ctx context.Context, in *chord.Payload, opts ...grpc.CallOption) (*chord.Payload, error) {
+	out := new(chord.Payload)
+	err := grpc.Invoke(ctx, "/netrpc.DifuseRPC/BatchGetServe", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *difuseRPCClient) BatchSetServe(ctx context.Context, in *chord.Payload, opts ...grpc.CallOption) (*chord.Payload, error) {
+	out := new(chord.Payload)
+	err := grpc.Invoke(ctx, "/netrpc.DifuseRPC/BatchSetServe", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *difuseRPCClient) ChordServe(ctx context.Context, in *chord.Payload, opts ...grpc.CallOption) (*chord.Payload, error) {
+	out := new(chord.Payload)
+	err := grpc.Invoke(ctx, "/netrpc.DifuseRPC/ChordServe", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *difuseRPCClient) SnapshotServe(ctx context.Context, in *chord.Payload, opts ...grpc.CallOption) (DifuseRPC_SnapshotServeClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_DifuseRPC_serviceDesc.Streams[3], c.cc, "/netrpc.DifuseRPC/SnapshotServe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &difuseRPCSnapshotServeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DifuseRPC_SnapshotServeClient interface {
+	Recv() (*chord.Payload, error)
+	grpc.ClientStream
+}
+
+type difuseRPCSnapshotServeClient struct {
+	grpc.ClientStream
+}
+
+func (x *difuseRPCSnapshotServeClient) Recv() (*chord.Payload, error) {
+	m := new(chord.Payload)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *difuseRPCClient) BootstrapServe(ctx context.Context, opts ...grpc.CallOption) (DifuseRPC_BootstrapServeClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_DifuseRPC_serviceDesc.Streams[4], c.cc, "/netrpc.DifuseRPC/BootstrapServe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &difuseRPCBootstrapServeClient{stream}
+	return x, nil
+}
+
+type DifuseRPC_BootstrapServeClient interface {
+	Send(*chord.Payload) error
+	CloseAndRecv() (*chord.Payload, error)
+	grpc.ClientStream
+}
+
+type difuseRPCBootstrapServeClient struct {
+	grpc.ClientStream
+}
+
+func (x *difuseRPCBootstrapServeClient) Send(m *chord.Payload) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *difuseRPCBootstrapServeClient) CloseAndRecv() (*chord.Payload, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(chord.Payload)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // Server API for DifuseRPC service
 
 type DifuseRPCServer interface {
 	GetTxServe(context.Context, *chord.Payload) (*chord.Payload, error)
 	AppendTxServe(context.Context, *chord.Payload) (*chord.Payload, error)
+	AppendTxsServe(context.Context, *chord.Payload) (*chord.Payload, error)
 	LastTxServe(context.Context, *chord.Payload) (*chord.Payload, error)
 	MerkleRootTxServe(context.Context, *chord.Payload) (*chord.Payload, error)
+	ProofTxServe(context.Context, *chord.Payload) (*chord.Payload, error)
 	TransactionsServe(*chord.Payload, DifuseRPC_TransactionsServeServer) error
 	StatServe(context.Context, *chord.Payload) (*chord.Payload, error)
 	SetInodeServe(context.Context, *chord.Payload) (*chord.Payload, error)
@@ -284,6 +404,11 @@ type DifuseRPCServer interface {
 	ReplicateBlocksServe(DifuseRPC_ReplicateBlocksServeServer) error
 	TransferKeysServe(DifuseRPC_TransferKeysServeServer) error
 	LookupLeaderServe(context.Context, *chord.Payload) (*chord.Payload, error)
+	BatchGetServe(context.Context, *chord.Payload) (*chord.Payload, error)
+	BatchSetServe(context.Context, *chord.Payload) (*chord.Payload, error)
+	ChordServe(context.Context, *chord.Payload) (*chord.Payload, error)
+	SnapshotServe(*chord.Payload, DifuseRPC_SnapshotServeServer) error
+	BootstrapServe(DifuseRPC_BootstrapServeServer) error
 }
 
 func RegisterDifuseRPCServer(s *grpc.Server, srv DifuseRPCServer) {
@@ -326,6 +451,24 @@ func _DifuseRPC_AppendTxServe_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DifuseRPC_AppendTxsServe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(chord.Payload)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DifuseRPCServer).AppendTxsServe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/netrpc.DifuseRPC/AppendTxsServe",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DifuseRPCServer).AppendTxsServe(ctx, req.(*chord.Payload))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _DifuseRPC_LastTxServe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(chord.Payload)
 	if err := dec(in); err != nil {
@@ -362,6 +505,24 @@ func _DifuseRPC_MerkleRootTxServe_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DifuseRPC_ProofTxServe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(chord.Payload)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DifuseRPCServer).ProofTxServe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/netrpc.DifuseRPC/ProofTxServe",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DifuseRPCServer).ProofTxServe(ctx, req.(*chord.Payload))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _DifuseRPC_TransactionsServe_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(chord.Payload)
 	if err := stream.RecvMsg(m); err != nil {
@@ -561,6 +722,107 @@ func _DifuseRPC_LookupLeaderServe_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DifuseRPC_BatchGetServe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(chord.Payload)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DifuseRPCServer).BatchGetServe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/netrpc.DifuseRPC/BatchGetServe",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DifuseRPCServer).BatchGetServe(ctx, req.(*chord.Payload))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DifuseRPC_BatchSetServe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(chord.Payload)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DifuseRPCServer).BatchSetServe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/netrpc.DifuseRPC/BatchSetServe",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DifuseRPCServer).BatchSetServe(ctx, req.(*chord.Payload))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DifuseRPC_ChordServe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(chord.Payload)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DifuseRPCServer).ChordServe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/netrpc.DifuseRPC/ChordServe",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DifuseRPCServer).ChordServe(ctx, req.(*chord.Payload))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DifuseRPC_SnapshotServe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(chord.Payload)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DifuseRPCServer).SnapshotServe(m, &difuseRPCSnapshotServeServer{stream})
+}
+
+type DifuseRPC_SnapshotServeServer interface {
+	Send(*chord.Payload) error
+	grpc.ServerStream
+}
+
+type difuseRPCSnapshotServeServer struct {
+	grpc.ServerStream
+}
+
+func (x *difuseRPCSnapshotServeServer) Send(m *chord.Payload) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _DifuseRPC_BootstrapServe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(DifuseRPCServer).BootstrapServe(&difuseRPCBootstrapServeServer{stream})
+}
+
+type DifuseRPC_BootstrapServeServer interface {
+	SendAndClose(*chord.Payload) error
+	Recv() (*chord.Payload, error)
+	grpc.ServerStream
+}
+
+type difuseRPCBootstrapServeServer struct {
+	grpc.ServerStream
+}
+
+func (x *difuseRPCBootstrapServeServer) SendAndClose(m *chord.Payload) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *difuseRPCBootstrapServeServer) Recv() (*chord.Payload, error) {
+	m := new(chord.Payload)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 var _DifuseRPC_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "netrpc.DifuseRPC",
 	HandlerType: (*DifuseRPCServer)(nil),
@@ -573,6 +835,10 @@ var _DifuseRPC_serviceDesc = grpc.ServiceDesc{
 			MethodName: "AppendTxServe",
 			Handler:    _DifuseRPC_AppendTxServe_Handler,
 		},
+		{
+			MethodName: "AppendTxsServe",
+			Handler:    _DifuseRPC_AppendTxsServe_Handler,
+		},
 		{
 			MethodName: "LastTxServe",
 			Handler:    _DifuseRPC_LastTxServe_Handler,
@@ -581,6 +847,10 @@ var _DifuseRPC_serviceDesc = grpc.ServiceDesc{
 			MethodName: "MerkleRootTxServe",
 			Handler:    _DifuseRPC_MerkleRootTxServe_Handler,
 		},
+		{
+			MethodName: "ProofTxServe",
+			Handler:    _DifuseRPC_ProofTxServe_Handler,
+		},
 		{
 			MethodName: "StatServe",
 			Handler:    _DifuseRPC_StatServe_Handler,
@@ -609,6 +879,18 @@ var _DifuseRPC_serviceDesc = grpc.ServiceDesc{
 			MethodName: "LookupLeaderServe",
 			Handler:    _DifuseRPC_LookupLeaderServe_Handler,
 		},
+		{
+			MethodName: "BatchGetServe",
+			Handler:    _DifuseRPC_BatchGetServe_Handler,
+		},
+		{
+			MethodName: "BatchSetServe",
+			Handler:    _DifuseRPC_BatchSetServe_Handler,
+		},
+		{
+			MethodName: "ChordServe",
+			Handler:    _DifuseRPC_ChordServe_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -626,6 +908,16 @@ var _DifuseRPC_serviceDesc = grpc.ServiceDesc{
 			Handler:       _DifuseRPC_TransferKeysServe_Handler,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "SnapshotServe",
+			Handler:       _DifuseRPC_SnapshotServe_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "BootstrapServe",
+			Handler:       _DifuseRPC_BootstrapServe_Handler,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "net.proto",
 }
@@ -633,22 +925,22 @@ var _DifuseRPC_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("net.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 261 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x94, 0xd2, 0xc1, 0x4a, 0xc3, 0x40,
-	0x18, 0x04, 0x60, 0x7b, 0xb0, 0x90, 0x5f, 0x2a, 0x26, 0x78, 0xea, 0xb1, 0x27, 0x2f, 0x4d, 0xac,
-	0x5a, 0x04, 0x6f, 0x6a, 0xa1, 0x88, 0x11, 0x4a, 0xd2, 0x17, 0xd8, 0x6e, 0xa6, 0x69, 0x48, 0xdc,
-	0x7f, 0xd9, 0xfc, 0x11, 0xfb, 0xd4, 0xbe, 0x82, 0x50, 0x24, 0x07, 0x4f, 0xd9, 0xe3, 0xc2, 0x7c,
-	0xec, 0x30, 0xbb, 0x14, 0x18, 0x48, 0x6c, 0x1d, 0x0b, 0x47, 0x63, 0x03, 0x71, 0x56, 0x4f, 0x67,
-	0x65, 0x25, 0x87, 0x6e, 0x17, 0x6b, 0xfe, 0x4c, 0x2a, 0x5b, 0x97, 0x49, 0xc9, 0x73, 0x7d, 0x60,
-	0x57, 0x24, 0x7d, 0xf6, 0xee, 0xe7, 0x9c, 0x82, 0x55, 0xb5, 0xef, 0x5a, 0x64, 0x9b, 0xd7, 0x28,
-	0x26, 0x5a, 0x43, 0xb6, 0xdf, 0x39, 0xdc, 0x17, 0xa2, 0xcb, 0xf8, 0x94, 0x8e, 0x37, 0xea, 0xd8,
-	0xb0, 0x2a, 0xa6, 0xff, 0xce, 0xb3, 0xb3, 0x68, 0x41, 0x93, 0x67, 0x6b, 0x61, 0x8a, 0xe1, 0x24,
-	0xa1, 0x8b, 0x54, 0xb5, 0x1e, 0x77, 0x2c, 0x29, 0xfc, 0x80, 0xab, 0x1b, 0x64, 0xcc, 0x1e, 0xec,
-	0x91, 0xc2, 0xad, 0x53, 0xa6, 0x55, 0x5a, 0x2a, 0x36, 0xed, 0x40, 0x76, 0x3b, 0x8a, 0xe6, 0x14,
-	0xe4, 0xa2, 0xc4, 0x63, 0x82, 0x1c, 0xf2, 0x66, 0xb8, 0xc0, 0x50, 0xf2, 0x40, 0x57, 0x2b, 0x34,
-	0x10, 0x78, 0xa9, 0x05, 0x4d, 0xd6, 0x90, 0x97, 0x86, 0x75, 0xed, 0xd7, 0xcd, 0x8b, 0xf4, 0xdd,
-	0xbc, 0xd4, 0x13, 0x5d, 0x67, 0xb0, 0x4d, 0xa5, 0xd5, 0x1f, 0x1c, 0xba, 0xf7, 0xcd, 0xa8, 0x7f,
-	0xa8, 0x3d, 0xdc, 0x3b, 0x8e, 0x1e, 0x70, 0x49, 0x61, 0xca, 0x5c, 0x77, 0x36, 0x85, 0x2a, 0xe0,
-	0x06, 0xc2, 0xdd, 0xf8, 0xf4, 0xf1, 0xef, 0x7f, 0x03, 0x00, 0x00, 0xff, 0xff, 0x97, 0x5a, 0xae,
-	0xa4, 0x31, 0x03, 0x00, 0x00,
+	// 268 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0xd3, 0xc1, 0x4a, 0xc3, 0x40,
+	0x18, 0x04, 0x60, 0x7b, 0x29, 0xf4, 0x97, 0x8a, 0x0d, 0x9e, 0x7a, 0xec, 0xc9, 0x4b, 0x13, 0xab,
+	0x16, 0xc1, 0x9b, 0xb5, 0x10, 0xc4, 0x08, 0x25, 0xe9, 0x0b, 0x6c, 0x37, 0xd3, 0x24, 0x24, 0xee,
+	0xbf, 0x6c, 0xfe, 0x88, 0x7d, 0x50, 0xdf, 0x47, 0x28, 0x92, 0x83, 0xa7, 0xec, 0x71, 0x61, 0x3e,
+	0x76, 0x98, 0x65, 0x69, 0x62, 0x20, 0xa1, 0x75, 0x2c, 0x1c, 0x8c, 0x0d, 0xc4, 0x59, 0x3d, 0x5f,
+	0x14, 0x95, 0x94, 0xdd, 0x21, 0xd4, 0xfc, 0x19, 0x55, 0xb6, 0x2e, 0xa2, 0x82, 0x97, 0xba, 0x64,
+	0x97, 0x47, 0x7d, 0xf6, 0xfe, 0x67, 0x4c, 0x93, 0x6d, 0x75, 0xec, 0x5a, 0xa4, 0xbb, 0xd7, 0x20,
+	0x24, 0x8a, 0x21, 0xfb, 0xef, 0x0c, 0xee, 0x0b, 0xc1, 0x55, 0x78, 0x4e, 0x87, 0x3b, 0x75, 0x6a,
+	0x58, 0xe5, 0xf3, 0x7f, 0xe7, 0xc5, 0x45, 0xb0, 0xa2, 0xe9, 0x8b, 0xb5, 0x30, 0xf9, 0x70, 0x12,
+	0xd1, 0x65, 0xa2, 0x5a, 0x8f, 0x3b, 0xd6, 0x34, 0xfb, 0x80, 0xab, 0x1b, 0xa4, 0xcc, 0x1e, 0xec,
+	0x89, 0x66, 0x7b, 0xa7, 0x4c, 0xab, 0xb4, 0x54, 0x6c, 0xda, 0x81, 0xec, 0x6e, 0x14, 0x2c, 0x69,
+	0x92, 0x89, 0x12, 0x8f, 0x09, 0x32, 0xc8, 0x9b, 0xe1, 0x1c, 0x43, 0xc9, 0x23, 0x5d, 0x6f, 0xd1,
+	0x40, 0xe0, 0xa5, 0x56, 0x34, 0x8d, 0x21, 0x9b, 0x86, 0x75, 0xed, 0xd7, 0xcd, 0x8b, 0xf4, 0xdd,
+	0xbc, 0xd4, 0x33, 0xdd, 0xa4, 0xb0, 0x4d, 0xa5, 0xd5, 0x1f, 0x1c, 0xba, 0xf7, 0xed, 0xa8, 0x7f,
+	0xa8, 0x23, 0xdc, 0x3b, 0x4e, 0x1e, 0x70, 0x4d, 0xb3, 0x84, 0xb9, 0xee, 0x6c, 0x02, 0x95, 0xc3,
+	0x79, 0x8c, 0xb2, 0x51, 0xa2, 0xcb, 0x18, 0xe2, 0x4b, 0xb2, 0xc1, 0xe4, 0x30, 0x3e, 0x7f, 0xaf,
+	0x87, 0xdf, 0x01, 0x00, 0x40, 0xaa, 0x69, 0xd4, 0x97, 0x03, 0x00, 0x00,
 }
@@ -1,6 +1,15 @@
 package difuse
 
-import chord "github.com/ipkg/go-chord"
+import (
+	"bytes"
+	"encoding/hex"
+	"strconv"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+
+	chord "github.com/ipkg/go-chord"
+)
 
 // ConsistencyLevel holds the consistency configuration for an operation
 type ConsistencyLevel uint8
@@ -18,11 +27,49 @@ const (
 
 const (
 	errInvalidConsistencyLevel = "invalid consistency level: %d"
+
+	// grpc metadata key carrying the consistency level of a request
+	consistencyMetadataKey = "difuse-consistency"
+	// grpc metadata key set when the request should wait for the tx to be applied
+	waitApplyMetadataKey = "difuse-wait-apply"
+	// grpc metadata key carrying the hex encoded commit token of a request or response
+	tokenMetadataKey = "difuse-token"
+	// grpc metadata key carrying the hex encoded value a conditional write expects
+	ifValueMetadataKey = "difuse-if-value"
+	// grpc metadata key set when a conditional write expects the key not to exist
+	ifAbsentMetadataKey = "difuse-if-absent"
+	// grpc metadata key carrying the code of the error of a response
+	errorCodeMetadataKey = "difuse-error-code"
 )
 
 // RequestOptions for a given operation.
 type RequestOptions struct {
 	Consistency ConsistencyLevel
+	// WaitApply makes writes wait until each vnode written to has applied the tx rather
+	// than returning once it is queued.  Apply errors are returned.
+	WaitApply bool
+	// Token is the commit token of an earlier write to the key.  Reads wait until the
+	// serving vnode has applied the write.
+	Token []byte
+	// If makes a write conditional on the current value of the key.  The condition is
+	// checked by the leader and the write fails with ErrConditionFailed if it does not
+	// hold.
+	If *Condition
+}
+
+// Condition is a condition on the current value of a key.
+type Condition struct {
+	// Value the key must currently have.  A nil value requires the key to not exist.
+	Value []byte
+}
+
+// holds returns whether the condition holds for the current value where nil is a key
+// that does not exist.
+func (c *Condition) holds(value []byte) bool {
+	if c.Value == nil || value == nil {
+		return c.Value == nil && value == nil
+	}
+	return bytes.Equal(c.Value, value)
 }
 
 // ReplRequest is a replication request.  It contains the source to destination vnode
@@ -32,3 +79,69 @@ type ReplRequest struct {
 	Dst *chord.Vnode
 	Key []byte
 }
+
+// optionsContext returns a child of the context carrying the options to the remote node.
+func optionsContext(ctx context.Context, opts *RequestOptions) context.Context {
+	if opts == nil {
+		return ctx
+	}
+	md := metadata.Pairs(consistencyMetadataKey, strconv.Itoa(int(opts.Consistency)))
+	if opts.WaitApply {
+		md[waitApplyMetadataKey] = []string{"1"}
+	}
+	if len(opts.Token) > 0 {
+		md[tokenMetadataKey] = []string{hex.EncodeToString(opts.Token)}
+	}
+	if opts.If != nil {
+		if opts.If.Value == nil {
+			md[ifAbsentMetadataKey] = []string{"1"}
+		} else {
+			md[ifValueMetadataKey] = []string{hex.EncodeToString(opts.If.Value)}
+		}
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// optionsFromContext returns the options sent by the remote node or nil if none were
+// sent.
+func optionsFromContext(ctx context.Context) *RequestOptions {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	vals := md[consistencyMetadataKey]
+	if len(vals) == 0 {
+		return nil
+	}
+
+	c, err := strconv.Atoi(vals[0])
+	if err != nil {
+		return nil
+	}
+
+	opts := &RequestOptions{Consistency: ConsistencyLevel(c)}
+	opts.WaitApply = len(md[waitApplyMetadataKey]) > 0
+	if vals = md[tokenMetadataKey]; len(vals) > 0 {
+		opts.Token, _ = hex.DecodeString(vals[0])
+	}
+	if len(md[ifAbsentMetadataKey]) > 0 {
+		opts.If = &Condition{}
+	} else if vals = md[ifValueMetadataKey]; len(vals) > 0 {
+		v, _ := hex.DecodeString(vals[0])
+		opts.If = &Condition{Value: append([]byte{}, v...)}
+	}
+	return opts
+}
+
+// waitApply returns whether the options request writes to wait for the tx to be applied.
+func waitApply(opts *RequestOptions) bool {
+	return opts != nil && opts.WaitApply
+}
+
+// readToken returns the commit token reads should wait for if any.
+func readToken(opts *RequestOptions) []byte {
+	if opts == nil {
+		return nil
+	}
+	return opts.Token
+}
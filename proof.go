@@ -0,0 +1,111 @@
+package difuse
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"golang.org/x/net/context"
+
+	chord "github.com/ipkg/go-chord"
+
+	"github.com/ipkg/difuse/txlog"
+)
+
+var errTxNotFound = errors.New("transaction not found")
+
+// InclusionProof proves a tx is part of the chain of its key and the key is part of the
+// store of a vnode.  TxRoot is the txroot of the key's inode once the tx is applied.
+type InclusionProof struct {
+	Vnode *chord.Vnode
+	Key   []byte
+	// Hash of the tx
+	Tx []byte
+	// Merkle root of the key's transactions and the proof of the tx within it
+	TxRoot  []byte
+	TxProof txlog.Proof
+	// Merkle root of the vnode store and the proof of the key's txroot within it
+	Root     []byte
+	KeyProof txlog.Proof
+}
+
+// Verify returns whether the tx is included in the txroot and the txroot in the store
+// root.  The roots are only as trustworthy as their source, so they should be compared
+// to ones obtained independently of the serving node.
+func (p *InclusionProof) Verify() bool {
+	return txlog.VerifyProof(p.Tx, p.TxRoot, p.TxProof) &&
+		txlog.VerifyProof(p.TxRoot, p.Root, p.KeyProof)
+}
+
+// MarshalJSON is a custom json encoder for legibility
+func (p *InclusionProof) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"vnode":     ShortVnodeID(p.Vnode),
+		"key":       string(p.Key),
+		"tx":        hex.EncodeToString(p.Tx),
+		"txroot":    hex.EncodeToString(p.TxRoot),
+		"tx_proof":  p.TxProof,
+		"root":      hex.EncodeToString(p.Root),
+		"key_proof": p.KeyProof,
+	})
+}
+
+// vnodeProof returns the inclusion proof of the tx from the store of a vnode.
+func vnodeProof(st VnodeStore, key, txhash []byte) (*InclusionProof, error) {
+	sp, err := st.ProofTx(key, txhash)
+	if err != nil {
+		return nil, errTxNotFound
+	}
+
+	return &InclusionProof{
+		Key:      key,
+		Tx:       txhash,
+		TxRoot:   sp.TxRoot,
+		TxProof:  sp.TxProof,
+		Root:     sp.Root,
+		KeyProof: sp.KeyProof,
+	}, nil
+}
+
+// Proof returns the inclusion proofs of the tx with the given hash from each replica of
+// the key holding it.
+func (s *Difuse) Proof(ctx context.Context, key, txhash []byte) ([]*InclusionProof, error) {
+	vl, err := s.ring.Lookup(s.config.Chord.NumSuccessors, key)
+	if err != nil {
+		return nil, err
+	}
+	return s.proof(ctx, key, txhash, vl)
+}
+
+// proof returns the inclusion proofs of the tx from the vnodes holding it.  If none do the
+// first error other than not found is returned.
+func (s *Difuse) proof(ctx context.Context, key, txhash []byte, vl []*chord.Vnode) ([]*InclusionProof, error) {
+	resp, _ := fanout(ctx, vl, fanoutAll, func(ctx context.Context, vns ...*chord.Vnode) ([]*VnodeResponse, error) {
+		return s.transport.ProofTx(ctx, key, txhash, nil, vns...)
+	})
+
+	var (
+		out []*InclusionProof
+		err error
+	)
+	for i, rsp := range resp {
+		if rsp.Err != nil {
+			if err == nil && ErrorCodeOf(rsp.Err) != CodeNotFound {
+				err = rsp.Err
+			}
+			continue
+		}
+		if p, ok := rsp.Data.(*InclusionProof); ok {
+			p.Vnode, p.Key, p.Tx = vl[i], key, txhash
+			out = append(out, p)
+		}
+	}
+
+	if len(out) > 0 {
+		return out, nil
+	}
+	if err == nil {
+		err = errTxNotFound
+	}
+	return nil, err
+}
@@ -0,0 +1,80 @@
+package difuse
+
+import (
+	"encoding/json"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	chord "github.com/ipkg/go-chord"
+
+	"github.com/ipkg/difuse/store"
+	"github.com/ipkg/difuse/txlog"
+)
+
+func TestDifuseProof(t *testing.T) {
+	kp, _ := txlog.GenerateECDSAKeypair()
+	vn := &chord.Vnode{Id: []byte("vnode-id"), Host: "host"}
+	st := store.NewMemLoggedStore(vn, kp)
+
+	lt := &localTransport{host: vn.Host, local: localStore{vn.String(): st}, vnodes: []*chord.Vnode{vn}}
+	s := &Difuse{transport: lt}
+
+	var last *txlog.Tx
+	for _, k := range []string{"a", "b", "c"} {
+		for i := 0; i < 3; i++ {
+			tx, _ := st.NewTx([]byte(k))
+			tx.Data = []byte{0xff}
+			tx.Sign(kp)
+			st.AppendTx(tx)
+			last = tx
+		}
+	}
+	if err := st.WaitTx(context.Background(), last.Key, last.Hash()); err == nil {
+		t.Fatal("invalid tx type should fail to apply")
+	}
+
+	vl := []*chord.Vnode{vn}
+	proofs, err := s.proof(context.Background(), last.Key, last.Hash(), vl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proofs) != 1 {
+		t.Fatal("should have a proof per vnode", len(proofs))
+	}
+
+	p := proofs[0]
+	if !p.Verify() {
+		t.Fatal("proof should verify")
+	}
+	if txroot, _ := st.MerkleRootTx(last.Key); !txlog.EqualBytes(txroot, p.TxRoot) {
+		t.Fatal("txroot mismatch")
+	}
+
+	p.Tx = txlog.ZeroHash()
+	if p.Verify() {
+		t.Fatal("should not verify another tx")
+	}
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	json.Unmarshal(b, &m)
+	if m["key"] != "c" || m["txroot"] == "" {
+		t.Fatal("wrong json", string(b))
+	}
+
+	// Proofs survive the wire
+	rsp, _ := lt.local.ProofTx(last.Key, last.Hash(), nil, vl...)
+	wp := deserializeVnodeProofList(serializeVnodeProofList(rsp))[0].Data.(*InclusionProof)
+	wp.Key, wp.Tx = last.Key, last.Hash()
+	if !wp.Verify() {
+		t.Fatal("deserialized proof should verify")
+	}
+
+	if _, err = s.proof(context.Background(), []byte("a"), txlog.ZeroHash(), vl); ErrorCodeOf(err) != CodeNotFound {
+		t.Fatal("should not be found", err)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 
+	"golang.org/x/net/context"
+
 	"github.com/ipkg/difuse/txlog"
 	chord "github.com/ipkg/go-chord"
 )
@@ -15,9 +17,9 @@ import (
 
 // Queue a key replication request on the given vnode.  The leader for the key is used
 // as the source vnode and the provided as destination.
-func (s *Difuse) requestVnodeKeyRepair(dst *chord.Vnode, key []byte) error {
+func (s *Difuse) requestVnodeKeyRepair(ctx context.Context, dst *chord.Vnode, key []byte) error {
 	// Get leader and vnodes
-	l, _, vm, err := s.LookupLeader(key)
+	l, _, vm, err := s.LookupLeader(ctx, key)
 	if err != nil {
 		return err
 	}
@@ -110,8 +112,13 @@ func (s *Difuse) startReplEngine() {
 			seek = ltx.Hash()
 		}
 
-		// Replicate transactions from remote to the vnode store. Error intentionally
-		// not caught, as it is inconsiquential
-		s.transport.ReplicateTransactions(req.Key, seek, req.Src, req.Dst)
+		// Replicate transactions from remote to the vnode store.  Errors other than
+		// a diverging chain are inconsiquential.
+		err = s.transport.ReplicateTransactions(context.Background(), req.Key, seek, req.Src, req.Dst)
+		if txlog.IsPrevHashError(err) {
+			if _, err = s.ResolveFork(context.Background(), req.Key); err != nil {
+				log.Printf("action=resolve-fork status=failed key='%s' msg='%v'", req.Key, err)
+			}
+		}
 	}
 }
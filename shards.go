@@ -0,0 +1,368 @@
+package difuse
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/fastsha256"
+	"golang.org/x/net/context"
+
+	chord "github.com/ipkg/go-chord"
+
+	"github.com/ipkg/difuse/erasure"
+	"github.com/ipkg/difuse/store"
+)
+
+var (
+	errTooFewHosts      = errors.New("too few hosts to place shards")
+	errNotErasureCoded  = errors.New("not erasure coded")
+	errShardUnavailable = errors.New("shard unavailable")
+)
+
+// BlockTransport gets and sets blocks on specific vnodes.  It is satisfied by any
+// Transport.
+type BlockTransport interface {
+	SetBlock(context.Context, []byte, *RequestOptions, ...*chord.Vnode) ([]*VnodeResponse, error)
+	GetBlock(context.Context, []byte, *RequestOptions, ...*chord.Vnode) ([]*VnodeResponse, error)
+}
+
+// ErasureClass stores the values of keys matching the prefix as data and parity shards
+// placed on distinct hosts rather than as full copies on every successor.  The value can
+// be read as long as any DataShards of the shards are available.
+type ErasureClass struct {
+	Prefix       string
+	DataShards   int
+	ParityShards int
+}
+
+// ParseErasureClasses parses a comma separated list of classes of the form
+// prefix:data:parity
+func ParseErasureClasses(s string) ([]*ErasureClass, error) {
+	out := []*ErasureClass{}
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		pp := strings.Split(v, ":")
+		if len(pp) != 3 {
+			return nil, fmt.Errorf("invalid erasure class: %s", v)
+		}
+		k, err := strconv.Atoi(pp[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid erasure class: %s", v)
+		}
+		m, err := strconv.Atoi(pp[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid erasure class: %s", v)
+		}
+		if _, err = erasure.New(k, m); err != nil {
+			return nil, err
+		}
+
+		out = append(out, &ErasureClass{Prefix: pp[0], DataShards: k, ParityShards: m})
+	}
+	return out, nil
+}
+
+// MatchErasureClass returns the class with the longest prefix matching the key or nil if
+// the key is not erasure coded.
+func MatchErasureClass(classes []*ErasureClass, key []byte) *ErasureClass {
+	var ec *ErasureClass
+	for _, c := range classes {
+		if bytes.HasPrefix(key, []byte(c.Prefix)) && (ec == nil || len(c.Prefix) > len(ec.Prefix)) {
+			ec = c
+		}
+	}
+	return ec
+}
+
+func (cfg *Config) erasureClass(key []byte) *ErasureClass {
+	return MatchErasureClass(cfg.ErasureClasses, key)
+}
+
+// ShardVnodes returns the first vnode of each distinct host in the successor list of a
+// key.  Shard i of the key is placed on vnode i.
+func ShardVnodes(vl []*chord.Vnode) []*chord.Vnode {
+	seen := make(map[string]bool)
+	out := make([]*chord.Vnode, 0, len(vl))
+	for _, vn := range vl {
+		if !seen[vn.Host] {
+			seen[vn.Host] = true
+			out = append(out, vn)
+		}
+	}
+	return out
+}
+
+func (s *Difuse) shardVnodes(key []byte) ([]*chord.Vnode, error) {
+	vl, err := s.ring.Lookup(s.config.Chord.NumSuccessors, key)
+	if err != nil {
+		return nil, err
+	}
+	return ShardVnodes(vl), nil
+}
+
+// WriteShards erasure codes the data with the class writing shard i to the placement
+// vnode i.  It returns the hashes of the shards in order.
+func WriteShards(ctx context.Context, trans BlockTransport, vns []*chord.Vnode, data []byte, ec *ErasureClass) ([][]byte, error) {
+	enc, err := erasure.New(ec.DataShards, ec.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+	shards, err := enc.Split(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(vns) < len(shards) {
+		return nil, errTooFewHosts
+	}
+
+	hashes := make([][]byte, len(shards))
+	for i, sd := range shards {
+		shard := &erasure.Shard{DataShards: ec.DataShards, ParityShards: ec.ParityShards, Index: i, Data: sd}
+		if hashes[i], err = writeShard(ctx, trans, shard, vns[i]); err != nil {
+			return nil, err
+		}
+	}
+	return hashes, nil
+}
+
+// writeShard writes the framed shard to the vnode returning its hash.
+func writeShard(ctx context.Context, trans BlockTransport, shard *erasure.Shard, vn *chord.Vnode) ([]byte, error) {
+	blob := shard.Bytes()
+	resp, err := trans.SetBlock(ctx, blob, &RequestOptions{Consistency: ConsistencyAll}, vn)
+	if err != nil {
+		return nil, err
+	}
+	if resp[0].Err != nil {
+		return nil, resp[0].Err
+	}
+
+	sh := fastsha256.Sum256(blob)
+	return sh[:], nil
+}
+
+// getShard gets the shard with the hash trying the vnode at the preferred index first
+// followed by the remaining vnodes.  It returns whether the shard was found on the
+// preferred vnode.
+func getShard(ctx context.Context, trans BlockTransport, hash []byte, vns []*chord.Vnode, pref int) (*erasure.Shard, bool, error) {
+	opts := &RequestOptions{Consistency: ConsistencyLazy}
+	err := errShardUnavailable
+
+	order := make([]*chord.Vnode, 0, len(vns))
+	if pref < len(vns) {
+		order = append(order, vns[pref])
+	}
+	for i, vn := range vns {
+		if i != pref {
+			order = append(order, vn)
+		}
+	}
+
+	for i, vn := range order {
+		resp, er := trans.GetBlock(ctx, hash, opts, vn)
+		if er != nil {
+			err = er
+			continue
+		}
+		blob, ok := resp[0].Data.([]byte)
+		if resp[0].Err != nil || !ok {
+			continue
+		}
+		// Skip corrupt shards
+		if sh := fastsha256.Sum256(blob); !bytes.Equal(sh[:], hash) {
+			continue
+		}
+
+		shard, er := erasure.ParseShard(blob)
+		if er != nil {
+			err = er
+			continue
+		}
+		return shard, i == 0 && pref < len(vns), nil
+	}
+
+	return nil, false, err
+}
+
+// fetchShards gets the shards of the inode from the placement vnodes.  Missing shards are
+// nil.  If all is false it stops once enough shards have been fetched to rebuild the data.
+// It returns the shards, which of them were found on their placement vnode and the
+// encoder for the inode.
+func fetchShards(ctx context.Context, trans BlockTransport, vns []*chord.Vnode, inode *store.Inode, all bool) ([][]byte, []bool, *erasure.Encoder, error) {
+	var (
+		shards = make([][]byte, len(inode.Blocks))
+		placed = make([]bool, len(inode.Blocks))
+		enc    *erasure.Encoder
+		cnt    int
+		err    error
+	)
+
+	for i, h := range inode.Blocks {
+		shard, ok, er := getShard(ctx, trans, h, vns, i)
+		if er != nil {
+			err = er
+			continue
+		}
+		if shard.Index != i || shard.DataShards+shard.ParityShards != len(inode.Blocks) {
+			err = errShardUnavailable
+			continue
+		}
+		if enc == nil {
+			if enc, err = erasure.New(shard.DataShards, shard.ParityShards); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+
+		shards[i] = shard.Data
+		placed[i] = ok
+		cnt++
+		if !all && cnt == enc.DataShards() {
+			break
+		}
+	}
+
+	if enc == nil || cnt < enc.DataShards() {
+		if err == nil {
+			err = errShardUnavailable
+		}
+		return nil, nil, nil, err
+	}
+	return shards, placed, enc, nil
+}
+
+// ReadShards rebuilds the data written by WriteShards for the inode from any k of its
+// shards.
+func ReadShards(ctx context.Context, trans BlockTransport, vns []*chord.Vnode, inode *store.Inode) ([]byte, error) {
+	shards, _, enc, err := fetchShards(ctx, trans, vns, inode, false)
+	if err != nil {
+		return nil, err
+	}
+	return enc.Join(shards)
+}
+
+// readShards rebuilds the value of an erasure coded inode decrypting and decompressing it.
+func (s *Difuse) readShards(ctx context.Context, inode *store.Inode) ([]byte, error) {
+	vns, err := s.shardVnodes(inode.Id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ReadShards(ctx, s.transport, vns, inode)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(inode.Keys) > 0 {
+		if s.config.BlockCipher == nil {
+			return nil, errNoBlockCipher
+		}
+		if data, err = s.config.BlockCipher.Decrypt(data, inode.Keys[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	return store.Decompress(data)
+}
+
+// RepairShards regenerates the shards of an erasure coded key missing from their
+// placement vnodes, such as after a node failure or the ring changing.  It returns the
+// number of shards regenerated.
+func (s *Difuse) RepairShards(ctx context.Context, key []byte) (int, error) {
+	inode, _, err := s.Stat(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if inode.Type != store.ErasureInodeType {
+		return 0, errNotErasureCoded
+	}
+
+	vns, err := s.shardVnodes(key)
+	if err != nil {
+		return 0, err
+	}
+	shards, placed, enc, err := fetchShards(ctx, s.transport, vns, inode, true)
+	if err != nil {
+		return 0, err
+	}
+
+	missing := []int{}
+	for i, ok := range placed {
+		if !ok {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+
+	if err = enc.Reconstruct(shards); err != nil {
+		return 0, err
+	}
+
+	var n int
+	for _, i := range missing {
+		if i >= len(vns) {
+			err = errTooFewHosts
+			continue
+		}
+
+		shard := &erasure.Shard{
+			DataShards:   enc.DataShards(),
+			ParityShards: enc.ParityShards(),
+			Index:        i,
+			Data:         shards[i],
+		}
+		if _, er := writeShard(ctx, s.transport, shard, vns[i]); er != nil {
+			err = er
+			continue
+		}
+		n++
+	}
+
+	return n, err
+}
+
+// localErasureKeys returns the erasure coded keys for which this host holds the first
+// placement vnode.
+func (s *Difuse) localErasureKeys() [][]byte {
+	_, stores := s.transport.localVnodes()
+
+	seen := make(map[string]bool)
+	keys := [][]byte{}
+	for _, st := range stores {
+		st.IterInodes(func(key []byte, inode *store.Inode) error {
+			if inode.Type != store.ErasureInodeType || seen[string(key)] {
+				return nil
+			}
+			seen[string(key)] = true
+
+			if vns, err := s.shardVnodes(key); err == nil && len(vns) > 0 && s.isLeader(vns[0]) {
+				keys = append(keys, key)
+			}
+			return nil
+		})
+	}
+	return keys
+}
+
+// startShardRepair periodically repairs the erasure coded keys this host is the first
+// placement vnode for so only one host repairs each key.
+func (s *Difuse) startShardRepair() {
+	for range time.Tick(s.config.ShardRepairInterval) {
+		for _, key := range s.localErasureKeys() {
+			n, err := s.RepairShards(context.Background(), key)
+			if err != nil {
+				log.Printf("action=repair-shards status=failed key='%s' msg='%v'", key, err)
+			} else if n > 0 {
+				log.Printf("action=repair-shards status=ok key='%s' count=%d", key, n)
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package difuse
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"sort"
+
+	"golang.org/x/net/context"
+
+	chord "github.com/ipkg/go-chord"
+
+	"github.com/ipkg/difuse/store"
+)
+
+// VnodeStatus is the status of a local vnode.
+type VnodeStatus struct {
+	Vnode      *chord.Vnode   `json:"vnode"`
+	Successors []*chord.Vnode `json:"successors"`
+	Keys       int            `json:"keys"`
+	Blocks     int            `json:"blocks"`
+	// Set while the vnode waits to be bootstrapped by its successor
+	Bootstrapping bool `json:"bootstrapping,omitempty"`
+}
+
+// RingStatus is the view of the ring from this node.
+type RingStatus struct {
+	Host string `json:"host"`
+	// Hosts seen in the successor lists of the local vnodes
+	Hosts  []string       `json:"hosts"`
+	Vnodes []*VnodeStatus `json:"vnodes"`
+}
+
+// RingStatus returns the local vnodes along with their successors and the number of
+// keys and blocks they hold.
+func (s *Difuse) RingStatus() (*RingStatus, error) {
+	vns, sts := s.transport.localVnodes()
+
+	rs := &RingStatus{Host: s.config.Chord.Hostname, Vnodes: make([]*VnodeStatus, len(vns))}
+	hosts := map[string]bool{rs.Host: true}
+
+	for i, vn := range vns {
+		vl, err := s.ring.Lookup(s.config.Chord.NumSuccessors+1, vn.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		vs := &VnodeStatus{Vnode: vn, Successors: []*chord.Vnode{}, Bootstrapping: !storeReady(sts[i])}
+		for _, v := range vl {
+			if !bytes.Equal(v.Id, vn.Id) {
+				vs.Successors = append(vs.Successors, v)
+				hosts[v.Host] = true
+			}
+		}
+
+		sts[i].IterInodes(func([]byte, *store.Inode) error {
+			vs.Keys++
+			return nil
+		})
+		sts[i].IterBlocks(func([]byte, []byte) error {
+			vs.Blocks++
+			return nil
+		})
+		rs.Vnodes[i] = vs
+	}
+
+	for h := range hosts {
+		rs.Hosts = append(rs.Hosts, h)
+	}
+	sort.Strings(rs.Hosts)
+
+	return rs, nil
+}
+
+// LocalKeys returns the sorted keys with the prefix held by the local vnodes.
+func (s *Difuse) LocalKeys(prefix []byte) []string {
+	_, sts := s.transport.localVnodes()
+
+	seen := make(map[string]bool)
+	for _, st := range sts {
+		st.IterInodes(func(key []byte, inode *store.Inode) error {
+			if bytes.HasPrefix(key, prefix) {
+				seen[string(key)] = true
+			}
+			return nil
+		})
+	}
+
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// HistoryEntry is a single transaction in the history of a key.
+type HistoryEntry struct {
+	ID     string `json:"id"`
+	Prev   string `json:"prev"`
+	Op     string `json:"op"`
+	Signer string `json:"signer"`
+	Epoch  uint64 `json:"epoch"`
+}
+
+// History returns the transactions of the key from its leader oldest first.
+func (s *Difuse) History(ctx context.Context, key []byte) ([]*HistoryEntry, error) {
+	l, _, _, err := s.LookupLeader(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	txs, err := s.transport.Transactions(ctx, key, nil, l)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*HistoryEntry, len(txs))
+	for i, tx := range txs {
+		he := &HistoryEntry{
+			ID:     hex.EncodeToString(tx.Hash()),
+			Prev:   hex.EncodeToString(tx.PrevHash),
+			Signer: string(tx.Source),
+			Epoch:  tx.Epoch,
+			Op:     "unknown",
+		}
+		if len(tx.Data) > 0 {
+			switch tx.Data[0] {
+			case store.TxTypeSet:
+				he.Op = "set"
+			case store.TxTypeDelete:
+				he.Op = "delete"
+			}
+		}
+		out[i] = he
+	}
+	return out, nil
+}
+
+// Snapshot returns a snapshot of the local vnode with the id.
+func (s *Difuse) Snapshot(id []byte) (io.ReadCloser, error) {
+	vns, sts := s.transport.localVnodes()
+	for i, vn := range vns {
+		if bytes.Equal(vn.Id, id) {
+			return sts[i].Snapshot()
+		}
+	}
+	return nil, errStoreNotFound
+}
@@ -3,7 +3,11 @@ package difuse
 import (
 	"errors"
 	"fmt"
+	"io"
 
+	"golang.org/x/net/context"
+
+	"github.com/ipkg/difuse/store"
 	"github.com/ipkg/difuse/txlog"
 	chord "github.com/ipkg/go-chord"
 )
@@ -24,14 +28,24 @@ func (nls localStore) GetStore(id []byte) (VnodeStore, error) {
 	return nil, errStoreNotFound
 }
 
+// readStore returns the store for the given id if it is serving reads.  A vnode still
+// being bootstrapped returns errBootstrapping.
+func (nls localStore) readStore(id []byte) (VnodeStore, error) {
+	st, err := nls.GetStore(id)
+	if err == nil && !storeReady(st) {
+		err = errBootstrapping
+	}
+	return st, err
+}
+
 func (nls localStore) ReplicateTransactions(key, seek []byte, src, dst *chord.Vnode) error {
 	// Source vnode
-	sstore, err := nls.GetStore(src.Id)
+	sstore, err := nls.readStore(src.Id)
 	if err != nil {
 		return err
 	}
 	// Dest vnode
-	dstore, err := nls.GetStore(src.Id)
+	dstore, err := nls.GetStore(dst.Id)
 	if err != nil {
 		return err
 	}
@@ -41,35 +55,34 @@ func (nls localStore) ReplicateTransactions(key, seek []byte, src, dst *chord.Vn
 		return err
 	}
 	for _, tx := range txs {
-		if e := dstore.AppendTx(tx); e != nil {
+		if e := dstore.ReplicateTx(tx); e != nil {
 			err = e
 		}
 	}
 	return err
 }
 
-/*// Snapshot snapshots a vnode store returning a Reader
-func (nls localStore) Snapshot(vn *chord.Vnode) (io.ReadCloser, error) {
-	st := nls.GetStore(vn.Id)
-	if st == nil {
-		return nil, fmt.Errorf("target vn not found: %s", vn.String())
+// Transactions returns the transactions for the key from the given vnode.
+func (nls localStore) Transactions(key, seek []byte, vn *chord.Vnode) (txlog.TxSlice, error) {
+	st, err := nls.readStore(vn.Id)
+	if err != nil {
+		return nil, err
 	}
-
-	return st.Snapshot()
+	return st.Transactions(key, seek)
 }
 
-// Restore data to the local vnode from the reader
-func (nls localStore) Restore(vn *chord.Vnode, r io.Reader) error {
-	st := nls.GetStore(vn.Id)
-	if st == nil {
-		return fmt.Errorf("target vn not found: %s", vn.String())
+// Snapshot returns a snapshot of the data selected by the filter from the local vnode.
+func (nls localStore) Snapshot(vn *chord.Vnode, filter *store.SnapshotFilter) (io.ReadCloser, error) {
+	st, err := nls.GetStore(vn.Id)
+	if err != nil {
+		return nil, err
 	}
+	return st.FilteredSnapshot(filter)
+}
 
-	return st.Restore(r)
-}*/
-
-// Stat gets a key from the local stores based on the consistency level.  All vnodes in the slice are assumed to be local vnodes
-func (nls localStore) Stat(key []byte, opts *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+// Stat gets a key from the local stores based on the consistency level.  All vnodes in the slice are assumed to be local vnodes.
+// If the options carry a commit token each vnode first waits until it has applied the write.
+func (nls localStore) Stat(ctx context.Context, key []byte, opts *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
 	/*opts := DefaultRequestOptions()
 	if len(options) > 0 {
 		opts = options[0]
@@ -99,7 +112,12 @@ func (nls localStore) Stat(key []byte, opts *RequestOptions, vs ...*chord.Vnode)
 
 	for i, vn := range vs {
 		r := &VnodeResponse{Id: vn.Id}
-		store, err := nls.GetStore(vn.Id)
+		store, err := nls.readStore(vn.Id)
+		if err == nil {
+			if token := readToken(opts); token != nil {
+				err = store.WaitTx(ctx, key, token)
+			}
+		}
 		if err == nil {
 			r.Data, r.Err = store.Stat(key)
 		} else {
@@ -130,7 +148,7 @@ func (nls localStore) MerkleRootTx(key []byte, opts *RequestOptions, vs ...*chor
 
 	for i, vn := range vs {
 		r := &VnodeResponse{Id: vn.Id, Data: []byte{}}
-		store, err := nls.GetStore(vn.Id)
+		store, err := nls.readStore(vn.Id)
 		if err == nil {
 			r.Data, r.Err = store.MerkleRootTx(key)
 		} else {
@@ -142,16 +160,37 @@ func (nls localStore) MerkleRootTx(key []byte, opts *RequestOptions, vs ...*chor
 	return resp, nil
 }
 
+// ProofTx returns the inclusion proof of the tx from each of the given local vnodes.
+func (nls localStore) ProofTx(key, txhash []byte, opts *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+	resp := make([]*VnodeResponse, len(vs))
+
+	for i, vn := range vs {
+		r := &VnodeResponse{Id: vn.Id}
+		store, err := nls.readStore(vn.Id)
+		if err == nil {
+			r.Data, r.Err = vnodeProof(store, key, txhash)
+		} else {
+			r.Err = err
+		}
+		resp[i] = r
+	}
+
+	return resp, nil
+}
+
 // AppendTx appends a keyed tx to the given vnodes.  All vnodes in the slice are assumed to be local vnodes.
+// If the options request it, each vnode waits until it has applied the tx.
 // TODO: support consistency level
-func (nls localStore) AppendTx(tx *txlog.Tx, opts *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
+func (nls localStore) AppendTx(ctx context.Context, tx *txlog.Tx, opts *RequestOptions, vs ...*chord.Vnode) ([]*VnodeResponse, error) {
 	resp := make([]*VnodeResponse, len(vs))
 
 	for i, vn := range vs {
 		r := &VnodeResponse{Id: vn.Id, Data: []byte{}}
 		store, err := nls.GetStore(vn.Id)
 		if err == nil {
-			r.Err = store.AppendTx(tx)
+			if r.Err = store.AppendTx(tx); r.Err == nil && waitApply(opts) {
+				r.Err = store.WaitTx(ctx, tx.Key, tx.Hash())
+			}
 		} else {
 			r.Err = err
 		}
@@ -161,6 +200,35 @@ func (nls localStore) AppendTx(tx *txlog.Tx, opts *RequestOptions, vs ...*chord.
 	return resp, nil
 }
 
+// AppendTxs appends the txs of each batch to its vnode returning a response per tx in the
+// order of the batches.  All vnodes are assumed to be local vnodes.  The txs of a vnode are
+// all queued before waiting for any to apply so they are committed together.
+func (nls localStore) AppendTxs(ctx context.Context, batches []*VnodeTxs, opts *RequestOptions) ([]*VnodeResponse, error) {
+	var resp []*VnodeResponse
+
+	for _, b := range batches {
+		rs := make([]*VnodeResponse, len(b.Txs))
+		store, err := nls.GetStore(b.Vnode.Id)
+		for i, tx := range b.Txs {
+			rs[i] = &VnodeResponse{Id: b.Vnode.Id, Data: []byte{}, Err: err}
+			if err == nil {
+				rs[i].Err = store.AppendTx(tx)
+			}
+		}
+
+		if err == nil && waitApply(opts) {
+			for i, tx := range b.Txs {
+				if rs[i].Err == nil {
+					rs[i].Err = store.WaitTx(ctx, tx.Key, tx.Hash())
+				}
+			}
+		}
+		resp = append(resp, rs...)
+	}
+
+	return resp, nil
+}
+
 // GetTx gets a keyed tx from multiple vnodes based on the specified consistency.  All vnodes in the slice are assumed to be local vnodes
 func (nls localStore) GetTx(key, txhash []byte, opts *RequestOptions, ids ...*chord.Vnode) ([]*VnodeResponse, error) {
 	/*opts := DefaultRequestOptions()
@@ -201,7 +269,7 @@ func (nls localStore) GetTx(key, txhash []byte, opts *RequestOptions, ids ...*ch
 	resp := make([]*VnodeResponse, len(ids))
 	for i, vn := range ids {
 		r := &VnodeResponse{Id: vn.Id}
-		store, err := nls.GetStore(vn.Id)
+		store, err := nls.readStore(vn.Id)
 		if err == nil {
 			r.Data, r.Err = store.GetTx(key, txhash)
 		} else {
@@ -258,7 +326,7 @@ func (nls localStore) LastTx(key []byte, opts *RequestOptions, ids ...*chord.Vno
 	resp := make([]*VnodeResponse, len(ids))
 	for i, vn := range ids {
 		r := &VnodeResponse{Id: vn.Id}
-		store, err := nls.GetStore(vn.Id)
+		store, err := nls.readStore(vn.Id)
 		if err == nil {
 			r.Data, r.Err = store.LastTx(key)
 		} else {
@@ -296,7 +364,7 @@ func (nls localStore) GetBlock(key []byte, opts *RequestOptions, ids ...*chord.V
 
 	for i, vn := range ids {
 		r := &VnodeResponse{Id: vn.Id}
-		store, err := nls.GetStore(vn.Id)
+		store, err := nls.readStore(vn.Id)
 		if err == nil {
 			r.Data, r.Err = store.GetBlock(key)
 		} else {
@@ -0,0 +1,252 @@
+package store
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Codec identifies the compression applied to a block or inline value.
+type Codec byte
+
+const (
+	// CodecNone is uncompressed data
+	CodecNone Codec = iota
+	// CodecGzip is gzip compressed data
+	CodecGzip
+	// CodecFlate is raw deflate compressed data
+	CodecFlate
+)
+
+// Compressed data is prefixed with the magic bytes followed by the codec.  Data without
+// the prefix is treated as uncompressed so untagged values remain readable.
+var codecMagic = []byte{0xdf, 0x7a}
+
+// First byte of all framed data i.e. tagged data and erasure coded shards.  Blocks are
+// never stored starting with it unless framed.
+const frameByte = 0xdf
+
+const codecHeaderSize = 3
+
+// MaxBlockSize is the max size of the uncompressed data of a block.  Compressed blocks
+// are only decompressed up to this size so small blocks cannot expand without bound.
+var MaxBlockSize int64 = 64 << 20
+
+// Compressor compresses and decompresses data for a codec.
+type Compressor interface {
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// StreamDecompressor is implemented by compressors able to decompress incrementally.  The
+// size of the data decompressed by these is bounded without decompressing all of it.
+type StreamDecompressor interface {
+	NewReader(data []byte) (io.ReadCloser, error)
+}
+
+type codecEntry struct {
+	name string
+	comp Compressor
+}
+
+var (
+	codecLock sync.RWMutex
+	codecs    = map[Codec]*codecEntry{
+		CodecGzip:  {name: "gzip", comp: gzipCompressor{}},
+		CodecFlate: {name: "flate", comp: flateCompressor{}},
+	}
+)
+
+// RegisterCodec registers a compressor for the codec allowing additional algorithms to
+// be used.  Every node in the cluster must register the codec to read the data.
+func RegisterCodec(c Codec, name string, comp Compressor) {
+	codecLock.Lock()
+	codecs[c] = &codecEntry{name: name, comp: comp}
+	codecLock.Unlock()
+}
+
+func lookupCodec(c Codec) (*codecEntry, bool) {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	e, ok := codecs[c]
+	return e, ok
+}
+
+func (c Codec) String() string {
+	if c == CodecNone {
+		return "none"
+	}
+	if e, ok := lookupCodec(c); ok {
+		return e.name
+	}
+	return "unknown"
+}
+
+// ParseCodec returns the registered codec with the given name.
+func ParseCodec(name string) (Codec, error) {
+	if name == "none" || name == "" {
+		return CodecNone, nil
+	}
+
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	for c, e := range codecs {
+		if e.name == name {
+			return c, nil
+		}
+	}
+	return CodecNone, fmt.Errorf("unsupported codec: %s", name)
+}
+
+// CodecOf returns the codec the data was compressed with.
+func CodecOf(data []byte) Codec {
+	if len(data) >= codecHeaderSize && bytes.HasPrefix(data, codecMagic) {
+		return Codec(data[2])
+	}
+	return CodecNone
+}
+
+// Compress compresses the data with the codec prefixing it with the codec tag.  The data
+// is left uncompressed if compression does not reduce its size.
+func Compress(c Codec, data []byte) ([]byte, error) {
+	if c != CodecNone {
+		e, ok := lookupCodec(c)
+		if !ok {
+			return nil, fmt.Errorf("unsupported codec: %d", c)
+		}
+
+		cd, err := e.comp.Compress(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(cd)+codecHeaderSize < len(data) {
+			return tagCodec(c, cd), nil
+		}
+	}
+
+	// Tag uncompressed data that happens to start like framed data so it is not mistaken
+	// for compressed data or a shard.
+	if len(data) > 0 && data[0] == frameByte {
+		return tagCodec(CodecNone, data), nil
+	}
+	return data, nil
+}
+
+// Decompress returns the uncompressed data.  Untagged data is returned as is.
+func Decompress(data []byte) ([]byte, error) {
+	if len(data) < codecHeaderSize || !bytes.HasPrefix(data, codecMagic) {
+		return data, nil
+	}
+
+	c := Codec(data[2])
+	if c == CodecNone {
+		return data[codecHeaderSize:], nil
+	}
+
+	e, ok := lookupCodec(c)
+	if !ok {
+		return nil, fmt.Errorf("unsupported codec: %d", c)
+	}
+	return e.comp.Decompress(data[codecHeaderSize:])
+}
+
+// DecompressLimit returns the uncompressed data failing with ErrBlockTooLarge if it
+// exceeds max bytes.
+func DecompressLimit(data []byte, max int64) ([]byte, error) {
+	if len(data) < codecHeaderSize || !bytes.HasPrefix(data, codecMagic) || Codec(data[2]) == CodecNone {
+		raw, _ := Decompress(data)
+		if int64(len(raw)) > max {
+			return nil, ErrBlockTooLarge
+		}
+		return raw, nil
+	}
+
+	e, ok := lookupCodec(Codec(data[2]))
+	if !ok {
+		return nil, fmt.Errorf("unsupported codec: %d", data[2])
+	}
+
+	var (
+		raw []byte
+		err error
+	)
+	if sd, ok := e.comp.(StreamDecompressor); ok {
+		var r io.ReadCloser
+		if r, err = sd.NewReader(data[codecHeaderSize:]); err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		raw, err = ioutil.ReadAll(io.LimitReader(r, max+1))
+	} else {
+		raw, err = e.comp.Decompress(data[codecHeaderSize:])
+	}
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(raw)) > max {
+		return nil, ErrBlockTooLarge
+	}
+	return raw, nil
+}
+
+func tagCodec(c Codec, data []byte) []byte {
+	out := make([]byte, codecHeaderSize+len(data))
+	copy(out, codecMagic)
+	out[2] = byte(c)
+	copy(out[codecHeaderSize:], data)
+	return out
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	err := w.Close()
+	return buf.Bytes(), err
+}
+
+func (c gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := c.NewReader(data)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (gzipCompressor) NewReader(data []byte) (io.ReadCloser, error) {
+	return gzip.NewReader(bytes.NewReader(data))
+}
+
+type flateCompressor struct{}
+
+func (flateCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	return buf.Bytes(), err
+}
+
+func (c flateCompressor) Decompress(data []byte) ([]byte, error) {
+	r, _ := c.NewReader(data)
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (flateCompressor) NewReader(data []byte) (io.ReadCloser, error) {
+	return flate.NewReader(bytes.NewReader(data)), nil
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/fastsha256"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"level":"info","msg":"compressible log line"}`), 50)
+
+	for _, c := range []Codec{CodecNone, CodecGzip, CodecFlate} {
+		cd, err := Compress(c, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if CodecOf(cd) != c {
+			t.Fatalf("codec want=%s have=%s", c, CodecOf(cd))
+		}
+		if c != CodecNone && len(cd) >= len(data) {
+			t.Fatalf("%s should compress", c)
+		}
+
+		out, err := Decompress(cd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatalf("%s round trip mismatch", c)
+		}
+	}
+}
+
+func TestCompressEdgeCases(t *testing.T) {
+	// Incompressible data should be left as is
+	small := []byte("abc")
+	cd, _ := Compress(CodecGzip, small)
+	if !bytes.Equal(cd, small) {
+		t.Fatal("small data should not be compressed")
+	}
+
+	// Uncompressed data starting with the magic should survive the round trip
+	magic := append([]byte{}, codecMagic...)
+	magic = append(magic, byte(CodecGzip), 'x')
+	cd, _ = Compress(CodecNone, magic)
+	out, err := Decompress(cd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, magic) {
+		t.Fatal("magic prefixed data mismatch")
+	}
+
+	// As should data framed like an erasure coded shard
+	shard := []byte{0xdf, 0x5e, 4, 2, 0, 'x'}
+	cd, _ = Compress(CodecNone, shard)
+	if bytes.HasPrefix(cd, shard[:2]) {
+		t.Fatal("shard like data should be tagged")
+	}
+	if out, _ = Decompress(cd); !bytes.Equal(out, shard) {
+		t.Fatal("shard like data mismatch")
+	}
+
+	if _, err = Decompress(tagCodec(Codec(99), []byte("x"))); err == nil {
+		t.Fatal("should fail on unknown codec")
+	}
+	if _, err = ParseCodec("zstd"); err == nil {
+		t.Fatal("should fail on unknown codec")
+	}
+	if c, _ := ParseCodec("gzip"); c != CodecGzip {
+		t.Fatal("should be gzip")
+	}
+}
+
+func TestSetBlockCompressedHash(t *testing.T) {
+	st, _ := prepStore()
+	data := bytes.Repeat([]byte("block data "), 100)
+	sh := fastsha256.Sum256(data)
+
+	cd, _ := Compress(CodecGzip, data)
+	h1, err := st.SetBlock(cd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, _ := st.SetBlock(data)
+	if !bytes.Equal(h1, sh[:]) || !bytes.Equal(h2, sh[:]) {
+		t.Fatal("hash should be over uncompressed data")
+	}
+
+	// First write wins and is stored compressed
+	val, err := st.GetBlock(sh[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(val, cd) {
+		t.Fatal("block should be stored compressed")
+	}
+}
+
+func TestSetBlockTooLarge(t *testing.T) {
+	st, _ := prepStore()
+
+	defer func(max int64) { MaxBlockSize = max }(MaxBlockSize)
+	MaxBlockSize = 1024
+
+	// Highly compressible data expanding past the limit
+	for _, c := range []Codec{CodecGzip, CodecFlate} {
+		cd, _ := Compress(c, make([]byte, 4096))
+		if _, err := st.SetBlock(cd); err != ErrBlockTooLarge {
+			t.Fatal(c, "should be too large", err)
+		}
+	}
+	if _, err := st.SetBlock(make([]byte, 1025)); err != ErrBlockTooLarge {
+		t.Fatal("should be too large", err)
+	}
+	if _, err := st.SetBlock(make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -22,6 +22,8 @@ func (i InodeType) String() string {
 		s = "dir"
 	case KeyInodeType:
 		s = "key"
+	case ErasureInodeType:
+		s = "erasure"
 	default:
 		s = "unknown"
 	}
@@ -35,6 +37,8 @@ const (
 	FileInodeType
 	// DirInodeType is an node that represents a directory
 	DirInodeType
+	// ErasureInodeType is an node whose value is erasure coded into shards
+	ErasureInodeType
 )
 
 // Inode represents a single unit of data around the ring.
@@ -47,6 +51,9 @@ type Inode struct {
 	// This holds the address to physical data.  The address can be of any type
 	// i.e. hash, key, url etc..
 	Blocks [][]byte
+	// Key material needed to decrypt each block in Blocks.  Empty if the blocks are not
+	// encrypted.
+	Keys [][]byte
 
 	// merkle root of transactions made against this inode
 	txroot []byte
@@ -83,6 +90,33 @@ func NewKeyInodeWithValue(key, value []byte) *Inode {
 	}
 }
 
+// NewFileInode instantiates a file inode referencing the given blocks and the key
+// material needed to decrypt them.  keys should be nil if the blocks are not encrypted.
+func NewFileInode(key []byte, size int64, blocks, keys [][]byte) *Inode {
+	return &Inode{
+		Id:     key,
+		Type:   FileInodeType,
+		Blocks: blocks,
+		Keys:   keys,
+		Size:   size,
+		txroot: txlog.ZeroHash(),
+	}
+}
+
+// NewErasureInode instantiates an inode referencing the erasure coded shards of the value
+// in order.  keys holds the key material to decrypt the joined shards or nil if the value
+// is not encrypted.
+func NewErasureInode(key []byte, size int64, shards, keys [][]byte) *Inode {
+	return &Inode{
+		Id:     key,
+		Type:   ErasureInodeType,
+		Blocks: shards,
+		Keys:   keys,
+		Size:   size,
+		txroot: txlog.ZeroHash(),
+	}
+}
+
 // TxRoot returns the merkle root of all transactions performed on this vnode.
 func (r *Inode) TxRoot() []byte {
 	return r.txroot
@@ -97,12 +131,13 @@ func (r *Inode) MarshalJSON() ([]byte, error) {
 		"txroot": fmt.Sprintf("%x", r.txroot),
 	}
 
-	if r.Type == FileInodeType {
+	if r.Type == FileInodeType || r.Type == ErasureInodeType {
 		bhs := make([]string, len(r.Blocks))
 		for i, v := range r.Blocks {
 			bhs[i] = fmt.Sprintf("%x", v)
 		}
 		m["blocks"] = bhs
+		m["encrypted"] = len(r.Keys) > 0
 	} else {
 		m["blocks"] = r.Blocks
 	}
@@ -110,6 +145,21 @@ func (r *Inode) MarshalJSON() ([]byte, error) {
 	return json.Marshal(m)
 }
 
+// GobEncode encodes the inode as a flatbuffer retaining the txroot
+func (r *Inode) GobEncode() ([]byte, error) {
+	fb := flatbuffers.NewBuilder(0)
+	fb.Finish(r.Serialize(fb))
+	return fb.Bytes[fb.Head():], nil
+}
+
+// GobDecode decodes an inode encoded by GobEncode
+func (r *Inode) GobDecode(b []byte) error {
+	// The inode references the buffer which gob may reuse
+	b = append([]byte(nil), b...)
+	r.Deserialize(gentypes.GetRootAsInode(b, 0))
+	return nil
+}
+
 // Deserialize deserializes the flatbuffer object into a Inode
 func (r *Inode) Deserialize(ind *gentypes.Inode) {
 
@@ -128,24 +178,37 @@ func (r *Inode) Deserialize(ind *gentypes.Inode) {
 	}
 
 	r.Blocks = bh
+
+	if l = ind.KeysLength(); l > 0 {
+		keys := make([][]byte, l)
+		for i := 0; i < l; i++ {
+			var obj gentypes.ByteSlice
+			ind.Keys(&obj, i)
+			keys[l-i-1] = obj.BBytes()
+		}
+		r.Keys = keys
+	}
 }
 
 // Serialize serializes the struct into the flatbuffer returning the offset.
 func (r *Inode) Serialize(fb *flatbuffers.Builder) flatbuffers.UOffsetT {
-	obh := make([]flatbuffers.UOffsetT, len(r.Blocks))
-
-	for i, v := range r.Blocks {
-		bhp := fb.CreateByteString(v)
-		gentypes.ByteSliceStart(fb)
-		gentypes.ByteSliceAddB(fb, bhp)
-		obh[i] = gentypes.ByteSliceEnd(fb)
-	}
-
+	obh := serializeByteSlices(fb, r.Blocks)
 	gentypes.InodeStartBlocksVector(fb, len(r.Blocks))
 	for _, v := range obh {
 		fb.PrependUOffsetT(v)
 	}
 	bh := fb.EndVector(len(r.Blocks))
+
+	var kh flatbuffers.UOffsetT
+	if len(r.Keys) > 0 {
+		okh := serializeByteSlices(fb, r.Keys)
+		gentypes.InodeStartKeysVector(fb, len(r.Keys))
+		for _, v := range okh {
+			fb.PrependUOffsetT(v)
+		}
+		kh = fb.EndVector(len(r.Keys))
+	}
+
 	kp := fb.CreateByteString(r.Id)
 	rp := fb.CreateByteString(r.txroot)
 
@@ -155,5 +218,19 @@ func (r *Inode) Serialize(fb *flatbuffers.Builder) flatbuffers.UOffsetT {
 	gentypes.InodeAddSize(fb, r.Size)
 	gentypes.InodeAddType(fb, int8(r.Type))
 	gentypes.InodeAddRoot(fb, rp)
+	if len(r.Keys) > 0 {
+		gentypes.InodeAddKeys(fb, kh)
+	}
 	return gentypes.InodeEnd(fb)
 }
+
+func serializeByteSlices(fb *flatbuffers.Builder, bs [][]byte) []flatbuffers.UOffsetT {
+	obh := make([]flatbuffers.UOffsetT, len(bs))
+	for i, v := range bs {
+		bhp := fb.CreateByteString(v)
+		gentypes.ByteSliceStart(fb)
+		gentypes.ByteSliceAddB(fb, bhp)
+		obh[i] = gentypes.ByteSliceEnd(fb)
+	}
+	return obh
+}
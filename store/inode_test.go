@@ -0,0 +1,41 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+
+	"github.com/ipkg/difuse/gentypes"
+)
+
+func TestInodeSerializeKeys(t *testing.T) {
+	inode := NewFileInode([]byte("key"), 10,
+		[][]byte{[]byte("hash-1"), []byte("hash-2")},
+		[][]byte{[]byte("key-1"), []byte("key-2")})
+
+	fb := flatbuffers.NewBuilder(0)
+	fb.Finish(inode.Serialize(fb))
+
+	var out Inode
+	out.Deserialize(gentypes.GetRootAsInode(fb.Bytes[fb.Head():], 0))
+
+	if !reflect.DeepEqual(out.Blocks, inode.Blocks) {
+		t.Fatal("blocks mismatch", out.Blocks)
+	}
+	if !reflect.DeepEqual(out.Keys, inode.Keys) {
+		t.Fatal("keys mismatch", out.Keys)
+	}
+	if out.Type != FileInodeType || out.Size != 10 {
+		t.Fatal("wrong type or size")
+	}
+
+	// Plaintext inodes should not have keys
+	fb = flatbuffers.NewBuilder(0)
+	fb.Finish(NewKeyInodeWithValue([]byte("key"), []byte("value")).Serialize(fb))
+	out = Inode{}
+	out.Deserialize(gentypes.GetRootAsInode(fb.Bytes[fb.Head():], 0))
+	if out.Keys != nil {
+		t.Fatal("should not have keys")
+	}
+}
@@ -1,17 +1,14 @@
 package store
 
 import (
-	"compress/zlib"
-	"encoding/gob"
 	"encoding/hex"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
-	"os"
 	"sync"
 
 	"github.com/btcsuite/fastsha256"
+	"golang.org/x/net/context"
 
 	chord "github.com/ipkg/go-chord"
 
@@ -25,6 +22,7 @@ type MemLoggedStore struct {
 
 	txstore txlog.TxStore
 	txl     *txlog.TxLog
+	kp      txlog.Signator
 }
 
 // NewMemLoggedStore instantiates a new tx log back in memory store.
@@ -32,6 +30,7 @@ func NewMemLoggedStore(vn *chord.Vnode, kp txlog.Signator) *MemLoggedStore {
 	mls := &MemLoggedStore{
 		MemDataStore: NewMemDataStore(vn),
 		txstore:      txlog.NewMemTxStore(),
+		kp:           kp,
 	}
 
 	mls.txl = txlog.NewTxLog(kp, mls.txstore, mls)
@@ -84,14 +83,13 @@ func (mem *MemLoggedStore) applySetKey(key, value []byte) error {
 func (mem *MemDataStore) applyDeleteKey(key []byte) error {
 	k := string(key)
 
-	_, ok := mem.txm[k]
-	if !ok {
-		return errKeyNotFound
-	}
-
 	mem.tlock.Lock()
+	defer mem.tlock.Unlock()
+
+	if _, ok := mem.txm[k]; !ok {
+		return ErrKeyNotFound
+	}
 	delete(mem.txm, k)
-	mem.tlock.Unlock()
 	return nil
 }
 
@@ -119,6 +117,35 @@ func (mem *MemLoggedStore) AppendTx(tx *txlog.Tx) error {
 	return mem.txl.AppendTx(tx)
 }
 
+// ReplicateTx appends/queues a transaction of the key's existing chain replicated from
+// another vnode.
+func (mem *MemLoggedStore) ReplicateTx(tx *txlog.Tx) error {
+	return mem.txl.ReplicateTx(tx)
+}
+
+// WaitTx waits until the transaction has been applied to the store returning the apply
+// error.
+func (mem *MemLoggedStore) WaitTx(ctx context.Context, key, txhash []byte) error {
+	return mem.txl.WaitTx(ctx, key, txhash)
+}
+
+// ProofTx returns the inclusion proofs of the tx within the chain of its key and of the
+// key's merkle root within the store, both from the same version of the store.
+func (mem *MemLoggedStore) ProofTx(key, txhash []byte) (*txlog.StoreProof, error) {
+	return mem.txstore.StoreProof(key, txhash)
+}
+
+// RebaseTx replaces the transactions following the ancestor with the branch, returning
+// the orphaned transactions.
+func (mem *MemLoggedStore) RebaseTx(key, ancestor []byte, branch txlog.TxSlice) (txlog.TxSlice, error) {
+	return mem.txl.Rebase(key, ancestor, branch)
+}
+
+// OrphanedTx returns the side branches orphaned from the key's chain.
+func (mem *MemLoggedStore) OrphanedTx(key []byte) ([]txlog.TxSlice, error) {
+	return mem.txstore.Orphans(key)
+}
+
 // NewTx creates a new transaction based on the previous hash from the log
 func (mem *MemLoggedStore) NewTx(key []byte) (*txlog.Tx, error) {
 	return mem.txl.NewTx(key)
@@ -129,6 +156,89 @@ func (mem *MemLoggedStore) LastTx(key []byte) (*txlog.Tx, error) {
 	return mem.txl.LastTx(key)
 }
 
+// Snapshot creates a snapshot of all blocks, inodes and transactions in temp space
+// returning the handle to it.  The snapshot is removed once the handle is closed.
+func (mem *MemLoggedStore) Snapshot() (io.ReadCloser, error) {
+	return mem.FilteredSnapshot(nil)
+}
+
+// FilteredSnapshot creates a snapshot of the blocks, inodes and transactions selected by
+// the filter.  Transactions are copied first as the inodes are applied from them.
+func (mem *MemLoggedStore) FilteredSnapshot(filter *SnapshotFilter) (io.ReadCloser, error) {
+	sn := newSnapshot()
+
+	var keys [][]byte
+	mem.txstore.Iter(func(key []byte, _ *txlog.KeyTransactions) error {
+		if filter.MatchKey(key) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	for _, k := range keys {
+		if txs, err := mem.txstore.Transactions(k, nil); err == nil {
+			sn.Txs[string(k)] = txs
+		}
+	}
+
+	mem.snapshot(sn, filter)
+
+	return writeSnapshot(mem.vn.String(), sn)
+}
+
+// Restore adds the transactions and blocks of the snapshot missing from the store.  The
+// transactions of a key are only restored if the store has none and they form its chain
+// from the zero hash, each signed by a signer authorized by the signator of the store.
+// The inodes are rebuilt by applying the restored transactions rather than taken from
+// the snapshot.  Keys whose chain does not verify are skipped returning the first error
+// once the rest of the snapshot has been restored.
+func (mem *MemLoggedStore) Restore(r io.Reader) error {
+	sn, err := ReadSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	var (
+		txs     txlog.TxSlice
+		skipped int
+	)
+	for k, v := range sn.Txs {
+		if _, e := mem.txstore.Last([]byte(k)); e == nil {
+			skipped++
+			continue
+		}
+		if e := txlog.VerifyTxs([]byte(k), v, mem.kp.Verify); e != nil {
+			log.Printf("action=restore status=failed vnode=%s key='%s' msg='%v'", mem.vn.String(), k, e)
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		txs = append(txs, v...)
+	}
+
+	errs := mem.txstore.AddBatch(txs)
+	for i, tx := range txs {
+		if errs != nil && errs[i] != nil {
+			if err == nil {
+				err = errs[i]
+			}
+			continue
+		}
+		// Txs failing to apply are still part of the chain as when appended
+		mem.Apply(tx)
+	}
+
+	// Blocks are keyed by the hash of their data rather than the snapshot
+	for _, v := range sn.Blocks {
+		if _, e := mem.SetBlock(v); e != nil && err == nil {
+			err = e
+		}
+	}
+	log.Printf("action=restore vnode=%s keys=%d blocks=%d skipped=%d", mem.vn.String(), len(sn.Txs), len(sn.Blocks), skipped)
+
+	return err
+}
+
 // MemDataStore is an in-memory datastore
 type MemDataStore struct {
 	// transactional store state
@@ -152,8 +262,16 @@ func NewMemDataStore(vn *chord.Vnode) *MemDataStore {
 
 // IterInodes iterates over all the inodes
 func (ms *MemDataStore) IterInodes(f func([]byte, *Inode) error) error {
-	var err error
+	// Copy the inodes so f is not called with the lock held
+	ms.tlock.Lock()
+	m := make(map[string]*Inode, len(ms.txm))
 	for k, v := range ms.txm {
+		m[k] = v
+	}
+	ms.tlock.Unlock()
+
+	var err error
+	for k, v := range m {
 		if e := f([]byte(k), v); e != nil {
 			err = e
 		}
@@ -165,12 +283,14 @@ func (ms *MemDataStore) IterInodes(f func([]byte, *Inode) error) error {
 func (ms *MemDataStore) Stat(key []byte) (*Inode, error) {
 	k := string(key)
 
+	ms.tlock.Lock()
 	rk, ok := ms.txm[k]
+	ms.tlock.Unlock()
 	if ok {
 		return rk, nil
 	}
 
-	return nil, errKeyNotFound
+	return nil, ErrKeyNotFound
 }
 
 // IterBlocks iterates over all the blocks in the store.  This obtains a read-lock.
@@ -201,13 +321,19 @@ func (ms *MemDataStore) GetBlock(key []byte) ([]byte, error) {
 	if v, ok := ms.cad[k]; ok {
 		return v, nil
 	}
-	return nil, errBlockNotFound
+	return nil, ErrBlockNotFound
 }
 
 // SetBlock sets the given value and returns the key hash.  This is used to directly
-// set kv data and useful for content addressable storage.
+// set kv data and useful for content addressable storage.  Compressed values are stored
+// as is while the hash is computed over the uncompressed data, which may be at most
+// MaxBlockSize.
 func (ms *MemDataStore) SetBlock(value []byte) ([]byte, error) {
-	sh := fastsha256.Sum256(value)
+	raw, err := DecompressLimit(value, MaxBlockSize)
+	if err != nil {
+		return nil, err
+	}
+	sh := fastsha256.Sum256(raw)
 	k := fmt.Sprintf("%x", sh)
 
 	ms.clock.Lock()
@@ -233,101 +359,90 @@ func (ms *MemDataStore) DeleteBlock(key []byte) error {
 		delete(ms.cad, k)
 		return nil
 	}
-	return errBlockNotFound
+	return ErrBlockNotFound
 }
 
+// Restore adds the blocks and inodes of the snapshot missing from the store.
 func (ms *MemDataStore) Restore(r io.Reader) error {
-
-	r, err := zlib.NewReader(r)
-	if err != nil {
-		return err
-	}
-
-	dec := gob.NewDecoder(r)
-
-	var tc map[string][]byte
-	if err = dec.Decode(&tc); err != nil {
-		return err
+	sn, err := ReadSnapshot(r)
+	if err == nil {
+		ms.restore(sn)
 	}
+	return err
+}
 
+func (ms *MemDataStore) restore(sn *Snapshot) {
 	ms.clock.Lock()
-	for k, v := range tc {
+	for k, v := range sn.Blocks {
 		ms.cad[k] = v
 	}
 	ms.clock.Unlock()
 
-	var tm map[string]*Inode
-	if err = dec.Decode(&tm); err != nil {
-		return err
-	}
-
+	var skipped int
 	ms.tlock.Lock()
-	for k, v := range tm {
-		hv, ok := ms.txm[k]
-		if !ok {
-			ms.txm[k] = v
+	for k, v := range sn.Inodes {
+		if _, ok := ms.txm[k]; ok {
+			skipped++
 			continue
 		}
-		log.Printf("TODO: check hash of '%v' and '%v'", hv, v)
+		ms.txm[k] = v
 	}
 	ms.tlock.Unlock()
 
-	log.Printf("Restored %s keys=%d objects=%d", ms.vn.String(), len(tm), len(tc))
-
-	return nil
+	log.Printf("action=restore vnode=%s keys=%d blocks=%d skipped=%d", ms.vn.String(), len(sn.Inodes), len(sn.Blocks), skipped)
 }
 
-// Snapshot creates a snapshot in temp space encoding objects then the indoes and
-// returns the handle to the snapshot.
+// Snapshot creates a snapshot of all blocks and inodes in temp space returning the
+// handle to it.  The snapshot is removed once the handle is closed.
 func (ms *MemDataStore) Snapshot() (io.ReadCloser, error) {
-	tfile, err := ioutil.TempFile("", ms.vn.String()+".")
-	if err != nil {
-		return nil, err
-	}
-	// compress the whole set
-	wz := zlib.NewWriter(tfile)
-	enc := gob.NewEncoder(wz)
-
-	// Encode objects
-	if len(ms.cad) > 0 {
-		ms.clock.Lock()
-		err = enc.Encode(ms.cad)
-		ms.clock.Unlock()
-	} else {
-		err = enc.Encode(map[string][]byte{})
-	}
+	return ms.FilteredSnapshot(nil)
+}
 
-	if err != nil {
-		tfile.Close()
-		os.Remove(tfile.Name())
-		return nil, err
-	}
+// FilteredSnapshot creates a snapshot of the blocks and inodes selected by the filter.
+func (ms *MemDataStore) FilteredSnapshot(filter *SnapshotFilter) (io.ReadCloser, error) {
+	sn := newSnapshot()
+	ms.snapshot(sn, filter)
 
-	// Encode inodes
-	if len(ms.txm) > 0 {
-		ms.tlock.Lock()
-		err = enc.Encode(ms.txm)
-		ms.tlock.Lock()
-	} else {
-		err = enc.Encode(map[string]*Inode{})
-	}
+	return writeSnapshot(ms.vn.String(), sn)
+}
 
-	if err != nil {
-		tfile.Close()
-		os.Remove(tfile.Name())
-		return nil, err
-	}
+// snapshot copies the inodes then the blocks selected by the filter to the snapshot.
+// Blocks are written before the inodes referencing them so every block of a copied inode
+// is copied as well.
+func (ms *MemDataStore) snapshot(sn *Snapshot, filter *SnapshotFilter) {
+	refs := make(map[string]string)
 
-	log.Printf("Snapshotted: %s keys=%d objects=%d", ms.vn, len(ms.txm), len(ms.cad))
-	// Close zlib
-	if err = wz.Close(); err != nil {
+	ms.tlock.Lock()
+	for k, v := range ms.txm {
+		if filter.MatchKey([]byte(k)) {
+			sn.Inodes[k] = v
+		}
+		for _, h := range v.Blocks {
+			refs[hex.EncodeToString(h)] = k
+		}
+	}
+	ms.tlock.Unlock()
 
-		tfile.Close()
-		os.Remove(tfile.Name())
-		return nil, err
+	ms.clock.RLock()
+	for k, v := range ms.cad {
+		h, err := hex.DecodeString(k)
+		if err != nil {
+			continue
+		}
+		ref, ok := refs[k]
+		var rkey []byte
+		if ok {
+			rkey = []byte(ref)
+		}
+		if !filter.MatchBlock(h, v, rkey) {
+			continue
+		}
+		sn.Blocks[k] = v
+		if ok {
+			sn.Refs[k] = ref
+		}
 	}
-	// close to flush,sync,persist
-	tfile.Close()
-	// open the snapshot and return
-	return os.Open(tfile.Name())
+	ms.clock.RUnlock()
+
+	log.Printf("action=snapshot vnode=%s keys=%d blocks=%d", ms.vn.String(), len(sn.Inodes), len(sn.Blocks))
 }
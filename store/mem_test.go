@@ -3,11 +3,13 @@ package store
 import (
 	"crypto/sha256"
 	"encoding/json"
+	"os"
 	"reflect"
 	"testing"
 	"time"
 
 	flatbuffers "github.com/google/flatbuffers/go"
+	"golang.org/x/net/context"
 
 	chord "github.com/ipkg/go-chord"
 
@@ -143,3 +145,134 @@ func TestInode(t *testing.T) {
 		t.Fatal("txroot should be zero")
 	}
 }
+
+func TestFilteredSnapshot(t *testing.T) {
+	src, kp := prepStore()
+
+	var last *txlog.Tx
+	for _, k := range []string{"a/1", "a/2", "b/1"} {
+		bh, _ := src.SetBlock([]byte("value-" + k))
+		inode := NewFileInode([]byte(k), 8, [][]byte{bh}, nil)
+
+		fb := flatbuffers.NewBuilder(0)
+		fb.Finish(inode.Serialize(fb))
+
+		tx, _ := src.NewTx([]byte(k))
+		tx.Data = append([]byte{TxTypeSet}, fb.Bytes[fb.Head():]...)
+		tx.Sign(kp)
+		src.AppendTx(tx)
+		last = tx
+	}
+	if err := src.WaitTx(context.Background(), last.Key, last.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	src.SetBlock([]byte("unreferenced"))
+
+	filter := &SnapshotFilter{Key: func(key []byte) bool { return key[0] == 'a' }}
+	rc, err := src.FilteredSnapshot(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := rc.(*tempSnapshot).Name()
+
+	sn, err := ReadSnapshot(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	if _, err = os.Stat(name); !os.IsNotExist(err) {
+		t.Fatal("snapshot file should be removed", err)
+	}
+
+	if len(sn.Inodes) != 2 || len(sn.Txs) != 2 || len(sn.Txs["a/1"]) != 1 {
+		t.Fatalf("wrong keys inodes=%d txs=%d", len(sn.Inodes), len(sn.Txs))
+	}
+	if len(sn.Blocks) != 4 || len(sn.Refs) != 3 {
+		t.Fatalf("wrong blocks=%d refs=%d", len(sn.Blocks), len(sn.Refs))
+	}
+
+	// Blocks selected by the key referencing them
+	bfilter := &SnapshotFilter{Block: func(hash, data, ref []byte) bool { return ref != nil && ref[0] == 'a' }}
+	brc, err := src.FilteredSnapshot(bfilter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bsn, err := ReadSnapshot(brc)
+	brc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bsn.Blocks) != 2 {
+		t.Fatal("should only have the blocks of the selected keys", len(bsn.Blocks))
+	}
+	root, _ := src.MerkleRootTx([]byte("a/1"))
+	if !txlog.EqualBytes(sn.Inodes["a/1"].TxRoot(), root) {
+		t.Fatal("txroot not retained")
+	}
+
+	rc, _ = src.FilteredSnapshot(filter)
+	defer rc.Close()
+
+	dst, _ := prepStore()
+	if err = dst.Restore(rc); err != nil {
+		t.Fatal(err)
+	}
+	if txs, err := dst.Transactions([]byte("a/2"), nil); err != nil || len(txs) != 1 {
+		t.Fatal("transactions not restored", err)
+	}
+	if _, err = dst.Stat([]byte("b/1")); err == nil {
+		t.Fatal("filtered key should not be restored")
+	}
+	if droot, _ := dst.MerkleRootTx([]byte("a/1")); !txlog.EqualBytes(droot, root) {
+		t.Fatal("merkle root mismatch")
+	}
+}
+
+func TestRestoreVerifiesChains(t *testing.T) {
+	src, kp := prepStore()
+
+	txs := make(map[string]*txlog.Tx)
+	for _, k := range []string{"good", "bad"} {
+		inode := NewKeyInodeWithValue([]byte(k), []byte("value-"+k))
+		fb := flatbuffers.NewBuilder(0)
+		fb.Finish(inode.Serialize(fb))
+
+		tx, _ := src.NewTx([]byte(k))
+		tx.Data = append([]byte{TxTypeSet}, fb.Bytes[fb.Head():]...)
+		tx.Sign(kp)
+		txs[k] = tx
+	}
+	// Tampered after signing
+	txs["bad"].Data = append([]byte{}, txs["good"].Data...)
+
+	sn := newSnapshot()
+	sn.Txs["good"] = txlog.TxSlice{txs["good"]}
+	sn.Txs["bad"] = txlog.TxSlice{txs["bad"]}
+	// Inodes are rebuilt from the transactions rather than taken from the snapshot
+	sn.Inodes["forged"] = NewKeyInodeWithValue([]byte("forged"), []byte("value"))
+	sn.Blocks["00"] = []byte("block")
+
+	rc, err := writeSnapshot("test", sn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	dst, _ := prepStore()
+	if err = dst.Restore(rc); err == nil {
+		t.Fatal("should fail to verify the tampered chain")
+	}
+	if _, err = dst.Stat([]byte("good")); err != nil {
+		t.Fatal("verified chain should be restored", err)
+	}
+	if _, err = dst.Transactions([]byte("bad"), nil); err == nil {
+		t.Fatal("tampered chain should not be restored")
+	}
+	if _, err = dst.Stat([]byte("forged")); err == nil {
+		t.Fatal("inode should not be taken from the snapshot")
+	}
+	hash := sha256.Sum256([]byte("block"))
+	if _, err = dst.GetBlock(hash[:]); err != nil {
+		t.Fatal("block should be keyed by its hash", err)
+	}
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"compress/zlib"
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/ipkg/difuse/txlog"
+)
+
+// SnapshotFilter selects the keys and blocks included in a snapshot.  A nil filter or
+// func includes everything.
+type SnapshotFilter struct {
+	// Key returns whether the inode and transactions of the key are included
+	Key func(key []byte) bool
+	// Block returns whether the block with the hash is included.  ref is the key of an
+	// inode referencing the block or nil if none does.
+	Block func(hash, data, ref []byte) bool
+}
+
+// MatchKey returns whether the key is selected by the filter.
+func (f *SnapshotFilter) MatchKey(key []byte) bool {
+	return f == nil || f.Key == nil || f.Key(key)
+}
+
+// MatchBlock returns whether the block is selected by the filter.
+func (f *SnapshotFilter) MatchBlock(hash, data, ref []byte) bool {
+	return f == nil || f.Block == nil || f.Block(hash, data, ref)
+}
+
+// Snapshot is the content of a vnode store.  Blocks are keyed by their hex encoded hash.
+type Snapshot struct {
+	Blocks map[string][]byte
+	Inodes map[string]*Inode
+	// Signed transactions of each key
+	Txs map[string]txlog.TxSlice
+	// Key of an inode referencing each block.  This is used to place blocks placed by
+	// key rather than by hash such as shards.
+	Refs map[string]string
+}
+
+func newSnapshot() *Snapshot {
+	return &Snapshot{
+		Blocks: map[string][]byte{},
+		Inodes: map[string]*Inode{},
+		Txs:    map[string]txlog.TxSlice{},
+		Refs:   map[string]string{},
+	}
+}
+
+// ReadSnapshot reads a snapshot of a vnode store.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	sn := &Snapshot{}
+	if err = gob.NewDecoder(zr).Decode(sn); err != nil {
+		return nil, err
+	}
+	return sn, nil
+}
+
+// tempSnapshot is a snapshot file removed once closed
+type tempSnapshot struct {
+	*os.File
+}
+
+func (ts *tempSnapshot) Close() error {
+	err := ts.File.Close()
+	os.Remove(ts.Name())
+	return err
+}
+
+// writeSnapshot writes the zlib compressed gob encoded snapshot to a temp file returning
+// the handle to it.  The file is removed once the handle is closed.
+func writeSnapshot(prefix string, sn *Snapshot) (io.ReadCloser, error) {
+	tfile, err := ioutil.TempFile("", prefix+".")
+	if err != nil {
+		return nil, err
+	}
+
+	wz := zlib.NewWriter(tfile)
+	if err = gob.NewEncoder(wz).Encode(sn); err == nil {
+		err = wz.Close()
+	}
+	if err == nil {
+		_, err = tfile.Seek(0, io.SeekStart)
+	}
+
+	if err != nil {
+		tfile.Close()
+		os.Remove(tfile.Name())
+		return nil, err
+	}
+	return &tempSnapshot{tfile}, nil
+}
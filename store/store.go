@@ -10,8 +10,14 @@ const (
 )
 
 var (
-	errKeyNotFound   = fmt.Errorf("key not found")
-	errBlockNotFound = fmt.Errorf("block not found")
+	// ErrKeyNotFound is returned when a key does not exist
+	ErrKeyNotFound = fmt.Errorf("key not found")
+	// ErrBlockNotFound is returned when a block does not exist
+	ErrBlockNotFound = fmt.Errorf("block not found")
+	// ErrBlockTooLarge is returned when the uncompressed data of a block exceeds
+	// MaxBlockSize
+	ErrBlockTooLarge = fmt.Errorf("block too large")
+
 	errAlreadyExists = fmt.Errorf("already exists")
 	errInvalidTxType = fmt.Errorf("invalid tx type")
 )
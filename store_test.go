@@ -0,0 +1,85 @@
+package difuse
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"golang.org/x/net/context"
+
+	chord "github.com/ipkg/go-chord"
+
+	"github.com/ipkg/difuse/store"
+	"github.com/ipkg/difuse/txlog"
+)
+
+func TestLocalStoreWaitApply(t *testing.T) {
+	kp, _ := txlog.GenerateECDSAKeypair()
+	vn := &chord.Vnode{Id: []byte("vnode-id"), Host: "host"}
+	st := store.NewMemLoggedStore(vn, kp)
+	ls := localStore{fmt.Sprintf("%x", vn.Id): st}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	key := []byte("key")
+	tx, _ := st.NewTx(key)
+	fb := flatbuffers.NewBuilder(0)
+	fb.Finish(store.NewKeyInodeWithValue(key, []byte("value")).Serialize(fb))
+	tx.Data = append([]byte{store.TxTypeSet}, fb.Bytes[fb.Head():]...)
+	tx.Sign(kp)
+
+	// Reading with the token before the write waits for it
+	done := make(chan *VnodeResponse, 1)
+	go func() {
+		resp, _ := ls.Stat(ctx, key, &RequestOptions{Token: tx.Hash()}, vn)
+		done <- resp[0]
+	}()
+
+	resp, _ := ls.AppendTx(ctx, tx, &RequestOptions{WaitApply: true}, vn)
+	if resp[0].Err != nil {
+		t.Fatal(resp[0].Err)
+	}
+	// Applied once the append returns
+	if _, err := st.Stat(key); err != nil {
+		t.Fatal(err)
+	}
+
+	if r := <-done; r.Err != nil {
+		t.Fatal(r.Err)
+	}
+
+	// Token that is never applied
+	tctx, tcancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer tcancel()
+	resp, _ = ls.Stat(tctx, key, &RequestOptions{Token: txlog.ZeroHash()}, vn)
+	if resp[0].Err != context.DeadlineExceeded {
+		t.Fatal("should exceed deadline", resp[0].Err)
+	}
+}
+
+func TestLocalStoreReplicateTransactions(t *testing.T) {
+	kp, _ := txlog.GenerateECDSAKeypair()
+	src := &chord.Vnode{Id: []byte("vnode-src"), Host: "host"}
+	dst := &chord.Vnode{Id: []byte("vnode-dst"), Host: "host"}
+	sst := store.NewMemLoggedStore(src, kp)
+	dstore := store.NewMemLoggedStore(dst, kp)
+	ls := localStore{src.String(): sst, dst.String(): dstore}
+
+	key := []byte("key")
+	tx, _ := sst.NewTx(key)
+	tx.Data = []byte{0xff}
+	tx.Sign(kp)
+	sst.AppendTx(tx)
+	sst.WaitTx(context.Background(), key, tx.Hash())
+
+	if err := ls.ReplicateTransactions(key, nil, src, dst); err != nil {
+		t.Fatal(err)
+	}
+	dstore.WaitTx(context.Background(), key, tx.Hash())
+
+	if txs, err := dstore.Transactions(key, nil); err != nil || len(txs) != 1 {
+		t.Fatal("should be replicated to the destination", err)
+	}
+}
@@ -0,0 +1,230 @@
+package testcluster
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+
+	chord "github.com/ipkg/go-chord"
+)
+
+var (
+	errHostUnreachable = errors.New("host unreachable")
+	errVnodeNotFound   = errors.New("vnode not found")
+)
+
+// chordNetwork connects the chord transports of the nodes of a cluster.
+type chordNetwork struct {
+	mu     sync.RWMutex
+	vnodes map[string][]*chord.Vnode            // vnodes of each host in registration order
+	rpcs   map[string]map[string]chord.VnodeRPC // host -> vnode id -> rpc
+	parts  map[string]int                       // partition of each partitioned host
+}
+
+func newChordNetwork() *chordNetwork {
+	return &chordNetwork{
+		vnodes: make(map[string][]*chord.Vnode),
+		rpcs:   make(map[string]map[string]chord.VnodeRPC),
+	}
+}
+
+// transport returns the transport of the host attaching the host to the network.
+func (n *chordNetwork) transport(host string) *chordTransport {
+	n.mu.Lock()
+	n.vnodes[host] = nil
+	n.rpcs[host] = make(map[string]chord.VnodeRPC)
+	n.mu.Unlock()
+
+	return &chordTransport{host: host, net: n}
+}
+
+// partition splits the network into the sets of hosts.  Hosts in different sets cannot
+// reach each other while hosts not in any set are reachable from all hosts.
+func (n *chordNetwork) partition(sets [][]string) {
+	parts := make(map[string]int)
+	for i, set := range sets {
+		for _, host := range set {
+			parts[host] = i
+		}
+	}
+
+	n.mu.Lock()
+	n.parts = parts
+	n.mu.Unlock()
+}
+
+// reachable returns whether the hosts are in the same partition.  The caller must hold
+// the lock.
+func (n *chordNetwork) reachable(h1, h2 string) bool {
+	p1, ok1 := n.parts[h1]
+	p2, ok2 := n.parts[h2]
+	return !ok1 || !ok2 || p1 == p2
+}
+
+// merge notifies the successor of each vnode on the whole network of the vnode.  Chord
+// does not merge rings split by a partition by itself.  Once each vnode is known to its
+// successor, stabilization walks every successor list back to the merged ring.
+func (n *chordNetwork) merge() {
+	type vnodeRPC struct {
+		vn  *chord.Vnode
+		rpc chord.VnodeRPC
+	}
+
+	n.mu.RLock()
+	var vl []vnodeRPC
+	for host, vns := range n.vnodes {
+		for _, vn := range vns {
+			vl = append(vl, vnodeRPC{vn: vn, rpc: n.rpcs[host][vn.String()]})
+		}
+	}
+	n.mu.RUnlock()
+
+	if len(vl) < 2 {
+		return
+	}
+	sort.Slice(vl, func(i, j int) bool { return bytes.Compare(vl[i].vn.Id, vl[j].vn.Id) < 0 })
+
+	for i, v := range vl {
+		succ := vl[(i+1)%len(vl)]
+		succ.rpc.Notify(copyVnode(v.vn))
+	}
+}
+
+// remove detaches the host from the network.
+func (n *chordNetwork) remove(host string) {
+	n.mu.Lock()
+	delete(n.vnodes, host)
+	delete(n.rpcs, host)
+	n.mu.Unlock()
+}
+
+// chordTransport is an in-process chord transport calling the vnodes of the other hosts
+// on the network directly.
+type chordTransport struct {
+	host string
+	net  *chordNetwork
+}
+
+// vnodeRPC returns the rpc of the vnode if both its host and the local one are attached.
+func (t *chordTransport) vnodeRPC(vn *chord.Vnode) (chord.VnodeRPC, error) {
+	t.net.mu.RLock()
+	defer t.net.mu.RUnlock()
+
+	if _, ok := t.net.rpcs[t.host]; !ok {
+		return nil, errHostUnreachable
+	}
+	rpcs, ok := t.net.rpcs[vn.Host]
+	if !ok || !t.net.reachable(t.host, vn.Host) {
+		return nil, errHostUnreachable
+	}
+	rpc, ok := rpcs[vn.String()]
+	if !ok {
+		return nil, errVnodeNotFound
+	}
+	return rpc, nil
+}
+
+// copyVnode returns a copy of the vnode as received from a remote host.
+func copyVnode(vn *chord.Vnode) *chord.Vnode {
+	if vn == nil {
+		return nil
+	}
+	return &chord.Vnode{Id: append([]byte(nil), vn.Id...), Host: vn.Host}
+}
+
+func copyVnodes(vl []*chord.Vnode) []*chord.Vnode {
+	out := make([]*chord.Vnode, len(vl))
+	for i, vn := range vl {
+		out[i] = copyVnode(vn)
+	}
+	return out
+}
+
+// ListVnodes returns the vnodes of the host.
+func (t *chordTransport) ListVnodes(host string) ([]*chord.Vnode, error) {
+	t.net.mu.RLock()
+	defer t.net.mu.RUnlock()
+
+	if _, ok := t.net.rpcs[t.host]; !ok {
+		return nil, errHostUnreachable
+	}
+	vl, ok := t.net.vnodes[host]
+	if !ok || !t.net.reachable(t.host, host) {
+		return nil, errHostUnreachable
+	}
+	return copyVnodes(vl), nil
+}
+
+// Ping returns whether the vnode is reachable.
+func (t *chordTransport) Ping(vn *chord.Vnode) (bool, error) {
+	if _, err := t.vnodeRPC(vn); err != nil {
+		if err == errVnodeNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// GetPredecessor returns the predecessor of the vnode.
+func (t *chordTransport) GetPredecessor(vn *chord.Vnode) (*chord.Vnode, error) {
+	rpc, err := t.vnodeRPC(vn)
+	if err != nil {
+		return nil, err
+	}
+	pred, err := rpc.GetPredecessor()
+	return copyVnode(pred), err
+}
+
+// Notify notifies the target vnode of self as a possible predecessor returning its
+// successors.
+func (t *chordTransport) Notify(target, self *chord.Vnode) ([]*chord.Vnode, error) {
+	rpc, err := t.vnodeRPC(target)
+	if err != nil {
+		return nil, err
+	}
+	vl, err := rpc.Notify(copyVnode(self))
+	return copyVnodes(vl), err
+}
+
+// FindSuccessors finds the n successors of the key starting at the vnode.
+func (t *chordTransport) FindSuccessors(vn *chord.Vnode, n int, key []byte) ([]*chord.Vnode, error) {
+	rpc, err := t.vnodeRPC(vn)
+	if err != nil {
+		return nil, err
+	}
+	vl, err := rpc.FindSuccessors(n, key)
+	return copyVnodes(vl), err
+}
+
+// ClearPredecessor clears the predecessor of the target vnode if it is self.
+func (t *chordTransport) ClearPredecessor(target, self *chord.Vnode) error {
+	rpc, err := t.vnodeRPC(target)
+	if err != nil {
+		return err
+	}
+	return rpc.ClearPredecessor(copyVnode(self))
+}
+
+// SkipSuccessor makes the target vnode skip self as its successor.
+func (t *chordTransport) SkipSuccessor(target, self *chord.Vnode) error {
+	rpc, err := t.vnodeRPC(target)
+	if err != nil {
+		return err
+	}
+	return rpc.SkipSuccessor(copyVnode(self))
+}
+
+// Register registers a local vnode to the network.
+func (t *chordTransport) Register(vn *chord.Vnode, rpc chord.VnodeRPC) {
+	t.net.mu.Lock()
+	defer t.net.mu.Unlock()
+
+	rpcs, ok := t.net.rpcs[t.host]
+	if !ok {
+		return
+	}
+	rpcs[vn.String()] = rpc
+	t.net.vnodes[t.host] = append(t.net.vnodes[t.host], vn)
+}
@@ -0,0 +1,430 @@
+// Package testcluster runs clusters of difuse nodes in a single process for tests.  Nodes
+// are connected by memory transports for both chord and difuse so no sockets are used.
+package testcluster
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	chord "github.com/ipkg/go-chord"
+
+	"github.com/ipkg/difuse"
+	"github.com/ipkg/difuse/store"
+	"github.com/ipkg/difuse/txlog"
+)
+
+const (
+	// interval at which WaitStable checks the cluster
+	stablePollInterval = 10 * time.Millisecond
+	// time a restarted node waits for the ring to stabilize without it
+	restartTimeout = 5 * time.Second
+)
+
+// Options holds the options of a cluster
+type Options struct {
+	NumVnodes     int
+	NumSuccessors int
+	StabilizeMin  time.Duration
+	StabilizeMax  time.Duration
+
+	// Configure is called with the config of each node before it is started
+	Configure func(*difuse.Config)
+}
+
+// DefaultOptions returns options for a small quickly stabilizing ring
+func DefaultOptions() *Options {
+	return &Options{
+		NumVnodes:     4,
+		NumSuccessors: 3,
+		StabilizeMin:  15 * time.Millisecond,
+		StabilizeMax:  45 * time.Millisecond,
+	}
+}
+
+// Node is a node of the cluster
+type Node struct {
+	Host   string
+	Config *difuse.Config
+	Difuse *difuse.Difuse
+	Ring   *chord.Ring
+	// Faults injected into the requests the node sends to other nodes
+	Faults *difuse.FaultTransport
+}
+
+// Cluster is a set of nodes joined into a ring.  Nodes sign with a key shared by the
+// cluster so all of them are authorized by the keyring seeded by the first node.
+type Cluster struct {
+	opts *Options
+	sig  txlog.Signator
+
+	dnet *difuse.MemNetwork
+	cnet *chordNetwork
+
+	mu     sync.Mutex
+	nodes  []*Node
+	seq    int                                         // number of nodes started used to name the next
+	parts  [][]string                                  // current partitions applied to nodes as they start
+	stores map[string]map[string]*store.MemLoggedStore // host -> vnode -> store last opened
+}
+
+// New starts n nodes joining them into a ring.  Default options are used if opts is nil.
+func New(n int, opts *Options) (*Cluster, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+
+	sig, err := txlog.GenerateKeypair(txlog.SchemeECDSA)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cluster{
+		opts:   opts,
+		sig:    sig,
+		dnet:   difuse.NewMemNetwork(),
+		cnet:   newChordNetwork(),
+		stores: make(map[string]map[string]*store.MemLoggedStore),
+	}
+	for i := 0; i < n; i++ {
+		if _, err := c.AddNode(); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// AddNode starts a new node creating the ring or joining it via the first node.
+func (c *Cluster) AddNode() (*Node, error) {
+	c.mu.Lock()
+	host := fmt.Sprintf("node-%d", c.seq)
+	c.seq++
+	c.mu.Unlock()
+
+	return c.start(host, false)
+}
+
+// Start starts a node for a host that is not part of the cluster such as one killed
+// earlier.  The node has empty stores and is bootstrapped like a new node.  The ring
+// should have stabilized without the host before it is started again.
+func (c *Cluster) Start(host string) (*Node, error) {
+	if c.Node(host) != nil {
+		return nil, fmt.Errorf("node already started: %s", host)
+	}
+	return c.start(host, false)
+}
+
+// Restart kills the node of the host and starts it again as after a crash.  The node is
+// only started once the ring has stabilized without it.  The vnodes of the node keep the
+// data of their stores as if persisted to disk and are then bootstrapped as usual.
+func (c *Cluster) Restart(host string) (*Node, error) {
+	if err := c.Kill(host); err != nil {
+		return nil, err
+	}
+	if err := c.WaitStable(restartTimeout); err != nil {
+		return nil, err
+	}
+	if c.Node(host) != nil {
+		return nil, fmt.Errorf("node already started: %s", host)
+	}
+	return c.start(host, true)
+}
+
+// openStore returns the function opening the vnode stores of the host along with the
+// stores last opened for its vnodes.
+func (c *Cluster) openStore(host string) (func(*chord.Vnode, txlog.Signator) difuse.VnodeStore, map[string]*store.MemLoggedStore) {
+	c.mu.Lock()
+	old := c.stores[host]
+	c.stores[host] = make(map[string]*store.MemLoggedStore)
+	c.mu.Unlock()
+
+	return func(vn *chord.Vnode, sig txlog.Signator) difuse.VnodeStore {
+		st := store.NewMemLoggedStore(vn, sig)
+
+		c.mu.Lock()
+		c.stores[host][vn.String()] = st
+		c.mu.Unlock()
+		return st
+	}, old
+}
+
+// keepStores restores the data of the old stores of the host to the stores of its new
+// vnodes.  The stores only accept transactions of authorized signers so restoring is
+// retried until the node has read the keyring or the timeout expires.
+func (c *Cluster) keepStores(host string, old map[string]*store.MemLoggedStore, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		c.mu.Lock()
+		cur := c.stores[host]
+		c.mu.Unlock()
+
+		var err error
+		for k, prev := range old {
+			if st, ok := cur[k]; ok {
+				if e := restoreStore(st, prev); e != nil {
+					err = e
+				}
+			}
+		}
+
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(stablePollInterval)
+	}
+}
+
+// restoreStore restores a snapshot of the src store to the dst store.
+func restoreStore(dst, src *store.MemLoggedStore) error {
+	rc, err := src.Snapshot()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return dst.Restore(rc)
+}
+
+// start starts a node for the host creating the ring or joining it via the first node.
+// The stores of the host's last node are kept if keep is set.
+func (c *Cluster) start(host string, keep bool) (*Node, error) {
+	c.mu.Lock()
+	var peer string
+	if len(c.nodes) > 0 {
+		peer = c.nodes[0].Host
+	}
+	parts := c.parts
+	c.mu.Unlock()
+
+	cfg := difuse.DefaultConfig()
+	cfg.BindAddr = host
+	cfg.Chord.Hostname = host
+	cfg.Chord.NumVnodes = c.opts.NumVnodes
+	cfg.Chord.NumSuccessors = c.opts.NumSuccessors
+	cfg.Chord.StabilizeMin = c.opts.StabilizeMin
+	cfg.Chord.StabilizeMax = c.opts.StabilizeMax
+	cfg.Signator = c.sig
+	if peer != "" {
+		cfg.Peers = []string{peer}
+	}
+	if c.opts.Configure != nil {
+		c.opts.Configure(cfg)
+	}
+	var old map[string]*store.MemLoggedStore
+	cfg.OpenStore, old = c.openStore(host)
+
+	ftrans := difuse.NewFaultTransport(c.dnet.Transport(host))
+	if len(parts) > 0 {
+		ftrans.SetFaults(&difuse.Faults{Partitions: parts})
+	}
+	d, err := difuse.NewDifuse(cfg, ftrans)
+	if err != nil {
+		c.dnet.Remove(host)
+		return nil, err
+	}
+	cfg.Chord.Delegate = d
+
+	ctrans := c.cnet.transport(host)
+
+	var ring *chord.Ring
+	if peer == "" {
+		ring, err = chord.Create(cfg.Chord, ctrans)
+	} else {
+		ring, err = chord.Join(cfg.Chord, ctrans, peer)
+	}
+	if err != nil {
+		c.dnet.Remove(host)
+		c.cnet.remove(host)
+		return nil, err
+	}
+	d.RegisterRing(ring)
+
+	if keep {
+		if err = c.keepStores(host, old, restartTimeout); err != nil {
+			log.Printf("action=restore-store status=failed host=%s msg='%v'", host, err)
+		}
+	}
+
+	node := &Node{Host: host, Config: cfg, Difuse: d, Ring: ring, Faults: ftrans}
+
+	c.mu.Lock()
+	c.nodes = append(c.nodes, node)
+	c.mu.Unlock()
+
+	return node, nil
+}
+
+// Node returns the node of the host or nil if it is not part of the cluster.
+func (c *Cluster) Node(host string) *Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, n := range c.nodes {
+		if n.Host == host {
+			return n
+		}
+	}
+	return nil
+}
+
+// Nodes returns the nodes of the cluster in the order they were added.
+func (c *Cluster) Nodes() []*Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := make([]*Node, len(c.nodes))
+	copy(out, c.nodes)
+	return out
+}
+
+// remove removes the node of the host from the cluster returning it.
+func (c *Cluster) remove(host string) (*Node, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, n := range c.nodes {
+		if n.Host == host {
+			c.nodes = append(c.nodes[:i], c.nodes[i+1:]...)
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("node not found: %s", host)
+}
+
+// Leave gracefully removes the node of the host from the ring.
+func (c *Cluster) Leave(host string) error {
+	n, err := c.remove(host)
+	if err != nil {
+		return err
+	}
+
+	err = n.Ring.Leave()
+	c.cnet.remove(host)
+	c.dnet.Remove(host)
+	return err
+}
+
+// Kill abruptly removes the node of the host.  It is disconnected from the other nodes
+// before it is shut down so it is seen as failed rather than leaving.
+func (c *Cluster) Kill(host string) error {
+	n, err := c.remove(host)
+	if err != nil {
+		return err
+	}
+
+	c.cnet.remove(host)
+	c.dnet.Remove(host)
+	n.Ring.Shutdown()
+	return nil
+}
+
+// Partition splits the nodes into the sets of hosts.  Nodes can then only send requests to
+// nodes in the same set.  Other faults set on the nodes are kept.  Ring maintenance is cut
+// the same way so vnodes drop the successors in other sets.  Nodes started later are
+// partitioned the same way.
+func (c *Cluster) Partition(sets ...[]string) error {
+	if err := (&difuse.Faults{Partitions: sets}).Validate(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.parts = sets
+	c.mu.Unlock()
+	c.cnet.partition(sets)
+
+	for _, n := range c.Nodes() {
+		f := n.Faults.Faults()
+		f.Partitions = sets
+		if err := n.Faults.SetFaults(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Heal removes all partitions.  The ring is merged back by notifying each vnode's
+// successor on the whole ring of it as when nodes rejoin.  Callers should wait
+// for the cluster to stabilize afterwards.
+func (c *Cluster) Heal() {
+	c.Partition()
+	c.cnet.merge()
+}
+
+// WaitStable waits until every node sees every vnode of the cluster on the ring with a
+// full successor list and no vnode is waiting to be bootstrapped.
+func (c *Cluster) WaitStable(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := c.stable()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("cluster not stable: %v", err)
+		}
+		time.Sleep(stablePollInterval)
+	}
+}
+
+// stable returns why the cluster is not stable or nil if it is.
+func (c *Cluster) stable() error {
+	nodes := c.Nodes()
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	live := make(map[string]bool)
+	for _, n := range nodes {
+		live[n.Host] = true
+	}
+
+	total := len(nodes) * c.opts.NumVnodes
+	succs := c.opts.NumSuccessors
+	if succs > total-1 {
+		succs = total - 1
+	}
+
+	for _, n := range nodes {
+		rs, err := n.Difuse.RingStatus()
+		if err != nil {
+			return fmt.Errorf("%s: %v", n.Host, err)
+		}
+		for _, h := range rs.Hosts {
+			if !live[h] {
+				return fmt.Errorf("%s: sees removed host %s", n.Host, h)
+			}
+		}
+		for _, vs := range rs.Vnodes {
+			if vs.Bootstrapping {
+				return fmt.Errorf("%s: bootstrapping %s", n.Host, difuse.ShortVnodeID(vs.Vnode))
+			}
+			if len(vs.Successors) != succs {
+				return fmt.Errorf("%s: %d successors for %s", n.Host, len(vs.Successors), difuse.ShortVnodeID(vs.Vnode))
+			}
+		}
+
+		// Walking the ring visits every vnode once all successor lists are consistent
+		ranges, err := n.Difuse.BackupRanges()
+		if err != nil {
+			return fmt.Errorf("%s: %v", n.Host, err)
+		}
+		if len(ranges) != total {
+			return fmt.Errorf("%s: sees %d of %d vnodes", n.Host, len(ranges), total)
+		}
+		for _, br := range ranges {
+			if !live[br.Vnode.Host] {
+				return fmt.Errorf("%s: sees removed vnode %s", n.Host, difuse.ShortVnodeID(br.Vnode))
+			}
+		}
+	}
+
+	return nil
+}
+
+// Close shuts down all nodes.
+func (c *Cluster) Close() {
+	for _, n := range c.Nodes() {
+		c.Kill(n.Host)
+	}
+}
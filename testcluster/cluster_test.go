@@ -0,0 +1,582 @@
+package testcluster
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/ipkg/difuse"
+	"github.com/ipkg/difuse/store"
+	"github.com/ipkg/difuse/txlog"
+)
+
+func setKeys(t *testing.T, n *Node, count int) {
+	for i := 0; i < count; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		if _, err := n.Difuse.Set(context.Background(), []byte(k), []byte(k), difuse.RequestOptions{Consistency: difuse.ConsistencyAll, WaitApply: true}); err != nil {
+			t.Fatal(k, err)
+		}
+	}
+}
+
+func checkKeys(t *testing.T, n *Node, count int) {
+	for i := 0; i < count; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		val, _, err := n.Difuse.Get(context.Background(), []byte(k), difuse.RequestOptions{Consistency: difuse.ConsistencyLeader})
+		if err != nil {
+			t.Fatal(n.Host, k, err)
+		}
+		if string(val) != k {
+			t.Fatal(n.Host, k, "wrong value", string(val))
+		}
+	}
+}
+
+func TestCluster(t *testing.T) {
+	c, err := New(3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err = c.WaitStable(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := c.Nodes()
+	setKeys(t, nodes[0], 20)
+	checkKeys(t, nodes[2], 20)
+
+	n, err := c.AddNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.WaitStable(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, n, 20)
+
+	if err = c.Leave(nodes[1].Host); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.WaitStable(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, n, 20)
+
+	if c.Node(nodes[1].Host) != nil {
+		t.Fatal("node should be removed")
+	}
+}
+
+func TestClusterGrow(t *testing.T) {
+	c, err := New(1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Keys written before the other nodes join are handed over to them
+	setKeys(t, c.Nodes()[0], 20)
+	for i := 0; i < 2; i++ {
+		if _, err = c.AddNode(); err != nil {
+			t.Fatal(err)
+		}
+		if err = c.WaitStable(5 * time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, n := range c.Nodes() {
+		checkKeys(t, n, 20)
+	}
+}
+
+func TestClusterKill(t *testing.T) {
+	c, err := New(4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err = c.WaitStable(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := c.Nodes()
+	setKeys(t, nodes[0], 10)
+
+	if err = c.Kill(nodes[3].Host); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.WaitStable(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, nodes[1], 10)
+
+	if err = c.Kill(nodes[3].Host); err == nil {
+		t.Fatal("should fail for a removed node")
+	}
+}
+
+func TestClusterRestart(t *testing.T) {
+	c, err := New(3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err = c.WaitStable(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := c.Nodes()
+	setKeys(t, nodes[0], 10)
+	before := storedKeys(c, nodes[1].Host)
+
+	n, err := c.Restart(nodes[1].Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Host != nodes[1].Host || n.Difuse == nodes[1].Difuse {
+		t.Fatal("should start a new node for the host")
+	}
+	if after := storedKeys(c, n.Host); after < before || before == 0 {
+		t.Fatal("stores should be kept", before, after)
+	}
+	if err = c.WaitStable(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, n, 10)
+}
+
+// storedKeys returns the number of keys in the stores of the host's vnodes.
+func storedKeys(c *Cluster, host string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var n int
+	for _, st := range c.stores[host] {
+		st.IterTx(func([]byte, *txlog.KeyTransactions) error {
+			n++
+			return nil
+		})
+	}
+	return n
+}
+
+// waitSplit waits until the successors of the node's vnodes on other hosts are dropped
+// or the node fails to walk the ring.
+func waitSplit(n *Node, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		rs, err := n.Difuse.RingStatus()
+		if err != nil || len(rs.Hosts) == 1 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s: still sees hosts %v", n.Host, rs.Hosts)
+		}
+		time.Sleep(stablePollInterval)
+	}
+}
+
+func TestClusterPartition(t *testing.T) {
+	c, err := New(3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err = c.WaitStable(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := c.Nodes()
+	if err = c.Partition([]string{nodes[0].Host}, []string{nodes[1].Host, nodes[2].Host}); err != nil {
+		t.Fatal(err)
+	}
+	opts := difuse.RequestOptions{Consistency: difuse.ConsistencyAll, WaitApply: true}
+	if _, err = nodes[0].Difuse.Set(context.Background(), []byte("key"), []byte("value"), opts); err == nil {
+		t.Fatal("should fail to reach the other nodes")
+	}
+
+	// Ring maintenance is partitioned as well
+	if err = waitSplit(nodes[0], 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Heal()
+	if err = c.WaitStable(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = nodes[0].Difuse.Set(context.Background(), []byte("key"), []byte("value"), opts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClusterCompareAndSet(t *testing.T) {
+	c, err := New(3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err = c.WaitStable(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	key := []byte("key")
+	nodes := c.Nodes()
+	opts := difuse.RequestOptions{Consistency: difuse.ConsistencyAll, WaitApply: true}
+
+	if _, err = nodes[0].Difuse.CompareAndSet(ctx, key, nil, []byte("v1"), opts); err != nil {
+		t.Fatal(err)
+	}
+	// Most nodes are not the leader so this also covers redirected writes.
+	for _, n := range nodes {
+		if _, err = n.Difuse.CompareAndSet(ctx, key, nil, []byte("v2")); difuse.ErrorCodeOf(err) != difuse.CodeConditionFailed {
+			t.Fatal(n.Host, "key should exist", err)
+		}
+		if _, err = n.Difuse.CompareAndSet(ctx, key, []byte("v0"), []byte("v2")); difuse.ErrorCodeOf(err) != difuse.CodeConditionFailed {
+			t.Fatal(n.Host, "value should differ", err)
+		}
+	}
+
+	if _, err = nodes[2].Difuse.CompareAndSet(ctx, key, []byte("v1"), []byte("v2"), opts); err != nil {
+		t.Fatal(err)
+	}
+	val, _, err := nodes[1].Difuse.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "v2" {
+		t.Fatal("wrong value", string(val))
+	}
+
+	inode, _, err := nodes[1].Difuse.Stat(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = nodes[1].Difuse.DeleteInode(ctx, inode, &opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = nodes[2].Difuse.CompareAndSet(ctx, key, nil, []byte("v3"), opts); err != nil {
+		t.Fatal("deleted key should not exist", err)
+	}
+}
+
+func TestClusterKeyring(t *testing.T) {
+	c, err := New(3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err = c.WaitStable(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	nodes := c.Nodes()
+	opts := &difuse.RequestOptions{Consistency: difuse.ConsistencyAll}
+
+	// Reserved keys cannot be written through the inode write paths of any node
+	for _, n := range nodes {
+		inode := store.NewKeyInodeWithValue(difuse.KeyringKey, []byte("key"))
+		if _, err = n.Difuse.SetInode(ctx, inode, opts); err != difuse.ErrReservedKey {
+			t.Fatal(n.Host, "should reject reserved key", err)
+		}
+		if _, err = n.Difuse.DeleteInode(ctx, inode, opts); err != difuse.ErrReservedKey {
+			t.Fatal(n.Host, "should reject reserved key", err)
+		}
+	}
+
+	// The keyring is seeded with the key of the node creating the cluster
+	keys, err := nodes[2].Difuse.Signers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !bytes.Equal(keys[0], nodes[0].Difuse.PublicKey()) {
+		t.Fatal("keyring not seeded", len(keys))
+	}
+
+	// A signer not in the keyring cannot append to the keyring of any replica
+	kp, _ := txlog.GenerateECDSAKeypair()
+	c.mu.Lock()
+	for _, st := range c.stores[nodes[1].Host] {
+		tx, err := st.NewTx(difuse.KeyringKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx.Data = []byte("keys")
+		tx.Sign(kp)
+		if err = st.AppendTx(tx); err == nil {
+			t.Fatal("should reject unauthorized signer")
+		}
+	}
+	c.mu.Unlock()
+
+	if err = nodes[0].Difuse.AddSigner(ctx, kp.PublicKey().Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err = nodes[2].Difuse.Signers(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatal("wrong signer count", len(keys))
+	}
+
+	if err = nodes[1].Difuse.RevokeSigner(ctx, kp.PublicKey().Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err = nodes[2].Difuse.Signers(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Fatal("wrong signer count", len(keys))
+	}
+}
+
+func TestClusterExport(t *testing.T) {
+	c, err := New(3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err = c.WaitStable(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	nodes := c.Nodes()
+	setKeys(t, nodes[0], 1)
+
+	export := func(n *Node) *txlog.ExportHeader {
+		var buf bytes.Buffer
+		if err := n.Difuse.Export(ctx, []byte("key-0"), &buf); err != nil {
+			t.Fatal(n.Host, err)
+		}
+		hdr, txs, err := txlog.ReadExport(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = txlog.VerifyChain(hdr, txs); err != nil {
+			t.Fatal(err)
+		}
+		return hdr
+	}
+
+	// Signers are taken from the keyring rather than the chain
+	if hdr := export(nodes[2]); len(hdr.Signers) != 1 {
+		t.Fatal("wrong signer count", len(hdr.Signers))
+	}
+	kp, _ := txlog.GenerateECDSAKeypair()
+	if err = nodes[0].Difuse.AddSigner(ctx, kp.PublicKey().Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if hdr := export(nodes[2]); len(hdr.Signers) != 2 {
+		t.Fatal("wrong signer count", len(hdr.Signers))
+	}
+}
+
+// waitChain waits until every store of the host holding the key has n transactions for it.
+func waitChain(c *Cluster, host string, key []byte, n int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		c.mu.Lock()
+		done := true
+		for _, st := range c.stores[host] {
+			if txs, err := st.Transactions(key, nil); err == nil && len(txs) != n {
+				done = false
+			}
+		}
+		c.mu.Unlock()
+
+		if done {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s: chain of %s is not %d long", host, key, n)
+		}
+		time.Sleep(stablePollInterval)
+	}
+}
+
+func TestClusterLeaderMovesBack(t *testing.T) {
+	c, err := New(3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err = c.WaitStable(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Find a key replicated on more than one host
+	ctx := context.Background()
+	var key []byte
+	for i := 0; key == nil; i++ {
+		k := []byte(fmt.Sprintf("key-%d", i))
+		_, _, vm, err := c.Nodes()[0].Difuse.LookupLeader(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(vm) > 1 {
+			key = k
+		}
+	}
+
+	all := difuse.RequestOptions{Consistency: difuse.ConsistencyAll, WaitApply: true}
+
+	meta, err := c.Nodes()[0].Difuse.Set(ctx, key, []byte("v1"), all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader := c.Node(meta.Vnode.Host)
+	var others []string
+	for _, n := range c.Nodes() {
+		if n != leader {
+			others = append(others, n.Host)
+		}
+	}
+
+	// Move leadership away with the leader cut off from the other replicas
+	for _, h := range others {
+		c.Node(h).Faults.SetFaults(&difuse.Faults{Partitions: [][]string{others, {leader.Host}}})
+	}
+	meta, err = c.Node(others[0]).Difuse.Set(ctx, key, []byte("v2"), difuse.RequestOptions{WaitApply: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Vnode.Host == leader.Host {
+		t.Fatal("leadership should have moved")
+	}
+	// Wait for the majority to hold the write
+	for _, h := range others {
+		if err = waitChain(c, h, key, 2, 5*time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Move it back without the old leader seeing the votes of the others.  It must catch
+	// up rather than fork the chain so the write is accepted by all replicas.
+	// The old leader refuses writes while it fails to catch up.  They may still be
+	// accepted by a replica elected once the write is redirected.  The others must not
+	// replicate the write to it in the meantime.
+	for _, h := range others {
+		c.Node(h).Faults.SetFaults(&difuse.Faults{Errors: map[string]string{"AppendTx": "unavailable"}})
+	}
+	leader.Faults.SetFaults(&difuse.Faults{Errors: map[string]string{"MerkleRootTx": "unavailable", "ReplicateTransactions": "unavailable"}})
+	if meta, err = leader.Difuse.Set(ctx, key, []byte("v3"), all); err == nil && meta.Vnode.Host == leader.Host {
+		t.Fatal("should not accept writes before catching up")
+	}
+
+	for _, h := range others {
+		c.Node(h).Faults.SetFaults(&difuse.Faults{})
+	}
+	leader.Faults.SetFaults(&difuse.Faults{Errors: map[string]string{"MerkleRootTx": "unavailable"}})
+	if meta, err = leader.Difuse.Set(ctx, key, []byte("v4"), all); err != nil {
+		t.Fatal(err)
+	}
+	if meta.Vnode.Host != leader.Host {
+		t.Fatal("leadership should have moved back")
+	}
+	leader.Faults.SetFaults(&difuse.Faults{})
+
+	for _, n := range c.Nodes() {
+		val, _, err := n.Difuse.Get(ctx, key, difuse.RequestOptions{Consistency: difuse.ConsistencyLeader})
+		if err != nil {
+			t.Fatal(n.Host, err)
+		}
+		if string(val) != "v4" {
+			t.Fatal(n.Host, "wrong value", string(val))
+		}
+	}
+}
+
+func TestClusterBatch(t *testing.T) {
+	c, err := New(3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err = c.WaitStable(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Later entries of a key overwrite earlier ones
+	var entries []*difuse.BatchEntry
+	for i := 0; i < 60; i++ {
+		k := fmt.Sprintf("key-%d", i%20)
+		entries = append(entries, &difuse.BatchEntry{Key: []byte(k), Value: []byte(fmt.Sprintf("%s-%d", k, i/20))})
+	}
+
+	nodes := c.Nodes()
+	opts := difuse.RequestOptions{Consistency: difuse.ConsistencyAll, WaitApply: true}
+	for _, r := range nodes[0].Difuse.BatchSet(context.Background(), entries, opts) {
+		if r.Err != nil {
+			t.Fatal(string(r.Key), r.Err)
+		}
+	}
+
+	keys := make([][]byte, 20)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key-%d", i))
+	}
+	for _, n := range nodes {
+		for _, r := range n.Difuse.BatchGet(context.Background(), keys, difuse.RequestOptions{Consistency: difuse.ConsistencyLeader}) {
+			if r.Err != nil {
+				t.Fatal(n.Host, string(r.Key), r.Err)
+			}
+			if want := string(r.Key) + "-2"; string(r.Value) != want {
+				t.Fatal(n.Host, "wrong value", string(r.Value), want)
+			}
+		}
+	}
+}
+
+func TestClusterProof(t *testing.T) {
+	c, err := New(3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err = c.WaitStable(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := c.Nodes()
+	key := []byte("key")
+	meta, err := nodes[0].Difuse.Set(context.Background(), key, []byte("value"), difuse.RequestOptions{Consistency: difuse.ConsistencyAll, WaitApply: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range nodes {
+		vl, err := n.Ring.Lookup(c.opts.NumSuccessors, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proofs, err := n.Difuse.Proof(context.Background(), key, meta.Token)
+		if err != nil {
+			t.Fatal(n.Host, err)
+		}
+		if len(proofs) != len(vl) {
+			t.Fatal(n.Host, "should have a proof per replica", len(proofs), len(vl))
+		}
+		for _, p := range proofs {
+			if !p.Verify() {
+				t.Fatal(n.Host, "proof should verify", difuse.ShortVnodeID(p.Vnode))
+			}
+		}
+	}
+}
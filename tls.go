@@ -0,0 +1,192 @@
+package difuse
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+var (
+	errNoCACerts       = errors.New("no CA certificates found")
+	errPeerNotVerified = errors.New("peer certificate not verified")
+)
+
+// TLSConfig holds the PEM encoded files used to secure node-to-node traffic with mutual
+// TLS.  Each node certificate must be signed by the CA and be valid for the node's
+// advertised address, as that is the address peers dial and verify.
+type TLSConfig struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	// Interval at which the files are checked for changes and reloaded.  Zero disables
+	// reloading.
+	ReloadInterval time.Duration
+}
+
+// CertReloader holds the node certificate and CA pool, reloading them from disk when
+// they change.  New connections use the latest certificates while existing connections
+// are unaffected.
+type CertReloader struct {
+	conf *TLSConfig
+
+	mu    sync.RWMutex
+	cert  *tls.Certificate
+	pool  *x509.CertPool
+	mtime time.Time
+}
+
+// NewCertReloader loads the certificates from the config.
+func NewCertReloader(conf *TLSConfig) (*CertReloader, error) {
+	cr := &CertReloader{conf: conf}
+	if err := cr.Reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// Reload reads the certificate, key and CA files.  The current certificates are kept if
+// any of them fail to load.
+func (cr *CertReloader) Reload() error {
+	mtime, err := cr.modTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.conf.CertFile, cr.conf.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	ca, err := ioutil.ReadFile(cr.conf.CAFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return errNoCACerts
+	}
+
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.pool = pool
+	cr.mtime = mtime
+	cr.mu.Unlock()
+
+	return nil
+}
+
+// Start checks the files for changes at the configured interval, reloading them when
+// modified.  It blocks and should be run in a goroutine.
+func (cr *CertReloader) Start() {
+	if cr.conf.ReloadInterval <= 0 {
+		return
+	}
+
+	for range time.Tick(cr.conf.ReloadInterval) {
+		mtime, err := cr.modTime()
+		if err != nil {
+			log.Printf("action=tls-reload status=failed msg='%v'", err)
+			continue
+		}
+
+		cr.mu.RLock()
+		changed := mtime.After(cr.mtime)
+		cr.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		if err = cr.Reload(); err != nil {
+			log.Printf("action=tls-reload status=failed msg='%v'", err)
+		} else {
+			log.Printf("action=tls-reload status=ok")
+		}
+	}
+}
+
+// modTime returns the latest modification time of the files.
+func (cr *CertReloader) modTime() (time.Time, error) {
+	var mtime time.Time
+	for _, p := range []string{cr.conf.CAFile, cr.conf.CertFile, cr.conf.KeyFile} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return mtime, err
+		}
+		if fi.ModTime().After(mtime) {
+			mtime = fi.ModTime()
+		}
+	}
+	return mtime, nil
+}
+
+func (cr *CertReloader) current() (*tls.Certificate, *x509.CertPool) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, cr.pool
+}
+
+// ServerConfig returns the tls config for serving.  Clients must present a certificate
+// signed by the CA.
+func (cr *CertReloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := cr.current()
+			return &tls.Config{
+				Certificates: []tls.Certificate{*cert},
+				ClientCAs:    pool,
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				MinVersion:   tls.VersionTLS12,
+			}, nil
+		},
+	}
+}
+
+// ClientConfig returns the tls config to dial the given host.  The peer certificate must
+// be signed by the CA and be valid for the host i.e. the peer's advertised address.
+func (cr *CertReloader) ClientConfig(host string) (*tls.Config, error) {
+	name, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, pool := cr.current()
+	return &tls.Config{
+		ServerName:   name,
+		Certificates: []tls.Certificate{*cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// verifyPeerHost checks the verified certificate of the tls peer of the request is valid
+// for the host i.e. the peer is the node advertising the host.  Plaintext requests are not
+// checked.
+func verifyPeerHost(ctx context.Context, host string) error {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	ti, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+	if len(ti.State.VerifiedChains) == 0 || len(ti.State.VerifiedChains[0]) == 0 {
+		return errPeerNotVerified
+	}
+
+	name, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return err
+	}
+	return ti.State.VerifiedChains[0][0].VerifyHostname(name)
+}
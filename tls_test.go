@@ -0,0 +1,347 @@
+package difuse
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	chord "github.com/ipkg/go-chord"
+
+	"github.com/ipkg/difuse/netrpc"
+	"github.com/ipkg/difuse/store"
+	"github.com/ipkg/difuse/txlog"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+var testSerial int64
+
+func newTestCA() (*testCA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	testSerial++
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(testSerial),
+		Subject:               pkix.Name{CommonName: "difuse-test-ca"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}, nil
+}
+
+// writeNodeCert writes the ca and a node certificate valid for the ip to the dir,
+// returning the tls config.
+func (ca *testCA) writeNodeCert(dir, name string, ip net.IP) (*TLSConfig, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	testSerial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(testSerial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{ip},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	kder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &TLSConfig{
+		CAFile:   filepath.Join(dir, name+"-ca.pem"),
+		CertFile: filepath.Join(dir, name+".pem"),
+		KeyFile:  filepath.Join(dir, name+"-key.pem"),
+	}
+	files := map[string][]byte{
+		conf.CAFile:   ca.pem,
+		conf.CertFile: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		conf.KeyFile:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}),
+	}
+	for p, b := range files {
+		if err = ioutil.WriteFile(p, b, 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	return conf, nil
+}
+
+type tlsTestNode struct {
+	vn    *chord.Vnode
+	nt    *NetTransport
+	ct    *ChordTransport
+	rpc   *testVnodeRPC
+	certs *CertReloader
+	ln    net.Listener
+}
+
+// testVnodeRPC records the predecessor it is notified of
+type testVnodeRPC struct {
+	vn   *chord.Vnode
+	pred *chord.Vnode
+}
+
+func (r *testVnodeRPC) GetPredecessor() (*chord.Vnode, error) { return r.pred, nil }
+func (r *testVnodeRPC) Notify(vn *chord.Vnode) ([]*chord.Vnode, error) {
+	r.pred = vn
+	return []*chord.Vnode{r.vn}, nil
+}
+func (r *testVnodeRPC) FindSuccessors(n int, key []byte) ([]*chord.Vnode, error) {
+	return []*chord.Vnode{r.vn}, nil
+}
+func (r *testVnodeRPC) ClearPredecessor(vn *chord.Vnode) error {
+	r.pred = nil
+	return nil
+}
+func (r *testVnodeRPC) SkipSuccessor(vn *chord.Vnode) error { return nil }
+
+// Rejected handshakes are retried until the dial timeout so keep it short.
+var tlsTestTimeouts = &NetTimeouts{Dial: 250 * time.Millisecond, RPC: 5 * time.Second}
+
+func startTLSTestNode(conf *TLSConfig, p int) (*tlsTestNode, error) {
+	certs, err := NewCertReloader(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", p))
+	if err != nil {
+		return nil, err
+	}
+
+	nt := NewNetTransport()
+	nt.UseTLS(certs)
+	nt.SetTimeouts(tlsTestTimeouts)
+	ct := NewChordTransport(nt)
+
+	opt := grpc.CustomCodec(&chord.PayloadCodec{})
+	server := grpc.NewServer(opt, grpc.Creds(credentials.NewTLS(certs.ServerConfig())))
+	netrpc.RegisterDifuseRPCServer(server, nt)
+	go server.Serve(ln)
+
+	kp, _ := txlog.GenerateEd25519Keypair()
+	vn := &chord.Vnode{Id: []byte(fmt.Sprintf("vnode-%d", p)), Host: ln.Addr().String()}
+	nt.RegisterVnode(vn, store.NewMemLoggedStore(vn, kp))
+	rpc := &testVnodeRPC{vn: vn}
+	ct.Register(vn, rpc)
+
+	return &tlsTestNode{vn: vn, nt: nt, ct: ct, rpc: rpc, certs: certs, ln: ln}, nil
+}
+
+func TestNetTransportTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "difuse-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, err := newTestCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	localhost := net.ParseIP("127.0.0.1")
+	nodes := make([]*tlsTestNode, 3)
+	for i := range nodes {
+		conf, err := ca.writeNodeCert(dir, fmt.Sprintf("node%d", i), localhost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nodes[i], err = startTLSTestNode(conf, 32340+i); err != nil {
+			t.Fatal(err)
+		}
+		defer nodes[i].ln.Close()
+	}
+
+	// Each node should be able to write to and read from the others.
+	for i, n := range nodes {
+		peer := nodes[(i+1)%len(nodes)]
+		resp, err := n.nt.SetBlock(context.Background(), []byte(fmt.Sprintf("tls-block-%d", i)), nil, peer.vn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp[0].Err != nil {
+			t.Fatal(resp[0].Err)
+		}
+		if resp, err = n.nt.GetBlock(context.Background(), resp[0].Data.([]byte), nil, peer.vn); err != nil {
+			t.Fatal(err)
+		}
+		if resp[0].Err != nil || resp[0].Data == nil {
+			t.Fatal("block not found", resp[0].Err)
+		}
+	}
+
+	// Chord is carried over the same connections
+	if ok, err := nodes[1].ct.Ping(nodes[0].vn); err != nil || !ok {
+		t.Fatal("vnode should exist", err)
+	}
+	if ok, _ := nodes[1].ct.Ping(&chord.Vnode{Id: []byte("missing"), Host: nodes[0].vn.Host}); ok {
+		t.Fatal("vnode should not exist")
+	}
+	if vl, err := nodes[1].ct.ListVnodes(nodes[0].vn.Host); err != nil || len(vl) != 1 {
+		t.Fatal("should list the vnode", err)
+	}
+	if _, err = nodes[1].ct.Notify(nodes[0].vn, nodes[1].vn); err != nil {
+		t.Fatal(err)
+	}
+	pred, err := nodes[2].ct.GetPredecessor(nodes[0].vn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pred == nil || pred.Host != nodes[1].vn.Host {
+		t.Fatal("wrong predecessor", pred)
+	}
+
+	// A peer cannot act for a vnode whose host its certificate is not valid for
+	imposter := &chord.Vnode{Id: []byte("imposter"), Host: "10.0.0.1:32340"}
+	if _, err = nodes[1].ct.Notify(nodes[0].vn, imposter); err == nil {
+		t.Fatal("peer should not act for another host")
+	}
+	if nodes[0].rpc.pred.Host != nodes[1].vn.Host {
+		t.Fatal("predecessor should not change")
+	}
+
+	// A node with a certificate from a different CA should be rejected.
+	rogueCA, _ := newTestCA()
+	rconf, err := rogueCA.writeNodeCert(dir, "rogue", localhost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rogue, err := startTLSTestNode(rconf, 32350)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rogue.ln.Close()
+
+	if _, err = rogue.nt.SetBlock(context.Background(), []byte("rogue"), nil, nodes[0].vn); err == nil {
+		t.Fatal("rogue node should be rejected")
+	}
+	if _, err = nodes[0].nt.SetBlock(context.Background(), []byte("rogue"), nil, rogue.vn); err == nil {
+		t.Fatal("rogue peer should not be trusted")
+	}
+	if _, err = rogue.ct.Ping(nodes[0].vn); err == nil {
+		t.Fatal("rogue node should be rejected by chord")
+	}
+
+	// A peer whose certificate is not valid for its advertised address should be rejected.
+	mconf, err := ca.writeNodeCert(dir, "mismatch", net.ParseIP("10.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatch, err := startTLSTestNode(mconf, 32351)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mismatch.ln.Close()
+
+	if _, err = nodes[0].nt.SetBlock(context.Background(), []byte("mismatch"), nil, mismatch.vn); err == nil {
+		t.Fatal("peer identity should not match the advertised address")
+	}
+
+	// Plaintext clients should not reach the difuse rpc's
+	plain := NewNetTransport()
+	plain.SetTimeouts(tlsTestTimeouts)
+	if _, err = plain.SetBlock(context.Background(), []byte("plain"), nil, nodes[0].vn); err == nil {
+		t.Fatal("plaintext should be rejected")
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "difuse-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, _ := newTestCA()
+	conf, err := ca.writeNodeCert(dir, "node", net.ParseIP("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cr, err := NewCertReloader(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial := func() *big.Int {
+		tc, err := cr.ServerConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := x509.ParseCertificate(tc.Certificates[0].Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.SerialNumber
+	}
+
+	before := serial()
+	if _, err = ca.writeNodeCert(dir, "node", net.ParseIP("127.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+	if err = cr.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if serial().Cmp(before) == 0 {
+		t.Fatal("certificate not reloaded")
+	}
+
+	// A bad key file should keep the current certificate
+	after := serial()
+	ioutil.WriteFile(conf.KeyFile, []byte("invalid"), 0600)
+	if err = cr.Reload(); err == nil {
+		t.Fatal("should fail")
+	}
+	if serial().Cmp(after) != 0 {
+		t.Fatal("certificate should not change")
+	}
+}
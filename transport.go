@@ -3,8 +3,11 @@ package difuse
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"sync"
 
+	"golang.org/x/net/context"
+
 	"github.com/ipkg/difuse/store"
 	"github.com/ipkg/difuse/txlog"
 	chord "github.com/ipkg/go-chord"
@@ -29,24 +32,40 @@ func (vr *VnodeResponse) MarshalJSON() ([]byte, error) {
 
 	if vr.Err != nil {
 		o["error"] = vr.Err.Error()
+		o["code"] = vr.Code().String()
 	}
 
 	return json.Marshal(o)
 }
 
+// Code returns the code of the response error.
+func (vr *VnodeResponse) Code() ErrorCode {
+	return ErrorCodeOf(vr.Err)
+}
+
 // ResponseMeta contains response metadata
 type ResponseMeta struct {
 	// Vnode that executed/responded.  In the case of writes this will be the leader
 	// vnode. For reads it will be the node that performed the acual read
 	Vnode *chord.Vnode
+	// Token is the commit token of a write i.e. the hash of its tx.  It can be passed
+	// in the options of later reads to wait until the serving vnode has applied it.
+	Token []byte
+}
+
+// VnodeTxs is the txs appended to a vnode by a batch append.
+type VnodeTxs struct {
+	Vnode *chord.Vnode
+	Txs   txlog.TxSlice
 }
 
 // localTransport routes requests to local or remote based on the given vnodes.
 type localTransport struct {
 	host string
 
-	lock  sync.Mutex
-	local localStore
+	lock   sync.Mutex
+	local  localStore
+	vnodes []*chord.Vnode // local vnodes in registration order
 
 	remote Transport
 
@@ -57,92 +76,113 @@ func newLocalTransport(remote Transport, cs ConsistentStore) *localTransport {
 	return &localTransport{remote: remote, local: make(localStore), cs: cs}
 }
 
-func (lt *localTransport) Stat(key []byte, options *RequestOptions, vl ...*chord.Vnode) ([]*VnodeResponse, error) {
+func (lt *localTransport) Stat(ctx context.Context, key []byte, options *RequestOptions, vl ...*chord.Vnode) ([]*VnodeResponse, error) {
 	if vl[0].Host == lt.host {
-		return lt.local.Stat(key, options, vl...)
+		return lt.local.Stat(ctx, key, options, vl...)
 	}
-	return lt.remote.Stat(key, options, vl...)
+	return lt.remote.Stat(ctx, key, options, vl...)
 }
 
-func (lt *localTransport) SetInode(host string, inode *store.Inode, options *RequestOptions) (*chord.Vnode, error) {
+func (lt *localTransport) SetInode(ctx context.Context, host string, inode *store.Inode, options *RequestOptions) (*ResponseMeta, error) {
 	if lt.host == host {
-		return lt.cs.SetInode(inode, options)
+		return lt.cs.SetInode(ctx, inode, options)
 	}
-	return lt.remote.SetInode(host, inode, options)
+	return lt.remote.SetInode(ctx, host, inode, options)
 }
 
-func (lt *localTransport) DeleteInode(host string, inode *store.Inode, options *RequestOptions) (*chord.Vnode, error) {
+func (lt *localTransport) DeleteInode(ctx context.Context, host string, inode *store.Inode, options *RequestOptions) (*ResponseMeta, error) {
 	if lt.host == host {
-		return lt.cs.DeleteInode(inode, options)
+		return lt.cs.DeleteInode(ctx, inode, options)
 	}
-	return lt.remote.DeleteInode(host, inode, options)
+	return lt.remote.DeleteInode(ctx, host, inode, options)
 }
 
-func (lt *localTransport) SetBlock(data []byte, options *RequestOptions, vl ...*chord.Vnode) ([]*VnodeResponse, error) {
+func (lt *localTransport) SetBlock(ctx context.Context, data []byte, options *RequestOptions, vl ...*chord.Vnode) ([]*VnodeResponse, error) {
 	if vl[0].Host == lt.host {
 		return lt.local.SetBlock(data, options, vl...)
 	}
-	return lt.remote.SetBlock(data, options, vl...)
+	return lt.remote.SetBlock(ctx, data, options, vl...)
 }
 
-func (lt *localTransport) GetBlock(hash []byte, options *RequestOptions, vl ...*chord.Vnode) ([]*VnodeResponse, error) {
+func (lt *localTransport) GetBlock(ctx context.Context, hash []byte, options *RequestOptions, vl ...*chord.Vnode) ([]*VnodeResponse, error) {
 	if vl[0].Host == lt.host {
 		return lt.local.GetBlock(hash, options, vl...)
 	}
-	return lt.remote.GetBlock(hash, options, vl...)
+	return lt.remote.GetBlock(ctx, hash, options, vl...)
 }
 
-func (lt *localTransport) DeleteBlock(hash []byte, options *RequestOptions, vl ...*chord.Vnode) ([]*VnodeResponse, error) {
+func (lt *localTransport) DeleteBlock(ctx context.Context, hash []byte, options *RequestOptions, vl ...*chord.Vnode) ([]*VnodeResponse, error) {
 	if vl[0].Host == lt.host {
 		return lt.local.DeleteBlock(hash, options, vl...)
 	}
-	return lt.remote.DeleteBlock(hash, options, vl...)
+	return lt.remote.DeleteBlock(ctx, hash, options, vl...)
 }
 
-func (lt *localTransport) ReplicateBlocks(src, dst *chord.Vnode) error {
-	return lt.remote.ReplicateBlocks(src, dst)
+func (lt *localTransport) ReplicateBlocks(ctx context.Context, src, dst *chord.Vnode) error {
+	return lt.remote.ReplicateBlocks(ctx, src, dst)
 }
 
-func (lt *localTransport) AppendTx(tx *txlog.Tx, options *RequestOptions, vl ...*chord.Vnode) ([]*VnodeResponse, error) {
+func (lt *localTransport) AppendTx(ctx context.Context, tx *txlog.Tx, options *RequestOptions, vl ...*chord.Vnode) ([]*VnodeResponse, error) {
 	if vl[0].Host == lt.host {
-		return lt.local.AppendTx(tx, options, vl...)
+		return lt.local.AppendTx(ctx, tx, options, vl...)
+	}
+	return lt.remote.AppendTx(ctx, tx, options, vl...)
+}
+
+func (lt *localTransport) AppendTxs(ctx context.Context, batches []*VnodeTxs, options *RequestOptions) ([]*VnodeResponse, error) {
+	if batches[0].Vnode.Host == lt.host {
+		return lt.local.AppendTxs(ctx, batches, options)
 	}
-	return lt.remote.AppendTx(tx, options, vl...)
+	return lt.remote.AppendTxs(ctx, batches, options)
 }
 
 // ReplicateTransactions replicates transactions from remote to local.  If remote is a local vnode then
 // then replication is assumed to be among two local vnodes.
-func (lt *localTransport) ReplicateTransactions(key, seek []byte, src, dst *chord.Vnode) error {
+func (lt *localTransport) ReplicateTransactions(ctx context.Context, key, seek []byte, src, dst *chord.Vnode) error {
 	if src.Host != lt.host && dst.Host != lt.host {
 		return fmt.Errorf("remote to remote replication")
 	} else if src.Host == lt.host && dst.Host == lt.host {
 		return lt.local.ReplicateTransactions(key, seek, src, dst)
 	} else if src.Host != lt.host {
-		return lt.remote.ReplicateTransactions(key, seek, src, dst)
+		return lt.remote.ReplicateTransactions(ctx, key, seek, src, dst)
 	} else {
-		return lt.remote.ReplicateTransactions(key, seek, dst, src)
+		return lt.remote.ReplicateTransactions(ctx, key, seek, dst, src)
 	}
 }
 
-func (lt *localTransport) GetTx(key, txhash []byte, options *RequestOptions, vl ...*chord.Vnode) ([]*VnodeResponse, error) {
+func (lt *localTransport) Transactions(ctx context.Context, key, seek []byte, vn *chord.Vnode) (txlog.TxSlice, error) {
+	if vn.Host == lt.host {
+		return lt.local.Transactions(key, seek, vn)
+	}
+	return lt.remote.Transactions(ctx, key, seek, vn)
+}
+
+func (lt *localTransport) GetTx(ctx context.Context, key, txhash []byte, options *RequestOptions, vl ...*chord.Vnode) ([]*VnodeResponse, error) {
 	if vl[0].Host == lt.host {
 		return lt.local.GetTx(key, txhash, options, vl...)
 	}
-	return lt.remote.GetTx(key, txhash, options, vl...)
+	return lt.remote.GetTx(ctx, key, txhash, options, vl...)
 }
 
-func (lt *localTransport) LastTx(key []byte, options *RequestOptions, vl ...*chord.Vnode) ([]*VnodeResponse, error) {
+func (lt *localTransport) LastTx(ctx context.Context, key []byte, options *RequestOptions, vl ...*chord.Vnode) ([]*VnodeResponse, error) {
 	if vl[0].Host == lt.host {
 		return lt.local.LastTx(key, options, vl...)
 	}
-	return lt.remote.LastTx(key, options, vl...)
+	return lt.remote.LastTx(ctx, key, options, vl...)
 }
 
-func (lt *localTransport) MerkleRootTx(key []byte, options *RequestOptions, vl ...*chord.Vnode) ([]*VnodeResponse, error) {
+func (lt *localTransport) MerkleRootTx(ctx context.Context, key []byte, options *RequestOptions, vl ...*chord.Vnode) ([]*VnodeResponse, error) {
 	if vl[0].Host == lt.host {
 		return lt.local.MerkleRootTx(key, options, vl...)
 	}
-	return lt.remote.MerkleRootTx(key, options, vl...)
+	return lt.remote.MerkleRootTx(ctx, key, options, vl...)
+}
+
+func (lt *localTransport) ProofTx(ctx context.Context, key, txhash []byte, options *RequestOptions, vl ...*chord.Vnode) ([]*VnodeResponse, error) {
+	if vl[0].Host == lt.host {
+		return lt.local.ProofTx(key, txhash, options, vl...)
+	}
+	return lt.remote.ProofTx(ctx, key, txhash, options, vl...)
 }
 
 func (lt *localTransport) NewTx(key []byte, vl ...*chord.Vnode) ([]*VnodeResponse, error) {
@@ -152,18 +192,43 @@ func (lt *localTransport) NewTx(key []byte, vl ...*chord.Vnode) ([]*VnodeRespons
 	return lt.remote.NewTx(key, vl...)
 }
 
-func (lt *localTransport) TransferKeys(src, dst *chord.Vnode) error {
-	return lt.remote.TransferKeys(src, dst)
+func (lt *localTransport) TransferKeys(ctx context.Context, src, dst *chord.Vnode) error {
+	return lt.remote.TransferKeys(ctx, src, dst)
+}
+
+func (lt *localTransport) Bootstrap(ctx context.Context, src, dst *chord.Vnode, filter *store.SnapshotFilter) error {
+	return lt.remote.Bootstrap(ctx, src, dst, filter)
 }
 
 // set request for remote on leader
 //Set(peer string, key, value []byte, options ...RequestOptions) error
 //Delete(peer string, key []byte, options ...RequestOptions) error
-func (lt *localTransport) LookupLeader(host string, key []byte) (*chord.Vnode, []*chord.Vnode, map[string][]*chord.Vnode, error) {
+func (lt *localTransport) LookupLeader(ctx context.Context, host string, key []byte) (*chord.Vnode, []*chord.Vnode, map[string][]*chord.Vnode, error) {
+	if lt.host == host {
+		return lt.cs.LookupLeader(ctx, key)
+	}
+	return lt.remote.LookupLeader(ctx, host, key)
+}
+
+func (lt *localTransport) BatchGet(ctx context.Context, host string, entries []*BatchEntry, options *RequestOptions) ([]*BatchEntry, error) {
+	if lt.host == host {
+		return batchGet(ctx, lt.cs, entries, options), nil
+	}
+	return lt.remote.BatchGet(ctx, host, entries, options)
+}
+
+func (lt *localTransport) BatchSet(ctx context.Context, host string, entries []*BatchEntry, options *RequestOptions) ([]*BatchEntry, error) {
 	if lt.host == host {
-		return lt.cs.LookupLeader(key)
+		return lt.cs.SetBatch(ctx, entries, options), nil
 	}
-	return lt.remote.LookupLeader(host, key)
+	return lt.remote.BatchSet(ctx, host, entries, options)
+}
+
+func (lt *localTransport) Snapshot(ctx context.Context, src, rng *chord.Vnode) (io.ReadCloser, error) {
+	if src.Host == lt.host {
+		return lt.local.Snapshot(src, lt.cs.RangeFilter(rng))
+	}
+	return lt.remote.Snapshot(ctx, src, rng)
 }
 
 // RegisterVnode registers a datastore for a vnode.
@@ -171,11 +236,26 @@ func (lt *localTransport) RegisterVnode(vn *chord.Vnode, vs VnodeStore) {
 	lt.lock.Lock()
 	lt.host = vn.Host
 	lt.local[vn.String()] = vs
+	lt.vnodes = append(lt.vnodes, vn)
 	lt.lock.Unlock()
 
 	lt.remote.RegisterVnode(vn, vs)
 }
 
+// localVnodes returns the local vnodes along with their stores.
+func (lt *localTransport) localVnodes() ([]*chord.Vnode, []VnodeStore) {
+	lt.lock.Lock()
+	defer lt.lock.Unlock()
+
+	vns := make([]*chord.Vnode, len(lt.vnodes))
+	sts := make([]VnodeStore, len(lt.vnodes))
+	for i, vn := range lt.vnodes {
+		vns[i] = vn
+		sts[i] = lt.local[vn.String()]
+	}
+	return vns, sts
+}
+
 func (lt *localTransport) Register(cs ConsistentStore) {
 	lt.cs = cs
 	lt.remote.Register(cs)
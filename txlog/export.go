@@ -0,0 +1,237 @@
+package txlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+
+	"github.com/ipkg/difuse/gentypes"
+)
+
+const (
+	// version of the export format following the magic bytes
+	exportVersion = 1
+	// max size of a single record guarding against corrupt length prefixes
+	maxExportRecord = 64 << 20
+)
+
+var (
+	exportMagic = []byte("difx")
+
+	errInvalidExport   = fmt.Errorf("invalid export")
+	errExportRecord    = fmt.Errorf("export record too large")
+	errKeyMismatch     = fmt.Errorf("key mismatch")
+	errTxRootMismatch  = fmt.Errorf("merkle root mismatch")
+	errPrevHashInvalid = fmt.Errorf("previous hash mismatch")
+)
+
+// ExportHeader describes the transactions of a key in an export.
+type ExportHeader struct {
+	Key []byte
+	// Merkle root of the transactions as recorded in the inode of the key
+	TxRoot []byte
+	// Public keys of the signers authorized to sign the transactions
+	Signers [][]byte
+}
+
+// WriteExport writes the header followed by the transactions.  Each record is a flatbuffer
+// prefixed with its big endian uint32 length.
+func WriteExport(w io.Writer, hdr *ExportHeader, txs TxSlice) error {
+	magic := make([]byte, len(exportMagic)+1)
+	copy(magic, exportMagic)
+	magic[len(exportMagic)] = exportVersion
+	if _, err := w.Write(magic); err != nil {
+		return err
+	}
+
+	fb := flatbuffers.NewBuilder(0)
+	fb.Finish(serializeExportHeader(fb, hdr))
+	if err := writeRecord(w, fb.Bytes[fb.Head():]); err != nil {
+		return err
+	}
+
+	for _, tx := range txs {
+		fb.Reset()
+		fb.Finish(tx.Serialize(fb))
+		if err := writeRecord(w, fb.Bytes[fb.Head():]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadExport reads an export written by WriteExport returning the header and
+// transactions in order.
+func ReadExport(r io.Reader) (*ExportHeader, TxSlice, error) {
+	magic := make([]byte, len(exportMagic)+1)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(magic[:len(exportMagic)], exportMagic) {
+		return nil, nil, errInvalidExport
+	}
+	if v := magic[len(exportMagic)]; v != exportVersion {
+		return nil, nil, fmt.Errorf("unsupported export version: %d", v)
+	}
+
+	b, err := readRecord(r)
+	if err != nil {
+		if err == io.EOF {
+			err = errInvalidExport
+		}
+		return nil, nil, err
+	}
+	hdr := deserializeExportHeader(gentypes.GetRootAsExportHeader(b, 0))
+
+	txs := TxSlice{}
+	for {
+		if b, err = readRecord(r); err != nil {
+			break
+		}
+
+		tx := &Tx{}
+		tx.Deserialize(gentypes.GetRootAsTx(b, 0))
+		txs = append(txs, tx)
+	}
+
+	if err == io.EOF {
+		err = nil
+	}
+	return hdr, txs, err
+}
+
+func writeRecord(w io.Writer, b []byte) error {
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(b)))
+	if _, err := w.Write(l[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// readRecord reads a length prefixed record.  It returns io.EOF only if there are no more
+// records.
+func readRecord(r io.Reader) ([]byte, error) {
+	var l [4]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(l[:])
+	if n > maxExportRecord {
+		return nil, errExportRecord
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+func serializeExportHeader(fb *flatbuffers.Builder, hdr *ExportHeader) flatbuffers.UOffsetT {
+	ofs := make([]flatbuffers.UOffsetT, len(hdr.Signers))
+	for i, pk := range hdr.Signers {
+		bp := fb.CreateByteString(pk)
+		gentypes.ByteSliceStart(fb)
+		gentypes.ByteSliceAddB(fb, bp)
+		ofs[i] = gentypes.ByteSliceEnd(fb)
+	}
+
+	gentypes.ExportHeaderStartSignersVector(fb, len(ofs))
+	for _, o := range ofs {
+		fb.PrependUOffsetT(o)
+	}
+	sp := fb.EndVector(len(ofs))
+
+	kp := fb.CreateByteString(hdr.Key)
+	rp := fb.CreateByteString(hdr.TxRoot)
+
+	gentypes.ExportHeaderStart(fb)
+	gentypes.ExportHeaderAddKey(fb, kp)
+	gentypes.ExportHeaderAddRoot(fb, rp)
+	gentypes.ExportHeaderAddSigners(fb, sp)
+	return gentypes.ExportHeaderEnd(fb)
+}
+
+func deserializeExportHeader(obj *gentypes.ExportHeader) *ExportHeader {
+	hdr := &ExportHeader{Key: obj.KeyBytes(), TxRoot: obj.RootBytes()}
+
+	l := obj.SignersLength()
+	hdr.Signers = make([][]byte, l)
+	for i := 0; i < l; i++ {
+		var bs gentypes.ByteSlice
+		obj.Signers(&bs, i)
+		// vectors are prepended so they are read in reverse order
+		hdr.Signers[l-i-1] = bs.BBytes()
+	}
+	return hdr
+}
+
+// ChainError is the first broken link found when verifying a chain of transactions.
+type ChainError struct {
+	// Index of the transaction in the chain
+	Index int
+	// Hash of the transaction
+	Tx  []byte
+	Err error
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("tx %d (%x): %v", e.Index, e.Tx, e.Err)
+}
+
+// VerifyChain verifies the transactions form the complete chain of the key in the header.
+// Each transaction must be signed by one of the signers, unless there are none, and link
+// to the previous one starting from the zero hash.  The merkle root of the transactions
+// must match the TxRoot of the header.  It returns a ChainError for the first broken
+// link.
+func VerifyChain(hdr *ExportHeader, txs TxSlice) error {
+	kr := NewKeyring(hdr.Signers...)
+	err := VerifyTxs(hdr.Key, txs, func(pubkey, signature, hash []byte) error {
+		if len(hdr.Signers) > 0 && !kr.Authorized(pubkey) {
+			return errUnauthorizedSigner
+		}
+		return VerifySignature(pubkey, signature, hash)
+	})
+	if err != nil {
+		return err
+	}
+
+	root, err := txs.MerkleRoot()
+	if err == nil && !EqualBytes(root, hdr.TxRoot) {
+		err = fmt.Errorf("%v: %x != %x", errTxRootMismatch, root, hdr.TxRoot)
+	}
+	return err
+}
+
+// VerifyTxs verifies the transactions form the chain of the key from the zero hash, each
+// one verified by the verify func and linking to the previous one.  It returns a
+// ChainError for the first broken link.
+func VerifyTxs(key []byte, txs TxSlice, verify func(pubkey, signature, hash []byte) error) error {
+	prev := ZeroHash()
+	for i, tx := range txs {
+		var err error
+		switch {
+		case !bytes.Equal(tx.Key, key):
+			err = errKeyMismatch
+		case !EqualBytes(tx.PrevHash, prev):
+			err = errPrevHashInvalid
+		default:
+			err = verify(tx.Source, tx.Signature, tx.Hash())
+		}
+
+		if err != nil {
+			return &ChainError{Index: i, Tx: tx.Hash(), Err: err}
+		}
+		prev = tx.Hash()
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package txlog
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	kp, _ := GenerateECDSAKeypair()
+	ekp, _ := GenerateEd25519Keypair()
+	key := []byte("key")
+
+	txs := genChain(kp, key, ZeroHash(), 3, "a")
+	txs = append(txs, genChain(ekp, key, txs.Last().Hash(), 2, "b")...)
+	root, _ := txs.MerkleRoot()
+
+	hdr := &ExportHeader{Key: key, TxRoot: root, Signers: [][]byte{kp.PublicKey().Bytes(), ekp.PublicKey().Bytes()}}
+
+	var buf bytes.Buffer
+	if err := WriteExport(&buf, hdr, txs); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	h, out, err := ReadExport(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(h.Key) != "key" || !EqualBytes(h.TxRoot, root) || len(h.Signers) != 2 || !EqualBytes(h.Signers[1], hdr.Signers[1]) {
+		t.Fatal("header mismatch")
+	}
+	if len(out) != len(txs) {
+		t.Fatal("wrong number of txs", len(out))
+	}
+	for i, tx := range out {
+		if !EqualBytes(tx.Hash(), txs[i].Hash()) || !EqualBytes(tx.Signature, txs[i].Signature) {
+			t.Fatal("tx mismatch", i)
+		}
+	}
+	if err = VerifyChain(h, out); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = ReadExport(bytes.NewReader(data[:len(data)-3])); err != io.ErrUnexpectedEOF {
+		t.Fatal("should fail on truncated record", err)
+	}
+	if _, _, err = ReadExport(bytes.NewReader(data[1:])); err != errInvalidExport {
+		t.Fatal("should fail on bad magic", err)
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	kp, _ := GenerateECDSAKeypair()
+	other, _ := GenerateECDSAKeypair()
+	key := []byte("key")
+
+	txs := genChain(kp, key, ZeroHash(), 5, "a")
+	root, _ := txs.MerkleRoot()
+	hdr := &ExportHeader{Key: key, TxRoot: root}
+
+	if err := VerifyChain(hdr, txs); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unknown signer
+	hdr.Signers = [][]byte{other.PublicKey().Bytes()}
+	if err, ok := VerifyChain(hdr, txs).(*ChainError); !ok || err.Index != 0 || err.Err != errUnauthorizedSigner {
+		t.Fatal("should fail on signer", err)
+	}
+	hdr.Signers = nil
+
+	// Tampered data
+	data := txs[2].Data
+	txs[2].Data = []byte("tampered")
+	if err, ok := VerifyChain(hdr, txs).(*ChainError); !ok || err.Index != 2 || err.Err != errSignatureVerify {
+		t.Fatal("should fail on signature", err)
+	}
+	txs[2].Data = data
+
+	// Missing link
+	broken := append(append(TxSlice{}, txs[:2]...), txs[3:]...)
+	if err, ok := VerifyChain(hdr, broken).(*ChainError); !ok || err.Index != 2 || err.Err != errPrevHashInvalid {
+		t.Fatal("should fail on link", err)
+	}
+
+	// Truncated chain
+	if err := VerifyChain(hdr, txs[:4]); err == nil {
+		t.Fatal("should fail on merkle root")
+	}
+	if err := VerifyChain(&ExportHeader{Key: key, TxRoot: ZeroHash()}, TxSlice{}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,26 @@
+package txlog
+
+// CommonAncestor returns the indices of the last transaction common to both chains.  The
+// chains are expected to be ordered from oldest to newest.  If the chains share no
+// transactions -1 is returned for both.
+func CommonAncestor(a, b TxSlice) (ai int, bi int) {
+	idx := make(map[string]int, len(a))
+	for i, tx := range a {
+		idx[string(tx.Hash())] = i
+	}
+
+	for bi = len(b) - 1; bi >= 0; bi-- {
+		if ai, ok := idx[string(b[bi].Hash())]; ok {
+			return ai, bi
+		}
+	}
+
+	return -1, -1
+}
+
+// IsForked returns whether neither chain contains the other i.e. both chains have
+// transactions after their common ancestor.
+func IsForked(a, b TxSlice) bool {
+	ai, bi := CommonAncestor(a, b)
+	return ai < len(a)-1 && bi < len(b)-1
+}
@@ -0,0 +1,169 @@
+package txlog
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// genChain generates a signed chain of n transactions starting from prev.
+func genChain(kp Signator, key, prev []byte, n int, prefix string) TxSlice {
+	txs := make(TxSlice, n)
+	for i := 0; i < n; i++ {
+		tx := NewTx(key, prev, []byte(fmt.Sprintf("%s-%d", prefix, i)))
+		tx.Sign(kp)
+		txs[i] = tx
+		prev = tx.Hash()
+	}
+	return txs
+}
+
+func TestCommonAncestor(t *testing.T) {
+	kp, _ := GenerateECDSAKeypair()
+	key := []byte("key")
+
+	base := genChain(kp, key, ZeroHash(), 3, "base")
+	a := append(append(TxSlice{}, base...), genChain(kp, key, base.Last().Hash(), 2, "a")...)
+	b := append(append(TxSlice{}, base...), genChain(kp, key, base.Last().Hash(), 4, "b")...)
+
+	ai, bi := CommonAncestor(a, b)
+	if ai != 2 || bi != 2 {
+		t.Fatalf("wrong ancestor a=%d b=%d", ai, bi)
+	}
+	if !IsForked(a, b) {
+		t.Fatal("should be forked")
+	}
+	if IsForked(base, a) {
+		t.Fatal("prefix should not be forked")
+	}
+
+	c := genChain(kp, key, ZeroHash(), 2, "c")
+	if ai, bi = CommonAncestor(a, c); ai != -1 || bi != -1 {
+		t.Fatal("should have no ancestor")
+	}
+}
+
+func TestTxLogRebase(t *testing.T) {
+	kp, _ := GenerateECDSAKeypair()
+	key := []byte("key")
+	store := NewMemTxStore()
+	txl := NewTxLog(kp, store, &testFsm{t: t})
+
+	base := genChain(kp, key, ZeroHash(), 2, "base")
+	local := genChain(kp, key, base.Last().Hash(), 2, "local")
+	remote := genChain(kp, key, base.Last().Hash(), 3, "remote")
+
+	for _, tx := range append(append(TxSlice{}, base...), local...) {
+		store.Add(tx)
+	}
+
+	err := txl.AppendTx(remote[0])
+	if !IsPrevHashError(err) {
+		t.Fatalf("should be prev hash error: %v", err)
+	}
+
+	orphaned, err := txl.Rebase(key, base.Last().Hash(), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphaned) != 2 {
+		t.Fatalf("wrong orphan count: %d", len(orphaned))
+	}
+
+	ltx, err := txl.LastTx(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !EqualBytes(ltx.Hash(), remote.Last().Hash()) {
+		t.Fatal("last tx should be the remote tip")
+	}
+
+	orphans, err := store.Orphans(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 1 || !EqualBytes(orphans[0].Last().Hash(), local.Last().Hash()) {
+		t.Fatal("orphaned branch not kept")
+	}
+
+	// Branch not chaining from the ancestor
+	if _, err = txl.Rebase(key, base.Last().Hash(), local[1:]); !IsPrevHashError(err) {
+		t.Fatal("should fail with prev hash error")
+	}
+}
+
+func TestTxLogRebaseQueued(t *testing.T) {
+	kp, _ := GenerateECDSAKeypair()
+	key := []byte("key")
+	store := NewMemTxStore()
+	txl := NewTxLog(kp, store, nopFsm{})
+
+	base := genChain(kp, key, ZeroHash(), 2, "base")
+	local := genChain(kp, key, base.Last().Hash(), 2, "local")
+	remote := genChain(kp, key, base.Last().Hash(), 3, "remote")
+
+	for _, tx := range base {
+		store.Add(tx)
+	}
+
+	// Queue the local branch without applying it
+	for _, tx := range local {
+		if err := txl.AppendTx(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := txl.Rebase(key, base.Last().Hash(), remote); err != nil {
+		t.Fatal(err)
+	}
+
+	go txl.Start()
+	defer txl.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := txl.WaitTx(ctx, key, local[0].Hash()); !IsPrevHashError(err) {
+		t.Fatal("queued orphan should be dropped", err)
+	}
+
+	next := genChain(kp, key, remote.Last().Hash(), 1, "next")
+	if err := txl.AppendTxWait(ctx, next[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	txs, _ := store.Transactions(key, nil)
+	if len(txs) != 6 || !EqualBytes(txs.Last().Hash(), next[0].Hash()) {
+		t.Fatal("orphans should not be committed", len(txs))
+	}
+}
+
+func TestTxLogEpoch(t *testing.T) {
+	kp, _ := GenerateECDSAKeypair()
+	key := []byte("key")
+	txl := NewTxLog(kp, NewMemTxStore(), nopFsm{})
+	go txl.Start()
+	defer txl.Shutdown()
+
+	tx, _ := txl.NewTx(key)
+	tx.Epoch = 3
+	tx.Sign(kp)
+	if err := txl.AppendTx(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Epochs carry over to new txs and are part of the hash
+	next, _ := txl.NewTx(key)
+	if next.Epoch != 3 {
+		t.Fatal("epoch should carry over", next.Epoch)
+	}
+	h := next.Hash()
+	next.Epoch = 2
+	if EqualBytes(h, next.Hash()) {
+		t.Fatal("epoch should be hashed")
+	}
+	next.Sign(kp)
+	if err := txl.AppendTx(next); err == nil {
+		t.Fatal("epoch should not go back")
+	}
+}
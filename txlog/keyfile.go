@@ -0,0 +1,212 @@
+package txlog
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	pemTypeECPrivateKey      = "EC PRIVATE KEY"
+	pemTypeEd25519PrivateKey = "ED25519 PRIVATE KEY"
+	pemTypeEncryptedPrefix   = "ENCRYPTED "
+
+	// scrypt parameters used to derive the key file encryption key
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	saltLen      = 16
+)
+
+var (
+	errPassphraseRequired = fmt.Errorf("key file encrypted: passphrase required")
+	errInvalidKeyFile     = fmt.Errorf("invalid key file")
+)
+
+// MarshalKeypair PEM encodes an ECDSA or Ed25519 keypair.  If a passphrase is provided the
+// key is encrypted with AES-256-GCM using a key derived from the passphrase with scrypt.
+func MarshalKeypair(kp Signator, passphrase []byte) ([]byte, error) {
+	var (
+		typ string
+		der []byte
+		err error
+	)
+
+	switch k := kp.(type) {
+	case *ECDSAKeypair:
+		typ = pemTypeECPrivateKey
+		if der, err = x509.MarshalECPrivateKey(k.PrivateKey); err != nil {
+			return nil, err
+		}
+	case *Ed25519Keypair:
+		typ = pemTypeEd25519PrivateKey
+		der = k.PrivateKey[:32]
+	default:
+		return nil, fmt.Errorf("unsupported keypair: %T", kp)
+	}
+
+	if len(passphrase) == 0 {
+		return pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), nil
+	}
+
+	salt := make([]byte, saltLen)
+	if _, err = rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	gcm, err := newKeyFileCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	blk := &pem.Block{
+		Type: pemTypeEncryptedPrefix + typ,
+		Headers: map[string]string{
+			"KDF":    "scrypt",
+			"Cipher": "AES-256-GCM",
+			"Salt":   hex.EncodeToString(salt),
+			"Nonce":  hex.EncodeToString(nonce),
+		},
+		Bytes: gcm.Seal(nil, nonce, der, nil),
+	}
+	return pem.EncodeToMemory(blk), nil
+}
+
+// UnmarshalKeypair decodes a PEM encoded keypair, decrypting it with the passphrase if it
+// is encrypted.
+func UnmarshalKeypair(b []byte, passphrase []byte) (Signator, error) {
+	blk, _ := pem.Decode(b)
+	if blk == nil {
+		return nil, errInvalidKeyFile
+	}
+
+	der := blk.Bytes
+	typ := blk.Type
+
+	if strings.HasPrefix(typ, pemTypeEncryptedPrefix) {
+		typ = strings.TrimPrefix(typ, pemTypeEncryptedPrefix)
+		if len(passphrase) == 0 {
+			return nil, errPassphraseRequired
+		}
+
+		salt, err := hex.DecodeString(blk.Headers["Salt"])
+		if err != nil {
+			return nil, err
+		}
+		nonce, err := hex.DecodeString(blk.Headers["Nonce"])
+		if err != nil {
+			return nil, err
+		}
+
+		gcm, err := newKeyFileCipher(passphrase, salt)
+		if err != nil {
+			return nil, err
+		}
+		if len(nonce) != gcm.NonceSize() {
+			return nil, errInvalidKeyFile
+		}
+
+		if der, err = gcm.Open(nil, nonce, der, nil); err != nil {
+			return nil, fmt.Errorf("failed to decrypt key file: %v", err)
+		}
+	}
+
+	switch typ {
+	case pemTypeECPrivateKey:
+		pk, err := x509.ParseECPrivateKey(der)
+		if err != nil {
+			return nil, err
+		}
+		return &ECDSAKeypair{PrivateKey: pk}, nil
+
+	case pemTypeEd25519PrivateKey:
+		if len(der) != 32 {
+			return nil, errInvalidKeyFile
+		}
+		_, pk, err := ed25519.GenerateKey(bytes.NewReader(der))
+		if err != nil {
+			return nil, err
+		}
+		return &Ed25519Keypair{PrivateKey: pk}, nil
+	}
+
+	return nil, errInvalidKeyFile
+}
+
+// WriteKeypairFile writes the keypair to the given path readable only by the owner.  It
+// fails if the file already exists so as to not accidentally replace a node identity.
+func WriteKeypairFile(path string, kp Signator, passphrase []byte) error {
+	b, err := MarshalKeypair(kp, passphrase)
+	if err != nil {
+		return err
+	}
+
+	fh, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = fh.Write(b); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
+
+// ReadKeypairFile reads the keypair from the given path.
+func ReadKeypairFile(path string, passphrase []byte) (Signator, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return UnmarshalKeypair(b, passphrase)
+}
+
+// LoadOrGenerateKeypair reads the keypair from the path.  If the file does not exist a new
+// keypair of the given scheme is generated and written to the path.  It returns whether a
+// new keypair was generated.
+func LoadOrGenerateKeypair(path string, passphrase []byte, scheme SignatureScheme) (Signator, bool, error) {
+	kp, err := ReadKeypairFile(path, passphrase)
+	if err == nil {
+		return kp, false, nil
+	} else if !os.IsNotExist(err) {
+		return nil, false, err
+	}
+
+	if kp, err = GenerateKeypair(scheme); err != nil {
+		return nil, false, err
+	}
+
+	err = WriteKeypairFile(path, kp, passphrase)
+	return kp, true, err
+}
+
+func newKeyFileCipher(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	blk, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(blk)
+}
@@ -0,0 +1,79 @@
+package txlog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeypairFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "difuse-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ekp, _ := GenerateECDSAKeypair()
+	dkp, _ := GenerateEd25519Keypair()
+
+	for _, kp := range []Signator{ekp, dkp} {
+		for _, pass := range [][]byte{nil, []byte("secret")} {
+			p := filepath.Join(dir, fmt.Sprintf("node-%T-%s.key", kp, pass))
+			if err = WriteKeypairFile(p, kp, pass); err != nil {
+				t.Fatal(err)
+			}
+			// Should not overwrite
+			if err = WriteKeypairFile(p, kp, pass); err == nil {
+				t.Fatal("should fail")
+			}
+
+			rkp, err := ReadKeypairFile(p, pass)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !EqualBytes(kp.PublicKey().Bytes(), rkp.PublicKey().Bytes()) {
+				t.Fatal("public key mismatch")
+			}
+			// Signatures from the loaded key should verify against the original
+			sig, err := rkp.Sign([]byte("data"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = kp.Verify(kp.PublicKey().Bytes(), sig.Bytes(), []byte("data")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	p := filepath.Join(dir, "node-*txlog.ECDSAKeypair-secret.key")
+	if _, err = ReadKeypairFile(p, nil); err != errPassphraseRequired {
+		t.Fatal("should require passphrase")
+	}
+	if _, err = ReadKeypairFile(p, []byte("wrong")); err == nil {
+		t.Fatal("should fail with wrong passphrase")
+	}
+
+	p = filepath.Join(dir, "generated.key")
+	gkp, created, err := LoadOrGenerateKeypair(p, nil, SchemeEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created {
+		t.Fatal("should be created")
+	}
+	lkp, created, err := LoadOrGenerateKeypair(p, nil, SchemeEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created {
+		t.Fatal("should be loaded")
+	}
+	if _, ok := lkp.(*Ed25519Keypair); !ok {
+		t.Fatal("should be an ed25519 keypair")
+	}
+	if !EqualBytes(gkp.PublicKey().Bytes(), lkp.PublicKey().Bytes()) {
+		t.Fatal("identity changed across loads")
+	}
+}
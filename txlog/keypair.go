@@ -4,17 +4,37 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 
 	"github.com/tv42/base58"
+	"golang.org/x/crypto/ed25519"
 )
 
-const ecdsaKeySize = 28
+const (
+	// Size in bytes of a P256 coordinate
+	ecdsaKeySize = 32
+	// Size the second value of encoded public keys and signatures was padded to before
+	// values were padded to the coordinate size.
+	legacyECDSAKeySize = 28
+)
 
 // PublicKey represents a public key to obtain the byte encoding
 type PublicKey interface {
 	Bytes() []byte
 }
 
+// GenerateKeypair generates a new keypair for the given signature scheme
+func GenerateKeypair(scheme SignatureScheme) (Signator, error) {
+	switch scheme {
+	case SchemeECDSA:
+		return GenerateECDSAKeypair()
+	case SchemeEd25519:
+		return GenerateEd25519Keypair()
+	}
+	return nil, fmt.Errorf("unsupported signature scheme: %d", scheme)
+}
+
 // ECDSAKeypair is used to satisfy the keypair interface
 type ECDSAKeypair struct {
 	PrivateKey *ecdsa.PrivateKey
@@ -33,10 +53,7 @@ func GenerateECDSAKeypair() (*ECDSAKeypair, error) {
 func (ekp *ECDSAKeypair) Sign(data []byte) (*Signature, error) {
 	sr, ss, err := ecdsa.Sign(rand.Reader, ekp.PrivateKey, data)
 	if err == nil {
-		//pub := ekp.PrivateKey.PublicKey
-		//pk := base58.EncodeBig([]byte{}, joinBigInt(ecdsaKeySize, pub.X, pub.Y))
-		//return &Signature{r: sr, s: ss}, pk, nil
-		return &Signature{r: sr, s: ss}, nil
+		return &Signature{scheme: SchemeECDSA, r: sr, s: ss}, nil
 	}
 	return nil, err
 }
@@ -46,14 +63,10 @@ func (ekp *ECDSAKeypair) PublicKey() PublicKey {
 	return ECDSAPublicKey(ekp.PrivateKey.PublicKey)
 }
 
-// Verify signature given the public key and data hash
+// Verify signature given the public key and data hash.  Signatures of any supported
+// scheme are verified.
 func (ekp *ECDSAKeypair) Verify(pubkey, signature, hash []byte) error {
-	sig, err := NewSignatureFromBytes(signature)
-	if err == nil {
-		err = sig.Verify(pubkey, hash)
-	}
-
-	return err
+	return VerifySignature(pubkey, signature, hash)
 }
 
 // ECDSAPublicKey satifsfies the PublicKey interface
@@ -66,3 +79,57 @@ func (pk ECDSAPublicKey) Bytes() []byte {
 	b := joinBigInt(ecdsaKeySize, x, y)
 	return base58.EncodeBig([]byte{}, b)
 }
+
+// Ed25519Keypair is an Ed25519 signator.  Signatures and public keys have fixed size
+// encodings.
+type Ed25519Keypair struct {
+	PrivateKey ed25519.PrivateKey
+}
+
+// GenerateEd25519Keypair generates a new Ed25519 keypair
+func GenerateEd25519Keypair() (*Ed25519Keypair, error) {
+	_, pk, err := ed25519.GenerateKey(rand.Reader)
+	if err == nil {
+		return &Ed25519Keypair{PrivateKey: pk}, nil
+	}
+	return nil, err
+}
+
+// Sign data returning the signature
+func (kp *Ed25519Keypair) Sign(data []byte) (*Signature, error) {
+	return &Signature{scheme: SchemeEd25519, b: ed25519.Sign(kp.PrivateKey, data)}, nil
+}
+
+// PublicKey of the given private key
+func (kp *Ed25519Keypair) PublicKey() PublicKey {
+	return Ed25519PublicKey(kp.PrivateKey.Public().(ed25519.PublicKey))
+}
+
+// Verify signature given the public key and data hash.  Signatures of any supported
+// scheme are verified.
+func (kp *Ed25519Keypair) Verify(pubkey, signature, hash []byte) error {
+	return VerifySignature(pubkey, signature, hash)
+}
+
+// Ed25519PublicKey satisfies the PublicKey interface
+type Ed25519PublicKey ed25519.PublicKey
+
+// Bytes of the hex encoded public key
+func (pk Ed25519PublicKey) Bytes() []byte {
+	b := make([]byte, ed25519HexKeyLen)
+	hex.Encode(b, pk)
+	return b
+}
+
+// NormalizePublicKey returns the public key in the current encoding.  ECDSA public keys
+// in the legacy encoding are re-encoded.  Keys that cannot be decoded are returned as is.
+func NormalizePublicKey(pubkey []byte) []byte {
+	if len(pubkey) == ed25519HexKeyLen {
+		return pubkey
+	}
+	pub, err := decodeECDSAPublicKeyBytes(pubkey)
+	if err != nil {
+		return pubkey
+	}
+	return ECDSAPublicKey(pub).Bytes()
+}
@@ -0,0 +1,120 @@
+package txlog
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var errUnauthorizedSigner = fmt.Errorf("unauthorized signer")
+
+// Keyring holds the encoded public keys of the signers authorized to append
+// transactions.  Keys are normalized so a signer is matched regardless of the encoding of
+// its public key.  A keyring authorizes no signers but those in it, and a pending keyring
+// authorizes none until its keys are set.
+type Keyring struct {
+	mu      sync.RWMutex
+	keys    map[string]bool
+	pending bool
+}
+
+// NewKeyring instantiates a new keyring with the given public keys.
+func NewKeyring(pubkeys ...[]byte) *Keyring {
+	kr := &Keyring{keys: make(map[string]bool)}
+	for _, pk := range pubkeys {
+		kr.keys[string(NormalizePublicKey(pk))] = true
+	}
+	return kr
+}
+
+// NewPendingKeyring instantiates a keyring that authorizes no signers until its keys are
+// first set e.g. once the keys have been read from the cluster.
+func NewPendingKeyring() *Keyring {
+	return &Keyring{keys: make(map[string]bool), pending: true}
+}
+
+// Pending returns whether the keys have yet to be set.
+func (kr *Keyring) Pending() bool {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.pending
+}
+
+// Add a public key to the keyring.
+func (kr *Keyring) Add(pubkey []byte) {
+	pubkey = NormalizePublicKey(pubkey)
+	kr.mu.Lock()
+	kr.keys[string(pubkey)] = true
+	kr.mu.Unlock()
+}
+
+// Revoke removes the public key from the keyring.
+func (kr *Keyring) Revoke(pubkey []byte) {
+	pubkey = NormalizePublicKey(pubkey)
+	kr.mu.Lock()
+	delete(kr.keys, string(pubkey))
+	kr.mu.Unlock()
+}
+
+// Set replaces all keys in the keyring with the given ones.
+func (kr *Keyring) Set(pubkeys [][]byte) {
+	m := make(map[string]bool, len(pubkeys))
+	for _, pk := range pubkeys {
+		m[string(NormalizePublicKey(pk))] = true
+	}
+
+	kr.mu.Lock()
+	kr.keys = m
+	kr.pending = false
+	kr.mu.Unlock()
+}
+
+// Authorized returns whether the public key is allowed to sign transactions.
+func (kr *Keyring) Authorized(pubkey []byte) bool {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	if kr.pending {
+		return false
+	}
+	return kr.keys[string(NormalizePublicKey(pubkey))]
+}
+
+// Keys returns all public keys in the keyring in sorted order.
+func (kr *Keyring) Keys() [][]byte {
+	kr.mu.RLock()
+	ks := make([]string, 0, len(kr.keys))
+	for k := range kr.keys {
+		ks = append(ks, k)
+	}
+	kr.mu.RUnlock()
+
+	sort.Strings(ks)
+	out := make([][]byte, len(ks))
+	for i, k := range ks {
+		out[i] = []byte(k)
+	}
+	return out
+}
+
+// KeyringSignator wraps a Signator only verifying signatures from public keys in the
+// keyring.  It authorizes new transactions.  Transactions already part of a chain are
+// verified by their signature alone as their signer may have been revoked since.
+type KeyringSignator struct {
+	Signator
+	Keyring *Keyring
+}
+
+// NewKeyringSignator returns a Signator signing with the given signator and verifying
+// only signers in the keyring.
+func NewKeyringSignator(signator Signator, kr *Keyring) *KeyringSignator {
+	return &KeyringSignator{Signator: signator, Keyring: kr}
+}
+
+// Verify the signer is in the keyring and the signature is valid.
+func (ks *KeyringSignator) Verify(pubkey, signature, hash []byte) error {
+	if !ks.Keyring.Authorized(pubkey) {
+		return errUnauthorizedSigner
+	}
+	return ks.Signator.Verify(pubkey, signature, hash)
+}
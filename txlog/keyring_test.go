@@ -0,0 +1,98 @@
+package txlog
+
+import "testing"
+
+func TestKeyringSignator(t *testing.T) {
+	kp1, _ := GenerateECDSAKeypair()
+	kp2, _ := GenerateECDSAKeypair()
+
+	kr := NewKeyring()
+	ks := NewKeyringSignator(kp1, kr)
+
+	txl := NewTxLog(ks, NewMemTxStore(), &testFsm{t: t})
+
+	tx, _ := txl.NewTx([]byte("key"))
+	tx.Data = []byte("value")
+	tx.Sign(kp2)
+
+	// Empty keyring allows none
+	if err := tx.VerifySignature(ks); err != errUnauthorizedSigner {
+		t.Fatalf("should be unauthorized: %v", err)
+	}
+
+	kr.Add(kp1.PublicKey().Bytes())
+	if err := txl.AppendTx(tx); err != errUnauthorizedSigner {
+		t.Fatalf("should be unauthorized: %v", err)
+	}
+
+	kr.Add(kp2.PublicKey().Bytes())
+	if len(kr.Keys()) != 2 {
+		t.Fatal("should have 2 keys")
+	}
+	if err := txl.AppendTx(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	kr.Revoke(kp2.PublicKey().Bytes())
+	if kr.Authorized(kp2.PublicKey().Bytes()) {
+		t.Fatal("should be revoked")
+	}
+
+	kr.Set([][]byte{kp2.PublicKey().Bytes()})
+	if kr.Authorized(kp1.PublicKey().Bytes()) || !kr.Authorized(kp2.PublicKey().Bytes()) {
+		t.Fatal("keys not replaced")
+	}
+}
+
+func TestPendingKeyring(t *testing.T) {
+	kp, _ := GenerateECDSAKeypair()
+	pk := kp.PublicKey().Bytes()
+
+	kr := NewPendingKeyring()
+	if !kr.Pending() || kr.Authorized(pk) {
+		t.Fatal("pending keyring should authorize no signers")
+	}
+
+	kr.Set([][]byte{})
+	if kr.Pending() || kr.Authorized(pk) {
+		t.Fatal("empty keyring should authorize no signers")
+	}
+
+	kr.Set([][]byte{pk})
+	if !kr.Authorized(pk) {
+		t.Fatal("should authorize signers once set")
+	}
+}
+
+func TestReplicateTxRevokedSigner(t *testing.T) {
+	kp1, _ := GenerateECDSAKeypair()
+	kp2, _ := GenerateECDSAKeypair()
+
+	kr := NewKeyring(kp1.PublicKey().Bytes())
+	txl := NewTxLog(NewKeyringSignator(kp1, kr), NewMemTxStore(), &testFsm{t: t})
+
+	tx, _ := txl.NewTx([]byte("key"))
+	tx.Data = []byte("value")
+	tx.Sign(kp2)
+
+	// New txs need an authorized signer while existing history only a valid signature
+	if err := txl.AppendTx(tx); err != errUnauthorizedSigner {
+		t.Fatalf("should be unauthorized: %v", err)
+	}
+	if err := txl.ReplicateTx(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	next := NewTx([]byte("key"), ZeroHash(), []byte("value"))
+	next.Sign(kp1)
+	if err := txl.ReplicateTx(next); !IsPrevHashError(err) {
+		t.Fatalf("should not link: %v", err)
+	}
+
+	bad := NewTx([]byte("key"), tx.Hash(), []byte("value"))
+	bad.Sign(kp1)
+	bad.Data = []byte("other")
+	if err := txl.ReplicateTx(bad); err == nil {
+		t.Fatal("should fail signature")
+	}
+}
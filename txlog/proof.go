@@ -0,0 +1,162 @@
+package txlog
+
+import (
+	"encoding/hex"
+	"encoding/json"
+
+	merkle "github.com/ipkg/go-merkle"
+)
+
+// ProofNode is the sibling of a node on the path from a leaf up to the merkle root.
+type ProofNode struct {
+	// Hash of the sibling.  Nil if the node has no sibling at its level.
+	Hash []byte
+	// Whether the sibling is on the left of the node
+	Left bool
+}
+
+// MarshalJSON encodes the hash as hex for legibility
+func (pn *ProofNode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"hash": hex.EncodeToString(pn.Hash),
+		"left": pn.Left,
+	})
+}
+
+// UnmarshalJSON decodes a node encoded by MarshalJSON
+func (pn *ProofNode) UnmarshalJSON(b []byte) error {
+	var m struct {
+		Hash string `json:"hash"`
+		Left bool   `json:"left"`
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+
+	var err error
+	if m.Hash != "" {
+		pn.Hash, err = hex.DecodeString(m.Hash)
+	}
+	pn.Left = m.Left
+	return err
+}
+
+// StoreProof proves a tx is part of the chain of its key and the chain part of the store.
+// Both proofs are taken from the same version of the store.
+type StoreProof struct {
+	// Merkle root of the key's transactions and the proof of the tx within it
+	TxRoot  []byte
+	TxProof Proof
+	// Merkle root of the store and the proof of the key's merkle root within it
+	Root     []byte
+	KeyProof Proof
+}
+
+// Proof is a merkle inclusion proof.  It holds the siblings on the path from a leaf up to
+// the root.
+type Proof []*ProofNode
+
+// merkleParent returns the hash of the parent of the nodes as computed by the merkle tree.
+// right is nil for a node without a sibling.
+func merkleParent(left, right []byte) []byte {
+	if right == nil {
+		return merkle.GenerateTree([][]byte{left}).Root().Hash()
+	}
+	return merkle.GenerateTree([][]byte{left, right}).Root().Hash()
+}
+
+// merkleLevels returns the levels of the merkle tree of the leaves starting with the
+// leaves and ending with the root.
+func merkleLevels(leaves [][]byte) [][][]byte {
+	levels := [][][]byte{leaves}
+
+	for level := leaves; len(level) > 1; {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for j := 0; j < len(level); j += 2 {
+			var right []byte
+			if j+1 < len(level) {
+				right = level[j+1]
+			}
+			next = append(next, merkleParent(level[j], right))
+		}
+		levels = append(levels, next)
+		level = next
+	}
+
+	return levels
+}
+
+// appendLeaf appends the leaf to the levels of a merkle tree updating the last node of
+// each level above it.
+func appendLeaf(levels [][][]byte, leaf []byte) [][][]byte {
+	if len(levels) == 0 {
+		return [][][]byte{{leaf}}
+	}
+	levels[0] = append(levels[0], leaf)
+
+	for l := 0; len(levels[l]) > 1; l++ {
+		level := levels[l]
+		p := (len(level) - 1) / 2
+
+		var right []byte
+		if 2*p+1 < len(level) {
+			right = level[2*p+1]
+		}
+		parent := merkleParent(level[2*p], right)
+
+		switch {
+		case l+1 == len(levels):
+			levels = append(levels, [][]byte{parent})
+		case p < len(levels[l+1]):
+			levels[l+1][p] = parent
+		default:
+			levels[l+1] = append(levels[l+1], parent)
+		}
+	}
+	return levels
+}
+
+// levelsProof returns the inclusion proof of the leaf at index i in the merkle tree with
+// the given levels.
+func levelsProof(levels [][][]byte, i int) Proof {
+	proof := Proof{}
+
+	for _, level := range levels[:len(levels)-1] {
+		switch {
+		case i%2 == 1:
+			proof = append(proof, &ProofNode{Hash: level[i-1], Left: true})
+		case i+1 < len(level):
+			proof = append(proof, &ProofNode{Hash: level[i+1]})
+		default:
+			proof = append(proof, &ProofNode{})
+		}
+		i /= 2
+	}
+
+	return proof
+}
+
+// newProof returns the inclusion proof of the leaf at index i in the merkle tree of the
+// leaves.
+func newProof(leaves [][]byte, i int) Proof {
+	return levelsProof(merkleLevels(leaves), i)
+}
+
+// VerifyProof returns whether the proof shows the leaf is included in the merkle tree
+// with the given root.  The root must come from a trusted source.
+func VerifyProof(leaf, root []byte, proof Proof) bool {
+	h := leaf
+	for _, pn := range proof {
+		switch {
+		case pn == nil:
+			return false
+		case pn.Hash == nil:
+			h = merkleParent(h, nil)
+		case pn.Left:
+			h = merkleParent(pn.Hash, h)
+		default:
+			h = merkleParent(h, pn.Hash)
+		}
+	}
+	return EqualBytes(h, root)
+}
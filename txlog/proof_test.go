@@ -0,0 +1,144 @@
+package txlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	merkle "github.com/ipkg/go-merkle"
+)
+
+func TestVerifyProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		leaves := make([][]byte, n)
+		for i := range leaves {
+			h := NewTx([]byte("key"), ZeroHash(), []byte(fmt.Sprint(i))).Hash()
+			leaves[i] = h
+		}
+		root := merkle.GenerateTree(leaves).Root().Hash()
+
+		for i := range leaves {
+			proof := newProof(leaves, i)
+			if !VerifyProof(leaves[i], root, proof) {
+				t.Fatalf("leaves=%d index=%d should verify", n, i)
+			}
+			if VerifyProof(leaves[(i+1)%n], root, proof) && n > 1 {
+				t.Fatalf("leaves=%d index=%d should not verify other leaf", n, i)
+			}
+			if len(proof) > 0 {
+				proof[0] = &ProofNode{Hash: ZeroHash(), Left: proof[0].Left}
+				if VerifyProof(leaves[i], root, proof) {
+					t.Fatalf("leaves=%d index=%d should not verify tampered proof", n, i)
+				}
+			}
+		}
+	}
+}
+
+func TestTxStoreProof(t *testing.T) {
+	st := NewMemTxStore()
+	var txs TxSlice
+	for _, k := range []string{"a", "b", "c"} {
+		prev := ZeroHash()
+		for i := 0; i < 5; i++ {
+			tx := NewTx([]byte(k), prev, []byte(fmt.Sprint(i)))
+			st.Add(tx)
+			prev = tx.Hash()
+			txs = append(txs, tx)
+		}
+	}
+
+	for _, tx := range txs {
+		txroot, proof, err := st.Proof(tx.Key, tx.Hash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mr, _ := st.MerkleRoot(tx.Key); !EqualBytes(mr, txroot) {
+			t.Fatal("txroot mismatch")
+		}
+		if !VerifyProof(tx.Hash(), txroot, proof) {
+			t.Fatal("tx proof should verify", string(tx.Key))
+		}
+
+		root, kproof, err := st.KeyProof(tx.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mr, _ := st.MerkleRoot(nil); !EqualBytes(mr, root) {
+			t.Fatal("store root mismatch")
+		}
+		if !VerifyProof(txroot, root, kproof) {
+			t.Fatal("key proof should verify", string(tx.Key))
+		}
+	}
+
+	if _, _, err := st.Proof([]byte("a"), ZeroHash()); err == nil {
+		t.Fatal("should fail")
+	}
+	if _, _, err := st.KeyProof([]byte("z")); err == nil {
+		t.Fatal("should fail")
+	}
+
+	// Cached store tree is rebuilt after a write
+	cached, _, _ := st.KeyProof(txs[0].Key)
+	st.Add(NewTx([]byte("z"), ZeroHash(), []byte("z")))
+	root, kproof, err := st.KeyProof([]byte("z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if EqualBytes(cached, root) {
+		t.Fatal("store root should change")
+	}
+	ztxroot, _ := st.MerkleRoot([]byte("z"))
+	if !VerifyProof(ztxroot, root, kproof) {
+		t.Fatal("key proof should verify after write")
+	}
+
+	// Round trip through json
+	_, proof, _ := st.Proof(txs[1].Key, txs[1].Hash())
+	b, err := json.Marshal(proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out Proof
+	if err = json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	txroot, _ := st.MerkleRoot(txs[1].Key)
+	if !VerifyProof(txs[1].Hash(), txroot, out) {
+		t.Fatal("decoded proof should verify", string(b))
+	}
+}
+
+func TestTxStoreStoreProof(t *testing.T) {
+	st := NewMemTxStore()
+	if _, _, err := st.KeyProof([]byte("a")); err != errNotFound {
+		t.Fatal("empty store should have no key proof", err)
+	}
+	if _, err := st.StoreProof([]byte("a"), ZeroHash()); err != errNotFound {
+		t.Fatal("empty store should have no proof", err)
+	}
+
+	var txs TxSlice
+	for _, k := range []string{"a", "b"} {
+		tx := NewTx([]byte(k), ZeroHash(), []byte(k))
+		st.Add(tx)
+		txs = append(txs, tx)
+	}
+
+	for _, tx := range txs {
+		sp, err := st.StoreProof(tx.Key, tx.Hash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !VerifyProof(tx.Hash(), sp.TxRoot, sp.TxProof) || !VerifyProof(sp.TxRoot, sp.Root, sp.KeyProof) {
+			t.Fatal("store proof should verify", string(tx.Key))
+		}
+		if root, _ := st.MerkleRoot(nil); !EqualBytes(root, sp.Root) {
+			t.Fatal("store root mismatch")
+		}
+	}
+	if _, err := st.StoreProof([]byte("a"), ZeroHash()); err == nil {
+		t.Fatal("should fail for an unknown tx")
+	}
+}
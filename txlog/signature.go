@@ -3,62 +3,205 @@ package txlog
 import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"encoding/hex"
 	"fmt"
 	"math/big"
 
 	"github.com/tv42/base58"
+	"golang.org/x/crypto/ed25519"
 )
 
+// SignatureScheme identifies the algorithm used to sign a transaction.  It is encoded as
+// the first byte of the signature so nodes using different schemes can verify each
+// other's transactions.
+type SignatureScheme byte
+
+const (
+	// SchemeECDSA is ECDSA on the P256 curve
+	SchemeECDSA SignatureScheme = iota + 1
+	// SchemeEd25519 is Ed25519
+	SchemeEd25519
+)
+
+const (
+	ecdsaSigSize     = 64
+	ed25519HexKeyLen = ed25519.PublicKeySize * 2
+)
+
+func (s SignatureScheme) String() string {
+	switch s {
+	case SchemeECDSA:
+		return "ecdsa"
+	case SchemeEd25519:
+		return "ed25519"
+	}
+	return "unknown"
+}
+
+// Set parses the scheme name satisfying the flag.Value interface
+func (s *SignatureScheme) Set(name string) error {
+	scheme, err := ParseSignatureScheme(name)
+	if err == nil {
+		*s = scheme
+	}
+	return err
+}
+
+// ParseSignatureScheme parses the name of a signature scheme.
+func ParseSignatureScheme(name string) (SignatureScheme, error) {
+	switch name {
+	case "ecdsa":
+		return SchemeECDSA, nil
+	case "ed25519":
+		return SchemeEd25519, nil
+	}
+	return 0, fmt.Errorf("unsupported signature scheme: %s", name)
+}
+
 var (
 	errSignatureVerify = fmt.Errorf("signature verification failed")
 	errNotSigned       = fmt.Errorf("not signed")
 	errAlreadySigned   = fmt.Errorf("already signed")
+	errInvalidPubKey   = fmt.Errorf("invalid public key")
 )
 
 // Signature of a block or transaction
 type Signature struct {
+	scheme SignatureScheme
+	// ecdsa
 	r *big.Int
 	s *big.Int
+	// legacy encoded ecdsa signature whose r and s may be split differently
+	legacy *big.Int
+	// ed25519
+	b []byte
 }
 
-// NewSignatureFromBytes decodes signature bytes into a signature struct.
+// NewSignatureFromBytes decodes signature bytes into a signature struct.  Signatures
+// without a scheme tag are decoded as legacy base58 encoded ECDSA signatures.
 func NewSignatureFromBytes(b []byte) (*Signature, error) {
 	if b == nil || len(b) == 0 {
 		return nil, errNotSigned
 	}
 
+	switch SignatureScheme(b[0]) {
+	case SchemeECDSA:
+		if len(b) != ecdsaSigSize+1 {
+			return nil, errSignatureVerify
+		}
+		half := ecdsaSigSize / 2
+		return &Signature{
+			scheme: SchemeECDSA,
+			r:      new(big.Int).SetBytes(b[1 : half+1]),
+			s:      new(big.Int).SetBytes(b[half+1:]),
+		}, nil
+
+	case SchemeEd25519:
+		if len(b) != ed25519.SignatureSize+1 {
+			return nil, errSignatureVerify
+		}
+		return &Signature{scheme: SchemeEd25519, b: b[1:]}, nil
+	}
+
 	bi, err := base58.DecodeToBig(b)
 	if err == nil {
-		pp := splitBigInt(bi, 2)
-		return &Signature{r: pp[0], s: pp[1]}, nil
+		pp := splitBigInt(bi, ecdsaKeySize, 2)
+		return &Signature{scheme: SchemeECDSA, r: pp[0], s: pp[1], legacy: bi}, nil
 	}
 
 	return nil, err
 }
 
-// Bytes of encoded signature
+// Scheme returns the scheme used to create the signature
+func (sig *Signature) Scheme() SignatureScheme {
+	return sig.scheme
+}
+
+// Bytes of encoded signature.  The first byte is the scheme followed by the fixed size
+// signature.
 func (sig *Signature) Bytes() []byte {
-	b := joinBigInt(ecdsaKeySize, sig.r, sig.s)
-	return base58.EncodeBig([]byte{}, b)
+	switch sig.scheme {
+	case SchemeEd25519:
+		return append([]byte{byte(SchemeEd25519)}, sig.b...)
+	}
+
+	half := ecdsaSigSize / 2
+	b := make([]byte, ecdsaSigSize+1)
+	b[0] = byte(SchemeECDSA)
+	rb, sb := sig.r.Bytes(), sig.s.Bytes()
+	copy(b[1+half-len(rb):half+1], rb)
+	copy(b[1+ecdsaSigSize-len(sb):], sb)
+	return b
 }
 
 // Verify data given the public key using the signature
 func (sig *Signature) Verify(pubkey []byte, data []byte) error {
+	switch sig.scheme {
+	case SchemeEd25519:
+		pub, err := decodeEd25519PublicKeyBytes(pubkey)
+		if err != nil {
+			return err
+		}
+		if !ed25519.Verify(pub, data, sig.b) {
+			return errSignatureVerify
+		}
+		return nil
+	}
+
 	pub, err := decodeECDSAPublicKeyBytes(pubkey)
-	if err == nil {
-		if ok := ecdsa.Verify(&pub, data, sig.r, sig.s); !ok {
-			err = errSignatureVerify
+	if err != nil {
+		return err
+	}
+	if ecdsa.Verify(&pub, data, sig.r, sig.s) {
+		return nil
+	}
+	if sig.legacy != nil {
+		for _, pp := range splitLegacyBigInt(sig.legacy) {
+			if ecdsa.Verify(&pub, data, pp[0], pp[1]) {
+				return nil
+			}
 		}
 	}
+	return errSignatureVerify
+}
+
+// VerifySignature verifies the signature of any supported scheme given the public key and
+// data hash.
+func VerifySignature(pubkey, signature, hash []byte) error {
+	sig, err := NewSignatureFromBytes(signature)
+	if err == nil {
+		err = sig.Verify(pubkey, hash)
+	}
 	return err
 }
 
+// decodeECDSAPublicKeyBytes decodes the public key in either the current or legacy
+// encoding.  The encodings only differ when the Y coordinate has leading zeros.
 func decodeECDSAPublicKeyBytes(pk []byte) (pub ecdsa.PublicKey, err error) {
 	var b *big.Int
-	if b, err = base58.DecodeToBig(pk); err == nil {
-		sigg := splitBigInt(b, 2)
-		pub = ecdsa.PublicKey{Curve: elliptic.P256(), X: sigg[0], Y: sigg[1]}
+	if b, err = base58.DecodeToBig(pk); err != nil {
+		return
+	}
+
+	curve := elliptic.P256()
+	cands := append([][]*big.Int{splitBigInt(b, ecdsaKeySize, 2)}, splitLegacyBigInt(b)...)
+	for _, xy := range cands {
+		if curve.IsOnCurve(xy[0], xy[1]) {
+			return ecdsa.PublicKey{Curve: curve, X: xy[0], Y: xy[1]}, nil
+		}
 	}
 
-	return
+	return pub, errInvalidPubKey
+}
+
+func decodeEd25519PublicKeyBytes(pk []byte) (ed25519.PublicKey, error) {
+	if len(pk) != ed25519HexKeyLen {
+		return nil, errInvalidPubKey
+	}
+
+	b := make([]byte, ed25519.PublicKeySize)
+	if _, err := hex.Decode(b, pk); err != nil {
+		return nil, errInvalidPubKey
+	}
+	return ed25519.PublicKey(b), nil
 }
@@ -0,0 +1,119 @@
+package txlog
+
+import (
+	"testing"
+
+	"github.com/tv42/base58"
+)
+
+func TestSignatureSchemes(t *testing.T) {
+	ekp, _ := GenerateECDSAKeypair()
+	dkp, _ := GenerateEd25519Keypair()
+
+	signers := []Signator{ekp, dkp}
+	sizes := []int{ecdsaSigSize + 1, 65}
+
+	for i, signer := range signers {
+		tx := NewTx([]byte("key"), ZeroHash(), []byte("data"))
+		if err := tx.Sign(signer); err != nil {
+			t.Fatal(err)
+		}
+		if len(tx.Signature) != sizes[i] {
+			t.Fatalf("signature size want=%d have=%d", sizes[i], len(tx.Signature))
+		}
+
+		// Each node should verify transactions signed with either scheme.
+		for _, verifier := range signers {
+			if err := tx.VerifySignature(verifier); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tx.Data = []byte("tampered")
+		if err := tx.VerifySignature(signer); err == nil {
+			t.Fatal("should fail")
+		}
+	}
+}
+
+func TestVerifyLegacySignature(t *testing.T) {
+	kp, _ := GenerateECDSAKeypair()
+	hash := []byte("01234567890123456789012345678901")
+
+	// Include signatures where s has leading zeros as the legacy encoding dropped them
+	for i, short := 0, 0; short < 2; i++ {
+		sig, err := kp.Sign(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sig.s.Bytes()) < ecdsaKeySize {
+			short++
+		} else if i > 0 {
+			continue
+		}
+
+		legacy := base58.EncodeBig([]byte{}, joinBigInt(legacyECDSAKeySize, sig.r, sig.s))
+		if err = VerifySignature(kp.PublicKey().Bytes(), legacy, hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLegacyPublicKey(t *testing.T) {
+	hash := []byte("01234567890123456789012345678901")
+
+	// The encodings only differ when Y has leading zeros
+	var kp *ECDSAKeypair
+	for kp == nil || len(kp.PrivateKey.Y.Bytes()) == ecdsaKeySize {
+		kp, _ = GenerateECDSAKeypair()
+	}
+	pub := kp.PrivateKey.PublicKey
+	legacy := base58.EncodeBig([]byte{}, joinBigInt(legacyECDSAKeySize, pub.X, pub.Y))
+	current := kp.PublicKey().Bytes()
+	if string(legacy) == string(current) {
+		t.Fatal("encodings should differ")
+	}
+
+	if string(NormalizePublicKey(legacy)) != string(current) {
+		t.Fatal("legacy key not normalized")
+	}
+	if string(NormalizePublicKey(current)) != string(current) {
+		t.Fatal("current key should not change")
+	}
+
+	sig, _ := kp.Sign(hash)
+	if err := VerifySignature(legacy, sig.Bytes(), hash); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keyrings holding either encoding authorize the signer
+	if !NewKeyring(legacy).Authorized(current) || !NewKeyring(current).Authorized(legacy) {
+		t.Fatal("signer should be authorized")
+	}
+}
+
+func TestVerifySignatureInvalid(t *testing.T) {
+	dkp, _ := GenerateEd25519Keypair()
+	ekp, _ := GenerateECDSAKeypair()
+	hash := []byte("01234567890123456789012345678901")
+
+	sig, _ := dkp.Sign(hash)
+	// Truncated signature
+	if err := VerifySignature(dkp.PublicKey().Bytes(), sig.Bytes()[:10], hash); err == nil {
+		t.Fatal("should fail")
+	}
+	// Mismatched public key scheme
+	if err := VerifySignature(ekp.PublicKey().Bytes(), sig.Bytes(), hash); err == nil {
+		t.Fatal("should fail")
+	}
+}
+
+func TestParseSignatureScheme(t *testing.T) {
+	var s SignatureScheme
+	if err := s.Set("ed25519"); err != nil || s != SchemeEd25519 {
+		t.Fatal("should be ed25519")
+	}
+	if _, err := ParseSignatureScheme("rsa"); err == nil {
+		t.Fatal("should fail")
+	}
+}
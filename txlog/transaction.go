@@ -4,6 +4,10 @@ package txlog
 type KeyTransactions struct {
 	txs  TxSlice
 	root []byte
+	// levels of the merkle tree of the tx hashes updated as txs are added
+	levels [][][]byte
+	// side branches orphaned by fork resolution
+	orphans []TxSlice
 }
 
 // NewKeyTransactions instances a new KeyTransactions to manages tx's for a key
@@ -19,25 +23,93 @@ func (k *KeyTransactions) Root() []byte {
 	return k.root
 }
 
-// Transactions returns all transactions
+// Transactions returns all transactions.  The slice is capped so appending to it does not
+// write to the chain.
 func (k *KeyTransactions) Transactions(seek []byte) (TxSlice, error) {
+	n := len(k.txs)
 	if seek == nil {
-		return k.txs, nil
+		return k.txs[:n:n], nil
 	}
 
 	for i, v := range k.txs {
 		if EqualBytes(v.Hash(), seek) {
-			return k.txs[i:], nil
+			return k.txs[i:n:n], nil
 		}
 	}
 
 	return nil, errNotFound
 }
 
+// Proof returns the inclusion proof of the tx with the given hash within the merkle root
+// of the key's transactions.
+func (k *KeyTransactions) Proof(txhash []byte) (Proof, error) {
+	idx := k.txs.Index(txhash)
+	if idx < 0 {
+		return nil, errNotFound
+	}
+	return levelsProof(k.levels, idx), nil
+}
+
 // AddTx adds a transaction for the key and updates the merkle root
-func (k *KeyTransactions) AddTx(tx *Tx) (err error) {
-	k.txs = append(k.txs, tx)
-	k.root, err = k.txs.MerkleRoot()
+func (k *KeyTransactions) AddTx(tx *Tx) error {
+	return k.AddTxs(tx)
+}
+
+// AddTxs adds the transactions for the key in order.  The merkle tree is updated along
+// the path of each new tx rather than rebuilt.
+func (k *KeyTransactions) AddTxs(txs ...*Tx) error {
+	for _, tx := range txs {
+		k.levels = appendLeaf(k.levels, tx.Hash())
+	}
+	k.txs = append(k.txs, txs...)
+	k.setRoot()
+	return nil
+}
+
+// setRoot sets the merkle root from the top level of the tree.
+func (k *KeyTransactions) setRoot() {
+	if len(k.levels) == 0 {
+		k.root = ZeroHash()
+		return
+	}
+	k.root = k.levels[len(k.levels)-1][0]
+}
+
+// Orphans returns the side branches orphaned by fork resolution.
+func (k *KeyTransactions) Orphans() []TxSlice {
+	return k.orphans
+}
+
+// Rebase replaces all transactions after the ancestor with the branch and updates the
+// merkle root.  The replaced transactions are kept as an orphaned side branch and
+// returned.  A zero hash ancestor replaces the complete chain.
+func (k *KeyTransactions) Rebase(ancestor []byte, branch TxSlice) (orphaned TxSlice, err error) {
+	i := -1
+	if !IsZeroHash(ancestor) {
+		if i = k.txs.Index(ancestor); i < 0 {
+			return nil, errNotFound
+		}
+	}
+
+	if n := k.txs[i+1:]; len(n) > 0 {
+		orphaned = make(TxSlice, len(n))
+		copy(orphaned, n)
+		k.orphans = append(k.orphans, orphaned)
+	}
+
+	txs := make(TxSlice, i+1, i+1+len(branch))
+	copy(txs, k.txs[:i+1])
+	k.txs = append(txs, branch...)
+
+	leaves := make([][]byte, len(k.txs))
+	for j, tx := range k.txs {
+		leaves[j] = tx.Hash()
+	}
+	k.levels = nil
+	if len(leaves) > 0 {
+		k.levels = merkleLevels(leaves)
+	}
+	k.setRoot()
 
 	return
 }
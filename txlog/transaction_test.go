@@ -1,6 +1,9 @@
 package txlog
 
-import "testing"
+import (
+	"fmt"
+	"testing"
+)
 
 func genSlices() TxSlice {
 	return TxSlice{
@@ -38,3 +41,54 @@ func Test_Tx_Sign_Verify(t *testing.T) {
 	}
 
 }
+
+func TestKeyTransactionsIncrementalRoot(t *testing.T) {
+	kt := NewKeyTransactions()
+	var all TxSlice
+
+	prev := ZeroHash()
+	for n := 1; n <= 20; n++ {
+		// Alternate single and batched appends
+		var txs TxSlice
+		for i := 0; i < n%3+1; i++ {
+			tx := NewTx([]byte("key"), prev, []byte(fmt.Sprint(len(all)+i)))
+			prev = tx.Hash()
+			txs = append(txs, tx)
+		}
+		kt.AddTxs(txs...)
+		all = append(all, txs...)
+
+		root, _ := all.MerkleRoot()
+		if !EqualBytes(kt.Root(), root) {
+			t.Fatalf("txs=%d root mismatch", len(all))
+		}
+		for _, tx := range all {
+			proof, err := kt.Proof(tx.Hash())
+			if err != nil || !VerifyProof(tx.Hash(), root, proof) {
+				t.Fatalf("txs=%d proof should verify", len(all))
+			}
+		}
+	}
+
+	// Appending to the returned txs leaves the chain as is
+	txs, _ := kt.Transactions(nil)
+	_ = append(txs, NewTx([]byte("key"), ZeroHash(), nil))
+	kt.AddTx(NewTx([]byte("key"), prev, []byte("last")))
+	if txs, _ = kt.Transactions(nil); !EqualBytes(txs[len(txs)-1].Data, []byte("last")) {
+		t.Fatal("chain should not be written through the returned txs")
+	}
+
+	if _, err := kt.Rebase(all[4].Hash(), all[10:12]); err != nil {
+		t.Fatal(err)
+	}
+	txs, _ = kt.Transactions(nil)
+	root, _ := txs.MerkleRoot()
+	if len(txs) != 7 || !EqualBytes(kt.Root(), root) {
+		t.Fatal("root mismatch after rebase", len(txs))
+	}
+	kt.AddTx(NewTx([]byte("key"), txs[6].Hash(), []byte("after")))
+	txs, _ = kt.Transactions(nil)
+	if root, _ = txs.MerkleRoot(); !EqualBytes(kt.Root(), root) {
+		t.Fatal("root mismatch after rebase and append")
+	}
+}
@@ -1,10 +1,14 @@
 package txlog
 
 import (
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 
 	"github.com/btcsuite/fastsha256"
+	flatbuffers "github.com/google/flatbuffers/go"
+
+	"github.com/ipkg/difuse/gentypes"
 )
 
 // Signator is used to sign a transaction
@@ -19,6 +23,9 @@ type TxHeader struct {
 	PrevHash    []byte
 	Source      []byte // from pubkey
 	Destination []byte // to pubkey
+	// Leader epoch the tx was written in.  A leader taking over a key starts a new epoch
+	// above any it has seen.
+	Epoch uint64
 }
 
 // Tx represents a single transaction
@@ -56,8 +63,15 @@ func (tx *Tx) DataHash() []byte {
 
 // bytesToGenHash returns the byte slice that should be used to generate the hash
 func (tx *Tx) bytesToGenHash() []byte {
-	// key + data hash + previous hash + src pub key + dst pub key
-	return concat(tx.Key, tx.DataHash(), tx.PrevHash, tx.Source, tx.Destination)
+	// key + data hash + previous hash + src pub key + dst pub key [+ epoch]
+	b := concat(tx.Key, tx.DataHash(), tx.PrevHash, tx.Source, tx.Destination)
+	// The epoch is only hashed when set so txs written before epochs keep their hash.
+	if tx.Epoch > 0 {
+		var e [8]byte
+		binary.BigEndian.PutUint64(e[:], tx.Epoch)
+		b = append(b, e[:]...)
+	}
+	return b
 }
 
 // Hash of the whole Tx
@@ -83,3 +97,36 @@ func (tx *Tx) Sign(signer Signator) error {
 func (tx *Tx) VerifySignature(verifier Signator) error {
 	return verifier.Verify(tx.Source, tx.Signature, tx.Hash())
 }
+
+// Deserialize deserializes the flatbuffer object into the Tx
+func (tx *Tx) Deserialize(obj *gentypes.Tx) {
+	tx.Key = obj.KeyBytes()
+	tx.Data = obj.DataBytes()
+	tx.Signature = obj.SignatureBytes()
+	tx.TxHeader = &TxHeader{
+		PrevHash:    obj.PrevHashBytes(),
+		Source:      obj.SourceBytes(),
+		Destination: obj.DestinationBytes(),
+		Epoch:       obj.Epoch(),
+	}
+}
+
+// Serialize serializes the Tx into the flatbuffer returning the offset.
+func (tx *Tx) Serialize(fb *flatbuffers.Builder) flatbuffers.UOffsetT {
+	kp := fb.CreateByteString(tx.Key)
+	pp := fb.CreateByteString(tx.PrevHash)
+	sp := fb.CreateByteString(tx.Source)
+	dp := fb.CreateByteString(tx.Destination)
+	ddp := fb.CreateByteString(tx.Data)
+	ssp := fb.CreateByteString(tx.Signature)
+
+	gentypes.TxStart(fb)
+	gentypes.TxAddKey(fb, kp)
+	gentypes.TxAddPrevHash(fb, pp)
+	gentypes.TxAddSource(fb, sp)
+	gentypes.TxAddDestination(fb, dp)
+	gentypes.TxAddData(fb, ddp)
+	gentypes.TxAddSignature(fb, ssp)
+	gentypes.TxAddEpoch(fb, tx.Epoch)
+	return gentypes.TxEnd(fb)
+}
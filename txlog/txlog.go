@@ -3,66 +3,139 @@ package txlog
 import (
 	"fmt"
 	"log"
+	"runtime"
 	"sync"
+	"time"
+
+	"golang.org/x/net/context"
 )
 
 const (
 	defaultTxBufIn = 32
+	// max transactions of a shard committed to the store at once
+	maxCommitBatch = 128
+	// time waited for a tx that is neither in the store nor queued to be appended
+	defaultUnknownTxWait = 5 * time.Second
 
 	errPrevHash = "previous hash want=%x have=%x"
+	errEpoch    = "epoch went back want>=%d have=%d"
 )
 
 var (
 	//errPrevHash = fmt.Errorf("previous hash mismatch")
 	errNotFound = fmt.Errorf("not found")
+	// ErrUnknownTx is returned when waiting for a tx that was not appended in time
+	ErrUnknownTx = fmt.Errorf("unknown tx")
 )
 
-// FSM represents the finite state machine
+// PrevHashError is returned when a tx does not extend the last tx for its key i.e. the
+// incoming tx belongs to a chain that has forked from the local one.
+type PrevHashError struct {
+	Key  []byte
+	Want []byte
+	Have []byte
+}
+
+func (e *PrevHashError) Error() string {
+	return fmt.Sprintf(errPrevHash, shortHash(e.Want), shortHash(e.Have))
+}
+
+// IsPrevHashError returns whether the error is due to a previous hash mismatch.
+func IsPrevHashError(err error) bool {
+	_, ok := err.(*PrevHashError)
+	return ok
+}
+
+// FSM represents the finite state machine.  Apply is called in order for the
+// transactions of a key but may be called concurrently for different keys.
 type FSM interface {
 	Apply(ktx *Tx) error
 }
 
-// TxLog is a key based transaction log
+// txWait is signalled once its tx has been applied to the fsm.
+type txWait struct {
+	done chan struct{}
+	err  error
+	// whether the tx has been queued.  Waits registered ahead of the tx are removed
+	// once all their waiters give up.
+	queued bool
+	refs   int
+}
+
+func newTxWait() *txWait {
+	return &txWait{done: make(chan struct{})}
+}
+
+// txShard queues and applies the transactions of the keys hashing to it.
+type txShard struct {
+	// held while a tx is checked against the last tx and queued so transactions of a
+	// key are queued in the order they were verified.
+	alock sync.Mutex
+	// Last transaction in the queue by key, not yet in the stable store.
+	txlock  sync.RWMutex
+	lastQTx map[string]*Tx
+	// txs yet to be applied by hash
+	pending map[string]*txWait
+	// incoming verified transactions from the user
+	in chan *Tx
+	// held while a batch is committed to the store or a chain of the shard is rebased
+	commit sync.Mutex
+}
+
+// TxLog is a key based transaction log.  Keys are spread over shards which are applied
+// in parallel.  Transactions of a key always land in the same shard and are applied in
+// order.
 type TxLog struct {
 	// signer
 	kp Signator
 	// transaction store
 	store TxStore
-	// Last transaction in the log.  This is the last transaction in the queue by key and
-	// not in the stable store.
-	txlock  sync.RWMutex
-	lastQTx map[string]*Tx
-
-	// incoming verified transactions from the user
-	in chan *Tx
-	// out to the store. unbuffered as we want to block
+	// shards by key hash
+	shards []*txShard
+	// signalled once all shards have stopped
 	shutdown chan bool
 	// finite state machine called when log is to be applied
 	fsm FSM
+	// time waited for a tx that is neither in the store nor queued to be appended
+	unknownWait time.Duration
 }
 
 func NewTxLog(kp Signator, store TxStore, fsm FSM) *TxLog {
 	txl := &TxLog{
-		fsm:      fsm,
-		kp:       kp,
-		store:    store,
-		shutdown: make(chan bool),
-		in:       make(chan *Tx, defaultTxBufIn),
-		lastQTx:  make(map[string]*Tx),
+		fsm:         fsm,
+		kp:          kp,
+		store:       store,
+		shutdown:    make(chan bool),
+		shards:      make([]*txShard, runtime.GOMAXPROCS(0)),
+		unknownWait: defaultUnknownTxWait,
+	}
+
+	for i := range txl.shards {
+		txl.shards[i] = &txShard{
+			in:      make(chan *Tx, defaultTxBufIn),
+			lastQTx: make(map[string]*Tx),
+			pending: make(map[string]*txWait),
+		}
 	}
 
 	return txl
 }
 
+// shard returns the shard for the key.
+func (txl *TxLog) shard(key []byte) *txShard {
+	return txl.shards[keyShard(key, len(txl.shards))]
+}
+
 // LastTx returns the last transaction for a given key in the log.  The transaction
 // may be retreived from the stable store if currently not in the queue.
 func (txl *TxLog) LastTx(key []byte) (*Tx, error) {
-	txl.txlock.RLock()
-	if v, ok := txl.lastQTx[string(key)]; ok {
-		defer txl.txlock.RUnlock()
+	sh := txl.shard(key)
+	sh.txlock.RLock()
+	if v, ok := sh.lastQTx[string(key)]; ok {
+		defer sh.txlock.RUnlock()
 		return v, nil
 	}
-	txl.txlock.RUnlock()
+	sh.txlock.RUnlock()
 
 	return txl.store.Last(key)
 }
@@ -70,85 +143,321 @@ func (txl *TxLog) LastTx(key []byte) (*Tx, error) {
 // NewTx get a new transaction. The transaction needs to be signed before making a call to AppendTx
 func (txl *TxLog) NewTx(key []byte) (*Tx, error) {
 	if lktx, _ := txl.LastTx(key); lktx != nil {
-		return NewTx(key, lktx.Hash(), nil), nil
+		tx := NewTx(key, lktx.Hash(), nil)
+		tx.Epoch = lktx.Epoch
+		return tx, nil
 	}
 
 	// Create a new key with the prev hash set to zero.
 	return NewTx(key, ZeroHash(), nil), nil
 }
 
-// AppendTx to the log.  Verfiy the signature before submitting to the channel.
+// AppendTx to the log.  Verfiy the signature before submitting to the channel.  The
+// signer must be authorized by the signator of the log, so with a KeyringSignator a new
+// tx of any key, including the reserved keys holding the keyring itself, is only accepted
+// from a signer already in the current keyring.
 func (txl *TxLog) AppendTx(ktx *Tx) error {
+	return txl.appendTx(ktx, txl.kp.Verify)
+}
+
+// ReplicateTx appends a tx of the key's existing chain replicated from another vnode.
+// Only the signature and the link to the last tx are verified as the signer may have
+// been revoked since the tx was accepted.
+func (txl *TxLog) ReplicateTx(ktx *Tx) error {
+	return txl.appendTx(ktx, VerifySignature)
+}
+
+// appendTx verifies the tx with the verify func and queues it if it extends the last tx
+// of the key.
+func (txl *TxLog) appendTx(ktx *Tx, verify func(pubkey, signature, hash []byte) error) error {
 	// Check if we have ktx in the our store.
 	_, err := txl.store.Get(ktx.Key, ktx.Hash())
 	if err == nil {
 		return nil
 	}
 
-	err = ktx.VerifySignature(txl.kp)
-	if err != nil {
+	if err = verify(ktx.Source, ktx.Signature, ktx.Hash()); err != nil {
 		return err
 	}
 
+	sh := txl.shard(ktx.Key)
+	sh.alock.Lock()
+	defer sh.alock.Unlock()
+
 	ltx, _ := txl.LastTx(ktx.Key)
 	if ltx != nil {
 		lh := ltx.Hash()
 		if !EqualBytes(lh, ktx.PrevHash) {
-			return fmt.Errorf(errPrevHash, lh[:8], ktx.PrevHash[:8])
+			return &PrevHashError{Key: ktx.Key, Want: lh, Have: ktx.PrevHash}
+		}
+		if ktx.Epoch < ltx.Epoch {
+			return fmt.Errorf(errEpoch, ltx.Epoch, ktx.Epoch)
 		}
 	} else {
 		// If last tx is found make sure this tx's previous hash is zero i.e
 		// the first tx for this key.
 		zh := ZeroHash()
 		if !EqualBytes(ktx.PrevHash, zh) {
-			return fmt.Errorf(errPrevHash, zh[:8], ktx.PrevHash[:8])
+			return &PrevHashError{Key: ktx.Key, Want: zh, Have: ktx.PrevHash}
 		}
 	}
 
-	txl.updateQLastTx(ktx)
+	sh.txlock.Lock()
+	sh.lastQTx[string(ktx.Key)] = ktx
+	w, ok := sh.pending[string(ktx.Hash())]
+	if !ok {
+		w = newTxWait()
+		sh.pending[string(ktx.Hash())] = w
+	}
+	w.queued = true
+	sh.txlock.Unlock()
 	// Queue tx for fsm to apply
-	txl.in <- ktx
+	sh.in <- ktx
 
 	return nil
 }
 
-// Start the txlog to process incoming transactions
-func (txl *TxLog) Start() {
-	// Range over incoming tx's until channel is closed.
-	for ktx := range txl.in {
+// AppendTxWait appends the tx to the log and waits until it has been applied to the fsm
+// returning the apply error.
+func (txl *TxLog) AppendTxWait(ctx context.Context, ktx *Tx) error {
+	if err := txl.AppendTx(ktx); err != nil {
+		return err
+	}
+	return txl.WaitTx(ctx, ktx.Key, ktx.Hash())
+}
 
-		if err := txl.applyTx(ktx); err != nil {
-			log.Printf("action=apply status=failed key='%s' msg='%v'", ktx.Key, err)
-			continue
+// WaitTx waits until the tx with the given hash has been applied to the fsm returning the
+// apply error.  A tx that is neither in the store nor queued is waited for until it is
+// appended and applied for a bounded time, after which ErrUnknownTx is returned.  It
+// returns early once the context is done.
+func (txl *TxLog) WaitTx(ctx context.Context, key, txhash []byte) error {
+	sh := txl.shard(key)
+	h := string(txhash)
+
+	sh.txlock.Lock()
+	w, ok := sh.pending[h]
+	if !ok {
+		// Not pending so it has either been applied or is yet to be appended.
+		if _, err := txl.store.Get(key, txhash); err == nil {
+			sh.txlock.Unlock()
+			return nil
 		}
+		w = newTxWait()
+		sh.pending[h] = w
+	}
+	w.refs++
+	queued := w.queued
+	sh.txlock.Unlock()
+
+	var unknown <-chan time.Time
+	if !queued {
+		t := time.NewTimer(txl.unknownWait)
+		defer t.Stop()
+		unknown = t.C
+	}
+
+	err := ctx.Err()
+	select {
+	case <-w.done:
+		return w.err
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-unknown:
+		err = ErrUnknownTx
 	}
+
+	sh.txlock.Lock()
+	if w.refs--; w.refs == 0 && !w.queued && sh.pending[h] == w {
+		delete(sh.pending, h)
+	}
+	sh.txlock.Unlock()
+
+	return err
+}
+
+// applied signals the waits of the applied txs.  The caller must hold the txlock of the
+// shard.
+func (sh *txShard) applied(txs TxSlice, errs []error) {
+	for i, ktx := range txs {
+		h := string(ktx.Hash())
+		if w, ok := sh.pending[h]; ok {
+			w.err = errs[i]
+			close(w.done)
+			delete(sh.pending, h)
+		}
+	}
+}
+
+// Rebase replaces the transactions following the ancestor with the given branch, keeping
+// the replaced transactions as an orphaned side branch in the store.  The branch must be
+// signed and chain from the ancestor.  As with ReplicateTx signers are not checked against
+// the keyring as the branch is existing history.  The last tx of the new chain is re-applied to the
+// fsm.  It returns the orphaned transactions.
+func (txl *TxLog) Rebase(key, ancestor []byte, branch TxSlice) (TxSlice, error) {
+	prev := ancestor
+	for _, tx := range branch {
+		if err := VerifySignature(tx.Source, tx.Signature, tx.Hash()); err != nil {
+			return nil, err
+		}
+		if !EqualBytes(prev, tx.PrevHash) {
+			return nil, &PrevHashError{Key: key, Want: prev, Have: tx.PrevHash}
+		}
+		prev = tx.Hash()
+	}
+
+	// No tx can be queued for the key or committed while the chain is replaced.
+	sh := txl.shard(key)
+	sh.alock.Lock()
+	sh.commit.Lock()
+	orphaned, err := txl.store.Rebase(key, ancestor, branch)
+	if err != nil {
+		sh.commit.Unlock()
+		sh.alock.Unlock()
+		return nil, err
+	}
+
+	// Drop the queued last tx as it may belong to the orphaned branch.  Txs of the key
+	// still queued no longer chain and are dropped once they reach the front.
+	sh.txlock.Lock()
+	delete(sh.lastQTx, string(key))
+	sh.txlock.Unlock()
+	sh.commit.Unlock()
+	sh.alock.Unlock()
+
+	ltx, err := txl.store.Last(key)
+	if err != nil {
+		return orphaned, nil
+	}
+	err = txl.fsm.Apply(ltx)
+
+	// The branch is now applied
+	sh.txlock.Lock()
+	sh.applied(branch, make([]error, len(branch)))
+	sh.txlock.Unlock()
+
+	return orphaned, err
+}
+
+// Start the txlog to process incoming transactions.  Each shard is applied on its own
+// go-routine.  It returns once Shutdown is called and all shards have drained.
+func (txl *TxLog) Start() {
+	var wg sync.WaitGroup
+	wg.Add(len(txl.shards))
+	for _, sh := range txl.shards {
+		go func(sh *txShard) {
+			defer wg.Done()
+			txl.runShard(sh)
+		}(sh)
+	}
+	wg.Wait()
+
 	txl.shutdown <- true
 }
 
-func (txl *TxLog) updateQLastTx(ktx *Tx) {
-	txl.txlock.Lock()
-	txl.lastQTx[string(ktx.Key)] = ktx
-	txl.txlock.Unlock()
+// runShard ranges over the incoming tx's of the shard until its channel is closed.
+// Transactions that have queued up while the previous batch was being applied are
+// committed together.
+func (txl *TxLog) runShard(sh *txShard) {
+	for ktx := range sh.in {
+		batch := TxSlice{ktx}
+
+	DRAIN:
+		for len(batch) < maxCommitBatch {
+			select {
+			case tx, ok := <-sh.in:
+				if !ok {
+					break DRAIN
+				}
+				batch = append(batch, tx)
+			default:
+				break DRAIN
+			}
+		}
+
+		txl.applyBatch(sh, batch)
+	}
 }
 
-// applyTx applies a transaction to the log.
-func (txl *TxLog) applyTx(ktx *Tx) error {
-	// Add tx to log i.e. tx stable store.
-	if err := txl.store.Add(ktx); err != nil {
-		return err
+// applyBatch adds the transactions to the stable store in a single commit then applies
+// them to the fsm in order.  Waits on the transactions are signalled with their apply
+// error.
+func (txl *TxLog) applyBatch(sh *txShard, batch TxSlice) {
+	sh.commit.Lock()
+	batch, dropped, derrs := txl.chained(batch)
+
+	// Add tx's to log i.e. tx stable store.
+	errs := txl.store.AddBatch(batch)
+	sh.commit.Unlock()
+	if errs == nil {
+		errs = make([]error, len(batch))
+	}
+
+	// The chain of a key whose txs were dropped or failed to commit continues from the
+	// store once they are no longer the last queued tx of the key.
+	sh.txlock.Lock()
+	sh.dequeue(batch)
+	sh.dequeue(dropped)
+	if len(dropped) > 0 {
+		log.Printf("action=apply status=dropped txs=%d msg='%v'", len(dropped), derrs[0])
+		sh.applied(dropped, derrs)
 	}
-	txl.txlock.Lock()
-	if v, ok := txl.lastQTx[string(ktx.Key)]; ok && EqualBytes(ktx.Hash(), v.Hash()) {
-		delete(txl.lastQTx, string(ktx.Key))
+	sh.txlock.Unlock()
+
+	// Called user defined fsm for the txs committed
+	for i, ktx := range batch {
+		if errs[i] == nil {
+			errs[i] = txl.fsm.Apply(ktx)
+		}
+		if errs[i] != nil {
+			log.Printf("action=apply status=failed key='%s' msg='%v'", ktx.Key, errs[i])
+		}
 	}
-	txl.txlock.Unlock()
 
-	// Called user defined fsm
-	return txl.fsm.Apply(ktx)
+	sh.txlock.Lock()
+	sh.applied(batch, errs)
+	sh.txlock.Unlock()
 }
 
-// Shutdown closes the incoming tx channel and waits for a shutdown from the loop.
+// dequeue removes the txs that are the last queued tx of their key.  The caller must hold
+// the txlock of the shard.
+func (sh *txShard) dequeue(txs TxSlice) {
+	for _, ktx := range txs {
+		if v, ok := sh.lastQTx[string(ktx.Key)]; ok && EqualBytes(ktx.Hash(), v.Hash()) {
+			delete(sh.lastQTx, string(ktx.Key))
+		}
+	}
+}
+
+// chained splits the batch into the txs extending the chain of their key in the store and
+// the ones that do not along with their errors.  Txs queued before the chain of their key
+// was rebased no longer extend it.
+func (txl *TxLog) chained(batch TxSlice) (txs, dropped TxSlice, errs []error) {
+	last := make(map[string][]byte)
+	for _, ktx := range batch {
+		lh, ok := last[string(ktx.Key)]
+		if !ok {
+			lh = ZeroHash()
+			if ltx, err := txl.store.Last(ktx.Key); err == nil {
+				lh = ltx.Hash()
+			}
+		}
+
+		if !EqualBytes(lh, ktx.PrevHash) {
+			dropped = append(dropped, ktx)
+			errs = append(errs, &PrevHashError{Key: ktx.Key, Want: lh, Have: ktx.PrevHash})
+			last[string(ktx.Key)] = lh
+			continue
+		}
+		txs = append(txs, ktx)
+		last[string(ktx.Key)] = ktx.Hash()
+	}
+	return
+}
+
+// Shutdown closes the incoming tx channels and waits for a shutdown from the loop.
 func (txl *TxLog) Shutdown() {
-	close(txl.in)
+	for _, sh := range txl.shards {
+		close(sh.in)
+	}
 	<-txl.shutdown
 }
@@ -2,8 +2,12 @@ package txlog
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"golang.org/x/net/context"
 )
 
 type testFsm struct {
@@ -16,6 +20,277 @@ func (tf *testFsm) Apply(ktx *Tx) error {
 	return nil
 }
 
+// testNopVerifier skips signature verification so tests and benchmarks measure the log.
+type testNopVerifier struct {
+	Signator
+}
+
+func (testNopVerifier) Verify(pubkey, signature, hash []byte) error { return nil }
+
+// orderFsm checks each applied tx extends the previously applied tx of its key.
+type orderFsm struct {
+	mu      sync.Mutex
+	last    map[string][]byte
+	applied int
+	err     error
+}
+
+func (of *orderFsm) Apply(ktx *Tx) error {
+	of.mu.Lock()
+	defer of.mu.Unlock()
+
+	prev, ok := of.last[string(ktx.Key)]
+	if !ok {
+		prev = ZeroHash()
+	}
+	if !EqualBytes(prev, ktx.PrevHash) && of.err == nil {
+		of.err = fmt.Errorf("key=%s applied out of order", ktx.Key)
+	}
+	of.last[string(ktx.Key)] = ktx.Hash()
+	of.applied++
+	return nil
+}
+
+type nopFsm struct{}
+
+func (nopFsm) Apply(ktx *Tx) error { return nil }
+
+func TestTxLogParallelApply(t *testing.T) {
+	kp, _ := GenerateECDSAKeypair()
+	store := NewMemTxStore()
+	fsm := &orderFsm{last: make(map[string][]byte)}
+
+	txl := NewTxLog(testNopVerifier{kp}, store, fsm)
+	go txl.Start()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				ntx, _ := txl.NewTx([]byte(fmt.Sprintf("key-%d", (i*50+j)%40)))
+				ntx.Data = []byte(fmt.Sprint(j))
+				// Writers race on shared keys so conflicts are expected
+				txl.AppendTx(ntx)
+			}
+		}(i)
+	}
+	wg.Wait()
+	txl.Shutdown()
+
+	if fsm.err != nil {
+		t.Fatal(fsm.err)
+	}
+
+	var n int
+	store.Iter(func(k []byte, kt *KeyTransactions) error {
+		txs, _ := kt.Transactions(nil)
+		n += len(txs)
+		if !EqualBytes(fsm.last[string(k)], txs.Last().Hash()) {
+			t.Errorf("key=%s last applied tx is not the last stored", k)
+		}
+		return nil
+	})
+	if n == 0 || n != fsm.applied {
+		t.Fatal("stored and applied mismatch", n, fsm.applied)
+	}
+}
+
+// errFsm fails to apply transactions with the data "fail".
+type errFsm struct{}
+
+func (errFsm) Apply(ktx *Tx) error {
+	if string(ktx.Data) == "fail" {
+		return fmt.Errorf("apply failed")
+	}
+	return nil
+}
+
+func TestTxLogWaitTx(t *testing.T) {
+	kp, _ := GenerateECDSAKeypair()
+	txl := NewTxLog(kp, NewMemTxStore(), errFsm{})
+	go txl.Start()
+	defer txl.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	key := []byte("key")
+	tx1, _ := txl.NewTx(key)
+	tx1.Data = []byte("ok")
+	tx1.Sign(kp)
+	if err := txl.AppendTxWait(ctx, tx1); err != nil {
+		t.Fatal(err)
+	}
+	// Already applied
+	if err := txl.WaitTx(ctx, key, tx1.Hash()); err != nil {
+		t.Fatal(err)
+	}
+
+	tx2, _ := txl.NewTx(key)
+	tx2.Data = []byte("fail")
+	tx2.Sign(kp)
+	if err := txl.AppendTxWait(ctx, tx2); err == nil || err.Error() != "apply failed" {
+		t.Fatal("should return apply error", err)
+	}
+
+	// Waiting ahead of the append
+	tx3, _ := txl.NewTx(key)
+	tx3.Data = []byte("ok")
+	tx3.Sign(kp)
+	done := make(chan error, 1)
+	go func() { done <- txl.WaitTx(ctx, key, tx3.Hash()) }()
+
+	<-time.After(20 * time.Millisecond)
+	if err := txl.AppendTx(tx3); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// Never appended
+	tctx, tcancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer tcancel()
+	if err := txl.WaitTx(tctx, key, ZeroHash()); err != context.DeadlineExceeded {
+		t.Fatal("should exceed deadline", err)
+	}
+	sh := txl.shard(key)
+	sh.txlock.RLock()
+	n := len(sh.pending)
+	sh.txlock.RUnlock()
+	if n != 0 {
+		t.Fatal("waits not cleaned up", n)
+	}
+
+	// Unknown tx waits are bounded
+	txl.unknownWait = 20 * time.Millisecond
+	if err := txl.WaitTx(context.Background(), key, ZeroHash()); err != ErrUnknownTx {
+		t.Fatal("should be unknown", err)
+	}
+	sh.txlock.RLock()
+	n = len(sh.pending)
+	sh.txlock.RUnlock()
+	if n != 0 {
+		t.Fatal("waits not cleaned up", n)
+	}
+}
+
+// failStore fails to commit the transactions of the key "bad".
+type failStore struct {
+	*MemTxStore
+}
+
+func (fs failStore) AddBatch(txs TxSlice) []error {
+	var ok TxSlice
+	for _, tx := range txs {
+		if string(tx.Key) != "bad" {
+			ok = append(ok, tx)
+		}
+	}
+	if errs := fs.MemTxStore.AddBatch(ok); errs != nil {
+		return errs
+	}
+	if len(ok) == len(txs) {
+		return nil
+	}
+
+	errs := make([]error, len(txs))
+	for i, tx := range txs {
+		if string(tx.Key) == "bad" {
+			errs[i] = fmt.Errorf("commit failed")
+		}
+	}
+	return errs
+}
+
+func TestTxLogPartialCommit(t *testing.T) {
+	kp, _ := GenerateECDSAKeypair()
+	txl := NewTxLog(kp, failStore{NewMemTxStore()}, nopFsm{})
+	go txl.Start()
+	defer txl.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	good, _ := txl.NewTx([]byte("good"))
+	good.Sign(kp)
+	bad, _ := txl.NewTx([]byte("bad"))
+	bad.Sign(kp)
+	done := make(chan error, 1)
+	go func() { done <- txl.WaitTx(ctx, bad.Key, bad.Hash()) }()
+
+	<-time.After(20 * time.Millisecond)
+	if err := txl.AppendTx(bad); err != nil {
+		t.Fatal(err)
+	}
+	if err := txl.AppendTxWait(ctx, good); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err == nil || err.Error() != "commit failed" {
+		t.Fatal("should return commit error", err)
+	}
+
+	// The failed tx is no longer the last tx of its key
+	if _, err := txl.LastTx(bad.Key); err == nil {
+		t.Fatal("failed tx should be dropped from the queue")
+	}
+	ntx, _ := txl.NewTx(bad.Key)
+	if !EqualBytes(ntx.PrevHash, ZeroHash()) {
+		t.Fatal("new tx should chain on the store")
+	}
+	if ltx, err := txl.LastTx(good.Key); err != nil || !EqualBytes(ltx.Hash(), good.Hash()) {
+		t.Fatal("good tx should be committed", err)
+	}
+}
+
+// BenchmarkTxLogAppend appends to distinct keys from each goroutine.  Run with -cpu to
+// see throughput scale with the number of shards.
+func BenchmarkTxLogAppend(b *testing.B) {
+	kp, _ := GenerateECDSAKeypair()
+	txl := NewTxLog(testNopVerifier{kp}, NewMemTxStore(), nopFsm{})
+	go txl.Start()
+
+	var id int32
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		g := atomic.AddInt32(&id, 1)
+		for i := 0; pb.Next(); i++ {
+			ntx, _ := txl.NewTx([]byte(fmt.Sprintf("key-%d-%d", g, i%1024)))
+			ntx.Data = []byte("value")
+			if err := txl.AppendTx(ntx); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+	// Include draining the queues so the apply rate is measured
+	txl.Shutdown()
+}
+
+func BenchmarkMemTxStoreAddBatch(b *testing.B) {
+	st := NewMemTxStore()
+	prev := make(map[string][]byte)
+
+	batch := make(TxSlice, 0, maxCommitBatch)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := fmt.Sprintf("key-%d", i%1024)
+		ph, ok := prev[k]
+		if !ok {
+			ph = ZeroHash()
+		}
+		tx := NewTx([]byte(k), ph, []byte("value"))
+		prev[k] = tx.Hash()
+
+		if batch = append(batch, tx); len(batch) == cap(batch) {
+			st.AddBatch(batch)
+			batch = batch[:0]
+		}
+	}
+	st.AddBatch(batch)
+}
+
 func Test_TxLog(t *testing.T) {
 	kp, _ := GenerateECDSAKeypair()
 	store := NewMemTxStore()
@@ -18,6 +18,16 @@ func (txs TxSlice) Exists(tx *Tx) bool {
 	return false
 }
 
+// Index returns the index of the tx with the given hash or -1 if not found.
+func (txs TxSlice) Index(txhash []byte) int {
+	for i, t := range txs {
+		if EqualBytes(txhash, t.Hash()) {
+			return i
+		}
+	}
+	return -1
+}
+
 // First transaction in the slice
 func (txs TxSlice) First() *Tx {
 	if len(txs) > 0 {
@@ -3,10 +3,13 @@ package txlog
 import (
 	"sort"
 	"sync"
+	"sync/atomic"
 
 	merkle "github.com/ipkg/go-merkle"
 )
 
+const defaultStoreShards = 32
+
 // TxStore persists transactions to data store.
 type TxStore interface {
 	Get(key []byte, txhash []byte) (*Tx, error)
@@ -20,31 +23,87 @@ type TxStore interface {
 	Transactions(key, seek []byte) (TxSlice, error)
 	// Add a transaction to the store.
 	Add(tx *Tx) error
+	// AddBatch adds the transactions to the store as a single commit.  Transactions of
+	// the same key are added in order and either all succeed or all fail.  It returns an
+	// error per transaction or nil if all were added.
+	AddBatch(txs TxSlice) []error
+	// Rebase replaces the transactions following the ancestor with the branch.  The
+	// replaced transactions are kept as an orphaned side branch and returned.
+	Rebase(key, ancestor []byte, branch TxSlice) (TxSlice, error)
+	// Orphans returns all side branches orphaned from the key's chain.
+	Orphans(key []byte) ([]TxSlice, error)
 	// Iterate over each key - calling f on each key with the key and transaction
 	// slice.
 	Iter(f func([]byte, *KeyTransactions) error) error
+	// Proof returns the merkle root of the key's transactions along with the inclusion
+	// proof of the tx within it.
+	Proof(key, txhash []byte) ([]byte, Proof, error)
+	// KeyProof returns the merkle root of the store along with the inclusion proof of
+	// the merkle root of the key's transactions within it.
+	KeyProof(key []byte) ([]byte, Proof, error)
+	// StoreProof returns the inclusion proofs of the tx within the chain of its key and
+	// of the key within the store from the same version of the store.
+	StoreProof(key, txhash []byte) (*StoreProof, error)
 }
 
-// MemTxStore stores the transaction log
-type MemTxStore struct {
+// memTxShard holds the transactions of the keys hashing to it.
+type memTxShard struct {
 	mu sync.RWMutex
 	m  map[string]*KeyTransactions
 }
 
+// storeTree is the merkle tree of the store as of a generation of the store.
+type storeTree struct {
+	gen    uint64
+	keys   []string
+	levels [][][]byte
+	root   []byte
+}
+
+// MemTxStore stores the transaction log.  Keys are spread over shards each with its own
+// lock so writes to different keys rarely contend.
+type MemTxStore struct {
+	shards []*memTxShard
+
+	// generation of the store incremented on every write
+	gen uint64
+
+	// store merkle tree cached for proofs until the next write
+	tmu  sync.Mutex
+	tree *storeTree
+}
+
 // NewMemTxStore initializes a new transaction store
 func NewMemTxStore() *MemTxStore {
-	return &MemTxStore{
-		m: map[string]*KeyTransactions{},
+	mts := &MemTxStore{shards: make([]*memTxShard, defaultStoreShards)}
+	for i := range mts.shards {
+		mts.shards[i] = &memTxShard{m: map[string]*KeyTransactions{}}
 	}
+	return mts
+}
+
+// shard returns the shard holding the key.
+func (mts *MemTxStore) shard(key []byte) *memTxShard {
+	return mts.shards[keyShard(key, len(mts.shards))]
 }
 
 // Iter iterates over key and associated transactions calling f
 // with the key and slice of transactions as arguments.
 func (mts *MemTxStore) Iter(f func(k []byte, kt *KeyTransactions) error) error {
 	var err error
-	for k, v := range mts.m {
-		if e := f([]byte(k), v); e != nil {
-			err = e
+	for _, sh := range mts.shards {
+		// Copy the shard so f is not called with the lock held
+		sh.mu.RLock()
+		m := make(map[string]*KeyTransactions, len(sh.m))
+		for k, v := range sh.m {
+			m[k] = v
+		}
+		sh.mu.RUnlock()
+
+		for k, v := range m {
+			if e := f([]byte(k), v); e != nil {
+				err = e
+			}
 		}
 	}
 	return err
@@ -52,10 +111,11 @@ func (mts *MemTxStore) Iter(f func(k []byte, kt *KeyTransactions) error) error {
 
 // Last returns the last transaction for a key
 func (mts *MemTxStore) Last(key []byte) (*Tx, error) {
-	mts.mu.RLock()
-	defer mts.mu.RUnlock()
+	sh := mts.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
 
-	if txs, ok := mts.m[string(key)]; ok {
+	if txs, ok := sh.m[string(key)]; ok {
 		if t := txs.txs.Last(); t != nil {
 			return t, nil
 		}
@@ -67,13 +127,18 @@ func (mts *MemTxStore) Last(key []byte) (*Tx, error) {
 // MerkleRoot returns the merkle root of the transaction log for a given key. If key is nil
 // the merkle root for the whole transaction log is returned.
 func (mts *MemTxStore) MerkleRoot(key []byte) ([]byte, error) {
-	mts.mu.RLock()
-	defer mts.mu.RUnlock()
-
 	if key == nil {
 		// Return merkle root of complete store
+		mts.rlockShards()
+		defer mts.runlockShards()
 		return mts.storeMerkleRoot()
-	} else if v, ok := mts.m[string(key)]; ok {
+	}
+
+	sh := mts.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	if v, ok := sh.m[string(key)]; ok {
 		// Return merkle root for the given key
 		return v.Root(), nil
 	}
@@ -83,10 +148,11 @@ func (mts *MemTxStore) MerkleRoot(key []byte) ([]byte, error) {
 
 // Transactions returns all transactions for the key starting from the seek point.
 func (mts *MemTxStore) Transactions(key, seek []byte) (TxSlice, error) {
-	mts.mu.RLock()
-	defer mts.mu.RUnlock()
+	sh := mts.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
 
-	v, ok := mts.m[string(key)]
+	v, ok := sh.m[string(key)]
 	if !ok {
 		return nil, errNotFound
 	}
@@ -96,28 +162,101 @@ func (mts *MemTxStore) Transactions(key, seek []byte) (TxSlice, error) {
 
 // Add adds a transaction to the transaction store.
 func (mts *MemTxStore) Add(tx *Tx) error {
-	mts.mu.Lock()
-	defer mts.mu.Unlock()
+	if errs := mts.AddBatch(TxSlice{tx}); errs != nil {
+		return errs[0]
+	}
+	return nil
+}
+
+// AddBatch adds the transactions to the store.  The merkle root of each key is updated
+// once for all of its transactions.  It returns an error per transaction or nil if all
+// were added.
+func (mts *MemTxStore) AddBatch(txs TxSlice) []error {
+	// Group transactions by shard then key keeping their order
+	var (
+		order []*memTxShard
+		keys  = make(map[*memTxShard][]string)
+		byKey = make(map[string]TxSlice)
+	)
+	for _, tx := range txs {
+		k := string(tx.Key)
+		if _, ok := byKey[k]; !ok {
+			sh := mts.shard(tx.Key)
+			if _, ok := keys[sh]; !ok {
+				order = append(order, sh)
+			}
+			keys[sh] = append(keys[sh], k)
+		}
+		byKey[k] = append(byKey[k], tx)
+	}
+
+	failed := make(map[string]error)
+	for _, sh := range order {
+		sh.mu.Lock()
+		for _, k := range keys[sh] {
+			kt, ok := sh.m[k]
+			if !ok {
+				kt = NewKeyTransactions()
+			}
+			if err := kt.AddTxs(byKey[k]...); err != nil {
+				failed[k] = err
+				continue
+			}
+			sh.m[k] = kt
+			atomic.AddUint64(&mts.gen, 1)
+		}
+		sh.mu.Unlock()
+	}
 
-	txs, ok := mts.m[string(tx.Key)]
+	if len(failed) == 0 {
+		return nil
+	}
+	errs := make([]error, len(txs))
+	for i, tx := range txs {
+		errs[i] = failed[string(tx.Key)]
+	}
+	return errs
+}
+
+// Rebase replaces the transactions for the key following the ancestor with the given
+// branch returning the orphaned transactions.
+func (mts *MemTxStore) Rebase(key, ancestor []byte, branch TxSlice) (TxSlice, error) {
+	sh := mts.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	txs, ok := sh.m[string(key)]
 	if !ok {
 		txs = NewKeyTransactions()
 	}
 
-	if err := txs.AddTx(tx); err != nil {
-		return err
+	orphaned, err := txs.Rebase(ancestor, branch)
+	if err == nil {
+		sh.m[string(key)] = txs
+		atomic.AddUint64(&mts.gen, 1)
 	}
+	return orphaned, err
+}
 
-	mts.m[string(tx.Key)] = txs
-	return nil
+// Orphans returns the orphaned side branches for the key.
+func (mts *MemTxStore) Orphans(key []byte) ([]TxSlice, error) {
+	sh := mts.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	if txs, ok := sh.m[string(key)]; ok {
+		return txs.Orphans(), nil
+	}
+	return nil, errNotFound
 }
 
 // Get geta a transaction for a given key and associated hash
 func (mts *MemTxStore) Get(key []byte, txhash []byte) (*Tx, error) {
-	mts.mu.RLock()
-	defer mts.mu.RUnlock()
+	sh := mts.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
 
-	if txs, ok := mts.m[string(key)]; ok {
+	if txs, ok := sh.m[string(key)]; ok {
 		for _, tx := range txs.txs {
 			if EqualBytes(tx.Hash(), txhash) {
 				return tx, nil
@@ -128,6 +267,122 @@ func (mts *MemTxStore) Get(key []byte, txhash []byte) (*Tx, error) {
 	return nil, errNotFound
 }
 
+// Proof returns the merkle root of the key's transactions and the inclusion proof of the
+// tx within it.
+func (mts *MemTxStore) Proof(key, txhash []byte) ([]byte, Proof, error) {
+	sh := mts.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	kt, ok := sh.m[string(key)]
+	if !ok {
+		return nil, nil, errNotFound
+	}
+
+	proof, err := kt.Proof(txhash)
+	if err != nil {
+		return nil, nil, err
+	}
+	return kt.Root(), proof, nil
+}
+
+// KeyProof returns the merkle root of the store and the inclusion proof of the key's
+// merkle root within it.  The store tree is only rebuilt after a write.
+func (mts *MemTxStore) KeyProof(key []byte) ([]byte, Proof, error) {
+	mts.rlockShards()
+	defer mts.runlockShards()
+
+	tree, err := mts.storeTree()
+	if err != nil {
+		return nil, nil, err
+	}
+	proof, err := tree.proof(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tree.root, proof, nil
+}
+
+// StoreProof returns the inclusion proofs of the tx within the chain of its key and of
+// the key within the store.  All shards are read locked throughout so both are taken
+// from the same version of the store.
+func (mts *MemTxStore) StoreProof(key, txhash []byte) (*StoreProof, error) {
+	mts.rlockShards()
+	defer mts.runlockShards()
+
+	kt, ok := mts.shard(key).m[string(key)]
+	if !ok {
+		return nil, errNotFound
+	}
+	txproof, err := kt.Proof(txhash)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := mts.storeTree()
+	if err != nil {
+		return nil, err
+	}
+	keyproof, err := tree.proof(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StoreProof{TxRoot: kt.Root(), TxProof: txproof, Root: tree.root, KeyProof: keyproof}, nil
+}
+
+// proof returns the inclusion proof of the key's merkle root within the tree.
+func (tree *storeTree) proof(key []byte) (Proof, error) {
+	i := sort.SearchStrings(tree.keys, string(key))
+	if i == len(tree.keys) || tree.keys[i] != string(key) {
+		return nil, errNotFound
+	}
+	return levelsProof(tree.levels, i), nil
+}
+
+func (mts *MemTxStore) rlockShards() {
+	for _, sh := range mts.shards {
+		sh.mu.RLock()
+	}
+}
+
+func (mts *MemTxStore) runlockShards() {
+	for _, sh := range mts.shards {
+		sh.mu.RUnlock()
+	}
+}
+
+// storeTree returns the merkle tree of the store building it if the store was written to
+// since it was cached.  An empty store has no tree.  The caller must hold the read lock
+// of all shards.
+func (mts *MemTxStore) storeTree() (*storeTree, error) {
+	// No writes happen while all shards are read locked
+	gen := atomic.LoadUint64(&mts.gen)
+
+	mts.tmu.Lock()
+	defer mts.tmu.Unlock()
+
+	if mts.tree != nil && mts.tree.gen == gen {
+		return mts.tree, nil
+	}
+
+	keys, leaves, err := mts.storeLeaves()
+	if err != nil {
+		return nil, err
+	}
+	if len(leaves) == 0 {
+		return nil, errNotFound
+	}
+
+	mts.tree = &storeTree{
+		gen:    gen,
+		keys:   keys,
+		levels: merkleLevels(leaves),
+		root:   merkle.GenerateTree(leaves).Root().Hash(),
+	}
+	return mts.tree, nil
+}
+
 func (mts *MemTxStore) keysMerkleTree() (*merkle.Tree, error) {
 	ks := mts.getSortedKeys()
 	keys := make([][]byte, len(ks))
@@ -139,30 +394,38 @@ func (mts *MemTxStore) keysMerkleTree() (*merkle.Tree, error) {
 }
 
 func (mts *MemTxStore) getSortedKeys() []string {
-	keys := make([]string, len(mts.m))
-	i := 0
-	for k := range mts.m {
-		keys[i] = k
-		i++
+	var keys []string
+	for _, sh := range mts.shards {
+		for k := range sh.m {
+			keys = append(keys, k)
+		}
 	}
 	sort.Strings(keys)
 	return keys
 }
 
-// storeMerkleRoot returns the merkle root for the while txstore.
-func (mts *MemTxStore) storeMerkleRoot() ([]byte, error) {
+// storeLeaves returns the sorted keys and the merkle roots of their transactions which
+// are the leaves of the store merkle tree.  The caller must hold the read lock of all
+// shards.
+func (mts *MemTxStore) storeLeaves() ([]string, [][]byte, error) {
 	keys := mts.getSortedKeys()
-	klen := len(keys)
-	if klen == 0 {
-		return ZeroHash(), nil
-	}
 
-	mrs := make([][]byte, klen)
+	mrs := make([][]byte, len(keys))
 	for i, k := range keys {
-		var err error
-		if mrs[i], err = mts.m[k].txs.MerkleRoot(); err != nil {
-			return nil, err
-		}
+		mrs[i] = mts.shard([]byte(k)).m[k].Root()
+	}
+	return keys, mrs, nil
+}
+
+// storeMerkleRoot returns the merkle root for the while txstore.  The caller must hold
+// the read lock of all shards.
+func (mts *MemTxStore) storeMerkleRoot() ([]byte, error) {
+	_, mrs, err := mts.storeLeaves()
+	if err != nil {
+		return nil, err
+	}
+	if len(mrs) == 0 {
+		return ZeroHash(), nil
 	}
 
 	tree := merkle.GenerateTree(mrs)
@@ -1,6 +1,9 @@
 package txlog
 
-import "math/big"
+import (
+	"hash/fnv"
+	"math/big"
+)
 
 // ZeroHash returns a 32 byte hash with all zeros
 func ZeroHash() []byte {
@@ -38,10 +41,12 @@ func joinBigInt(expectedLen int, bigs ...*big.Int) *big.Int {
 	return b
 }
 
-func splitBigInt(b *big.Int, parts int) []*big.Int {
+// splitBigInt splits b into the number of parts each of the given size in bytes.  Leading
+// zeros dropped by the big int are restored before splitting.
+func splitBigInt(b *big.Int, size, parts int) []*big.Int {
 	bs := b.Bytes()
-	if len(bs)%2 != 0 {
-		bs = append([]byte{0}, bs...)
+	if dif := size*parts - len(bs); dif > 0 {
+		bs = append(arrayOfBytes(dif, 0), bs...)
 	}
 
 	l := len(bs) / parts
@@ -55,6 +60,20 @@ func splitBigInt(b *big.Int, parts int) []*big.Int {
 
 }
 
+// splitLegacyBigInt returns the candidate pairs b may have been joined from with the
+// legacy key size.  The first value was never padded and the second only to the legacy
+// size so the boundary between them is ambiguous.
+func splitLegacyBigInt(b *big.Int) [][]*big.Int {
+	bs := b.Bytes()
+	out := [][]*big.Int{}
+	for n := legacyECDSAKeySize; n < ecdsaKeySize; n++ {
+		if i := len(bs) - n; i > 0 && i <= ecdsaKeySize {
+			out = append(out, []*big.Int{new(big.Int).SetBytes(bs[:i]), new(big.Int).SetBytes(bs[i:])})
+		}
+	}
+	return out
+}
+
 // create an array filled with b
 func arrayOfBytes(i int, b byte) (p []byte) {
 	for i != 0 {
@@ -77,6 +96,14 @@ func EqualBytes(a, b []byte) bool {
 	return true
 }
 
+// shortHash returns at most the first 8 bytes of the hash for display purposes.
+func shortHash(h []byte) []byte {
+	if len(h) > 8 {
+		return h[:8]
+	}
+	return h
+}
+
 func IsZeroHash(b []byte) bool {
 	for _, v := range b {
 		if v != 0 {
@@ -85,3 +112,10 @@ func IsZeroHash(b []byte) bool {
 	}
 	return true
 }
+
+// keyShard returns the shard out of n the key belongs to.
+func keyShard(key []byte, n int) int {
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % uint32(n))
+}
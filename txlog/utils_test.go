@@ -1,6 +1,9 @@
 package txlog
 
-import "testing"
+import (
+	"math/big"
+	"testing"
+)
 
 func Test_concat(t *testing.T) {
 	arr := [][]byte{
@@ -16,3 +19,14 @@ func Test_concat(t *testing.T) {
 		t.Fatalf("mismatch %s", s)
 	}
 }
+
+func Test_splitBigInt(t *testing.T) {
+	// Leading zeros in both parts should survive the round trip
+	x := new(big.Int).SetBytes([]byte{0, 0, 1, 2})
+	y := new(big.Int).SetBytes([]byte{0, 3, 4, 5})
+
+	pp := splitBigInt(joinBigInt(4, x, y), 4, 2)
+	if pp[0].Cmp(x) != 0 || pp[1].Cmp(y) != 0 {
+		t.Fatalf("mismatch %x %x", pp[0], pp[1])
+	}
+}